@@ -0,0 +1,111 @@
+// Package accesslog provides a goa middleware writing a structured log entry
+// for each request, including the request ID, the identity of the caller and
+// the space the request is about.
+package accesslog
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/fabric8-services/fabric8-common/token"
+	"github.com/goadesign/goa"
+	"github.com/goadesign/goa/middleware"
+)
+
+type contextKey int
+
+const entryKey contextKey = iota
+
+// Options configure the access log
+type Options struct {
+	// SampleRate is the fraction (0 to 1) of the successful requests which
+	// are logged, requests failing with a 4xx or 5xx status are always logged
+	SampleRate float64
+	// Exclude are the path prefixes of the requests which are never logged
+	Exclude []string
+}
+
+// entry holds the values filled by the inner middlewares
+type entry struct {
+	mu         sync.Mutex
+	identityID string
+}
+
+// Middleware returns the access log middleware. It must be mounted after the
+// RequestID middleware and before the Identity one.
+func Middleware(opts Options) goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			for _, prefix := range opts.Exclude {
+				if strings.HasPrefix(req.URL.Path, prefix) {
+					return h(ctx, rw, req)
+				}
+			}
+
+			e := &entry{}
+			start := time.Now()
+			err := h(context.WithValue(ctx, entryKey, e), rw, req)
+
+			status, length := 0, 0
+			if resp := goa.ContextResponse(ctx); resp != nil {
+				status, length = resp.Status, resp.Length
+			}
+			if status < http.StatusBadRequest && err == nil && !sampled(opts.SampleRate) {
+				return err
+			}
+
+			fields := map[string]interface{}{
+				"method":      req.Method,
+				"path":        req.URL.Path,
+				"status":      status,
+				"duration_ms": time.Since(start).Seconds() * 1000,
+				"bytes":       length,
+				"req_id":      middleware.ContextRequestID(ctx),
+			}
+			e.mu.Lock()
+			if e.identityID != "" {
+				fields["identity_id"] = e.identityID
+			}
+			e.mu.Unlock()
+			if r := goa.ContextRequest(ctx); r != nil {
+				if spaceID := r.Params.Get("spaceID"); spaceID != "" {
+					fields["space_id"] = spaceID
+				}
+			}
+			if err != nil {
+				fields["err"] = err
+			}
+			log.Info(ctx, fields, "%s %s %d", req.Method, req.URL.Path, status)
+			return err
+		}
+	}
+}
+
+// Identity returns a middleware recording the identity ID of the token in
+// the access log entry. It must be mounted after the token middlewares.
+func Identity(tokenMgr token.Manager) goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			if e, ok := ctx.Value(entryKey).(*entry); ok {
+				if id, err := tokenMgr.Locate(ctx); err == nil {
+					e.mu.Lock()
+					e.identityID = id.String()
+					e.mu.Unlock()
+				}
+			}
+			return h(ctx, rw, req)
+		}
+	}
+}
+
+func sampled(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	return rand.Float64() < rate
+}
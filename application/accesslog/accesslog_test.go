@@ -0,0 +1,97 @@
+package accesslog_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/application/accesslog"
+	"github.com/fabric8-services/fabric8-common/log"
+	testauth "github.com/fabric8-services/fabric8-common/test/auth"
+	"github.com/fabric8-services/fabric8-common/token"
+	"github.com/goadesign/goa"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a logrus hook keeping the logged entries
+type recorder struct {
+	mu      sync.Mutex
+	entries []*logrus.Entry
+}
+
+func (r *recorder) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (r *recorder) Fire(e *logrus.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+func (r *recorder) reset() []*logrus.Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := r.entries
+	r.entries = nil
+	return entries
+}
+
+func TestMiddleware(t *testing.T) {
+	log.InitializeLogger(true, "info")
+	rec := &recorder{}
+	log.Logger().AddHook(rec)
+
+	identity := testauth.NewIdentity()
+	svc, err := testauth.ServiceAsUser("accesslog-test", identity)
+	require.NoError(t, err)
+	tokenMgr, err := token.ReadManagerFromContext(svc.Context)
+	require.NoError(t, err)
+
+	serve := func(opts accesslog.Options, path string, status int, herr error) {
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			rw.WriteHeader(status)
+			rw.Write([]byte("hello"))
+			return herr
+		}
+		h = accesslog.Middleware(opts)(accesslog.Identity(tokenMgr)(h))
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		params := url.Values{"spaceID": []string{"f7c9e8fb-4fd3-4b8b-a4d9-3c4e3a5d8d37"}}
+		ctx := goa.NewContext(svc.Context, rw, req, params)
+		h(ctx, goa.ContextResponse(ctx), req)
+	}
+
+	t.Run("logged", func(t *testing.T) {
+		serve(accesslog.Options{SampleRate: 1}, "/api/spaces/foo", http.StatusOK, nil)
+		entries := rec.reset()
+		require.Len(t, entries, 1)
+		assert.Equal(t, "GET", entries[0].Data["method"])
+		assert.Equal(t, http.StatusOK, entries[0].Data["status"])
+		assert.Equal(t, 5, entries[0].Data["bytes"])
+		assert.Equal(t, identity.ID.String(), entries[0].Data["identity_id"])
+		assert.Equal(t, "f7c9e8fb-4fd3-4b8b-a4d9-3c4e3a5d8d37", entries[0].Data["space_id"])
+	})
+
+	t.Run("excluded", func(t *testing.T) {
+		serve(accesslog.Options{SampleRate: 1, Exclude: []string{"/api/status"}}, "/api/status", http.StatusOK, nil)
+		assert.Empty(t, rec.reset())
+	})
+
+	t.Run("sampled", func(t *testing.T) {
+		serve(accesslog.Options{SampleRate: 0}, "/api/spaces/foo", http.StatusOK, nil)
+		assert.Empty(t, rec.reset())
+		// errors are always logged
+		serve(accesslog.Options{SampleRate: 0}, "/api/spaces/foo", http.StatusInternalServerError, errors.New("boom"))
+		entries := rec.reset()
+		require.Len(t, entries, 1)
+		assert.Equal(t, http.StatusInternalServerError, entries[0].Data["status"])
+	})
+}
@@ -1,9 +1,20 @@
 package application
 
-import "github.com/fabric8-services/fabric8-build/build"
+import (
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/idempotency"
+	"github.com/fabric8-services/fabric8-build/quota"
+	"github.com/fabric8-services/fabric8-build/space"
+)
 
 type Application interface {
 	PipelineEnvMap() build.Repository
+	PipelineEnvMapRevisions() build.RevisionRepository
+	PipelineEnvMapChanges() build.ChangeRepository
+	PipelineDefinitions() build.DefinitionRepository
+	IdempotencyKeys() idempotency.Repository
+	MissingSpaces() space.MissingRepository
+	SpaceQuotas() quota.Repository
 }
 
 type Transaction interface {
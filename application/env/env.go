@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/fabric8-services/fabric8-build/application/env/envservice"
 	"github.com/fabric8-services/fabric8-build/application/rest"
+	"github.com/fabric8-services/fabric8-build/application/tracing"
 	"github.com/fabric8-services/fabric8-build/configuration"
 	commonerr "github.com/fabric8-services/fabric8-common/errors"
 	"github.com/fabric8-services/fabric8-common/goasupport"
@@ -30,6 +31,10 @@ type ENVServiceImpl struct {
 
 // GetEnvList talks to the ENV service and return the list of env's in a given space
 func (s *ENVServiceImpl) GetEnvList(ctx context.Context, spaceID string) (envs []Environment, e error) {
+	ctx, span := tracing.Start(ctx, "env.GetEnvList")
+	span.SetAttribute("space_id", spaceID)
+	defer func() { span.End(e) }()
+
 	remoteENVService, err := s.createClientWithContextSigner(ctx)
 	if err != nil {
 		return nil, err
@@ -95,7 +100,11 @@ func (s *ENVServiceImpl) createClient() (*envservice.Client, error) {
 		return nil, err
 	}
 
-	c := envservice.New(s.doer)
+	doer := s.doer
+	if doer == nil {
+		doer = rest.DefaultHttpDoer()
+	}
+	c := envservice.New(doer)
 	c.Host = u.Host
 	c.Scheme = u.Scheme
 	return c, nil
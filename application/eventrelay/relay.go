@@ -0,0 +1,105 @@
+// Package eventrelay publishes the changes of the pipeline environment maps
+// recorded in the database, which serves as an outbox: a change is
+// published after the commit of its transaction, even if the publisher was
+// unavailable at the time.
+package eventrelay
+
+import (
+	"context"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/events"
+)
+
+// DefaultBatchSize is the number of changes published in a transaction
+const DefaultBatchSize = 100
+
+// Relay publishes the changes not yet published, in their order. A single
+// instance of the service relays the changes at once, and a change is
+// published again if its publication could not be recorded.
+type Relay struct {
+	db        application.DB
+	publisher events.Publisher
+	batchSize int
+}
+
+// New returns a relay publishing the changes with the given publisher
+func New(db application.DB, publisher events.Publisher) *Relay {
+	return &Relay{
+		db:        db,
+		publisher: publisher,
+		batchSize: DefaultBatchSize,
+	}
+}
+
+// Relay publishes the pending changes until there is none, returning the
+// number of changes published. It stops at the first failure of the
+// publisher, so that the changes of a map are never published out of order.
+func (r *Relay) Relay(ctx context.Context) (int, error) {
+	var total int
+	for {
+		published, done, err := r.relayBatch(ctx)
+		total += published
+		if err != nil || done {
+			return total, err
+		}
+	}
+}
+
+// relayBatch publishes a batch of changes, returning true when there are no
+// more changes to publish or another instance is publishing them
+func (r *Relay) relayBatch(ctx context.Context) (int, bool, error) {
+	var published []int64
+	var done bool
+	var perr error
+	err := application.Transactional(r.db, func(appl application.Application) error {
+		locked, err := appl.PipelineEnvMapChanges().LockPublishing(ctx)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			done = true
+			return nil
+		}
+		changes, err := appl.PipelineEnvMapChanges().ListUnpublished(ctx, r.batchSize)
+		if err != nil {
+			return err
+		}
+		done = len(changes) < r.batchSize
+		for _, change := range changes {
+			e, err := events.NewMapChangeEvent(change)
+			if err == nil {
+				err = r.publisher.Publish(ctx, e)
+			}
+			if err != nil {
+				// the publication of the previous changes is still recorded
+				perr = err
+				break
+			}
+			published = append(published, change.Seq)
+		}
+		return appl.PipelineEnvMapChanges().MarkPublished(ctx, published, time.Now())
+	})
+	if err != nil {
+		return 0, true, err
+	}
+	return len(published), done || perr != nil, perr
+}
+
+// Discard marks the pending changes as published without publishing them,
+// returning the number of changes discarded. It runs when no publisher is
+// configured, so that enabling one later doesn't publish the whole history
+// of the changes.
+func Discard(ctx context.Context, db application.DB) (int64, error) {
+	var discarded int64
+	err := application.Transactional(db, func(appl application.Application) error {
+		locked, err := appl.PipelineEnvMapChanges().LockPublishing(ctx)
+		if err != nil || !locked {
+			return err
+		}
+		discarded, err = appl.PipelineEnvMapChanges().DiscardUnpublished(ctx, time.Now())
+		return err
+	})
+	return discarded, err
+}
@@ -0,0 +1,145 @@
+package eventrelay_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/application/eventrelay"
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-build/events"
+	"github.com/fabric8-services/fabric8-build/gormapp"
+	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	errs "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// recorder keeps the published events, failing on the event of the given ID
+type recorder struct {
+	events []*events.Event
+	failOn string
+}
+
+func (r *recorder) Publish(ctx context.Context, e *events.Event) error {
+	if e.ID == r.failOn {
+		return errs.New("the broker is unavailable")
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+// of returns the types of the events of the given space
+func (r *recorder) of(spaceID uuid.UUID) []string {
+	var types []string
+	for _, e := range r.events {
+		if strings.Contains(e.Source, spaceID.String()) {
+			types = append(types, e.Type)
+		}
+	}
+	return types
+}
+
+type RelaySuite struct {
+	testsuite.DBTestSuite
+	db *gormapp.GormDB
+}
+
+func TestRelay(t *testing.T) {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	suite.Run(t, &RelaySuite{DBTestSuite: testsuite.NewDBTestSuite(config)})
+}
+
+func (s *RelaySuite) SetupSuite() {
+	s.DBTestSuite.SetupSuite()
+	s.db = gormapp.NewGormDB(s.DB)
+}
+
+// changeMap creates a map in the given space, updates it and deletes it
+func (s *RelaySuite) changeMap(spaceID uuid.UUID) {
+	ctx := context.Background()
+	name := "relay-" + spaceID.String()
+	env1ID, env2ID := uuid.NewV4(), uuid.NewV4()
+	err := application.Transactional(s.db, func(appl application.Application) error {
+		ppl, err := appl.PipelineEnvMap().Create(ctx, &build.PipelineEnvMap{
+			Name:         &name,
+			SpaceID:      &spaceID,
+			Environments: []build.PipelineEnvironment{{EnvironmentID: &env1ID}},
+		})
+		if err != nil {
+			return err
+		}
+		ppl.Environments = []build.PipelineEnvironment{{EnvironmentID: &env2ID}}
+		if _, err := appl.PipelineEnvMap().Save(ctx, ppl); err != nil {
+			return err
+		}
+		return appl.PipelineEnvMap().Delete(ctx, ppl.ID)
+	})
+	require.NoError(s.T(), err)
+}
+
+func (s *RelaySuite) TestRelay() {
+	ctx := context.Background()
+	spaceID := uuid.NewV4()
+	s.changeMap(spaceID)
+
+	s.T().Run("published in order", func(t *testing.T) {
+		r := &recorder{}
+		_, err := eventrelay.New(s.db, r).Relay(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{events.TypeMapCreated, events.TypeMapUpdated, events.TypeMapDeleted}, r.of(spaceID))
+	})
+
+	s.T().Run("published once", func(t *testing.T) {
+		r := &recorder{}
+		_, err := eventrelay.New(s.db, r).Relay(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, r.of(spaceID))
+	})
+}
+
+func (s *RelaySuite) TestRelayFailure() {
+	ctx := context.Background()
+	spaceID := uuid.NewV4()
+	s.changeMap(spaceID)
+	changes, err := s.db.PipelineEnvMapChanges().List(ctx, spaceID, 0, 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), changes, 3)
+
+	// the broker fails on the update
+	failing := &recorder{failOn: changes[1].Cursor()}
+	_, err = eventrelay.New(s.db, failing).Relay(ctx)
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), []string{events.TypeMapCreated}, failing.of(spaceID))
+
+	// the update is published again, after the create
+	r := &recorder{}
+	_, err = eventrelay.New(s.db, r).Relay(ctx)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{events.TypeMapUpdated, events.TypeMapDeleted}, r.of(spaceID))
+}
+
+func (s *RelaySuite) TestDiscard() {
+	ctx := context.Background()
+	spaceID := uuid.NewV4()
+	s.changeMap(spaceID)
+
+	discarded, err := eventrelay.Discard(ctx, s.db)
+	require.NoError(s.T(), err)
+	assert.True(s.T(), discarded >= 3)
+
+	// the discarded changes are not published anymore
+	r := &recorder{}
+	_, err = eventrelay.New(s.db, r).Relay(ctx)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), r.of(spaceID))
+}
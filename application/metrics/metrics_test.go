@@ -0,0 +1,97 @@
+package metrics_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/application/metrics"
+	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gather returns the metric families of the registry by name
+func gather(t *testing.T, reg *prometheus.Registry) map[string]*dto.MetricFamily {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	m := map[string]*dto.MetricFamily{}
+	for _, f := range families {
+		m[f.GetName()] = f
+	}
+	return m
+}
+
+func labels(m *dto.Metric) map[string]string {
+	l := map[string]string{}
+	for _, p := range m.GetLabel() {
+		l[p.GetName()] = p.GetValue()
+	}
+	return l
+}
+
+func TestSink(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := metrics.NewSink(reg)
+	require.NoError(t, err)
+
+	sink.AddSample([]string{"goa", "db", "pipeline_env_maps", "create", "success"}, 20)
+	sink.AddSample([]string{"goa", "db", "pipeline_env_maps", "create", "success"}, 40)
+	sink.AddSample([]string{"goa", "db", "pipeline_env_maps", "load", "not_found"}, 5)
+	sink.AddSample([]string{"goa", "db", "pipeline_env_maps", "list"}, 5)
+	sink.AddSample([]string{"goa", "request", "show"}, 12)
+	sink.IncrCounter([]string{"goa", "requests"}, 2)
+	sink.SetGauge([]string{"goa", "workers"}, 3)
+
+	families := gather(t, reg)
+
+	durations := families["fabric8_build_service_db_operation_duration_seconds"]
+	require.NotNil(t, durations)
+	byOperation := map[string]*dto.Metric{}
+	for _, m := range durations.GetMetric() {
+		l := labels(m)
+		assert.Equal(t, "pipeline_env_maps", l["table"])
+		byOperation[l["operation"]+"/"+l["outcome"]] = m
+	}
+	require.Len(t, byOperation, 3)
+	create := byOperation["create/success"]
+	require.NotNil(t, create)
+	assert.Equal(t, uint64(2), create.GetHistogram().GetSampleCount())
+	assert.InDelta(t, 0.06, create.GetHistogram().GetSampleSum(), 0.0001)
+	assert.NotNil(t, byOperation["load/not_found"])
+	assert.NotNil(t, byOperation["list/"+metrics.OutcomeUnknown])
+
+	samples := families["fabric8_build_service_goa_samples"]
+	require.NotNil(t, samples)
+	require.Len(t, samples.GetMetric(), 1)
+	assert.Equal(t, "goa.request.show", labels(samples.GetMetric()[0])["key"])
+
+	counters := families["fabric8_build_service_goa_counters_total"]
+	require.NotNil(t, counters)
+	assert.Equal(t, float64(2), counters.GetMetric()[0].GetCounter().GetValue())
+
+	gauges := families["fabric8_build_service_goa_gauges"]
+	require.NotNil(t, gauges)
+	assert.Equal(t, float64(3), gauges.GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestDBStatsCollector(t *testing.T) {
+	// the connection pool is not opened before it is used
+	db, err := sql.Open("postgres", "host=localhost dbname=unused")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(7)
+
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(metrics.NewDBStatsCollector(db)))
+
+	families := gather(t, reg)
+	maxOpen := families["fabric8_build_service_db_pool_max_open_connections"]
+	require.NotNil(t, maxOpen)
+	assert.Equal(t, float64(7), maxOpen.GetMetric()[0].GetGauge().GetValue())
+	open := families["fabric8_build_service_db_pool_open_connections"]
+	require.NotNil(t, open)
+	assert.Equal(t, float64(0), open.GetMetric()[0].GetGauge().GetValue())
+	assert.NotNil(t, families["fabric8_build_service_db_pool_wait_total"])
+}
@@ -0,0 +1,130 @@
+// Package metrics exposes the goa metrics, such as the timings of the
+// repository operations, and the database connection pool statistics to
+// Prometheus.
+package metrics
+
+import (
+	"strings"
+
+	gometrics "github.com/armon/go-metrics"
+	"github.com/goadesign/goa"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "fabric8_build_service"
+
+// OutcomeUnknown is the outcome of the repository operations measured
+// without one
+const OutcomeUnknown = "unknown"
+
+// Sink is a go-metrics sink recording the goa metrics in Prometheus. The
+// timings of the repository operations, measured with the
+// `goa.db.<table>.<operation>[.<outcome>]` keys, are recorded in a histogram,
+// the other metrics are recorded by key.
+type Sink struct {
+	dbDurations *prometheus.HistogramVec
+	samples     *prometheus.SummaryVec
+	counters    *prometheus.CounterVec
+	gauges      *prometheus.GaugeVec
+}
+
+var _ gometrics.MetricSink = &Sink{}
+
+// NewSink returns a sink whose metrics are registered in the given registry
+func NewSink(reg prometheus.Registerer) (*Sink, error) {
+	s := &Sink{
+		dbDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_operation_duration_seconds",
+			Help:      "Duration of the repository operations, by table, operation and outcome.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+		}, []string{"table", "operation", "outcome"}),
+		samples: prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace: namespace,
+			Name:      "goa_samples",
+			Help:      "Samples recorded with goa, by key.",
+		}, []string{"key"}),
+		counters: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "goa_counters_total",
+			Help:      "Counters incremented with goa, by key.",
+		}, []string{"key"}),
+		gauges: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "goa_gauges",
+			Help:      "Gauges set with goa, by key.",
+		}, []string{"key"}),
+	}
+	for _, c := range []prometheus.Collector{s.dbDurations, s.samples, s.counters, s.gauges} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Setup configures goa to send its metrics to a sink registered in the
+// given registry
+func Setup(reg prometheus.Registerer) (*Sink, error) {
+	s, err := NewSink(reg)
+	if err != nil {
+		return nil, err
+	}
+	conf := gometrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableHostnameLabel = false
+	conf.EnableRuntimeMetrics = false
+	if err := goa.NewMetrics(conf, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetGauge sets the gauge of the given key
+func (s *Sink) SetGauge(key []string, val float32) {
+	s.gauges.WithLabelValues(flatten(key)).Set(float64(val))
+}
+
+// SetGaugeWithLabels sets the gauge of the given key, the labels are ignored
+func (s *Sink) SetGaugeWithLabels(key []string, val float32, labels []gometrics.Label) {
+	s.SetGauge(key, val)
+}
+
+// EmitKey records the value as a gauge
+func (s *Sink) EmitKey(key []string, val float32) {
+	s.SetGauge(key, val)
+}
+
+// IncrCounter increments the counter of the given key
+func (s *Sink) IncrCounter(key []string, val float32) {
+	s.counters.WithLabelValues(flatten(key)).Add(float64(val))
+}
+
+// IncrCounterWithLabels increments the counter of the given key, the labels
+// are ignored
+func (s *Sink) IncrCounterWithLabels(key []string, val float32, labels []gometrics.Label) {
+	s.IncrCounter(key, val)
+}
+
+// AddSample records a sample. The samples of the repository operations are
+// timings in milliseconds.
+func (s *Sink) AddSample(key []string, val float32) {
+	if len(key) >= 4 && len(key) <= 5 && key[0] == "goa" && key[1] == "db" {
+		outcome := OutcomeUnknown
+		if len(key) == 5 {
+			outcome = key[4]
+		}
+		s.dbDurations.WithLabelValues(key[2], key[3], outcome).Observe(float64(val) / 1000)
+		return
+	}
+	s.samples.WithLabelValues(flatten(key)).Observe(float64(val))
+}
+
+// AddSampleWithLabels records a sample, the labels are ignored
+func (s *Sink) AddSampleWithLabels(key []string, val float32, labels []gometrics.Label) {
+	s.AddSample(key, val)
+}
+
+func flatten(key []string) string {
+	return strings.Join(key, ".")
+}
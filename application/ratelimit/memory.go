@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore keeps the buckets in memory, each replica limiting the
+// requests it serves
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(ctx context.Context, buckets []Bucket, now time.Time) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := make([]float64, len(buckets))
+	for i, bk := range buckets {
+		b, ok := s.buckets[bk.Key]
+		if !ok {
+			b = &bucket{tokens: float64(bk.Limit.Burst), last: now}
+			s.buckets[bk.Key] = b
+		}
+		var allowed bool
+		var wait time.Duration
+		tokens[i], allowed, wait = take(b.tokens, b.last, now, bk.Limit)
+		if !allowed {
+			return i, wait, nil
+		}
+	}
+	for i, bk := range buckets {
+		b := s.buckets[bk.Key]
+		b.tokens = tokens[i]
+		if now.After(b.last) {
+			b.last = now
+		}
+	}
+	return -1, 0, nil
+}
+
+// Prune implements Store
+func (s *MemoryStore) Prune(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pruned int64
+	for key, b := range s.buckets {
+		if b.last.Before(before) {
+			delete(s.buckets, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
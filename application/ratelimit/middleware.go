@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/fabric8-services/fabric8-common/token"
+	"github.com/goadesign/goa"
+	"github.com/prometheus/client_golang/prometheus"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Scopes of the limits
+const (
+	ScopeIdentity = "identity"
+	ScopeSpace    = "space"
+)
+
+var rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "fabric8_build_service",
+	Name:      "rate_limited_requests_total",
+	Help:      "Requests rejected by the rate limiter, by action and scope.",
+}, []string{"action", "scope"})
+
+func init() {
+	prometheus.MustRegister(rejected)
+}
+
+// Options configure the rate limiter
+type Options struct {
+	Store Store
+	// IdentityLimits returns the limits of the requests of each identity,
+	// called on each request so that the limits can change
+	IdentityLimits func() Limits
+	// SpaceLimits returns the limits of the requests on each space,
+	// identified by the spaceID parameter
+	SpaceLimits func() Limits
+	// TokenManager locates the identity of the requests
+	TokenManager token.Manager
+	// Now returns the current time, time.Now if nil
+	Now func() time.Time
+}
+
+// Middleware returns a goa middleware rejecting the requests exceeding the
+// limits of their identity or space with a 429 (Too Many Requests) response.
+// The tokens of both buckets are taken at once, so that a request rejected
+// by one of the limits doesn't count against the other one. It must be
+// mounted after the token middlewares. The store failures are logged and the
+// requests let through.
+func Middleware(opts Options) goa.Middleware {
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			action := goa.ContextAction(ctx)
+			var buckets []Bucket
+			var scopes []string
+			if limit, ok := opts.IdentityLimits().For(action); ok && opts.TokenManager != nil {
+				if id, err := opts.TokenManager.Locate(ctx); err == nil {
+					buckets = append(buckets, Bucket{Key: bucketKey(ScopeIdentity, action, id.String()), Limit: limit})
+					scopes = append(scopes, ScopeIdentity)
+				}
+			}
+			if limit, ok := opts.SpaceLimits().For(action); ok {
+				if r := goa.ContextRequest(ctx); r != nil {
+					if spaceID := r.Params.Get("spaceID"); spaceID != "" {
+						buckets = append(buckets, Bucket{Key: bucketKey(ScopeSpace, action, spaceID), Limit: limit})
+						scopes = append(scopes, ScopeSpace)
+					}
+				}
+			}
+			if rejected, wait := check(ctx, opts.Store, buckets, now()); rejected >= 0 {
+				return tooManyRequests(ctx, rw, scopes[rejected], action, wait)
+			}
+			return h(ctx, rw, req)
+		}
+	}
+}
+
+// bucketKey returns the key of the bucket of the given scope, action and ID
+func bucketKey(scope, action, id string) string {
+	return scope + ":" + action + ":" + id
+}
+
+// check takes a token from each of the given buckets, returning the index
+// of the bucket rejecting the request, -1 if it is allowed
+func check(ctx context.Context, store Store, buckets []Bucket, now time.Time) (int, time.Duration) {
+	if len(buckets) == 0 {
+		return -1, 0
+	}
+	rejected, wait, err := store.Take(ctx, buckets, now)
+	if err != nil {
+		keys := make([]string, 0, len(buckets))
+		for _, b := range buckets {
+			keys = append(keys, b.Key)
+		}
+		log.Error(ctx, map[string]interface{}{
+			"err":  err,
+			"keys": keys,
+		}, "unable to check the rate limit, letting the request through")
+		return -1, 0
+	}
+	return rejected, wait
+}
+
+type jsonAPIError struct {
+	ID     string                 `json:"id"`
+	Status string                 `json:"status"`
+	Code   string                 `json:"code"`
+	Title  string                 `json:"title"`
+	Detail string                 `json:"detail"`
+	Meta   map[string]interface{} `json:"meta,omitempty"`
+}
+
+// tooManyRequests writes a 429 response with the JSONAPI error and the
+// number of seconds to wait before retrying
+func tooManyRequests(ctx context.Context, rw http.ResponseWriter, scope, action string, wait time.Duration) error {
+	rejected.WithLabelValues(action, scope).Inc()
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	body := map[string][]jsonAPIError{
+		"errors": {{
+			ID:     uuid.NewV4().String(),
+			Status: strconv.Itoa(http.StatusTooManyRequests),
+			Code:   "too_many_requests_error",
+			Title:  "Too Many Requests",
+			Detail: fmt.Sprintf("rate limit of the %s exceeded for the %s action, retry in %d seconds", scope, action, retryAfter),
+			Meta: map[string]interface{}{
+				"scope":       scope,
+				"retry_after": retryAfter,
+			},
+		}},
+	}
+	rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	rw.Header().Set("Content-Type", "application/vnd.jsonapierrors+json")
+	rw.WriteHeader(http.StatusTooManyRequests)
+	return json.NewEncoder(rw).Encode(body)
+}
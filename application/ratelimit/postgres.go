@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PostgresStore keeps the buckets in the rate_limit_buckets table, so that
+// all the replicas share the same limits
+type PostgresStore struct {
+	db *sql.DB
+}
+
+var _ Store = &PostgresStore{}
+
+// NewPostgresStore returns a store using the given database
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Take implements Store, the rows of the buckets being locked during the
+// update in the order of their keys, so that concurrent requests taking
+// from the same buckets don't deadlock
+func (s *PostgresStore) Take(ctx context.Context, buckets []Bucket, now time.Time) (rejected int, wait time.Duration, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return -1, 0, errors.Wrap(err, "unable to start the transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = errors.Wrap(tx.Commit(), "unable to commit the transaction")
+	}()
+
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return buckets[order[a]].Key < buckets[order[b]].Key })
+	tokens := make([]float64, len(buckets))
+	lasts := make([]time.Time, len(buckets))
+	rejected = -1
+	for _, i := range order {
+		key, limit := buckets[i].Key, buckets[i].Limit
+		_, err = tx.Exec(`INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ($1, $2, $3)
+			ON CONFLICT (key) DO NOTHING`, key, float64(limit.Burst), now)
+		if err != nil {
+			return -1, 0, errors.Wrapf(err, "unable to create the bucket %s", key)
+		}
+		err = tx.QueryRow("SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE", key).Scan(&tokens[i], &lasts[i])
+		if err != nil {
+			return -1, 0, errors.Wrapf(err, "unable to read the bucket %s", key)
+		}
+		var allowed bool
+		var w time.Duration
+		tokens[i], allowed, w = take(tokens[i], lasts[i], now, limit)
+		if !allowed && (rejected < 0 || i < rejected) {
+			rejected, wait = i, w
+		}
+	}
+	if rejected >= 0 {
+		return rejected, wait, nil
+	}
+	for i, b := range buckets {
+		last := lasts[i]
+		if now.After(last) {
+			last = now
+		}
+		_, err = tx.Exec("UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3 WHERE key = $1", b.Key, tokens[i], last)
+		if err != nil {
+			return -1, 0, errors.Wrapf(err, "unable to update the bucket %s", b.Key)
+		}
+	}
+	return -1, 0, nil
+}
+
+// Prune implements Store
+func (s *PostgresStore) Prune(before time.Time) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM rate_limit_buckets WHERE updated_at < $1", before)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to prune the buckets")
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,76 @@
+// Package ratelimit limits the rate of the requests of each identity and on
+// each space with token buckets, per action.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit is the refill rate and the capacity of a token bucket
+type Limit struct {
+	// Rate is the number of tokens added per second
+	Rate float64
+	// Burst is the capacity of the bucket
+	Burst int
+}
+
+// DefaultAction is the key of the limit applied to the actions without
+// their own limit
+const DefaultAction = "*"
+
+// Limits are the limits by action name
+type Limits map[string]Limit
+
+// For returns the limit of the given action, falling back to the default one
+func (l Limits) For(action string) (Limit, bool) {
+	if limit, ok := l[action]; ok {
+		return limit, true
+	}
+	limit, ok := l[DefaultAction]
+	return limit, ok
+}
+
+// Bucket is the key and the limit of a token bucket
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+// Store keeps the token buckets
+type Store interface {
+	// Take takes a token from each of the given buckets, or from none of
+	// them when one is empty. It then returns the index of the first empty
+	// bucket and the time until a token is available in it, and otherwise
+	// -1.
+	Take(ctx context.Context, buckets []Bucket, now time.Time) (int, time.Duration, error)
+	// Prune drops the buckets not used since the given time, which are
+	// full again when the time is older than the longest refill.
+	Prune(before time.Time) (int64, error)
+}
+
+// take refills a bucket holding the given tokens since the given time and
+// takes a token. It returns the remaining tokens, whether a token was taken
+// and otherwise the time until a token is available.
+func take(tokens float64, last, now time.Time, limit Limit) (float64, bool, time.Duration) {
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+	}
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+	wait := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	return tokens, false, wait
+}
+
+// MaxRefill returns the longest time taken to refill an empty bucket
+func (l Limits) MaxRefill() time.Duration {
+	var max time.Duration
+	for _, limit := range l {
+		if d := time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second)); d > max {
+			max = d
+		}
+	}
+	return max
+}
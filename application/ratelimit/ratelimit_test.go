@@ -0,0 +1,193 @@
+package ratelimit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/ratelimit"
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-common/resource"
+	testauth "github.com/fabric8-services/fabric8-common/test/auth"
+	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	"github.com/fabric8-services/fabric8-common/token"
+	"github.com/goadesign/goa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLimits(t *testing.T) {
+	resource.Require(t, resource.UnitTest)
+
+	limits := ratelimit.Limits{"*": {Rate: 10, Burst: 10}, "create": {Rate: 1, Burst: 2}}
+	create, ok := limits.For("create")
+	require.True(t, ok)
+	assert.Equal(t, 2, create.Burst)
+	other, ok := limits.For("list")
+	require.True(t, ok)
+	assert.Equal(t, 10, other.Burst)
+	assert.Equal(t, 2*time.Second, limits.MaxRefill())
+
+	_, ok = ratelimit.Limits{"create": {Rate: 1, Burst: 2}}.For("list")
+	assert.False(t, ok)
+}
+
+// testStore checks the token bucket behaviour of a store
+func testStore(t *testing.T, store ratelimit.Store, key string) {
+	ctx := context.Background()
+	limit := ratelimit.Limit{Rate: 2, Burst: 3}
+	// postgres keeps microseconds
+	now := time.Now().Truncate(time.Millisecond)
+	take := func(at time.Time, buckets ...ratelimit.Bucket) (bool, time.Duration) {
+		buckets = append([]ratelimit.Bucket{{Key: key, Limit: limit}}, buckets...)
+		rejected, wait, err := store.Take(ctx, buckets, at)
+		require.NoError(t, err)
+		return rejected < 0, wait
+	}
+
+	// the burst is allowed
+	for i := 0; i < 3; i++ {
+		ok, _ := take(now)
+		assert.True(t, ok, "request %d", i)
+	}
+	// then the bucket is empty
+	ok, wait := take(now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// no token is taken from the other buckets when one is empty
+	other := ratelimit.Bucket{Key: key + ":other", Limit: ratelimit.Limit{Rate: 1, Burst: 1}}
+	ok, _ = take(now, other)
+	assert.False(t, ok)
+	rejected, _, err := store.Take(ctx, []ratelimit.Bucket{other}, now)
+	require.NoError(t, err)
+	assert.Equal(t, -1, rejected)
+	rejected, wait, err = store.Take(ctx, []ratelimit.Bucket{{Key: key, Limit: limit}, other}, now.Add(500*time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, 1, rejected)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// refilled at the rate
+	ok, _ = take(now.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+	ok, _ = take(now.Add(500 * time.Millisecond))
+	assert.False(t, ok)
+
+	// idle buckets are pruned
+	pruned, err := store.Prune(now.Add(time.Second))
+	require.NoError(t, err)
+	assert.True(t, pruned >= 1)
+	ok, _ = take(now.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+}
+
+func TestMemoryStore(t *testing.T) {
+	resource.Require(t, resource.UnitTest)
+	testStore(t, ratelimit.NewMemoryStore(), "identity:create:foo")
+}
+
+type PostgresStoreSuite struct {
+	testsuite.DBTestSuite
+}
+
+func TestPostgresStore(t *testing.T) {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	suite.Run(t, &PostgresStoreSuite{DBTestSuite: testsuite.NewDBTestSuite(config)})
+}
+
+func (s *PostgresStoreSuite) TestTake() {
+	testStore(s.T(), ratelimit.NewPostgresStore(s.DB.DB()), "identity:create:"+time.Now().String())
+}
+
+func TestMiddleware(t *testing.T) {
+	resource.Require(t, resource.UnitTest)
+
+	identity := testauth.NewIdentity()
+	svc, err := testauth.ServiceAsUser("ratelimit-test", identity)
+	require.NoError(t, err)
+	tokenMgr, err := token.ReadManagerFromContext(svc.Context)
+	require.NoError(t, err)
+
+	now := time.Now()
+	// handler returns a handler limited by the given limits
+	handler := func(identityLimits, spaceLimits ratelimit.Limits) goa.Handler {
+		mw := ratelimit.Middleware(ratelimit.Options{
+			Store:          ratelimit.NewMemoryStore(),
+			IdentityLimits: func() ratelimit.Limits { return identityLimits },
+			SpaceLimits:    func() ratelimit.Limits { return spaceLimits },
+			TokenManager:   tokenMgr,
+			Now:            func() time.Time { return now },
+		})
+		return mw(func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			rw.WriteHeader(http.StatusOK)
+			return nil
+		})
+	}
+	serveWith := func(ctx context.Context, h goa.Handler, action, spaceID string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/spaces/"+spaceID+"/pipeline-environment-maps", nil)
+		params := url.Values{"spaceID": []string{spaceID}}
+		ctx = goa.WithAction(goa.NewContext(ctx, rw, req, params), action)
+		require.NoError(t, h(ctx, goa.ContextResponse(ctx), req))
+		return rw
+	}
+	h := handler(ratelimit.Limits{"create": {Rate: 1, Burst: 2}}, ratelimit.Limits{"*": {Rate: 0.1, Burst: 3}})
+	serve := func(ctx context.Context, action, spaceID string) *httptest.ResponseRecorder {
+		return serveWith(ctx, h, action, spaceID)
+	}
+
+	t.Run("identity", func(t *testing.T) {
+		spaces := []string{"a", "b", "c"}
+		for _, space := range spaces[:2] {
+			assert.Equal(t, http.StatusOK, serve(svc.Context, "create", space).Code)
+		}
+		rw := serve(svc.Context, "create", spaces[2])
+		assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+		assert.Equal(t, "1", rw.Header().Get("Retry-After"))
+		assert.Equal(t, "application/vnd.jsonapierrors+json", rw.Header().Get("Content-Type"))
+		var body struct {
+			Errors []struct {
+				Status string                 `json:"status"`
+				Code   string                 `json:"code"`
+				Detail string                 `json:"detail"`
+				Meta   map[string]interface{} `json:"meta"`
+			} `json:"errors"`
+		}
+		require.NoError(t, json.Unmarshal(rw.Body.Bytes(), &body))
+		require.Len(t, body.Errors, 1)
+		assert.Equal(t, "429", body.Errors[0].Status)
+		assert.Equal(t, ratelimit.ScopeIdentity, body.Errors[0].Meta["scope"])
+
+		// other actions have no identity limit
+		assert.Equal(t, http.StatusOK, serve(svc.Context, "list", "d").Code)
+	})
+
+	t.Run("space", func(t *testing.T) {
+		// anonymous requests are only limited by space
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, serve(context.Background(), "list", "e").Code)
+		}
+		rw := serve(context.Background(), "list", "e")
+		assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+		assert.Equal(t, "10", rw.Header().Get("Retry-After"))
+		assert.Equal(t, http.StatusOK, serve(context.Background(), "list", "f").Code)
+	})
+	t.Run("rejected by the space only", func(t *testing.T) {
+		h := handler(ratelimit.Limits{"*": {Rate: 0.1, Burst: 2}}, ratelimit.Limits{"*": {Rate: 0.1, Burst: 1}})
+		assert.Equal(t, http.StatusOK, serveWith(svc.Context, h, "update", "g").Code)
+		rw := serveWith(svc.Context, h, "update", "g")
+		assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+		assert.Contains(t, rw.Body.String(), ratelimit.ScopeSpace)
+		// the rejected request didn't take the token of the identity
+		assert.Equal(t, http.StatusOK, serveWith(svc.Context, h, "update", "h").Code)
+		rw = serveWith(svc.Context, h, "update", "i")
+		assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+		assert.Contains(t, rw.Body.String(), ratelimit.ScopeIdentity)
+	})
+}
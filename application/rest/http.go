@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/fabric8-services/fabric8-build/application/tracing"
 	"github.com/goadesign/goa/client"
 )
 
@@ -28,6 +29,10 @@ func DefaultHttpDoer() HttpDoer {
 }
 
 // Do overrides Do method of the default goa client Doer. It's needed for mocking http clients in tests.
+// The request is traced and carries the trace context of ctx.
 func (d *HttpClientDoer) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
-	return d.HttpClient.Do(req)
+	done := tracing.TraceRequest(ctx, req)
+	resp, err := d.HttpClient.Do(req)
+	done(resp, err)
+	return resp, err
 }
@@ -0,0 +1,91 @@
+// Package serviceaccount recognizes the tokens of the service accounts
+// allowed to call the internal endpoints.
+package serviceaccount
+
+import (
+	"context"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/fabric8-services/fabric8-common/errors"
+	goajwt "github.com/goadesign/goa/middleware/security/jwt"
+)
+
+// NameClaim is the claim holding the name of the service account in the
+// tokens issued by auth
+const NameClaim = "service_accountname"
+
+// DefaultNames are the service accounts allowed when none is configured
+var DefaultNames = []string{"fabric8-wit"}
+
+// Account is the service account a token was issued to
+type Account struct {
+	ID   string
+	Name string
+}
+
+// FromToken returns the service account of the given token, false if it
+// isn't a service account token
+func FromToken(token *jwt.Token) (*Account, bool) {
+	if token == nil {
+		return nil, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, false
+	}
+	name, _ := claims[NameClaim].(string)
+	if name == "" {
+		return nil, false
+	}
+	id, _ := claims["sub"].(string)
+	return &Account{ID: id, Name: name}, true
+}
+
+// Authorizer allows the service accounts with the configured names or IDs
+type Authorizer struct {
+	names map[string]struct{}
+	ids   map[string]struct{}
+}
+
+// NewAuthorizer returns an authorizer allowing the service accounts with
+// one of the given names or IDs
+func NewAuthorizer(names, ids []string) *Authorizer {
+	a := &Authorizer{
+		names: make(map[string]struct{}, len(names)),
+		ids:   make(map[string]struct{}, len(ids)),
+	}
+	for _, name := range names {
+		a.names[name] = struct{}{}
+	}
+	for _, id := range ids {
+		a.ids[id] = struct{}{}
+	}
+	return a
+}
+
+// Allowed returns true if the given service account is allowed
+func (a *Authorizer) Allowed(account *Account) bool {
+	if account == nil {
+		return false
+	}
+	if _, ok := a.names[account.Name]; ok {
+		return true
+	}
+	_, ok := a.ids[account.ID]
+	return ok && account.ID != ""
+}
+
+// Authorize returns the service account of the token of the given context.
+// It returns an unauthorized error if the request has no token and a
+// forbidden error if the token isn't the one of an allowed service account.
+func (a *Authorizer) Authorize(ctx context.Context) (*Account, error) {
+	token := goajwt.ContextJWT(ctx)
+	if token == nil {
+		return nil, errors.NewUnauthorizedError("missing token")
+	}
+	account, ok := FromToken(token)
+	if !ok || !a.Allowed(account) {
+		return nil, errors.NewForbiddenError("restricted to the service accounts")
+	}
+	return account, nil
+}
@@ -0,0 +1,74 @@
+package serviceaccount_test
+
+import (
+	"context"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/fabric8-services/fabric8-build/application/serviceaccount"
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/fabric8-services/fabric8-common/resource"
+	goajwt "github.com/goadesign/goa/middleware/security/jwt"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// contextWithToken returns a context holding a token with the given claims,
+// signed with the dev-mode key and parsed back as the token middleware does
+func contextWithToken(t *testing.T, claims jwt.MapClaims) context.Context {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	pem := config.GetDevModePrivateKey()
+	require.NotNil(t, pem, "requires F8_DEVELOPER_MODE_ENABLED")
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+	require.NoError(t, err)
+
+	raw, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	require.NoError(t, err)
+	token, err := jwt.Parse(raw, func(*jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	require.NoError(t, err)
+	return goajwt.WithJWT(context.Background(), token)
+}
+
+func TestAuthorize(t *testing.T) {
+	resource.Require(t, resource.UnitTest)
+
+	saID := uuid.NewV4().String()
+	authz := serviceaccount.NewAuthorizer([]string{"fabric8-wit"}, []string{saID})
+
+	t.Run("allowed by name", func(t *testing.T) {
+		ctx := contextWithToken(t, jwt.MapClaims{"sub": uuid.NewV4().String(), serviceaccount.NameClaim: "fabric8-wit"})
+		account, err := authz.Authorize(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "fabric8-wit", account.Name)
+	})
+
+	t.Run("allowed by ID", func(t *testing.T) {
+		ctx := contextWithToken(t, jwt.MapClaims{"sub": saID, serviceaccount.NameClaim: "fabric8-admin"})
+		account, err := authz.Authorize(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, saID, account.ID)
+	})
+
+	t.Run("other service account", func(t *testing.T) {
+		ctx := contextWithToken(t, jwt.MapClaims{"sub": uuid.NewV4().String(), serviceaccount.NameClaim: "fabric8-tenant"})
+		_, err := authz.Authorize(ctx)
+		assert.IsType(t, errors.ForbiddenError{}, err)
+	})
+
+	t.Run("user", func(t *testing.T) {
+		// the ID of a user is never enough
+		ctx := contextWithToken(t, jwt.MapClaims{"sub": saID, "preferred_username": "fabric8-wit"})
+		_, err := authz.Authorize(ctx)
+		assert.IsType(t, errors.ForbiddenError{}, err)
+	})
+
+	t.Run("no token", func(t *testing.T) {
+		_, err := authz.Authorize(context.Background())
+		assert.IsType(t, errors.UnauthorizedError{}, err)
+	})
+}
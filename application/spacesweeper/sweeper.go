@@ -0,0 +1,107 @@
+// Package spacesweeper deletes the pipeline environment maps of the spaces
+// deleted in WIT.
+package spacesweeper
+
+import (
+	"context"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/application/wit"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/fabric8-services/fabric8-common/log"
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultGracePeriod is how long a space is missing from WIT before its maps
+// are deleted by default
+const DefaultGracePeriod = 72 * time.Hour
+
+// Sweeper checks that the spaces of the pipeline environment maps still
+// exist in WIT. The maps of a space not found during the whole grace period
+// are deleted, so that a transient failure of WIT doesn't delete anything.
+type Sweeper struct {
+	db          application.DB
+	wit         wit.WITService
+	gracePeriod time.Duration
+}
+
+// New returns a sweeper deleting the maps of the spaces not found for the
+// given grace period
+func New(db application.DB, witService wit.WITService, gracePeriod time.Duration) *Sweeper {
+	return &Sweeper{
+		db:          db,
+		wit:         witService,
+		gracePeriod: gracePeriod,
+	}
+}
+
+// Sweep checks all the spaces and deletes the maps of the ones missing since
+// the grace period. It returns the IDs of the spaces whose maps were deleted.
+// The context must hold a token allowed to read the spaces.
+func (s *Sweeper) Sweep(ctx context.Context, now time.Time) ([]uuid.UUID, error) {
+	spaceIDs, err := s.db.PipelineEnvMap().ListSpaceIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var purged []uuid.UUID
+	for _, spaceID := range spaceIDs {
+		if ctx.Err() != nil {
+			return purged, ctx.Err()
+		}
+		ok, err := s.sweep(ctx, spaceID, now)
+		if err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"err":      err,
+				"space_id": spaceID.String(),
+			}, "unable to check the space")
+			continue
+		}
+		if ok {
+			purged = append(purged, spaceID)
+		}
+	}
+	return purged, nil
+}
+
+// sweep checks the given space, returning true if its maps were deleted
+func (s *Sweeper) sweep(ctx context.Context, spaceID uuid.UUID, now time.Time) (bool, error) {
+	_, err := s.wit.GetSpace(ctx, spaceID.String())
+	if err == nil {
+		// found again
+		return false, s.db.MissingSpaces().Delete(ctx, spaceID)
+	}
+	if ok, _ := errors.IsNotFoundError(err); !ok {
+		return false, err
+	}
+
+	missing, err := s.db.MissingSpaces().Mark(ctx, spaceID, now)
+	if err != nil {
+		return false, err
+	}
+	if now.Sub(missing.FirstSeenAt) < s.gracePeriod {
+		log.Info(ctx, map[string]interface{}{
+			"space_id":      spaceID.String(),
+			"first_seen_at": missing.FirstSeenAt,
+		}, "space not found in WIT, waiting for the grace period")
+		return false, nil
+	}
+
+	var deleted int64
+	err = application.Transactional(s.db, func(appl application.Application) error {
+		deleted, err = appl.PipelineEnvMap().DeleteBySpace(ctx, spaceID)
+		if err != nil {
+			return err
+		}
+		return appl.MissingSpaces().Delete(ctx, spaceID)
+	})
+	if err != nil {
+		return false, err
+	}
+	log.Info(ctx, map[string]interface{}{
+		"space_id":      spaceID.String(),
+		"first_seen_at": missing.FirstSeenAt,
+		"deleted":       deleted,
+	}, "pipeline environment maps of the deleted space purged")
+	return true, nil
+}
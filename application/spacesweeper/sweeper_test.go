@@ -0,0 +1,120 @@
+package spacesweeper_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/spacesweeper"
+	"github.com/fabric8-services/fabric8-build/application/wit"
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-build/gormapp"
+	"github.com/fabric8-services/fabric8-common/errors"
+	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	errs "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// witStub answers with the space unless it is missing or WIT is failing
+type witStub struct {
+	missing map[string]bool
+	failing bool
+}
+
+func (w *witStub) GetSpace(ctx context.Context, spaceID string) (*wit.Space, error) {
+	if w.failing {
+		return nil, errs.New("WIT is unavailable")
+	}
+	if w.missing[spaceID] {
+		return nil, errors.NewNotFoundErrorFromString("Cannot find space: " + spaceID)
+	}
+	return &wit.Space{}, nil
+}
+
+type SweeperSuite struct {
+	testsuite.DBTestSuite
+	db *gormapp.GormDB
+}
+
+func TestSweeper(t *testing.T) {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	suite.Run(t, &SweeperSuite{DBTestSuite: testsuite.NewDBTestSuite(config)})
+}
+
+func (s *SweeperSuite) SetupSuite() {
+	s.DBTestSuite.SetupSuite()
+	s.db = gormapp.NewGormDB(s.DB)
+}
+
+func (s *SweeperSuite) createMap(name string, spaceID uuid.UUID) *build.PipelineEnvMap {
+	envID := uuid.NewV4()
+	ppl, err := s.db.PipelineEnvMap().Create(context.Background(), &build.PipelineEnvMap{
+		Name:         &name,
+		SpaceID:      &spaceID,
+		Environments: []build.PipelineEnvironment{{EnvironmentID: &envID}},
+	})
+	require.NoError(s.T(), err)
+	return ppl
+}
+
+func (s *SweeperSuite) TestSweep() {
+	ctx := context.Background()
+	deletedSpaceID, spaceID := uuid.NewV4(), uuid.NewV4()
+	deleted := s.createMap("sweeper-deleted", deletedSpaceID)
+	kept := s.createMap("sweeper-kept", spaceID)
+
+	stub := &witStub{missing: map[string]bool{deletedSpaceID.String(): true}}
+	sweeper := spacesweeper.New(s.db, stub, time.Hour)
+	now := time.Now()
+
+	s.T().Run("grace period", func(t *testing.T) {
+		purged, err := sweeper.Sweep(ctx, now)
+		require.NoError(t, err)
+		assert.NotContains(t, purged, deletedSpaceID)
+		_, err = s.db.PipelineEnvMap().Load(ctx, deleted.ID)
+		require.NoError(t, err)
+	})
+
+	s.T().Run("WIT failing", func(t *testing.T) {
+		stub.failing = true
+		defer func() { stub.failing = false }()
+		purged, err := sweeper.Sweep(ctx, now.Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.Empty(t, purged)
+	})
+
+	s.T().Run("purged", func(t *testing.T) {
+		purged, err := sweeper.Sweep(ctx, now.Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.Contains(t, purged, deletedSpaceID)
+		assert.NotContains(t, purged, spaceID)
+		_, err = s.db.PipelineEnvMap().Load(ctx, deleted.ID)
+		ok, _ := errors.IsNotFoundError(err)
+		assert.True(t, ok)
+		_, err = s.db.PipelineEnvMap().Load(ctx, kept.ID)
+		require.NoError(t, err)
+	})
+
+	s.T().Run("found again", func(t *testing.T) {
+		flakySpaceID := uuid.NewV4()
+		flaky := s.createMap("sweeper-flaky", flakySpaceID)
+		stub.missing[flakySpaceID.String()] = true
+		_, err := sweeper.Sweep(ctx, now)
+		require.NoError(t, err)
+		// the space is back, the grace period starts over
+		delete(stub.missing, flakySpaceID.String())
+		_, err = sweeper.Sweep(ctx, now.Add(30*time.Minute))
+		require.NoError(t, err)
+		stub.missing[flakySpaceID.String()] = true
+		purged, err := sweeper.Sweep(ctx, now.Add(90*time.Minute))
+		require.NoError(t, err)
+		assert.NotContains(t, purged, flakySpaceID)
+		_, err = s.db.PipelineEnvMap().Load(ctx, flaky.ID)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,186 @@
+// Package tlsconfig builds the TLS configuration of the HTTP listeners, with
+// optional client certificate authentication (mTLS) and automatic reload of
+// the certificates when their files change.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// Client authentication modes
+const (
+	ClientAuthNone             = "none"
+	ClientAuthRequest          = "request"
+	ClientAuthRequire          = "require"
+	ClientAuthVerifyIfGiven    = "verify-if-given"
+	ClientAuthRequireAndVerify = "require-and-verify"
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	ClientAuthNone:             tls.NoClientCert,
+	ClientAuthRequest:          tls.RequestClientCert,
+	ClientAuthRequire:          tls.RequireAnyClientCert,
+	ClientAuthVerifyIfGiven:    tls.VerifyClientCertIfGiven,
+	ClientAuthRequireAndVerify: tls.RequireAndVerifyClientCert,
+}
+
+// reloadDebounce is how long to wait after a file change before reloading,
+// so that the certificate and key are both updated
+const reloadDebounce = 500 * time.Millisecond
+
+// Options configure a TLS listener
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// ClientAuth is one of the ClientAuth* modes, it defaults to
+	// ClientAuthRequireAndVerify when a ClientCAFile is given.
+	ClientAuth string
+}
+
+// ParseClientAuth returns the tls.ClientAuthType of the given mode
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	t, ok := clientAuthTypes[mode]
+	if !ok {
+		return tls.NoClientCert, errors.Errorf("unknown client authentication mode %q", mode)
+	}
+	return t, nil
+}
+
+// Reloader holds the certificates of a TLS listener in memory and reloads
+// them from their files on demand or when the files change.
+type Reloader struct {
+	opts       Options
+	clientAuth tls.ClientAuthType
+	mu         sync.RWMutex
+	cert       *tls.Certificate
+	clientCAs  *x509.CertPool
+}
+
+// New loads the certificates and returns the TLS configuration along with
+// the Reloader keeping it up to date.
+func New(opts Options) (*tls.Config, *Reloader, error) {
+	if opts.ClientAuth == "" {
+		opts.ClientAuth = ClientAuthNone
+		if opts.ClientCAFile != "" {
+			opts.ClientAuth = ClientAuthRequireAndVerify
+		}
+	}
+	clientAuth, err := ParseClientAuth(opts.ClientAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &Reloader{opts: opts, clientAuth: clientAuth}
+	if err := r.Reload(); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+		ClientAuth:     clientAuth,
+		ClientCAs:      r.ClientCAs(),
+	}
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		// return a fresh config so that a reloaded client CA is picked up
+		c := cfg.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = r.ClientCAs()
+		return c, nil
+	}
+	return cfg, r, nil
+}
+
+// Reload loads the certificates from their files, the current ones are kept
+// if the new ones are invalid.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load the certificate %s and key %s", r.opts.CertFile, r.opts.KeyFile)
+	}
+	var pool *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read the client CA %s", r.opts.ClientCAFile)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.Errorf("no certificate found in the client CA %s", r.opts.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCAs = pool
+	return nil
+}
+
+// GetCertificate returns the current server certificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// ClientCAs returns the current pool of client certificate authorities
+func (r *Reloader) ClientCAs() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.clientCAs
+}
+
+// Watch reloads the certificates when their files change, until the context
+// is done. The parent directories are watched so that atomic replacements
+// (e.g. of mounted secrets) are detected.
+func (r *Reloader) Watch(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err}, "unable to watch the TLS certificates")
+		return
+	}
+	defer watcher.Close()
+
+	dirs := map[string]struct{}{}
+	for _, f := range []string{r.opts.CertFile, r.opts.KeyFile, r.opts.ClientCAFile} {
+		if f != "" {
+			dirs[filepath.Dir(f)] = struct{}{}
+		}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			log.Error(ctx, map[string]interface{}{"err": err, "dir": dir}, "unable to watch the TLS certificates")
+			return
+		}
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-watcher.Events:
+			reload = time.After(reloadDebounce)
+		case err := <-watcher.Errors:
+			log.Error(ctx, map[string]interface{}{"err": err}, "error while watching the TLS certificates")
+		case <-reload:
+			reload = nil
+			if err := r.Reload(); err != nil {
+				log.Error(ctx, map[string]interface{}{"err": err}, "unable to reload the TLS certificates, keeping the current ones")
+				continue
+			}
+			log.Info(ctx, map[string]interface{}{"cert_file": r.opts.CertFile}, "TLS certificates reloaded")
+		}
+	}
+}
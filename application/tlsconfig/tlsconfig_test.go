@@ -0,0 +1,107 @@
+package tlsconfig_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/tlsconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self-signed certificate and its key with the given
+// common name in dir
+func writeCert(t *testing.T, dir, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tls.crt"), certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tls.key"), keyPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ca.crt"), certPEM, 0600))
+}
+
+func commonName(t *testing.T, cfg *tls.Config) string {
+	cert, err := cfg.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeCert(t, dir, "first")
+
+	opts := tlsconfig.Options{
+		CertFile:     filepath.Join(dir, "tls.crt"),
+		KeyFile:      filepath.Join(dir, "tls.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+
+	t.Run("mtls", func(t *testing.T) {
+		cfg, _, err := tlsconfig.New(opts)
+		require.NoError(t, err)
+		assert.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+		assert.NotNil(t, cfg.ClientCAs)
+		assert.Equal(t, "first", commonName(t, cfg))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, _, err := tlsconfig.New(tlsconfig.Options{CertFile: "/unknown/tls.crt", KeyFile: "/unknown/tls.key"})
+		assert.Error(t, err)
+		o := opts
+		o.ClientAuth = "sometimes"
+		_, _, err = tlsconfig.New(o)
+		assert.Error(t, err)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		cfg, reloader, err := tlsconfig.New(opts)
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go reloader.Watch(ctx)
+		time.Sleep(100 * time.Millisecond) // let the watcher start
+
+		writeCert(t, dir, "second")
+		deadline := time.Now().Add(5 * time.Second)
+		for commonName(t, cfg) != "second" && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		assert.Equal(t, "second", commonName(t, cfg))
+
+		// invalid files keep the current certificate
+		require.NoError(t, ioutil.WriteFile(opts.KeyFile, []byte("garbage"), 0600))
+		assert.Error(t, reloader.Reload())
+		assert.Equal(t, "second", commonName(t, cfg))
+	})
+}
@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Exporter receives the ended spans which are sampled
+type Exporter interface {
+	Export(span *SpanData)
+	Close() error
+}
+
+// NoopExporter drops all the spans
+type NoopExporter struct{}
+
+// Export does nothing
+func (NoopExporter) Export(*SpanData) {}
+
+// Close does nothing
+func (NoopExporter) Close() error { return nil }
+
+// WriterExporter writes the spans as JSON lines
+type WriterExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// NewWriterExporter returns an exporter writing to w, e.g. os.Stdout
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{enc: json.NewEncoder(w)}
+}
+
+// NewFileExporter returns an exporter appending to the given file
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open the trace file %s", path)
+	}
+	return &WriterExporter{enc: json.NewEncoder(f), c: f}, nil
+}
+
+// Export writes the span
+func (e *WriterExporter) Export(span *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_ = e.enc.Encode(span)
+}
+
+// Close closes the underlying file if any
+func (e *WriterExporter) Close() error {
+	if e.c == nil {
+		return nil
+	}
+	return e.c.Close()
+}
+
+// MemoryExporter keeps the spans in memory, to be used in tests
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+// Export keeps the span
+func (e *MemoryExporter) Export(span *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the spans exported so far
+func (e *MemoryExporter) Spans() []*SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*SpanData{}, e.spans...)
+}
+
+// Reset drops the spans exported so far
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// Close does nothing
+func (e *MemoryExporter) Close() error { return nil }
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/goadesign/goa"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	gormContextKey = "tracing:context"
+	gormSpanKey    = "tracing:span"
+)
+
+// Middleware returns a goa middleware tracing the actions, continuing the
+// trace of the caller if the request holds a trace context.
+func Middleware() goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			ctx, span := Start(Extract(ctx, req.Header), "goa."+goa.ContextController(ctx)+"."+goa.ContextAction(ctx))
+			span.SetKind(KindServer)
+			span.SetAttribute("http.method", req.Method)
+			span.SetAttribute("http.path", req.URL.Path)
+			err := h(ctx, rw, req)
+			if resp := goa.ContextResponse(ctx); resp != nil {
+				span.SetAttribute("http.status_code", resp.Status)
+			}
+			span.End(err)
+			return err
+		}
+	}
+}
+
+// TraceRequest starts a client span for the given outgoing request and
+// injects its trace context in the request headers. The returned func must
+// be called with the response once received.
+func TraceRequest(ctx context.Context, req *http.Request) func(*http.Response, error) {
+	ctx, span := Start(ctx, "http."+req.Method)
+	span.SetKind(KindClient)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.String())
+	Inject(ctx, req.Header)
+	return func(resp *http.Response, err error) {
+		if resp != nil {
+			span.SetAttribute("http.status_code", resp.StatusCode)
+		}
+		span.End(err)
+	}
+}
+
+// WithGormContext returns a gorm DB whose queries are traced as children of
+// the span in ctx
+func WithGormContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return db.Set(gormContextKey, ctx)
+}
+
+// RegisterGormCallbacks traces the queries of the given gorm DB run with a
+// context set by WithGormContext
+func RegisterGormCallbacks(db *gorm.DB) {
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("tracing:before_create", beforeQuery("create"))
+	cb.Create().After("gorm:create").Register("tracing:after_create", afterQuery)
+	cb.Query().Before("gorm:query").Register("tracing:before_query", beforeQuery("query"))
+	cb.Query().After("gorm:query").Register("tracing:after_query", afterQuery)
+	cb.Update().Before("gorm:update").Register("tracing:before_update", beforeQuery("update"))
+	cb.Update().After("gorm:update").Register("tracing:after_update", afterQuery)
+	cb.Delete().Before("gorm:delete").Register("tracing:before_delete", beforeQuery("delete"))
+	cb.Delete().After("gorm:delete").Register("tracing:after_delete", afterQuery)
+	cb.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", beforeQuery("row_query"))
+	cb.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", afterQuery)
+}
+
+func beforeQuery(operation string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		v, ok := scope.Get(gormContextKey)
+		if !ok {
+			return
+		}
+		ctx, ok := v.(context.Context)
+		if !ok {
+			return
+		}
+		_, span := Start(ctx, "gorm."+operation)
+		span.SetKind(KindClient)
+		span.SetAttribute("db.system", "postgresql")
+		span.SetAttribute("db.table", scope.TableName())
+		scope.Set(gormSpanKey, span)
+	}
+}
+
+func afterQuery(scope *gorm.Scope) {
+	v, ok := scope.Get(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(*Span)
+	span.SetAttribute("db.statement", scope.SQL)
+	span.SetAttribute("db.rows_affected", scope.DB().RowsAffected)
+	err := scope.DB().Error
+	if gorm.IsRecordNotFoundError(err) {
+		err = nil
+	}
+	span.End(err)
+}
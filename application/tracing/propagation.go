@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceParentHeader is the W3C trace context header
+const TraceParentHeader = "traceparent"
+
+// Inject sets the trace context of the span in ctx in the given headers
+func Inject(ctx context.Context, h http.Header) {
+	parent, ok := parentContext(ctx)
+	if !ok {
+		return
+	}
+	flags := "00"
+	if parent.sampled {
+		flags = "01"
+	}
+	h.Set(TraceParentHeader, fmt.Sprintf("00-%s-%s-%s", parent.traceID, parent.spanID, flags))
+}
+
+// Extract returns a context holding the remote trace context found in the
+// given headers, the spans started from it belong to the remote trace.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(strings.TrimSpace(h.Get(TraceParentHeader)), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || !isHex(parts[1], 32) || !isHex(parts[2], 16) || len(parts[3]) != 2 {
+		return ctx
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey, spanContext{
+		traceID: parts[1],
+		spanID:  parts[2],
+		sampled: parts[3] == "01",
+	})
+}
+
+func isHex(s string, size int) bool {
+	if len(s) != size {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+// Package tracing records spans around the goa actions, the calls to the
+// upstream services and the database queries, and propagates the trace
+// context to the upstream services with the W3C `traceparent` header.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	mathrand "math/rand"
+	"sync"
+	"time"
+)
+
+type contextKey int
+
+const spanKey contextKey = iota
+
+// Kinds of span
+const (
+	KindInternal = "internal"
+	KindServer   = "server"
+	KindClient   = "client"
+)
+
+// SpanData is the exported representation of an ended span
+type SpanData struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+// Span is an operation being traced
+type Span struct {
+	mu      sync.Mutex
+	data    SpanData
+	sampled bool
+	ended   bool
+	tracer  *Tracer
+}
+
+// spanContext identifies a span, possibly a remote one
+type spanContext struct {
+	traceID string
+	spanID  string
+	sampled bool
+}
+
+// Tracer creates spans and hands them over to its exporter once ended
+type Tracer struct {
+	exporter   Exporter
+	sampleRate float64
+}
+
+var (
+	globalMu sync.RWMutex
+	global   = &Tracer{exporter: NoopExporter{}}
+)
+
+// Configure sets the exporter of the ended spans and the fraction (0 to 1)
+// of the traces which are sampled.
+func Configure(exporter Exporter, sampleRate float64) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	global = &Tracer{exporter: exporter, sampleRate: sampleRate}
+}
+
+// Close flushes and closes the exporter
+func Close() error {
+	return tracer().exporter.Close()
+}
+
+func tracer() *Tracer {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return global
+}
+
+// Start starts a span child of the span (or remote span context) in ctx, or
+// a new trace if there is none. The returned context holds the new span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	t := tracer()
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			SpanID: newID(8),
+			Name:   name,
+			Kind:   KindInternal,
+			Start:  time.Now(),
+		},
+	}
+	if parent, ok := parentContext(ctx); ok {
+		s.data.TraceID = parent.traceID
+		s.data.ParentSpanID = parent.spanID
+		s.sampled = parent.sampled
+	} else {
+		s.data.TraceID = newID(16)
+		s.sampled = t.sampleRate >= 1 || (t.sampleRate > 0 && mathrand.Float64() < t.sampleRate)
+	}
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+// FromContext returns the span in ctx if any
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// parentContext returns the context of the span, or remote span, in ctx
+func parentContext(ctx context.Context) (spanContext, bool) {
+	switch v := ctx.Value(spanKey).(type) {
+	case *Span:
+		return v.context(), true
+	case spanContext:
+		return v, true
+	}
+	return spanContext{}, false
+}
+
+func (s *Span) context() spanContext {
+	return spanContext{traceID: s.data.TraceID, spanID: s.data.SpanID, sampled: s.sampled}
+}
+
+// TraceID returns the ID of the trace the span belongs to
+func (s *Span) TraceID() string {
+	return s.data.TraceID
+}
+
+// SetKind sets the kind of the span, see the Kind* constants
+func (s *Span) SetKind(kind string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Kind = kind
+}
+
+// SetAttribute records a key/value on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]interface{}{}
+	}
+	s.data.Attributes[key] = value
+}
+
+// End ends the span, recording the error of the operation if any. Only the
+// first call has an effect.
+func (s *Span) End(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.mu.Unlock()
+	if s.sampled {
+		s.tracer.exporter.Export(&data)
+	}
+}
+
+func newID(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		mathrand.Read(b)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,143 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/application/rest"
+	"github.com/fabric8-services/fabric8-build/application/tracing"
+	"github.com/goadesign/goa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStart(t *testing.T) {
+	exporter := &tracing.MemoryExporter{}
+	tracing.Configure(exporter, 1)
+	defer tracing.Configure(tracing.NoopExporter{}, 0)
+
+	ctx, parent := tracing.Start(context.Background(), "parent")
+	_, child := tracing.Start(ctx, "child")
+	child.SetAttribute("foo", "bar")
+	child.End(errors.New("boom"))
+	child.End(nil) // ignored
+	parent.End(nil)
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, "parent", spans[1].Name)
+	assert.Equal(t, spans[1].TraceID, spans[0].TraceID)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Empty(t, spans[1].ParentSpanID)
+	assert.Equal(t, "bar", spans[0].Attributes["foo"])
+	assert.Equal(t, "boom", spans[0].Error)
+	assert.Len(t, spans[0].TraceID, 32)
+	assert.Len(t, spans[0].SpanID, 16)
+
+	t.Run("not sampled", func(t *testing.T) {
+		exporter.Reset()
+		tracing.Configure(exporter, 0)
+		_, span := tracing.Start(context.Background(), "dropped")
+		span.End(nil)
+		assert.Empty(t, exporter.Spans())
+	})
+}
+
+func TestPropagation(t *testing.T) {
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	t.Run("valid", func(t *testing.T) {
+		h := http.Header{}
+		h.Set(tracing.TraceParentHeader, "00-"+traceID+"-00f067aa0ba902b7-01")
+		ctx, span := tracing.Start(tracing.Extract(context.Background(), h), "remote child")
+		assert.Equal(t, traceID, span.TraceID())
+
+		out := http.Header{}
+		tracing.Inject(ctx, out)
+		assert.Regexp(t, "^00-"+traceID+"-[0-9a-f]{16}-01$", out.Get(tracing.TraceParentHeader))
+		assert.NotContains(t, out.Get(tracing.TraceParentHeader), "00f067aa0ba902b7")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, v := range []string{"", "garbage", "00-" + traceID + "-00f067aa0ba902b7", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+			h := http.Header{}
+			h.Set(tracing.TraceParentHeader, v)
+			_, span := tracing.Start(tracing.Extract(context.Background(), h), "root")
+			assert.NotEqual(t, traceID, span.TraceID(), v)
+		}
+	})
+
+	t.Run("no span", func(t *testing.T) {
+		h := http.Header{}
+		tracing.Inject(context.Background(), h)
+		assert.Empty(t, h.Get(tracing.TraceParentHeader))
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := &tracing.MemoryExporter{}
+	tracing.Configure(exporter, 1)
+	defer tracing.Configure(tracing.NoopExporter{}, 0)
+
+	// upstream service receiving the trace context
+	var upstreamHeader string
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		upstreamHeader = req.Header.Get(tracing.TraceParentHeader)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+		upstreamReq, _ := http.NewRequest("GET", upstream.URL+"/api/spaces", nil)
+		resp, err := rest.DefaultHttpDoer().Do(ctx, upstreamReq)
+		if err != nil {
+			return err
+		}
+		rest.CloseResponse(resp)
+		rw.WriteHeader(http.StatusOK)
+		return nil
+	}
+	h = tracing.Middleware()(h)
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/pipeline-environment-maps", nil)
+	req.Header.Set(tracing.TraceParentHeader, "00-"+traceID+"-00f067aa0ba902b7-01")
+	ctx := goa.WithAction(goa.NewContext(context.Background(), rw, req, nil), "show")
+	require.NoError(t, h(ctx, goa.ContextResponse(ctx), req))
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	client, server := spans[0], spans[1]
+	assert.Equal(t, tracing.KindServer, server.Kind)
+	assert.Contains(t, server.Name, "show")
+	assert.Equal(t, traceID, server.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", server.ParentSpanID)
+	assert.Equal(t, http.StatusOK, server.Attributes["http.status_code"])
+
+	assert.Equal(t, tracing.KindClient, client.Kind)
+	assert.Equal(t, traceID, client.TraceID)
+	assert.Equal(t, server.SpanID, client.ParentSpanID)
+	assert.Equal(t, http.StatusNoContent, client.Attributes["http.status_code"])
+	assert.Equal(t, "00-"+traceID+"-"+client.SpanID+"-01", upstreamHeader)
+}
+
+func TestWriterExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tracing.Configure(tracing.NewWriterExporter(buf), 1)
+	defer tracing.Configure(tracing.NoopExporter{}, 0)
+
+	_, span := tracing.Start(context.Background(), "written")
+	span.End(nil)
+
+	var data tracing.SpanData
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &data))
+	assert.Equal(t, "written", data.Name)
+	assert.Equal(t, span.TraceID(), data.TraceID)
+}
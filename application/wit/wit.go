@@ -6,6 +6,7 @@ import (
 	"net/url"
 
 	"github.com/fabric8-services/fabric8-build/application/rest"
+	"github.com/fabric8-services/fabric8-build/application/tracing"
 	"github.com/fabric8-services/fabric8-build/application/wit/witservice"
 	"github.com/fabric8-services/fabric8-build/configuration"
 	commonerr "github.com/fabric8-services/fabric8-common/errors"
@@ -34,6 +35,10 @@ type WITServiceImpl struct {
 
 // GetSpace talks to the WIT service to retrieve a space record for the specified spaceID, then returns space
 func (s *WITServiceImpl) GetSpace(ctx context.Context, spaceID string) (space *Space, e error) {
+	ctx, span := tracing.Start(ctx, "wit.GetSpace")
+	span.SetAttribute("space_id", spaceID)
+	defer func() { span.End(e) }()
+
 	remoteWITService, err := s.createClientWithContextSigner(ctx)
 	if err != nil {
 		return nil, err
@@ -59,6 +64,8 @@ func (s *WITServiceImpl) GetSpace(ctx context.Context, spaceID string) (space *S
 		}, "unable to get space from WIT")
 		if res.StatusCode == 404 {
 			return nil, commonerr.NewNotFoundErrorFromString("Cannot find space: " + spaceID)
+		} else if res.StatusCode == 403 {
+			return nil, commonerr.NewForbiddenError("Access denied to space: " + spaceID)
 		} else {
 			return nil, errors.Errorf("unable to get space from WIT. Response status: %s. Response body: %s", res.Status, bodyString)
 		}
@@ -97,7 +104,11 @@ func (s *WITServiceImpl) createClient() (*witservice.Client, error) {
 		return nil, err
 	}
 
-	c := witservice.New(s.doer)
+	doer := s.doer
+	if doer == nil {
+		doer = rest.DefaultHttpDoer()
+	}
+	c := witservice.New(doer)
 	c.Host = u.Host
 	c.Scheme = u.Scheme
 	return c, nil
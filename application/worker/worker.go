@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/pkg/errors"
+)
+
+// Group runs background workers until it is stopped
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewGroup creates a new group of workers
+func NewGroup() *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Go runs fn in the background. The context given to fn is cancelled when
+// the group is stopped.
+func (g *Group) Go(name string, fn func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				log.Error(nil, map[string]interface{}{
+					"worker": name,
+					"err":    err,
+					"stack":  string(debug.Stack()),
+				}, "background worker panicked")
+			}
+		}()
+		log.Debug(nil, map[string]interface{}{"worker": name}, "background worker started")
+		fn(g.ctx)
+		log.Debug(nil, map[string]interface{}{"worker": name}, "background worker stopped")
+	}()
+}
+
+// Every runs fn in the background at the given interval until the group is
+// stopped. A panic in fn only aborts the current run.
+func (g *Group) Every(name string, interval time.Duration, fn func(ctx context.Context)) {
+	g.Go(name, func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runOnce(ctx, name, fn)
+			}
+		}
+	})
+}
+
+func runOnce(ctx context.Context, name string, fn func(ctx context.Context)) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"worker": name,
+				"err":    err,
+				"stack":  string(debug.Stack()),
+			}, "periodic worker run panicked")
+		}
+	}()
+	fn(ctx)
+}
+
+// Stop cancels the context of all the workers and waits for them to return,
+// or for the given context to be done.
+func (g *Group) Stop(ctx context.Context) error {
+	g.cancel()
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "timeout while waiting for the background workers to stop")
+	}
+}
@@ -0,0 +1,43 @@
+package worker_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroup(t *testing.T) {
+	t.Run("stop", func(t *testing.T) {
+		g := worker.NewGroup()
+		var runs int32
+		g.Every("ticker", time.Millisecond, func(ctx context.Context) {
+			atomic.AddInt32(&runs, 1)
+		})
+		g.Every("panicking", time.Millisecond, func(ctx context.Context) {
+			panic("boom")
+		})
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, g.Stop(context.Background()))
+		stopped := atomic.LoadInt32(&runs)
+		assert.True(t, stopped > 0)
+		time.Sleep(5 * time.Millisecond)
+		assert.Equal(t, stopped, atomic.LoadInt32(&runs))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		g := worker.NewGroup()
+		release := make(chan struct{})
+		defer close(release)
+		g.Go("stuck", func(ctx context.Context) {
+			<-release
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.Error(t, g.Stop(ctx))
+	})
+}
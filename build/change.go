@@ -0,0 +1,286 @@
+package build
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/tracing"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/prometheus/common/log"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Operations of the changes
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Change is a create, update or delete of a Pipeline Env Map, numbered in the
+// order of the commits of the changes of its space. The deletes are
+// tombstones, without the state of the map.
+type Change struct {
+	Seq              int64     `gorm:"primary_key"`
+	SpaceID          uuid.UUID `sql:"type:uuid"`
+	PipelineEnvMapID uuid.UUID `sql:"type:uuid" gorm:"column:pipelineenvmap_id"`
+	Operation        string
+	Name             *string
+	EnvironmentIDs   pq.StringArray `sql:"type:uuid[]" gorm:"column:environment_ids"`
+	Labels           Labels         `sql:"type:jsonb"`
+	ChangedAt        time.Time
+	// PublishedAt is when the change was published, nil until then
+	PublishedAt *time.Time
+}
+
+// TableName implements gorm.tabler
+func (Change) TableName() string {
+	return "pipeline_env_map_changes"
+}
+
+// Environments returns the IDs of the environments of the map after the
+// change, in their order
+func (c Change) Environments() []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(c.EnvironmentIDs))
+	for _, id := range c.EnvironmentIDs {
+		ids = append(ids, uuid.FromStringOrNil(id))
+	}
+	return ids
+}
+
+const cursorPrefix = "v1:"
+
+// Cursor returns the opaque cursor to resume reading the changes after this
+// one
+func (c Change) Cursor() string {
+	return EncodeCursor(c.Seq)
+}
+
+// EncodeCursor returns the opaque cursor of the given change sequence number
+func EncodeCursor(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.FormatInt(seq, 10)))
+}
+
+// DecodeCursor returns the change sequence number of the given cursor
+func DecodeCursor(cursor string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(b), cursorPrefix) {
+		seq, err := strconv.ParseInt(strings.TrimPrefix(string(b), cursorPrefix), 10, 64)
+		if err == nil && seq >= 0 {
+			return seq, nil
+		}
+	}
+	return 0, errors.NewBadParameterError("since", cursor).Expected("a cursor returned by a previous request")
+}
+
+// ChangeRepository reads the changes of the Pipeline Env Maps, which are
+// recorded by the Repository along with the changes themselves
+type ChangeRepository interface {
+	List(ctx context.Context, spaceID uuid.UUID, after int64, limit int) ([]*Change, error)
+	Latest(ctx context.Context, spaceID uuid.UUID) (int64, error)
+	Purged(ctx context.Context, spaceID uuid.UUID) (int64, error)
+	LockPublishing(ctx context.Context) (bool, error)
+	ListUnpublished(ctx context.Context, limit int) ([]*Change, error)
+	MarkPublished(ctx context.Context, seqs []int64, at time.Time) error
+	DiscardUnpublished(ctx context.Context, at time.Time) (int64, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+type GormChangeRepository struct {
+	db *gorm.DB
+}
+
+func NewChangeRepository(db *gorm.DB) *GormChangeRepository {
+	return &GormChangeRepository{
+		db: db,
+	}
+}
+
+func (r *GormChangeRepository) dbFor(ctx context.Context) *gorm.DB {
+	return tracing.WithGormContext(ctx, r.db)
+}
+
+// List at most limit changes of the Pipeline Env Maps of the given space
+// following the given sequence number, in their order
+func (r *GormChangeRepository) List(ctx context.Context, spaceID uuid.UUID, after int64, limit int) (_ []*Change, err error) {
+	defer measure("list_changes", time.Now(), &err)
+	var rows []*Change
+	err = r.dbFor(ctx).Where("space_id = ? AND seq > ?", spaceID, after).Order("seq").Limit(limit).Find(&rows).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to list the changes of the pipeline-environment maps")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	return rows, nil
+}
+
+// Latest returns the sequence number of the last change of the Pipeline Env
+// Maps of the given space, 0 if there is none
+func (r *GormChangeRepository) Latest(ctx context.Context, spaceID uuid.UUID) (_ int64, err error) {
+	defer measure("latest_change", time.Now(), &err)
+	var seq int64
+	err = r.dbFor(ctx).Model(&Change{}).Where("space_id = ?", spaceID).Select("COALESCE(MAX(seq), 0)").Row().Scan(&seq)
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to get the last change of the pipeline-environment maps")
+		return 0, errors.NewInternalError(ctx, err)
+	}
+	return seq, nil
+}
+
+// LockPublishing tries to take the lock of the publication of the changes
+// until the end of the transaction, returning false if another instance of
+// the service holds it
+func (r *GormChangeRepository) LockPublishing(ctx context.Context) (_ bool, err error) {
+	defer measure("lock_publishing", time.Now(), &err)
+	var locked bool
+	err = r.dbFor(ctx).Raw("SELECT pg_try_advisory_xact_lock(hashtext(?))", "pipeline_env_map_changes:publishing").Row().Scan(&locked)
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err},
+			"unable to lock the publication of the changes")
+		return false, errors.NewInternalError(ctx, err)
+	}
+	return locked, nil
+}
+
+// ListUnpublished lists at most limit changes not yet published, of all the
+// spaces, in their order
+func (r *GormChangeRepository) ListUnpublished(ctx context.Context, limit int) (_ []*Change, err error) {
+	defer measure("list_unpublished_changes", time.Now(), &err)
+	var rows []*Change
+	err = r.dbFor(ctx).Where("published_at IS NULL").Order("seq").Limit(limit).Find(&rows).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err},
+			"unable to list the unpublished changes of the pipeline-environment maps")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	return rows, nil
+}
+
+// MarkPublished records that the changes of the given sequence numbers were
+// published at the given time
+func (r *GormChangeRepository) MarkPublished(ctx context.Context, seqs []int64, at time.Time) (err error) {
+	defer measure("mark_published_changes", time.Now(), &err)
+	if len(seqs) == 0 {
+		return nil
+	}
+	err = r.dbFor(ctx).Model(&Change{}).Where("seq IN (?)", seqs).Update("published_at", at).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "changes": len(seqs)},
+			"unable to mark the changes of the pipeline-environment maps as published")
+		return errors.NewInternalError(ctx, err)
+	}
+	return nil
+}
+
+// DiscardUnpublished marks all the changes not yet published as published at
+// the given time without publishing them, returning the number of changes
+// discarded
+func (r *GormChangeRepository) DiscardUnpublished(ctx context.Context, at time.Time) (_ int64, err error) {
+	defer measure("discard_unpublished_changes", time.Now(), &err)
+	tx := r.dbFor(ctx).Model(&Change{}).Where("published_at IS NULL").Update("published_at", at)
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error},
+			"unable to discard the unpublished changes of the pipeline-environment maps")
+		return 0, errors.NewInternalError(ctx, tx.Error)
+	}
+	return tx.RowsAffected, nil
+}
+
+// Purged returns the sequence number of the last purged change of the Pipeline
+// Env Maps of the given space, 0 if none was purged. The changes can't be
+// read without a gap from a cursor before it.
+func (r *GormChangeRepository) Purged(ctx context.Context, spaceID uuid.UUID) (_ int64, err error) {
+	defer measure("purged_changes", time.Now(), &err)
+	var seq int64
+	err = r.dbFor(ctx).Raw("SELECT COALESCE(MAX(seq), 0) FROM pipeline_env_map_changes_purged WHERE space_id = ?", spaceID).Row().Scan(&seq)
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to get the last purged change of the pipeline-environment maps")
+		return 0, errors.NewInternalError(ctx, err)
+	}
+	return seq, nil
+}
+
+// Purge deletes the changes published before the given time, returning the
+// number of changes deleted. The changes not yet published are kept. The last
+// purged change of each space is recorded along, so that the cursors before it
+// are rejected instead of silently skipping the purged changes.
+func (r *GormChangeRepository) Purge(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer measure("purge_changes", time.Now(), &err)
+	var purged int64
+	err = r.dbFor(ctx).Raw(`WITH purged AS (
+			DELETE FROM pipeline_env_map_changes WHERE published_at < ? RETURNING space_id, seq
+		), recorded AS (
+			INSERT INTO pipeline_env_map_changes_purged (space_id, seq)
+			SELECT space_id, MAX(seq) FROM purged GROUP BY space_id
+			ON CONFLICT (space_id) DO UPDATE SET seq = GREATEST(pipeline_env_map_changes_purged.seq, EXCLUDED.seq)
+		)
+		SELECT count(*) FROM purged`, before).Row().Scan(&purged)
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "before": before},
+			"unable to purge the changes of the pipeline-environment maps")
+		return 0, errors.NewInternalError(ctx, err)
+	}
+	return purged, nil
+}
+
+// lockChanges serializes the changes of the given space until the end of the
+// transaction, so that their sequence numbers follow the order of their
+// commits and a reader never skips a change committed late. It must be called
+// before writing the maps, so that the writers of a space cannot deadlock.
+func (r *GormRepository) lockChanges(ctx context.Context, spaceID *uuid.UUID) error {
+	if spaceID == nil {
+		return nil
+	}
+	err := r.dbFor(ctx).Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "pipeline_env_map_changes:"+spaceID.String()).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to lock the changes of the space")
+		return errors.NewInternalError(ctx, err)
+	}
+	return nil
+}
+
+// spaceOf returns the space of the Pipeline Env Map of given ID, deleted or
+// not, nil if there is no such map
+func (r *GormRepository) spaceOf(ctx context.Context, ID uuid.UUID) (*uuid.UUID, error) {
+	var spaceIDs []uuid.UUID
+	err := r.dbFor(ctx).Unscoped().Model(&PipelineEnvMap{}).Where("id = ? AND space_id IS NOT NULL", ID).Pluck("space_id", &spaceIDs).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "id": ID.String()},
+			"unable to get the space of the pipeline-environment map")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	if len(spaceIDs) == 0 {
+		return nil, nil
+	}
+	return &spaceIDs[0], nil
+}
+
+// recordChanges records the given operation on the Pipeline Env Maps matching
+// the condition, along with their state unless deleted
+func (r *GormRepository) recordChanges(ctx context.Context, operation string, where string, args ...interface{}) error {
+	state := `m.name,
+		COALESCE((SELECT array_agg(e.environment_id ORDER BY e.created_at) FROM pipeline_environments e
+			WHERE e.pipelineenvmap_id = m.id AND e.deleted_at IS NULL), '{}'),
+		m.labels`
+	if operation == ChangeDelete {
+		state = "NULL, NULL, NULL"
+	}
+	err := r.dbFor(ctx).Exec(`INSERT INTO pipeline_env_map_changes (space_id, pipelineenvmap_id, operation, name, environment_ids, labels, changed_at)
+		SELECT m.space_id, m.id, ?, `+state+`, now()
+		FROM pipeline_env_maps m WHERE m.space_id IS NOT NULL AND `+where+`
+		ORDER BY m.created_at, m.id`, append([]interface{}{operation}, args...)...).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "operation": operation},
+			"unable to record the change of the pipeline-environment maps")
+		return errors.NewInternalError(ctx, err)
+	}
+	return nil
+}
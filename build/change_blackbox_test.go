@@ -0,0 +1,161 @@
+package build_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-common/errors"
+	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	errs "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCursor(t *testing.T) {
+	for _, seq := range []int64{0, 1, 4242} {
+		decoded, err := build.DecodeCursor(build.EncodeCursor(seq))
+		require.NoError(t, err)
+		assert.Equal(t, seq, decoded)
+	}
+
+	for _, cursor := range []string{"", "42", "not base64!", build.EncodeCursor(-1)} {
+		_, err := build.DecodeCursor(cursor)
+		require.Error(t, err, cursor)
+		assert.IsType(t, errors.BadParameterError{}, errs.Cause(err))
+	}
+}
+
+type ChangeRepositorySuite struct {
+	testsuite.DBTestSuite
+	buildRepo  *build.GormRepository
+	changeRepo *build.GormChangeRepository
+}
+
+func TestChangeRepository(t *testing.T) {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	suite.Run(t, &ChangeRepositorySuite{DBTestSuite: testsuite.NewDBTestSuite(config)})
+}
+
+func (s *ChangeRepositorySuite) SetupSuite() {
+	s.DBTestSuite.SetupSuite()
+	s.buildRepo = build.NewRepository(s.DB)
+	s.changeRepo = build.NewChangeRepository(s.DB)
+}
+
+func (s *ChangeRepositorySuite) TestRecorded() {
+	ctx := context.Background()
+	spaceID, env1ID, env2ID := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	ppl := newPipelineEnvMap("pipelineChanges", spaceID, env1ID)
+	ppl.Labels = build.Labels{"team": "payments"}
+	ppl, err := s.buildRepo.Create(ctx, ppl)
+	require.NoError(s.T(), err)
+	_, err = s.buildRepo.Save(ctx, updatePipelineEnvMap(ppl, env2ID))
+	require.NoError(s.T(), err)
+	_, err = s.buildRepo.DeleteEnvironments(ctx, ppl.ID, []uuid.UUID{env2ID})
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.buildRepo.Delete(ctx, ppl.ID))
+	_, err = s.buildRepo.Restore(ctx, ppl.ID)
+	require.NoError(s.T(), err)
+	other, err := s.buildRepo.Create(ctx, newPipelineEnvMap("pipelineChangesOther", spaceID, env1ID))
+	require.NoError(s.T(), err)
+	_, err = s.buildRepo.DeleteBySpace(ctx, spaceID)
+	require.NoError(s.T(), err)
+
+	changes, err := s.changeRepo.List(ctx, spaceID, 0, 100)
+	require.NoError(s.T(), err)
+	var operations []string
+	for _, change := range changes {
+		operations = append(operations, change.Operation)
+	}
+	assert.Equal(s.T(), []string{
+		build.ChangeCreate, build.ChangeUpdate, build.ChangeUpdate, build.ChangeDelete,
+		build.ChangeCreate, build.ChangeCreate, build.ChangeDelete, build.ChangeDelete,
+	}, operations)
+
+	s.T().Run("state of the map", func(t *testing.T) {
+		assert.Equal(t, "pipelineChanges", *changes[0].Name)
+		assert.Equal(t, []uuid.UUID{env1ID}, changes[0].Environments())
+		assert.Equal(t, build.Labels{"team": "payments"}, changes[0].Labels)
+		assert.Equal(t, []uuid.UUID{env2ID}, changes[1].Environments())
+		assert.Empty(t, changes[2].Environments())
+		assert.Equal(t, other.ID, changes[5].PipelineEnvMapID)
+	})
+
+	s.T().Run("tombstones", func(t *testing.T) {
+		assert.Equal(t, ppl.ID, changes[3].PipelineEnvMapID)
+		assert.Nil(t, changes[3].Name)
+		assert.Empty(t, changes[3].Environments())
+	})
+
+	s.T().Run("ordered", func(t *testing.T) {
+		for i := 1; i < len(changes); i++ {
+			assert.True(t, changes[i].Seq > changes[i-1].Seq)
+		}
+	})
+
+	s.T().Run("following a cursor", func(t *testing.T) {
+		page, err := s.changeRepo.List(ctx, spaceID, changes[2].Seq, 2)
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		assert.Equal(t, changes[3].Seq, page[0].Seq)
+		assert.Equal(t, changes[4].Seq, page[1].Seq)
+	})
+
+	s.T().Run("latest", func(t *testing.T) {
+		latest, err := s.changeRepo.Latest(ctx, spaceID)
+		require.NoError(t, err)
+		assert.Equal(t, changes[len(changes)-1].Seq, latest)
+	})
+
+	s.T().Run("other spaces", func(t *testing.T) {
+		changes, err := s.changeRepo.List(ctx, uuid.NewV4(), 0, 100)
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+		latest, err := s.changeRepo.Latest(ctx, uuid.NewV4())
+		require.NoError(t, err)
+		assert.Zero(t, latest)
+	})
+}
+
+func (s *ChangeRepositorySuite) TestPurge() {
+	ctx := context.Background()
+	spaceID := uuid.NewV4()
+	ppl, err := s.buildRepo.Create(ctx, newPipelineEnvMap("pipelineChangesPurge", spaceID, uuid.NewV4()))
+	require.NoError(s.T(), err)
+	_, err = s.buildRepo.Save(ctx, updatePipelineEnvMap(ppl, uuid.NewV4()))
+	require.NoError(s.T(), err)
+	changes, err := s.changeRepo.List(ctx, spaceID, 0, 100)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), changes, 2)
+	created := changes[0].Seq
+	// only the create was published, two days ago
+	err = s.changeRepo.MarkPublished(ctx, []int64{created}, time.Now().Add(-48*time.Hour))
+	require.NoError(s.T(), err)
+	last, err := s.changeRepo.Purged(ctx, spaceID)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), last)
+
+	purged, err := s.changeRepo.Purge(ctx, time.Now().Add(-24*time.Hour))
+	require.NoError(s.T(), err)
+	assert.True(s.T(), purged >= 1)
+
+	// the last purged change of the space is recorded
+	last, err = s.changeRepo.Purged(ctx, spaceID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), created, last)
+	last, err = s.changeRepo.Purged(ctx, uuid.NewV4())
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), last)
+
+	// the unpublished update is kept
+	changes, err = s.changeRepo.List(ctx, spaceID, 0, 100)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), changes, 1)
+	assert.Equal(s.T(), build.ChangeUpdate, changes[0].Operation)
+}
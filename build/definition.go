@@ -0,0 +1,116 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/tracing"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/fabric8-services/fabric8-common/gormsupport"
+	"github.com/jinzhu/gorm"
+	errs "github.com/pkg/errors"
+	"github.com/prometheus/common/log"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Definition is an immutable version of the pipeline definition document of a
+// Pipeline Env Map, kept as sent once validated
+type Definition struct {
+	PipelineEnvMapID uuid.UUID  `sql:"type:uuid" gorm:"primary_key;column:pipelineenvmap_id"`
+	Version          int        `gorm:"primary_key;auto_increment:false"`
+	Document         string     `gorm:"not null"`
+	AuthorID         *uuid.UUID `sql:"type:uuid"`
+	CreatedAt        time.Time
+}
+
+// TableName implements gorm.tabler
+func (Definition) TableName() string {
+	return "pipeline_definitions"
+}
+
+// DefinitionRepository records and reads the versions of the pipeline
+// definitions of the Pipeline Env Maps
+type DefinitionRepository interface {
+	Create(ctx context.Context, ID uuid.UUID, document string, authorID *uuid.UUID) (*Definition, error)
+	Load(ctx context.Context, ID uuid.UUID, version int) (*Definition, error)
+	LoadLatest(ctx context.Context, ID uuid.UUID) (*Definition, error)
+}
+
+type GormDefinitionRepository struct {
+	db *gorm.DB
+}
+
+func NewDefinitionRepository(db *gorm.DB) *GormDefinitionRepository {
+	return &GormDefinitionRepository{
+		db: db,
+	}
+}
+
+func (r *GormDefinitionRepository) dbFor(ctx context.Context) *gorm.DB {
+	return tracing.WithGormContext(ctx, r.db)
+}
+
+// Create records the given document as the next version of the pipeline
+// definition of the Pipeline Env Map of given ID, a concurrent change being
+// reported as a conflict.
+func (r *GormDefinitionRepository) Create(ctx context.Context, ID uuid.UUID, document string, authorID *uuid.UUID) (_ *Definition, err error) {
+	defer measure("create_definition", time.Now(), &err)
+	db := r.dbFor(ctx)
+	var last struct {
+		Version int
+	}
+	err = db.Raw("SELECT COALESCE(max(version), 0) AS version FROM pipeline_definitions WHERE pipelineenvmap_id = ?", ID).
+		Scan(&last).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "id": ID.String()},
+			"unable to get the last version of the pipeline definition")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+
+	def := Definition{
+		PipelineEnvMapID: ID,
+		Version:          last.Version + 1,
+		Document:         document,
+		AuthorID:         authorID,
+		CreatedAt:        time.Now(),
+	}
+	err = db.Create(&def).Error
+	if err != nil {
+		if gormsupport.IsUniqueViolation(err, "pipeline_definitions_pkey") {
+			return nil, errors.NewDataConflictError("the pipeline definition was changed concurrently")
+		}
+		log.Error(ctx, map[string]interface{}{"err": err, "id": ID.String()},
+			"unable to create the version of the pipeline definition")
+		return nil, errs.WithStack(err)
+	}
+	return &def, nil
+}
+
+// Load the given version of the pipeline definition of the Pipeline Env Map
+// of given ID
+func (r *GormDefinitionRepository) Load(ctx context.Context, ID uuid.UUID, version int) (_ *Definition, err error) {
+	defer measure("load_definition", time.Now(), &err)
+	return r.load(ctx, ID, r.dbFor(ctx).Where("pipelineenvmap_id = ? AND version = ?", ID, version), fmt.Sprintf("%s/%d", ID, version))
+}
+
+// LoadLatest loads the last version of the pipeline definition of the
+// Pipeline Env Map of given ID
+func (r *GormDefinitionRepository) LoadLatest(ctx context.Context, ID uuid.UUID) (_ *Definition, err error) {
+	defer measure("load_latest_definition", time.Now(), &err)
+	return r.load(ctx, ID, r.dbFor(ctx).Where("pipelineenvmap_id = ?", ID).Order("version DESC"), ID.String())
+}
+
+func (r *GormDefinitionRepository) load(ctx context.Context, ID uuid.UUID, query *gorm.DB, key string) (*Definition, error) {
+	def := Definition{}
+	tx := query.First(&def)
+	if tx.RecordNotFound() {
+		return nil, errors.NewNotFoundError("pipeline definition", key)
+	}
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error, "id": ID.String()},
+			"unable to load the pipeline definition")
+		return nil, errors.NewInternalError(ctx, tx.Error)
+	}
+	return &def, nil
+}
@@ -0,0 +1,185 @@
+package build_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-common/errors"
+	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	errs "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestParseDefinition(t *testing.T) {
+	envID := uuid.NewV4()
+	otherEnvID := uuid.NewV4()
+	document := `version: 1
+builder:
+  image: fabric8/maven-builder
+stages:
+- name: build
+  steps:
+  - name: package
+    run: mvn -B package
+- name: stage
+  environment: ` + envID.String() + `
+  builder:
+    image: openshift/origin-cli
+  steps:
+  - name: deploy
+    run: oc apply -f target/openshift
+`
+	def, err := build.ParseDefinition(document, []uuid.UUID{otherEnvID, envID})
+	require.NoError(t, err)
+	assert.Equal(t, &build.PipelineDefinition{
+		Version: 1,
+		Builder: build.Builder{Image: "fabric8/maven-builder"},
+		Stages: []build.Stage{
+			{Name: "build", Steps: []build.Step{{Name: "package", Run: "mvn -B package"}}},
+			{
+				Name:        "stage",
+				Environment: &envID,
+				Builder:     &build.Builder{Image: "openshift/origin-cli"},
+				Steps:       []build.Step{{Name: "deploy", Run: "oc apply -f target/openshift"}},
+			},
+		},
+	}, def)
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			document string
+			errors   []build.DefinitionError
+		}{
+			"syntax": {
+				document: "version: 1\nbuilder:\n  image: fabric8: maven\n",
+				errors:   []build.DefinitionError{{Line: 3, Message: "mapping values are not allowed in this context"}},
+			},
+			"empty": {
+				document: "",
+				errors:   []build.DefinitionError{{Line: 1, Column: 1, Message: "the document is empty"}},
+			},
+			"not a mapping": {
+				document: "- version: 1\n",
+				errors:   []build.DefinitionError{{Line: 1, Column: 1, Message: "must be a mapping"}},
+			},
+			"missing fields": {
+				document: "version: 2\ntimeout: 10m\n",
+				errors: []build.DefinitionError{
+					{Line: 1, Column: 1, Path: "builder", Message: "missing required field"},
+					{Line: 1, Column: 1, Path: "stages", Message: "missing required field"},
+					{Line: 1, Column: 10, Path: "version", Message: "must be 1"},
+					{Line: 2, Column: 1, Path: "timeout", Message: "unknown field, expected one of version, builder, stages"},
+				},
+			},
+			"invalid stages": {
+				document: `version: 1
+builder:
+  image: ""
+stages:
+- name: Build
+  steps: []
+- name: deploy
+  environment: ` + uuid.NewV4().String() + `
+  steps:
+  - name: deploy
+    run: 42
+  - name: deploy
+    run: oc apply
+- name: deploy
+  steps:
+  - run: oc apply
+`,
+				errors: []build.DefinitionError{
+					{Line: 3, Column: 10, Path: "builder.image", Message: "must not be empty"},
+					{Line: 5, Column: 9, Path: "stages[0].name", Message: "must be at most 63 lowercase alphanumeric characters or '-', starting and ending with an alphanumeric character"},
+					{Line: 6, Column: 10, Path: "stages[0].steps", Message: "must not be empty"},
+					{Line: 8, Column: 16, Path: "stages[1].environment", Message: "the environment is not an environment of the pipeline environment map"},
+					{Line: 11, Column: 10, Path: "stages[1].steps[0].run", Message: "must be a string"},
+					{Line: 12, Column: 11, Path: "stages[1].steps[1].name", Message: `the name "deploy" is already used`},
+					{Line: 14, Column: 9, Path: "stages[2].name", Message: `the name "deploy" is already used`},
+					{Line: 16, Column: 5, Path: "stages[2].steps[0].name", Message: "missing required field"},
+				},
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := build.ParseDefinition(tc.document, []uuid.UUID{envID})
+				require.Error(t, err)
+				derr, ok := errs.Cause(err).(*build.InvalidDefinitionError)
+				require.True(t, ok, "%T", err)
+				require.Len(t, derr.Errors, len(tc.errors))
+				for i, expected := range tc.errors {
+					actual := derr.Errors[i]
+					// the ID of the unknown environment is random
+					if expected.Path == "stages[1].environment" {
+						assert.Contains(t, actual.Message, "is not an environment of the pipeline environment map")
+						actual.Message = expected.Message
+					}
+					assert.Equal(t, expected, actual)
+				}
+			})
+		}
+	})
+}
+
+type DefinitionRepositorySuite struct {
+	testsuite.DBTestSuite
+	buildRepo *build.GormRepository
+	defRepo   *build.GormDefinitionRepository
+}
+
+func TestDefinitionRepository(t *testing.T) {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	suite.Run(t, &DefinitionRepositorySuite{DBTestSuite: testsuite.NewDBTestSuite(config)})
+}
+
+func (s *DefinitionRepositorySuite) SetupSuite() {
+	s.DBTestSuite.SetupSuite()
+	s.buildRepo = build.NewRepository(s.DB)
+	s.defRepo = build.NewDefinitionRepository(s.DB)
+}
+
+func (s *DefinitionRepositorySuite) TestCreate() {
+	spaceID, envID, authorID := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	ppl, err := s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineDefinitions", spaceID, envID))
+	require.NoError(s.T(), err)
+
+	s.T().Run("no definition", func(t *testing.T) {
+		_, err := s.defRepo.LoadLatest(context.Background(), ppl.ID)
+		require.Error(t, err)
+		assert.IsType(t, errors.NotFoundError{}, errs.Cause(err))
+	})
+
+	def1, err := s.defRepo.Create(context.Background(), ppl.ID, "version: 1\n", &authorID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, def1.Version)
+	def2, err := s.defRepo.Create(context.Background(), ppl.ID, "version: 1\nstages: []\n", nil)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, def2.Version)
+
+	s.T().Run("load latest", func(t *testing.T) {
+		def, err := s.defRepo.LoadLatest(context.Background(), ppl.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 2, def.Version)
+		assert.Equal(t, "version: 1\nstages: []\n", def.Document)
+		assert.Nil(t, def.AuthorID)
+	})
+
+	s.T().Run("load", func(t *testing.T) {
+		def, err := s.defRepo.Load(context.Background(), ppl.ID, 1)
+		require.NoError(t, err)
+		assert.Equal(t, "version: 1\n", def.Document)
+		assert.Equal(t, authorID, *def.AuthorID)
+	})
+
+	s.T().Run("not found", func(t *testing.T) {
+		_, err := s.defRepo.Load(context.Background(), ppl.ID, 3)
+		require.Error(t, err)
+		assert.IsType(t, errors.NotFoundError{}, errs.Cause(err))
+	})
+}
@@ -0,0 +1,362 @@
+package build
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// DefinitionSchemaVersion is the version of the schema of the pipeline
+// definition documents, given by their "version" field
+const DefinitionSchemaVersion = 1
+
+// PipelineDefinition is the pipeline run for a Pipeline Env Map: stages run
+// in their order, each one running its steps in the builder image and
+// possibly targeting one of the environments of the map.
+//
+//	version: 1
+//	builder:
+//	  image: fabric8/maven-builder
+//	stages:
+//	- name: build
+//	  steps:
+//	  - name: package
+//	    run: mvn -B package
+//	- name: stage
+//	  environment: 40bbdd3d-8b5d-4fd6-ac90-7236b669af04
+//	  steps:
+//	  - name: deploy
+//	    run: oc apply -f target/openshift
+type PipelineDefinition struct {
+	Version int
+	Builder Builder
+	Stages  []Stage
+}
+
+// Builder is the image running the steps
+type Builder struct {
+	Image string
+}
+
+// Stage is a named sequence of steps, run in the builder image of the
+// pipeline unless it has its own
+type Stage struct {
+	Name        string
+	Environment *uuid.UUID
+	Builder     *Builder
+	Steps       []Step
+}
+
+// Step is a named command
+type Step struct {
+	Name string
+	Run  string
+}
+
+// DefinitionError is an error of a pipeline definition document at the given
+// line and column, starting at 1, and at the given path of field. The column
+// of the syntax errors is unknown, i.e. 0.
+type DefinitionError struct {
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+func (e DefinitionError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Message)
+}
+
+// InvalidDefinitionError holds the errors of an invalid pipeline definition
+// document, in their order in the document
+type InvalidDefinitionError struct {
+	Errors []DefinitionError
+}
+
+func (e *InvalidDefinitionError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, derr := range e.Errors {
+		msgs = append(msgs, derr.Error())
+	}
+	return "invalid pipeline definition: " + strings.Join(msgs, "; ")
+}
+
+var (
+	definitionNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	// yaml.v3 reports the syntax errors as "yaml: line N: message"
+	yamlErrorRegexp = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+)
+
+const definitionNameMaxLength = 63
+
+// ParseDefinition parses and validates the given pipeline definition
+// document, the stages targeting one of the given environments of the
+// Pipeline Env Map. An invalid document is reported as an
+// *InvalidDefinitionError listing all its errors.
+func ParseDefinition(document string, environments []uuid.UUID) (*PipelineDefinition, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(document), &root); err != nil {
+		derr := DefinitionError{Line: 1, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if m := yamlErrorRegexp.FindStringSubmatch(err.Error()); m != nil {
+			derr.Line, _ = strconv.Atoi(m[1])
+			derr.Message = m[2]
+		}
+		return nil, &InvalidDefinitionError{Errors: []DefinitionError{derr}}
+	}
+	if len(root.Content) == 0 {
+		return nil, &InvalidDefinitionError{Errors: []DefinitionError{{Line: 1, Column: 1, Message: "the document is empty"}}}
+	}
+
+	v := &definitionValidator{environments: map[uuid.UUID]bool{}}
+	for _, envID := range environments {
+		v.environments[envID] = true
+	}
+	def := v.definition(root.Content[0])
+	if len(v.errors) > 0 {
+		sort.SliceStable(v.errors, func(i, j int) bool {
+			if v.errors[i].Line != v.errors[j].Line {
+				return v.errors[i].Line < v.errors[j].Line
+			}
+			return v.errors[i].Column < v.errors[j].Column
+		})
+		return nil, &InvalidDefinitionError{Errors: v.errors}
+	}
+	return def, nil
+}
+
+// definitionValidator walks the nodes of a document, collecting its errors
+type definitionValidator struct {
+	environments map[uuid.UUID]bool
+	errors       []DefinitionError
+}
+
+func (v *definitionValidator) add(n *yaml.Node, path, format string, args ...interface{}) {
+	v.errors = append(v.errors, DefinitionError{
+		Line:    n.Line,
+		Column:  n.Column,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *definitionValidator) definition(n *yaml.Node) *PipelineDefinition {
+	def := &PipelineDefinition{}
+	fields, ok := v.mapping(n, "", "version", "builder", "stages")
+	if !ok {
+		return def
+	}
+	if version := v.required(n, fields, "", "version"); version != nil {
+		i, err := strconv.Atoi(version.Value)
+		if version.Kind != yaml.ScalarNode || version.Tag != "!!int" || err != nil || i != DefinitionSchemaVersion {
+			v.add(version, "version", "must be %d", DefinitionSchemaVersion)
+		}
+		def.Version = i
+	}
+	if builder := v.required(n, fields, "", "builder"); builder != nil {
+		def.Builder = v.builder(builder, "builder")
+	}
+	if stages := v.required(n, fields, "", "stages"); stages != nil {
+		def.Stages = v.stages(stages, "stages")
+	}
+	return def
+}
+
+func (v *definitionValidator) builder(n *yaml.Node, path string) Builder {
+	b := Builder{}
+	fields, ok := v.mapping(n, path, "image")
+	if !ok {
+		return b
+	}
+	if image := v.required(n, fields, path, "image"); image != nil {
+		b.Image = v.string(image, path+".image")
+	}
+	return b
+}
+
+func (v *definitionValidator) stages(n *yaml.Node, path string) []Stage {
+	items, ok := v.sequence(n, path)
+	if !ok {
+		return nil
+	}
+	stages := make([]Stage, 0, len(items))
+	names := map[string]bool{}
+	for i, item := range items {
+		stagePath := fmt.Sprintf("%s[%d]", path, i)
+		stage := Stage{}
+		fields, ok := v.mapping(item, stagePath, "name", "environment", "builder", "steps")
+		if !ok {
+			continue
+		}
+		if name := v.required(item, fields, stagePath, "name"); name != nil {
+			stage.Name = v.name(name, stagePath+".name", names)
+		}
+		if env, found := fields["environment"]; found {
+			stage.Environment = v.environment(env, stagePath+".environment")
+		}
+		if builder, found := fields["builder"]; found {
+			b := v.builder(builder, stagePath+".builder")
+			stage.Builder = &b
+		}
+		if steps := v.required(item, fields, stagePath, "steps"); steps != nil {
+			stage.Steps = v.steps(steps, stagePath+".steps")
+		}
+		stages = append(stages, stage)
+	}
+	return stages
+}
+
+func (v *definitionValidator) steps(n *yaml.Node, path string) []Step {
+	items, ok := v.sequence(n, path)
+	if !ok {
+		return nil
+	}
+	steps := make([]Step, 0, len(items))
+	names := map[string]bool{}
+	for i, item := range items {
+		stepPath := fmt.Sprintf("%s[%d]", path, i)
+		step := Step{}
+		fields, ok := v.mapping(item, stepPath, "name", "run")
+		if !ok {
+			continue
+		}
+		if name := v.required(item, fields, stepPath, "name"); name != nil {
+			step.Name = v.name(name, stepPath+".name", names)
+		}
+		if run := v.required(item, fields, stepPath, "run"); run != nil {
+			step.Run = v.string(run, stepPath+".run")
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// environment checks that the given node is the ID of one of the
+// environments of the map
+func (v *definitionValidator) environment(n *yaml.Node, path string) *uuid.UUID {
+	value := v.string(n, path)
+	if value == "" {
+		return nil
+	}
+	envID, err := uuid.FromString(value)
+	if err != nil {
+		v.add(n, path, "must be the ID of an environment")
+		return nil
+	}
+	if !v.environments[envID] {
+		v.add(n, path, "the environment %s is not an environment of the pipeline environment map", envID)
+		return nil
+	}
+	return &envID
+}
+
+// name checks that the given node is a DNS label, unique among the given
+// names
+func (v *definitionValidator) name(n *yaml.Node, path string, names map[string]bool) string {
+	name := v.string(n, path)
+	if name == "" {
+		return ""
+	}
+	if len(name) > definitionNameMaxLength || !definitionNameRegexp.MatchString(name) {
+		v.add(n, path, "must be at most 63 lowercase alphanumeric characters or '-', starting and ending with an alphanumeric character")
+		return name
+	}
+	if names[name] {
+		v.add(n, path, "the name %q is already used", name)
+	}
+	names[name] = true
+	return name
+}
+
+// string checks that the given node is a non empty string
+func (v *definitionValidator) string(n *yaml.Node, path string) string {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!str" {
+		v.add(n, path, "must be a string")
+		return ""
+	}
+	if strings.TrimSpace(n.Value) == "" {
+		v.add(n, path, "must not be empty")
+		return ""
+	}
+	return n.Value
+}
+
+// sequence checks that the given node is a non empty sequence
+func (v *definitionValidator) sequence(n *yaml.Node, path string) ([]*yaml.Node, bool) {
+	n = resolve(n)
+	if n.Kind != yaml.SequenceNode {
+		v.add(n, path, "must be a list")
+		return nil, false
+	}
+	if len(n.Content) == 0 {
+		v.add(n, path, "must not be empty")
+		return nil, false
+	}
+	items := make([]*yaml.Node, 0, len(n.Content))
+	for _, item := range n.Content {
+		items = append(items, resolve(item))
+	}
+	return items, true
+}
+
+// mapping checks that the given node is a mapping of the given keys, and
+// returns the values by key
+func (v *definitionValidator) mapping(n *yaml.Node, path string, keys ...string) (map[string]*yaml.Node, bool) {
+	n = resolve(n)
+	if n.Kind != yaml.MappingNode {
+		v.add(n, path, "must be a mapping")
+		return nil, false
+	}
+	known := map[string]bool{}
+	for _, key := range keys {
+		known[key] = true
+	}
+	fields := map[string]*yaml.Node{}
+	// the content of a mapping alternates the keys and the values
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], resolve(n.Content[i+1])
+		keyPath := joinPath(path, key.Value)
+		switch {
+		case !known[key.Value]:
+			v.add(key, keyPath, "unknown field, expected one of %s", strings.Join(keys, ", "))
+		case fields[key.Value] != nil:
+			v.add(key, keyPath, "duplicate field")
+		default:
+			fields[key.Value] = value
+		}
+	}
+	return fields, true
+}
+
+// required returns the value of the given key of the mapping, reporting it
+// as missing at the mapping if not found
+func (v *definitionValidator) required(n *yaml.Node, fields map[string]*yaml.Node, path, key string) *yaml.Node {
+	value, found := fields[key]
+	if !found {
+		v.add(resolve(n), joinPath(path, key), "missing required field")
+		return nil
+	}
+	return value
+}
+
+// resolve returns the node an alias refers to
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
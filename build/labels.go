@@ -0,0 +1,237 @@
+package build
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/jinzhu/gorm"
+	errs "github.com/pkg/errors"
+)
+
+// Labels are the key/value pairs organizing the Pipeline Env Maps, stored as
+// a JSONB object
+type Labels map[string]string
+
+// Value implements driver.Valuer
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, errs.Wrap(err, "unable to encode the labels")
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (l *Labels) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*l = Labels{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errs.Errorf("unable to decode the labels from %T", src)
+	}
+	labels := Labels{}
+	if err := json.Unmarshal(b, &labels); err != nil {
+		return errs.Wrap(err, "unable to decode the labels")
+	}
+	*l = labels
+	return nil
+}
+
+const (
+	labelNameMaxLength   = 63
+	labelPrefixMaxLength = 253
+)
+
+var (
+	labelNameRegexp   = regexp.MustCompile(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`)
+	labelPrefixRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// ValidateLabelKey checks the given label key like Kubernetes does: an
+// optional DNS subdomain prefix followed by a slash, and a name of at most
+// 63 alphanumeric characters, '-', '_' or '.'
+func ValidateLabelKey(key string) error {
+	name := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		prefix := key[:i]
+		name = key[i+1:]
+		if prefix == "" || len(prefix) > labelPrefixMaxLength || !labelPrefixRegexp.MatchString(prefix) {
+			return fmt.Errorf("the prefix of %q must be a DNS subdomain", key)
+		}
+	}
+	if name == "" || len(name) > labelNameMaxLength || !labelNameRegexp.MatchString(name) {
+		return fmt.Errorf("the name of %q must be at most 63 alphanumeric characters, '-', '_' or '.', starting and ending with an alphanumeric character", key)
+	}
+	return nil
+}
+
+// ValidateLabelValue checks the given label value like Kubernetes does: empty
+// or at most 63 alphanumeric characters, '-', '_' or '.'
+func ValidateLabelValue(value string) error {
+	if value == "" {
+		return nil
+	}
+	if len(value) > labelNameMaxLength || !labelNameRegexp.MatchString(value) {
+		return fmt.Errorf("the value %q must be at most 63 alphanumeric characters, '-', '_' or '.', starting and ending with an alphanumeric character", value)
+	}
+	return nil
+}
+
+// ValidateLabels checks the keys and values of the given labels
+func ValidateLabels(param string, labels map[string]string) error {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	// the first invalid label in order is reported
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := ValidateLabelKey(key); err != nil {
+			return errors.NewBadParameterError(param, key).Expected(err.Error())
+		}
+		if err := ValidateLabelValue(labels[key]); err != nil {
+			return errors.NewBadParameterError(param+"."+key, labels[key]).Expected(err.Error())
+		}
+	}
+	return nil
+}
+
+// Operators of the label selector requirements
+const (
+	SelectorEquals       = "="
+	SelectorNotEquals    = "!="
+	SelectorIn           = "in"
+	SelectorNotIn        = "notin"
+	SelectorExists       = "exists"
+	SelectorDoesNotExist = "!"
+)
+
+// Requirement is a condition on a label of the Pipeline Env Maps
+type Requirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// Selector selects the Pipeline Env Maps matching all its requirements
+type Selector []Requirement
+
+// ParseSelector parses a Kubernetes label selector, i.e. comma separated
+// requirements among key=value, key==value, key!=value, key in (v1,v2),
+// key notin (v1,v2), key and !key
+func ParseSelector(selector string) (Selector, error) {
+	var s Selector
+	for _, expr := range splitRequirements(selector) {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			return nil, errors.NewBadParameterError("labelSelector", selector).Expected("comma separated requirements")
+		}
+		r, err := parseRequirement(expr)
+		if err != nil {
+			return nil, errors.NewBadParameterError("labelSelector", expr).Expected(err.Error())
+		}
+		s = append(s, *r)
+	}
+	return s, nil
+}
+
+// splitRequirements splits the selector on the commas which are not in the
+// values of a set-based requirement
+func splitRequirements(selector string) []string {
+	if strings.TrimSpace(selector) == "" {
+		return nil
+	}
+	var exprs []string
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				exprs = append(exprs, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(exprs, selector[start:])
+}
+
+var setRequirementRegexp = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\(([^()]*)\)$`)
+
+func parseRequirement(expr string) (*Requirement, error) {
+	var r Requirement
+	if m := setRequirementRegexp.FindStringSubmatch(expr); m != nil {
+		r = Requirement{Key: m[1], Operator: m[2]}
+		for _, value := range strings.Split(m[3], ",") {
+			r.Values = append(r.Values, strings.TrimSpace(value))
+		}
+	} else if i := strings.Index(expr, "!="); i >= 0 {
+		r = Requirement{Key: expr[:i], Operator: SelectorNotEquals, Values: []string{expr[i+2:]}}
+	} else if i := strings.Index(expr, "=="); i >= 0 {
+		r = Requirement{Key: expr[:i], Operator: SelectorEquals, Values: []string{expr[i+2:]}}
+	} else if i := strings.Index(expr, "="); i >= 0 {
+		r = Requirement{Key: expr[:i], Operator: SelectorEquals, Values: []string{expr[i+1:]}}
+	} else if strings.HasPrefix(expr, "!") {
+		r = Requirement{Key: strings.TrimSpace(expr[1:]), Operator: SelectorDoesNotExist}
+	} else {
+		r = Requirement{Key: expr, Operator: SelectorExists}
+	}
+
+	r.Key = strings.TrimSpace(r.Key)
+	if err := ValidateLabelKey(r.Key); err != nil {
+		return nil, err
+	}
+	for i, value := range r.Values {
+		r.Values[i] = strings.TrimSpace(value)
+		if err := ValidateLabelValue(r.Values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+// apply adds the conditions of the selector to the query. As with
+// Kubernetes, the maps without the label match != and notin.
+func (s Selector) apply(db *gorm.DB) (*gorm.DB, error) {
+	for _, r := range s {
+		switch r.Operator {
+		case SelectorEquals:
+			// the containment is answered by the GIN index
+			b, err := json.Marshal(map[string]string{r.Key: r.Values[0]})
+			if err != nil {
+				return nil, errs.Wrap(err, "unable to encode the label selector")
+			}
+			db = db.Where("labels @> ?::jsonb", string(b))
+		case SelectorNotEquals:
+			db = db.Where("labels ->> ?::text IS DISTINCT FROM ?", r.Key, r.Values[0])
+		case SelectorIn:
+			db = db.Where("labels ->> ?::text IN (?)", r.Key, r.Values)
+		case SelectorNotIn:
+			db = db.Where("(labels ->> ?::text IS NULL OR labels ->> ?::text NOT IN (?))", r.Key, r.Key, r.Values)
+		case SelectorExists:
+			db = db.Where("labels ->> ?::text IS NOT NULL", r.Key)
+		case SelectorDoesNotExist:
+			db = db.Where("labels ->> ?::text IS NULL", r.Key)
+		default:
+			return nil, errors.NewBadParameterError("labelSelector", r.Operator).Expected("a selector operator")
+		}
+	}
+	return db, nil
+}
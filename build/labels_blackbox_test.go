@@ -0,0 +1,58 @@
+package build_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLabels(t *testing.T) {
+	valid := map[string]string{
+		"team":                     "payments",
+		"app.kubernetes.io/name":   "my-app_1.0",
+		"tier":                     "",
+		strings.Repeat("k", 63):    strings.Repeat("v", 63),
+		"example.com/" + "a.b-c_d": "x",
+	}
+	require.NoError(t, build.ValidateLabels("labels", valid))
+
+	for _, labels := range []map[string]string{
+		{"": "value"},
+		{"-team": "payments"},
+		{"team-": "payments"},
+		{"/team": "payments"},
+		{"Example.com/team": "payments"},
+		{strings.Repeat("k", 64): "payments"},
+		{"team": "pay ments"},
+		{"team": "-payments"},
+		{"team": strings.Repeat("v", 64)},
+	} {
+		assert.Error(t, build.ValidateLabels("labels", labels), "%v", labels)
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	sel, err := build.ParseSelector("team=payments, tier!=batch,env==prod,region in (eu, us),zone notin (a),canary,!legacy")
+	require.NoError(t, err)
+	assert.Equal(t, build.Selector{
+		{Key: "team", Operator: build.SelectorEquals, Values: []string{"payments"}},
+		{Key: "tier", Operator: build.SelectorNotEquals, Values: []string{"batch"}},
+		{Key: "env", Operator: build.SelectorEquals, Values: []string{"prod"}},
+		{Key: "region", Operator: build.SelectorIn, Values: []string{"eu", "us"}},
+		{Key: "zone", Operator: build.SelectorNotIn, Values: []string{"a"}},
+		{Key: "canary", Operator: build.SelectorExists},
+		{Key: "legacy", Operator: build.SelectorDoesNotExist},
+	}, sel)
+
+	sel, err = build.ParseSelector("")
+	require.NoError(t, err)
+	assert.Empty(t, sel)
+
+	for _, selector := range []string{"team=payments,", "team=pay ments", "=payments", "tier in (web", "tier in web", "!"} {
+		_, err := build.ParseSelector(selector)
+		assert.Error(t, err, selector)
+	}
+}
@@ -3,8 +3,10 @@ package build
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/fabric8-services/fabric8-build/application/tracing"
 	"github.com/fabric8-services/fabric8-common/errors"
 	"github.com/fabric8-services/fabric8-common/gormsupport"
 	"github.com/goadesign/goa"
@@ -20,6 +22,7 @@ type PipelineEnvMap struct {
 	ID           uuid.UUID  `sql:"type:uuid default uuid_generate_v4()" gorm:"primary_key"`
 	Name         *string    `gorm:"not null;unique"` // Set field as not nullable and unique
 	SpaceID      *uuid.UUID `sql:"type:uuid"`
+	Labels       Labels     `sql:"type:jsonb"`
 	Environments []PipelineEnvironment
 }
 
@@ -33,8 +36,86 @@ type PipelineEnvironment struct {
 type Repository interface {
 	Create(ctx context.Context, pipEnvMap *PipelineEnvMap) (*PipelineEnvMap, error)
 	Load(ctx context.Context, ID uuid.UUID) (*PipelineEnvMap, error)
-	List(ctx context.Context, spaceID uuid.UUID) ([]*PipelineEnvMap, error)
+	List(ctx context.Context, spaceID uuid.UUID, selector Selector, sort ...SortKey) ([]*PipelineEnvMap, error)
+	ListByEnvironment(ctx context.Context, envID uuid.UUID) ([]*PipelineEnvMap, error)
 	Save(ctx context.Context, pipEnvMap *PipelineEnvMap) (*PipelineEnvMap, error)
+	Delete(ctx context.Context, ID uuid.UUID) error
+	DeleteBySpace(ctx context.Context, spaceID uuid.UUID) (int64, error)
+	ListSpaceIDs(ctx context.Context) ([]uuid.UUID, error)
+	DeleteEnvironments(ctx context.Context, ID uuid.UUID, envIDs []uuid.UUID) (int64, error)
+	Stats(ctx context.Context) (*Stats, error)
+	CountBySpace(ctx context.Context, spaceID uuid.UUID) (int, error)
+	LastModified(ctx context.Context, spaceID uuid.UUID) (*time.Time, error)
+	ListDeleted(ctx context.Context, spaceID uuid.UUID) ([]*PipelineEnvMap, error)
+	LoadDeleted(ctx context.Context, ID uuid.UUID) (*PipelineEnvMap, error)
+	Restore(ctx context.Context, ID uuid.UUID) (*PipelineEnvMap, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// DefaultTrashRetention is how long the deleted Pipeline Env Maps are kept
+// before being purged
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// Stats counts the Pipeline Env Maps
+type Stats struct {
+	Maps         int64
+	Spaces       int64
+	Environments int64
+}
+
+// sortableColumns are the columns the Pipeline Env Maps can be sorted by,
+// keyed by their name in the API
+var sortableColumns = map[string]string{
+	"name":       "name",
+	"created-at": "created_at",
+	"updated-at": "updated_at",
+}
+
+// columnNames is the set of the sortable columns
+var columnNames = func() map[string]struct{} {
+	names := make(map[string]struct{}, len(sortableColumns))
+	for _, column := range sortableColumns {
+		names[column] = struct{}{}
+	}
+	return names
+}()
+
+// SortKey orders the Pipeline Env Maps by a sortable column
+type SortKey struct {
+	Column     string
+	Descending bool
+}
+
+// ParseSort parses a JSONAPI sort parameter, i.e. comma separated keys
+// prefixed with "-" for a descending order, e.g. "name,-updated-at"
+func ParseSort(sort string) ([]SortKey, error) {
+	if sort == "" {
+		return nil, nil
+	}
+	var keys []SortKey
+	for _, key := range strings.Split(sort, ",") {
+		descending := strings.HasPrefix(key, "-")
+		column, ok := sortableColumns[strings.TrimPrefix(key, "-")]
+		if !ok {
+			return nil, errors.NewBadParameterError("sort", key).Expected("one of name, created-at or updated-at")
+		}
+		keys = append(keys, SortKey{Column: column, Descending: descending})
+	}
+	return keys, nil
+}
+
+// orderBy returns the ORDER BY clause of the given keys, the ID being the
+// last key so that the order is stable
+func orderBy(keys []SortKey) string {
+	clauses := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		if key.Descending {
+			clauses = append(clauses, key.Column+" DESC")
+		} else {
+			clauses = append(clauses, key.Column)
+		}
+	}
+	return strings.Join(append(clauses, "id"), ", ")
 }
 
 type GormRepository struct {
@@ -47,11 +128,39 @@ func NewRepository(db *gorm.DB) *GormRepository {
 	}
 }
 
+// measure records the duration of the given repository operation along with
+// its outcome: success, not_found, conflict, bad_parameter or error
+func measure(operation string, start time.Time, err *error) {
+	outcome := "success"
+	if *err != nil {
+		switch errs.Cause(*err).(type) {
+		case errors.NotFoundError:
+			outcome = "not_found"
+		case errors.DataConflictError:
+			outcome = "conflict"
+		case errors.BadParameterError:
+			outcome = "bad_parameter"
+		default:
+			outcome = "error"
+		}
+	}
+	goa.MeasureSince([]string{"goa", "db", "pipeline_env_maps", operation, outcome}, start)
+}
+
+// dbFor returns the DB to run the queries on behalf of ctx, so that they are
+// traced as part of the current request
+func (r *GormRepository) dbFor(ctx context.Context) *gorm.DB {
+	return tracing.WithGormContext(ctx, r.db)
+}
+
 // Create a Pipeline Env Map
-func (r *GormRepository) Create(ctx context.Context, pipEnvMap *PipelineEnvMap) (*PipelineEnvMap, error) {
-	defer goa.MeasureSince([]string{"goa", "db", "pipeline_env_maps", "create"}, time.Now())
+func (r *GormRepository) Create(ctx context.Context, pipEnvMap *PipelineEnvMap) (_ *PipelineEnvMap, err error) {
+	defer measure("create", time.Now(), &err)
+	if err = r.lockChanges(ctx, pipEnvMap.SpaceID); err != nil {
+		return nil, err
+	}
 
-	err := r.db.Create(pipEnvMap).Error
+	err = r.dbFor(ctx).Create(pipEnvMap).Error
 	if err != nil {
 		if gormsupport.IsUniqueViolation(err, "pipeline_env_maps_name_space_id_key") {
 			return nil, errors.NewDataConflictError(fmt.Sprintf("pipeline_environment_map_name %s with spaceID %s already exists", *pipEnvMap.Name, *pipEnvMap.SpaceID))
@@ -61,15 +170,33 @@ func (r *GormRepository) Create(ctx context.Context, pipEnvMap *PipelineEnvMap)
 			"unable to create pipeline-environment map")
 		return nil, errs.WithStack(err)
 	}
+	if err = r.recordChanges(ctx, ChangeCreate, "m.id = ?", pipEnvMap.ID); err != nil {
+		return nil, err
+	}
 
 	return pipEnvMap, nil
 }
 
-// List all Pipeline Env Map in a space
-func (r *GormRepository) List(ctx context.Context, spaceID uuid.UUID) ([]*PipelineEnvMap, error) {
-	defer goa.MeasureSince([]string{"goa", "db", "pipeline_env_maps", "list"}, time.Now())
+// List all Pipeline Env Map in a space matching the label selector, sorted by
+// the given keys
+func (r *GormRepository) List(ctx context.Context, spaceID uuid.UUID, selector Selector, sort ...SortKey) (_ []*PipelineEnvMap, err error) {
+	defer measure("list", time.Now(), &err)
 	var rows []*PipelineEnvMap
-	tx := r.db.Model(&PipelineEnvMap{}).Where("space_id = ?", spaceID).Preload("Environments").Find(&rows)
+	db := r.dbFor(ctx).Model(&PipelineEnvMap{}).Where("space_id = ?", spaceID)
+	db, err = selector.apply(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(sort) > 0 {
+		// only the whitelisted columns are accepted by ParseSort
+		for _, key := range sort {
+			if _, ok := columnNames[key.Column]; !ok {
+				return nil, errors.NewBadParameterError("sort", key.Column).Expected("a sortable column")
+			}
+		}
+		db = db.Order(orderBy(sort))
+	}
+	tx := db.Preload("Environments").Find(&rows)
 	if tx.RecordNotFound() {
 		log.Error(ctx, map[string]interface{}{"space_id": spaceID.String()},
 			"state or known referer was empty")
@@ -85,11 +212,26 @@ func (r *GormRepository) List(ctx context.Context, spaceID uuid.UUID) ([]*Pipeli
 	return rows, nil
 }
 
+// ListByEnvironment lists all Pipeline Env Map referencing the given environment
+func (r *GormRepository) ListByEnvironment(ctx context.Context, envID uuid.UUID) (_ []*PipelineEnvMap, err error) {
+	defer measure("list_by_environment", time.Now(), &err)
+	var rows []*PipelineEnvMap
+	tx := r.dbFor(ctx).Model(&PipelineEnvMap{}).
+		Where("id IN (SELECT pipelineenvmap_id FROM pipeline_environments WHERE environment_id = ? AND deleted_at IS NULL)", envID).
+		Preload("Environments").Find(&rows)
+	if tx.Error != nil && !tx.RecordNotFound() {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error, "env_id": envID.String()},
+			"unable to list the pipeline-environment by environment ID")
+		return nil, errors.NewInternalError(ctx, tx.Error)
+	}
+	return rows, nil
+}
+
 // Load a Pipeline Env Map of given ID
-func (r *GormRepository) Load(ctx context.Context, ID uuid.UUID) (*PipelineEnvMap, error) {
-	defer goa.MeasureSince([]string{"goa", "db", "pipeline_env_maps", "load"}, time.Now())
+func (r *GormRepository) Load(ctx context.Context, ID uuid.UUID) (_ *PipelineEnvMap, err error) {
+	defer measure("load", time.Now(), &err)
 	ppl := PipelineEnvMap{}
-	tx := r.db.Model(&PipelineEnvMap{}).Where("id = ?", ID).Preload("Environments").First(&ppl)
+	tx := r.dbFor(ctx).Model(&PipelineEnvMap{}).Where("id = ?", ID).Preload("Environments").First(&ppl)
 	if tx.RecordNotFound() {
 		log.Error(ctx, map[string]interface{}{"id": ID.String()},
 			"state or known referer was empty")
@@ -106,8 +248,8 @@ func (r *GormRepository) Load(ctx context.Context, ID uuid.UUID) (*PipelineEnvMa
 }
 
 // Save the given Pipeline Env Map
-func (r *GormRepository) Save(ctx context.Context, p *PipelineEnvMap) (*PipelineEnvMap, error) {
-	defer goa.MeasureSince([]string{"goa", "db", "pipeline_env_maps", "save"}, time.Now())
+func (r *GormRepository) Save(ctx context.Context, p *PipelineEnvMap) (_ *PipelineEnvMap, err error) {
+	defer measure("save", time.Now(), &err)
 	ppl, err := r.Load(ctx, p.ID)
 	if err != nil {
 		log.Error(ctx, map[string]interface{}{
@@ -116,14 +258,29 @@ func (r *GormRepository) Save(ctx context.Context, p *PipelineEnvMap) (*Pipeline
 		}, "unable to load pipeline environment map")
 		return nil, errors.NewInternalError(ctx, err)
 	}
+	if err = r.lockChanges(ctx, ppl.SpaceID); err != nil {
+		return nil, err
+	}
 
-	tx := r.db.Model(ppl).Updates(p)
+	// the environments are replaced, not merged, so that their order is kept
+	if p.Environments != nil {
+		err = r.dbFor(ctx).Where("pipelineenvmap_id = ?", p.ID).Delete(&PipelineEnvironment{}).Error
+		if err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"err":                         err,
+				"pipeline_environment_map_id": p.ID,
+			}, "unable to replace the environments of the pipeline environment map")
+			return nil, errors.NewInternalError(ctx, err)
+		}
+		ppl.Environments = nil
+	}
+	tx := r.dbFor(ctx).Model(ppl).Updates(p)
 	if err := tx.Error; err != nil {
 		if gormsupport.IsCheckViolation(tx.Error, "pipelineEnvMap_name_check") {
 			return nil, errors.NewBadParameterError("Name", p.Name).Expected("not empty")
 		}
-		if gormsupport.IsUniqueViolation(tx.Error, "pipelineEnvMap_name_id") {
-			return nil, errors.NewBadParameterError("Name", p.Name).Expected("unique")
+		if gormsupport.IsUniqueViolation(tx.Error, "pipeline_env_maps_name_space_id_key") {
+			return nil, errors.NewDataConflictError(fmt.Sprintf("pipeline_environment_map_name %s with spaceID %s already exists", *p.Name, *ppl.SpaceID))
 		}
 		log.Error(ctx, map[string]interface{}{
 			"err":                         err,
@@ -131,8 +288,303 @@ func (r *GormRepository) Save(ctx context.Context, p *PipelineEnvMap) (*Pipeline
 		}, "unable to update pipeline environment map")
 		return nil, errors.NewInternalError(ctx, err)
 	}
+	if err = r.recordChanges(ctx, ChangeUpdate, "m.id = ?", p.ID); err != nil {
+		return nil, err
+	}
 	log.Info(ctx, map[string]interface{}{
 		"pipelineEnvironment_id": p.ID,
 	}, "pipelineEnvironment map updated successfully")
 	return p, nil
 }
+
+// Delete the Pipeline Env Map of given ID along with its environments
+func (r *GormRepository) Delete(ctx context.Context, ID uuid.UUID) (err error) {
+	defer measure("delete", time.Now(), &err)
+	spaceID, err := r.spaceOf(ctx, ID)
+	if err != nil {
+		return err
+	}
+	if err = r.lockChanges(ctx, spaceID); err != nil {
+		return err
+	}
+	tx := r.dbFor(ctx).Where("id = ?", ID).Delete(&PipelineEnvMap{})
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error, "id": ID.String()},
+			"unable to delete the pipeline-environment map")
+		return errors.NewInternalError(ctx, tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return errors.NewNotFoundError("pipeline-environment", ID.String())
+	}
+
+	err = r.dbFor(ctx).Where("pipelineenvmap_id = ?", ID).Delete(&PipelineEnvironment{}).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "id": ID.String()},
+			"unable to delete the environments of the pipeline-environment map")
+		return errors.NewInternalError(ctx, err)
+	}
+	if err = r.recordChanges(ctx, ChangeDelete, "m.id = ?", ID); err != nil {
+		return err
+	}
+	log.Info(ctx, map[string]interface{}{
+		"pipelineEnvironment_id": ID,
+	}, "pipelineEnvironment map deleted successfully")
+	return nil
+}
+
+// DeleteBySpace permanently deletes all the Pipeline Env Map of the given space
+// along with their environments, it returns the number of maps deleted
+func (r *GormRepository) DeleteBySpace(ctx context.Context, spaceID uuid.UUID) (_ int64, err error) {
+	defer measure("delete_by_space", time.Now(), &err)
+	if err = r.lockChanges(ctx, &spaceID); err != nil {
+		return 0, err
+	}
+	// the tombstones of the maps deleted before were already recorded
+	if err = r.recordChanges(ctx, ChangeDelete, "m.space_id = ? AND m.deleted_at IS NULL", spaceID); err != nil {
+		return 0, err
+	}
+	db := r.dbFor(ctx).Unscoped()
+	err = db.Where("pipelineenvmap_id IN (SELECT id FROM pipeline_env_maps WHERE space_id = ?)", spaceID).Delete(&PipelineEnvironment{}).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to delete the environments of the pipeline-environment maps of the space")
+		return 0, errors.NewInternalError(ctx, err)
+	}
+	tx := db.Where("space_id = ?", spaceID).Delete(&PipelineEnvMap{})
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error, "space_id": spaceID.String()},
+			"unable to delete the pipeline-environment maps of the space")
+		return 0, errors.NewInternalError(ctx, tx.Error)
+	}
+	log.Info(ctx, map[string]interface{}{
+		"space_id": spaceID,
+		"deleted":  tx.RowsAffected,
+	}, "pipelineEnvironment maps of the space deleted successfully")
+	return tx.RowsAffected, nil
+}
+
+// ListSpaceIDs lists the IDs of the spaces having Pipeline Env Maps
+func (r *GormRepository) ListSpaceIDs(ctx context.Context) (_ []uuid.UUID, err error) {
+	defer measure("list_space_ids", time.Now(), &err)
+	var spaceIDs []uuid.UUID
+	err = r.dbFor(ctx).Model(&PipelineEnvMap{}).Where("space_id IS NOT NULL").Pluck("DISTINCT space_id", &spaceIDs).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err},
+			"unable to list the spaces of the pipeline-environment maps")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	return spaceIDs, nil
+}
+
+// DeleteEnvironments removes the given environments from the Pipeline Env Map
+// of given ID, it returns the number of environments removed
+func (r *GormRepository) DeleteEnvironments(ctx context.Context, ID uuid.UUID, envIDs []uuid.UUID) (_ int64, err error) {
+	defer measure("delete_environments", time.Now(), &err)
+	if len(envIDs) == 0 {
+		return 0, nil
+	}
+	spaceID, err := r.spaceOf(ctx, ID)
+	if err != nil {
+		return 0, err
+	}
+	if err = r.lockChanges(ctx, spaceID); err != nil {
+		return 0, err
+	}
+	tx := r.dbFor(ctx).Where("pipelineenvmap_id = ? AND environment_id IN (?)", ID, envIDs).Delete(&PipelineEnvironment{})
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error, "id": ID.String()},
+			"unable to delete the environments of the pipeline-environment map")
+		return 0, errors.NewInternalError(ctx, tx.Error)
+	}
+	if tx.RowsAffected > 0 {
+		// the map changed along with its environments
+		err = r.dbFor(ctx).Model(&PipelineEnvMap{}).Where("id = ?", ID).UpdateColumn("updated_at", time.Now()).Error
+		if err != nil {
+			log.Error(ctx, map[string]interface{}{"err": err, "id": ID.String()},
+				"unable to touch the pipeline-environment map")
+			return 0, errors.NewInternalError(ctx, err)
+		}
+		if err = r.recordChanges(ctx, ChangeUpdate, "m.id = ?", ID); err != nil {
+			return 0, err
+		}
+	}
+	log.Info(ctx, map[string]interface{}{
+		"pipelineEnvironment_id": ID,
+		"deleted":                tx.RowsAffected,
+	}, "environments of the pipelineEnvironment map deleted successfully")
+	return tx.RowsAffected, nil
+}
+
+// Stats counts the Pipeline Env Maps, their spaces and their environments
+func (r *GormRepository) Stats(ctx context.Context) (_ *Stats, err error) {
+	defer measure("stats", time.Now(), &err)
+	stats := Stats{}
+	err = r.dbFor(ctx).Raw(`SELECT
+		(SELECT count(*) FROM pipeline_env_maps WHERE deleted_at IS NULL) AS maps,
+		(SELECT count(DISTINCT space_id) FROM pipeline_env_maps WHERE deleted_at IS NULL) AS spaces,
+		(SELECT count(*) FROM pipeline_environments WHERE deleted_at IS NULL) AS environments`).Scan(&stats).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err},
+			"unable to count the pipeline-environment maps")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	return &stats, nil
+}
+
+// CountBySpace counts the Pipeline Env Maps of the given space, the deleted
+// ones excepted
+func (r *GormRepository) CountBySpace(ctx context.Context, spaceID uuid.UUID) (_ int, err error) {
+	defer measure("count_by_space", time.Now(), &err)
+	var count int
+	err = r.dbFor(ctx).Model(&PipelineEnvMap{}).Where("space_id = ?", spaceID).Count(&count).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to count the pipeline-environment maps of the space")
+		return 0, errors.NewInternalError(ctx, err)
+	}
+	return count, nil
+}
+
+// LastModified returns when the Pipeline Env Maps of the given space were
+// last created, updated or deleted, nil if the space never had any
+func (r *GormRepository) LastModified(ctx context.Context, spaceID uuid.UUID) (_ *time.Time, err error) {
+	defer measure("last_modified", time.Now(), &err)
+	var row struct {
+		LastModified *time.Time
+	}
+	err = r.dbFor(ctx).Raw(`SELECT max(greatest(updated_at, deleted_at)) AS last_modified
+		FROM pipeline_env_maps WHERE space_id = ?`, spaceID).Scan(&row).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to get the last modification of the pipeline-environment maps")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	return row.LastModified, nil
+}
+
+// ListDeleted lists the soft-deleted Pipeline Env Maps of a space, most
+// recently deleted first, along with the environments they had when deleted
+func (r *GormRepository) ListDeleted(ctx context.Context, spaceID uuid.UUID) (_ []*PipelineEnvMap, err error) {
+	defer measure("list_deleted", time.Now(), &err)
+	var rows []*PipelineEnvMap
+	db := r.dbFor(ctx).Unscoped()
+	err = db.Where("space_id = ? AND deleted_at IS NOT NULL", spaceID).Order("deleted_at DESC, id").Find(&rows).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to list the deleted pipeline-environment maps")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	if len(rows) == 0 {
+		return rows, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(rows))
+	byID := make(map[uuid.UUID]*PipelineEnvMap, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+		byID[row.ID] = row
+	}
+	var envs []PipelineEnvironment
+	err = db.Where("pipelineenvmap_id IN (?) AND deleted_at IS NOT NULL", ids).Order("created_at").Find(&envs).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to list the environments of the deleted pipeline-environment maps")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	for _, env := range envs {
+		// the environments removed before the map was deleted are skipped
+		ppl := byID[env.PipelineEnvMapID]
+		if env.DeletedAt.Before(*ppl.DeletedAt) {
+			continue
+		}
+		ppl.Environments = append(ppl.Environments, env)
+	}
+	return rows, nil
+}
+
+// LoadDeleted loads the soft-deleted Pipeline Env Map of given ID along with
+// the environments it had when deleted
+func (r *GormRepository) LoadDeleted(ctx context.Context, ID uuid.UUID) (_ *PipelineEnvMap, err error) {
+	defer measure("load_deleted", time.Now(), &err)
+	ppl := PipelineEnvMap{}
+	db := r.dbFor(ctx).Unscoped()
+	tx := db.Where("id = ? AND deleted_at IS NOT NULL", ID).First(&ppl)
+	if tx.RecordNotFound() {
+		return nil, errors.NewNotFoundError("deleted pipeline-environment", ID.String())
+	}
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error, "id": ID.String()},
+			"unable to load the deleted pipeline-environment map")
+		return nil, errors.NewInternalError(ctx, tx.Error)
+	}
+	// the environments removed before the map was deleted are skipped
+	err = db.Where("pipelineenvmap_id = ? AND deleted_at >= ?", ID, ppl.DeletedAt).Order("created_at").Find(&ppl.Environments).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "id": ID.String()},
+			"unable to load the environments of the deleted pipeline-environment map")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	return &ppl, nil
+}
+
+// Restore the soft-deleted Pipeline Env Map of given ID along with the
+// environments it had when deleted. Its name must not have been reused in the
+// meantime.
+func (r *GormRepository) Restore(ctx context.Context, ID uuid.UUID) (_ *PipelineEnvMap, err error) {
+	defer measure("restore", time.Now(), &err)
+	ppl, err := r.LoadDeleted(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+	db := r.dbFor(ctx).Unscoped()
+	if err = r.lockChanges(ctx, ppl.SpaceID); err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&PipelineEnvMap{}).Where("id = ?", ID).
+		UpdateColumns(map[string]interface{}{"deleted_at": nil, "updated_at": time.Now()}).Error
+	if err != nil {
+		if gormsupport.IsUniqueViolation(err, "pipeline_env_maps_name_space_id_key") {
+			return nil, errors.NewDataConflictError(fmt.Sprintf("pipeline_environment_map_name %s with spaceID %s already exists", *ppl.Name, *ppl.SpaceID))
+		}
+		log.Error(ctx, map[string]interface{}{"err": err, "id": ID.String()},
+			"unable to restore the pipeline-environment map")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	err = db.Model(&PipelineEnvironment{}).Where("pipelineenvmap_id = ? AND deleted_at >= ?", ID, ppl.DeletedAt).
+		UpdateColumn("deleted_at", nil).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "id": ID.String()},
+			"unable to restore the environments of the pipeline-environment map")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	// the map reappears for the readers of the changes
+	if err = r.recordChanges(ctx, ChangeCreate, "m.id = ?", ID); err != nil {
+		return nil, err
+	}
+	log.Info(ctx, map[string]interface{}{
+		"pipelineEnvironment_id": ID,
+	}, "pipelineEnvironment map restored successfully")
+	return r.Load(ctx, ID)
+}
+
+// Purge permanently deletes the Pipeline Env Maps and the environments
+// soft-deleted before the given time, it returns the number of maps deleted
+func (r *GormRepository) Purge(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer measure("purge", time.Now(), &err)
+	db := r.dbFor(ctx).Unscoped()
+	err = db.Where("deleted_at < ? OR pipelineenvmap_id IN (SELECT id FROM pipeline_env_maps WHERE deleted_at < ?)", before, before).
+		Delete(&PipelineEnvironment{}).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err},
+			"unable to purge the deleted environments of the pipeline-environment maps")
+		return 0, errors.NewInternalError(ctx, err)
+	}
+	tx := db.Where("deleted_at < ?", before).Delete(&PipelineEnvMap{})
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error},
+			"unable to purge the deleted pipeline-environment maps")
+		return 0, errors.NewInternalError(ctx, tx.Error)
+	}
+	return tx.RowsAffected, nil
+}
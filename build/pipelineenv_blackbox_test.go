@@ -3,9 +3,12 @@ package build_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-common/errors"
 	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	errs "github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -77,17 +80,109 @@ func (s *BuildRepositorySuite) TestList() {
 	require.NotNil(s.T(), newEnv)
 	require.NotNil(s.T(), newEnv2)
 
-	env, err := s.buildRepo.List(context.Background(), spaceID)
+	env, err := s.buildRepo.List(context.Background(), spaceID, nil)
 	require.NoError(s.T(), err)
 	assert.NotNil(s.T(), env)
 	assert.Equal(s.T(), 2, len(env))
 
-	env2, err2 := s.buildRepo.List(context.Background(), uuid.NewV4())
+	env2, err2 := s.buildRepo.List(context.Background(), uuid.NewV4(), nil)
 	require.NoError(s.T(), err2)
 	assert.NotNil(s.T(), env2)
 	assert.Equal(s.T(), 0, len(env2))
 }
 
+func (s *BuildRepositorySuite) TestListSorted() {
+	ctx := context.Background()
+	spaceID := uuid.NewV4()
+	for _, name := range []string{"sort-b", "sort-c", "sort-a"} {
+		_, err := s.buildRepo.Create(ctx, newPipelineEnvMap(name, spaceID, uuid.NewV4()))
+		require.NoError(s.T(), err)
+	}
+	names := func(ppls []*build.PipelineEnvMap) []string {
+		var names []string
+		for _, ppl := range ppls {
+			names = append(names, *ppl.Name)
+		}
+		return names
+	}
+
+	sort, err := build.ParseSort("name")
+	require.NoError(s.T(), err)
+	ppls, err := s.buildRepo.List(ctx, spaceID, nil, sort...)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"sort-a", "sort-b", "sort-c"}, names(ppls))
+
+	sort, err = build.ParseSort("-created-at,name")
+	require.NoError(s.T(), err)
+	ppls, err = s.buildRepo.List(ctx, spaceID, nil, sort...)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"sort-a", "sort-c", "sort-b"}, names(ppls))
+
+	s.T().Run("not sortable", func(t *testing.T) {
+		_, err := s.buildRepo.List(ctx, spaceID, nil, build.SortKey{Column: "name; DROP TABLE pipeline_env_maps"})
+		require.Error(t, err)
+	})
+}
+
+func (s *BuildRepositorySuite) TestListSelector() {
+	ctx := context.Background()
+	spaceID := uuid.NewV4()
+	for name, labels := range map[string]build.Labels{
+		"select-web":   {"team": "payments", "tier": "web"},
+		"select-batch": {"team": "payments", "tier": "batch"},
+		"select-other": {"team": "billing"},
+		"select-none":  nil,
+	} {
+		ppl := newPipelineEnvMap(name, spaceID, uuid.NewV4())
+		ppl.Labels = labels
+		_, err := s.buildRepo.Create(ctx, ppl)
+		require.NoError(s.T(), err)
+	}
+	names := func(selector string) []string {
+		sel, err := build.ParseSelector(selector)
+		require.NoError(s.T(), err)
+		ppls, err := s.buildRepo.List(ctx, spaceID, sel, build.SortKey{Column: "name"})
+		require.NoError(s.T(), err)
+		var names []string
+		for _, ppl := range ppls {
+			names = append(names, *ppl.Name)
+		}
+		return names
+	}
+
+	assert.Equal(s.T(), []string{"select-web"}, names("team=payments,tier!=batch"))
+	assert.Equal(s.T(), []string{"select-batch", "select-none", "select-other"}, names("tier!=web"))
+	assert.Equal(s.T(), []string{"select-batch", "select-web"}, names("tier in (web, batch)"))
+	assert.Equal(s.T(), []string{"select-none", "select-other", "select-web"}, names("tier notin (batch)"))
+	assert.Equal(s.T(), []string{"select-batch", "select-other", "select-web"}, names("team"))
+	assert.Equal(s.T(), []string{"select-none"}, names("!team"))
+	assert.Len(s.T(), names(""), 4)
+
+	s.T().Run("labels loaded", func(t *testing.T) {
+		sel, err := build.ParseSelector("team==billing")
+		require.NoError(t, err)
+		ppls, err := s.buildRepo.List(ctx, spaceID, sel)
+		require.NoError(t, err)
+		require.Len(t, ppls, 1)
+		assert.Equal(t, build.Labels{"team": "billing"}, ppls[0].Labels)
+	})
+}
+
+func TestParseSort(t *testing.T) {
+	keys, err := build.ParseSort("name,-updated-at")
+	require.NoError(t, err)
+	assert.Equal(t, []build.SortKey{{Column: "name"}, {Column: "updated_at", Descending: true}}, keys)
+
+	keys, err = build.ParseSort("")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	for _, sort := range []string{"space", "name,", "-", "updated_at"} {
+		_, err := build.ParseSort(sort)
+		assert.Error(t, err, sort)
+	}
+}
+
 func (s *BuildRepositorySuite) TestSave() {
 	spaceID, envUUID, envUUID2, envUUID3 := uuid.NewV4(), uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
 	pipeline := newPipelineEnvMap("pipelineShow", spaceID, envUUID)
@@ -105,6 +200,178 @@ func (s *BuildRepositorySuite) TestSave() {
 	require.NotNil(s.T(), env)
 	require.NotNil(s.T(), env)
 	assert.Equal(s.T(), envUUID3, *(env.Environments[0].EnvironmentID))
+
+	// the environments are replaced
+	loaded, err := s.buildRepo.Load(context.Background(), pipeline.ID)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), loaded.Environments, 1)
+	assert.Equal(s.T(), envUUID3, *loaded.Environments[0].EnvironmentID)
+
+	// the names are unique in a space
+	_, err = s.buildRepo.Save(context.Background(), updatePipelineEnvMap(newEnv2, envUUID2))
+	require.NoError(s.T(), err)
+	conflicting := updatePipelineEnvMap(newEnv2, envUUID2)
+	conflicting.Name = pipeline.Name
+	_, err = s.buildRepo.Save(context.Background(), conflicting)
+	require.Error(s.T(), err)
+	assert.IsType(s.T(), errors.DataConflictError{}, errs.Cause(err))
+}
+
+func (s *BuildRepositorySuite) TestDelete() {
+	spaceID, envUUID := uuid.NewV4(), uuid.NewV4()
+	newPipEnvMap, err := s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineDelete", spaceID, envUUID))
+	require.NoError(s.T(), err)
+
+	err = s.buildRepo.Delete(context.Background(), newPipEnvMap.ID)
+	require.NoError(s.T(), err)
+
+	_, err = s.buildRepo.Load(context.Background(), newPipEnvMap.ID)
+	require.Error(s.T(), err)
+
+	err = s.buildRepo.Delete(context.Background(), newPipEnvMap.ID)
+	require.Error(s.T(), err)
+	assert.Regexp(s.T(), ".*not found.*", err.Error())
+}
+
+func (s *BuildRepositorySuite) TestTrash() {
+	spaceID, env1ID, env2ID := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	ppl, err := s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineTrash", spaceID, env1ID))
+	require.NoError(s.T(), err)
+	_, err = s.buildRepo.Save(context.Background(), updatePipelineEnvMap(ppl, env2ID))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.buildRepo.Delete(context.Background(), ppl.ID))
+
+	s.T().Run("list", func(t *testing.T) {
+		deleted, err := s.buildRepo.ListDeleted(context.Background(), spaceID)
+		require.NoError(t, err)
+		require.Len(t, deleted, 1)
+		assert.Equal(t, ppl.ID, deleted[0].ID)
+		assert.NotNil(t, deleted[0].DeletedAt)
+		// env1 was removed before the map was deleted
+		require.Len(t, deleted[0].Environments, 1)
+		assert.Equal(t, env2ID, *deleted[0].Environments[0].EnvironmentID)
+	})
+
+	s.T().Run("load deleted", func(t *testing.T) {
+		deleted, err := s.buildRepo.LoadDeleted(context.Background(), ppl.ID)
+		require.NoError(t, err)
+		assert.Equal(t, spaceID, *deleted.SpaceID)
+		require.Len(t, deleted.Environments, 1)
+		assert.Equal(t, env2ID, *deleted.Environments[0].EnvironmentID)
+
+		_, err = s.buildRepo.LoadDeleted(context.Background(), uuid.NewV4())
+		require.Error(t, err)
+		assert.IsType(t, errors.NotFoundError{}, errs.Cause(err))
+	})
+
+	// the name of a deleted map can be reused
+	other, err := s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineTrash", spaceID, env1ID))
+	require.NoError(s.T(), err)
+
+	s.T().Run("restore name taken", func(t *testing.T) {
+		_, err := s.buildRepo.Restore(context.Background(), ppl.ID)
+		require.Error(t, err)
+		assert.IsType(t, errors.DataConflictError{}, errs.Cause(err))
+	})
+
+	s.T().Run("restore", func(t *testing.T) {
+		require.NoError(t, s.buildRepo.Delete(context.Background(), other.ID))
+		restored, err := s.buildRepo.Restore(context.Background(), ppl.ID)
+		require.NoError(t, err)
+		require.Len(t, restored.Environments, 1)
+		assert.Equal(t, env2ID, *restored.Environments[0].EnvironmentID)
+
+		_, err = s.buildRepo.Restore(context.Background(), ppl.ID)
+		require.Error(t, err)
+		assert.IsType(t, errors.NotFoundError{}, errs.Cause(err))
+	})
+
+	s.T().Run("purge", func(t *testing.T) {
+		_, err := s.buildRepo.Purge(context.Background(), time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		deleted, err := s.buildRepo.ListDeleted(context.Background(), spaceID)
+		require.NoError(t, err)
+		assert.Len(t, deleted, 1)
+
+		purged, err := s.buildRepo.Purge(context.Background(), time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.True(t, purged >= 1)
+		deleted, err = s.buildRepo.ListDeleted(context.Background(), spaceID)
+		require.NoError(t, err)
+		assert.Empty(t, deleted)
+
+		loaded, err := s.buildRepo.Load(context.Background(), ppl.ID)
+		require.NoError(t, err)
+		require.Len(t, loaded.Environments, 1)
+		assert.Equal(t, env2ID, *loaded.Environments[0].EnvironmentID)
+	})
+}
+
+func (s *BuildRepositorySuite) TestListByEnvironment() {
+	space1ID, space2ID, envUUID, envUUID2 := uuid.NewV4(), uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	ppl1, err := s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineByEnv1", space1ID, envUUID))
+	require.NoError(s.T(), err)
+	ppl2, err := s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineByEnv2", space2ID, envUUID))
+	require.NoError(s.T(), err)
+	ppl3, err := s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineByEnv3", space1ID, envUUID2))
+	require.NoError(s.T(), err)
+
+	maps, err := s.buildRepo.ListByEnvironment(context.Background(), envUUID)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), maps, 2)
+	ids := []uuid.UUID{maps[0].ID, maps[1].ID}
+	assert.Contains(s.T(), ids, ppl1.ID)
+	assert.Contains(s.T(), ids, ppl2.ID)
+	assert.NotContains(s.T(), ids, ppl3.ID)
+	assert.Len(s.T(), maps[0].Environments, 1)
+
+	// deleted maps are not listed
+	require.NoError(s.T(), s.buildRepo.Delete(context.Background(), ppl2.ID))
+	maps, err = s.buildRepo.ListByEnvironment(context.Background(), envUUID)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), maps, 1)
+	assert.Equal(s.T(), ppl1.ID, maps[0].ID)
+
+	maps, err = s.buildRepo.ListByEnvironment(context.Background(), uuid.NewV4())
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), maps)
+}
+
+func (s *BuildRepositorySuite) TestDeleteBySpace() {
+	spaceID, otherSpaceID, envUUID := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	_, err := s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineBySpace1", spaceID, envUUID))
+	require.NoError(s.T(), err)
+	_, err = s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineBySpace2", spaceID, envUUID))
+	require.NoError(s.T(), err)
+	other, err := s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineBySpace3", otherSpaceID, envUUID))
+	require.NoError(s.T(), err)
+
+	spaceIDs, err := s.buildRepo.ListSpaceIDs(context.Background())
+	require.NoError(s.T(), err)
+	assert.Contains(s.T(), spaceIDs, spaceID)
+	assert.Contains(s.T(), spaceIDs, otherSpaceID)
+
+	deleted, err := s.buildRepo.DeleteBySpace(context.Background(), spaceID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(2), deleted)
+
+	maps, err := s.buildRepo.List(context.Background(), spaceID, nil)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), maps)
+	var count int
+	require.NoError(s.T(), s.DB.Unscoped().Model(&build.PipelineEnvMap{}).Where("space_id = ?", spaceID).Count(&count).Error)
+	assert.Equal(s.T(), 0, count)
+
+	// the other spaces are left untouched
+	_, err = s.buildRepo.Load(context.Background(), other.ID)
+	require.NoError(s.T(), err)
+	spaceIDs, err = s.buildRepo.ListSpaceIDs(context.Background())
+	require.NoError(s.T(), err)
+	assert.NotContains(s.T(), spaceIDs, spaceID)
+
+	deleted, err = s.buildRepo.DeleteBySpace(context.Background(), spaceID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(0), deleted)
 }
 
 func newPipelineEnvMap(name string, spaceID, envUUID uuid.UUID) *build.PipelineEnvMap {
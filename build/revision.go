@@ -0,0 +1,138 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/tracing"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/fabric8-services/fabric8-common/gormsupport"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	errs "github.com/pkg/errors"
+	"github.com/prometheus/common/log"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Revision is an immutable state of a Pipeline Env Map, recorded on each
+// change
+type Revision struct {
+	PipelineEnvMapID uuid.UUID      `sql:"type:uuid" gorm:"primary_key;column:pipelineenvmap_id"`
+	Revision         int            `gorm:"primary_key;auto_increment:false"`
+	Name             string         `gorm:"not null"`
+	EnvironmentIDs   pq.StringArray `sql:"type:uuid[]" gorm:"column:environment_ids"`
+	AuthorID         *uuid.UUID     `sql:"type:uuid"`
+	RestoredFrom     *int
+	CreatedAt        time.Time
+}
+
+// TableName implements gorm.tabler
+func (Revision) TableName() string {
+	return "pipeline_env_map_revisions"
+}
+
+// Environments returns the IDs of the environments of the revision, in
+// their order
+func (r Revision) Environments() []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(r.EnvironmentIDs))
+	for _, id := range r.EnvironmentIDs {
+		ids = append(ids, uuid.FromStringOrNil(id))
+	}
+	return ids
+}
+
+// RevisionRepository records and reads the revisions of the Pipeline Env Maps
+type RevisionRepository interface {
+	Create(ctx context.Context, ppl *PipelineEnvMap, authorID *uuid.UUID, restoredFrom *int) (*Revision, error)
+	List(ctx context.Context, ID uuid.UUID) ([]*Revision, error)
+	Load(ctx context.Context, ID uuid.UUID, revision int) (*Revision, error)
+}
+
+type GormRevisionRepository struct {
+	db *gorm.DB
+}
+
+func NewRevisionRepository(db *gorm.DB) *GormRevisionRepository {
+	return &GormRevisionRepository{
+		db: db,
+	}
+}
+
+func (r *GormRevisionRepository) dbFor(ctx context.Context) *gorm.DB {
+	return tracing.WithGormContext(ctx, r.db)
+}
+
+// Create records the current state of the given Pipeline Env Map as its next
+// revision. It must run in the transaction changing the map, a concurrent
+// change being reported as a conflict.
+func (r *GormRevisionRepository) Create(ctx context.Context, ppl *PipelineEnvMap, authorID *uuid.UUID, restoredFrom *int) (_ *Revision, err error) {
+	defer measure("create_revision", time.Now(), &err)
+	db := r.dbFor(ctx)
+	var last struct {
+		Revision int
+	}
+	err = db.Raw("SELECT COALESCE(max(revision), 0) AS revision FROM pipeline_env_map_revisions WHERE pipelineenvmap_id = ?", ppl.ID).
+		Scan(&last).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "id": ppl.ID.String()},
+			"unable to get the last revision of the pipeline-environment map")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+
+	rev := Revision{
+		PipelineEnvMapID: ppl.ID,
+		Revision:         last.Revision + 1,
+		EnvironmentIDs:   pq.StringArray{},
+		AuthorID:         authorID,
+		RestoredFrom:     restoredFrom,
+		CreatedAt:        time.Now(),
+	}
+	if ppl.Name != nil {
+		rev.Name = *ppl.Name
+	}
+	for _, env := range ppl.Environments {
+		if env.EnvironmentID != nil {
+			rev.EnvironmentIDs = append(rev.EnvironmentIDs, env.EnvironmentID.String())
+		}
+	}
+	err = db.Create(&rev).Error
+	if err != nil {
+		if gormsupport.IsUniqueViolation(err, "pipeline_env_map_revisions_pkey") {
+			return nil, errors.NewDataConflictError("the pipeline environment map was changed concurrently")
+		}
+		log.Error(ctx, map[string]interface{}{"err": err, "id": ppl.ID.String()},
+			"unable to create the revision of the pipeline-environment map")
+		return nil, errs.WithStack(err)
+	}
+	return &rev, nil
+}
+
+// List the revisions of the Pipeline Env Map of given ID, oldest first
+func (r *GormRevisionRepository) List(ctx context.Context, ID uuid.UUID) (_ []*Revision, err error) {
+	defer measure("list_revisions", time.Now(), &err)
+	var rows []*Revision
+	err = r.dbFor(ctx).Where("pipelineenvmap_id = ?", ID).Order("revision").Find(&rows).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "id": ID.String()},
+			"unable to list the revisions of the pipeline-environment map")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	return rows, nil
+}
+
+// Load the given revision of the Pipeline Env Map of given ID
+func (r *GormRevisionRepository) Load(ctx context.Context, ID uuid.UUID, revision int) (_ *Revision, err error) {
+	defer measure("load_revision", time.Now(), &err)
+	rev := Revision{}
+	tx := r.dbFor(ctx).Where("pipelineenvmap_id = ? AND revision = ?", ID, revision).First(&rev)
+	if tx.RecordNotFound() {
+		return nil, errors.NewNotFoundError("pipeline-environment revision", fmt.Sprintf("%s/%d", ID, revision))
+	}
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error, "id": ID.String(), "revision": revision},
+			"unable to load the revision of the pipeline-environment map")
+		return nil, errors.NewInternalError(ctx, tx.Error)
+	}
+	return &rev, nil
+}
@@ -0,0 +1,85 @@
+package build_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-common/errors"
+	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	errs "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type RevisionRepositorySuite struct {
+	testsuite.DBTestSuite
+	buildRepo *build.GormRepository
+	revRepo   *build.GormRevisionRepository
+}
+
+func TestRevisionRepository(t *testing.T) {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	suite.Run(t, &RevisionRepositorySuite{DBTestSuite: testsuite.NewDBTestSuite(config)})
+}
+
+func (s *RevisionRepositorySuite) SetupSuite() {
+	s.DBTestSuite.SetupSuite()
+	s.buildRepo = build.NewRepository(s.DB)
+	s.revRepo = build.NewRevisionRepository(s.DB)
+}
+
+func (s *RevisionRepositorySuite) TestCreate() {
+	spaceID, env1ID, env2ID, authorID := uuid.NewV4(), uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	ppl, err := s.buildRepo.Create(context.Background(), newPipelineEnvMap("pipelineRevisions", spaceID, env1ID))
+	require.NoError(s.T(), err)
+
+	rev1, err := s.revRepo.Create(context.Background(), ppl, &authorID, nil)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, rev1.Revision)
+	assert.Equal(s.T(), "pipelineRevisions", rev1.Name)
+	assert.Equal(s.T(), []uuid.UUID{env1ID}, rev1.Environments())
+
+	// the environments are kept in their order
+	ppl.Environments = []build.PipelineEnvironment{{EnvironmentID: &env2ID}, {EnvironmentID: &env1ID}}
+	restored := 1
+	rev2, err := s.revRepo.Create(context.Background(), ppl, nil, &restored)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, rev2.Revision)
+
+	s.T().Run("list", func(t *testing.T) {
+		revs, err := s.revRepo.List(context.Background(), ppl.ID)
+		require.NoError(t, err)
+		require.Len(t, revs, 2)
+		assert.Equal(t, 1, revs[0].Revision)
+		assert.Equal(t, authorID, *revs[0].AuthorID)
+		assert.Nil(t, revs[0].RestoredFrom)
+		assert.Equal(t, []uuid.UUID{env2ID, env1ID}, revs[1].Environments())
+		assert.Nil(t, revs[1].AuthorID)
+		assert.Equal(t, 1, *revs[1].RestoredFrom)
+	})
+
+	s.T().Run("load", func(t *testing.T) {
+		rev, err := s.revRepo.Load(context.Background(), ppl.ID, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{env2ID, env1ID}, rev.Environments())
+	})
+
+	s.T().Run("not found", func(t *testing.T) {
+		_, err := s.revRepo.Load(context.Background(), ppl.ID, 3)
+		require.Error(t, err)
+		assert.IsType(t, errors.NotFoundError{}, errs.Cause(err))
+	})
+
+	s.T().Run("deleted along with the space", func(t *testing.T) {
+		_, err := s.buildRepo.DeleteBySpace(context.Background(), spaceID)
+		require.NoError(t, err)
+		revs, err := s.revRepo.List(context.Background(), ppl.ID)
+		require.NoError(t, err)
+		assert.Empty(t, revs)
+	})
+}
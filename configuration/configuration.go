@@ -3,7 +3,10 @@ package configuration
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	commonconfig "github.com/fabric8-services/fabric8-common/configuration"
@@ -16,13 +19,56 @@ const (
 	varCleanTestDataEnabled = "clean.test.data"
 	varDBLogsEnabled        = "enable.db.logs"
 	varDeveloperModeEnabled = "developer.mode.enabled"
+	varLocalServicesEnabled = "developer.local.services.enabled"
 	varDiagnoseHTTPAddress  = "diagnose.http.address"
 	varEnvironment          = "environment"
 	varHTTPAddress          = "http.address"
+	varHTTPShutdownDelay    = "http.shutdown.delay"
+	varHTTPShutdownTimeout  = "http.shutdown.timeout"
 	varLogJSON              = "log.json"
 	varLogLevel             = "log.level"
+	varAccessLogEnabled     = "log.access.enabled"
+	varAccessLogSampleRate  = "log.access.sample.rate"
+	varAccessLogExclude     = "log.access.exclude"
 	varMetricsHTTPAddress   = "metrics.http.address"
-	varSentryDSN            = "sentry.dsn"
+	varIdempotencyKeyTTL    = "idempotency.key.ttl"
+	varServiceAccountNames  = "auth.serviceaccounts.names"
+	varServiceAccountIDs    = "auth.serviceaccounts.ids"
+	varSpaceSweeperEnabled  = "space.sweeper.enabled"
+	varSpaceSweeperInterval = "space.sweeper.interval"
+	varSpaceSweeperGrace    = "space.sweeper.grace.period"
+	varSpaceSweeperToken    = "space.sweeper.token"
+	varTrashRetention       = "trash.retention"
+	varTrashPurgeInterval   = "trash.purge.interval"
+	varQuotaMaxMaps         = "quota.max.maps"
+	varQuotaMaxEnvironments = "quota.max.environments.per.map"
+	varEventsHeartbeat      = "events.heartbeat.interval"
+	varEventsMaxStreams     = "events.max.streams.per.identity"
+	varEventsPublisher      = "events.publisher"
+	varEventsInterval       = "events.publisher.interval"
+	varEventsHTTPURL        = "events.publisher.http.url"
+	varEventsHTTPTimeout    = "events.publisher.http.timeout"
+	varEventsAMQPURL        = "events.publisher.amqp.url"
+	varEventsAMQPExchange   = "events.publisher.amqp.exchange"
+	varEventsRetention      = "events.changes.retention"
+	varRateLimitEnabled     = "ratelimit.enabled"
+	varRateLimitStore       = "ratelimit.store"
+	varRateLimitIdentity    = "ratelimit.identity"
+	varRateLimitSpace       = "ratelimit.space"
+	varTracingExporter      = "tracing.exporter"
+	varTracingFile          = "tracing.file"
+	varTracingSampleRate    = "tracing.sample.rate"
+
+	// TLS of the HTTP and metrics listeners
+	varHTTPTLSCertFile        = "http.tls.cert.file"
+	varHTTPTLSKeyFile         = "http.tls.key.file"
+	varHTTPTLSClientCAFile    = "http.tls.client.ca.file"
+	varHTTPTLSClientAuth      = "http.tls.client.auth"
+	varMetricsTLSCertFile     = "metrics.tls.cert.file"
+	varMetricsTLSKeyFile      = "metrics.tls.key.file"
+	varMetricsTLSClientCAFile = "metrics.tls.client.ca.file"
+	varMetricsTLSClientAuth   = "metrics.tls.client.auth"
+	varSentryDSN              = "sentry.dsn"
 
 	// External f8 services
 	varAuthURL = "auth.url"
@@ -43,33 +89,85 @@ const (
 	varPostgresConnectionMaxOpen    = "postgres.connection.maxopen"
 )
 
+// Defaults of the settings of the domain
+const (
+	// defaultServiceAccountName is the service account allowed to call the
+	// internal endpoints when none is configured
+	defaultServiceAccountName = "fabric8-wit"
+	// defaultTrashRetention is how long the deleted pipeline environment
+	// maps are kept before being purged
+	defaultTrashRetention = 30 * 24 * time.Hour
+	// default quotas of the spaces
+	defaultQuotaMaxMaps               = 100
+	defaultQuotaMaxEnvironmentsPerMap = 20
+	// defaultEventsHeartbeatInterval is how often a comment is sent on the
+	// idle event streams
+	defaultEventsHeartbeatInterval = 15 * time.Second
+	// defaultEventsMaxStreamsPerIdentity is the number of concurrent event
+	// streams of an identity
+	defaultEventsMaxStreamsPerIdentity = 5
+	// defaultEventsRetention is how long the published changes are kept for
+	// the clients following them
+	defaultEventsRetention = 7 * 24 * time.Hour
+)
+
 // New creates a configuration reader object using a configurable configuration
 // file path.
 func New(configFilePath string) (*Config, error) {
+	v, err := newViper(configFilePath)
+	if err != nil {
+		return nil, err
+	}
 	c := Config{
-		v: viper.New(),
+		state: &state{
+			configFilePath: configFilePath,
+			overrides:      map[string]interface{}{},
+		},
 	}
-	c.v.SetEnvPrefix("F8")
-	c.v.AutomaticEnv()
-	c.v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	c.v.SetTypeByDefaultValue(true)
-	c.setConfigDefaults()
+	c.state.v.Store(v)
+	return &c, nil
+}
+
+// newViper reads the configuration from the defaults, the given file and
+// the `F8_*` environment variables
+func newViper(configFilePath string) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetEnvPrefix("F8")
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.SetTypeByDefaultValue(true)
+	setConfigDefaults(v)
 
 	if configFilePath != "" {
-		c.v.SetConfigType("yaml")
-		c.v.SetConfigFile(configFilePath)
-		err := c.v.ReadInConfig() // Find and read the config file
-		if err != nil {           // Handle errors reading the config file
+		v.SetConfigType("yaml")
+		v.SetConfigFile(configFilePath)
+		err := v.ReadInConfig() // Find and read the config file
+		if err != nil {         // Handle errors reading the config file
 			return nil, errs.Errorf("Fatal error config file: %s \n", err)
 		}
 	}
-	return &c, nil
+	return v, nil
 }
 
 // Config encapsulates the Viper configuration registry which stores the
 // configuration data in-memory.
 type Config struct {
-	v *viper.Viper
+	state *state
+}
+
+// state is shared by the copies of a Config so that they all see the
+// reloaded values
+type state struct {
+	v              atomic.Value // *viper.Viper, swapped on reload
+	mu             sync.Mutex   // serializes reloads and overrides
+	configFilePath string
+	overrides      map[string]interface{}
+	listeners      []func(*Config)
+}
+
+// v returns the current Viper registry
+func (c *Config) v() *viper.Viper {
+	return c.state.v.Load().(*viper.Viper)
 }
 
 // GetConfig is a wrapper over NewConfigurationData which reads configuration file path
@@ -84,38 +182,87 @@ func getMainConfigFile() string {
 	return envConfigPath
 }
 
-func (c *Config) setConfigDefaults() {
-	c.v.SetTypeByDefaultValue(true)
-
-	c.v.SetDefault(varLogLevel, defaultLogLevel)
-	c.v.SetDefault(varHTTPAddress, "0.0.0.0:8080")
-	c.v.SetDefault(varMetricsHTTPAddress, "0.0.0.0:8080")
-	c.v.SetDefault(varDeveloperModeEnabled, false)
-	c.v.SetDefault(varDBLogsEnabled, false)
-	c.v.SetDefault(varCleanTestDataEnabled, true)
+func setConfigDefaults(v *viper.Viper) {
+	v.SetTypeByDefaultValue(true)
+
+	v.SetDefault(varLogLevel, defaultLogLevel)
+	v.SetDefault(varAccessLogEnabled, true)
+	v.SetDefault(varAccessLogSampleRate, 1.0)
+	v.SetDefault(varAccessLogExclude, []string{"/api/status"})
+	v.SetDefault(varIdempotencyKeyTTL, 24*time.Hour)
+	v.SetDefault(varServiceAccountNames, []string{defaultServiceAccountName})
+	v.SetDefault(varServiceAccountIDs, []string{})
+	v.SetDefault(varSpaceSweeperEnabled, false)
+	v.SetDefault(varSpaceSweeperInterval, time.Hour)
+	v.SetDefault(varSpaceSweeperGrace, 72*time.Hour)
+	v.SetDefault(varSpaceSweeperToken, "")
+	v.SetDefault(varTrashRetention, defaultTrashRetention)
+	v.SetDefault(varTrashPurgeInterval, time.Hour)
+	v.SetDefault(varQuotaMaxMaps, defaultQuotaMaxMaps)
+	v.SetDefault(varQuotaMaxEnvironments, defaultQuotaMaxEnvironmentsPerMap)
+	v.SetDefault(varEventsHeartbeat, defaultEventsHeartbeatInterval)
+	v.SetDefault(varEventsMaxStreams, defaultEventsMaxStreamsPerIdentity)
+	v.SetDefault(varEventsPublisher, EventsPublisherNone)
+	v.SetDefault(varEventsInterval, 5*time.Second)
+	v.SetDefault(varEventsHTTPURL, "")
+	v.SetDefault(varEventsHTTPTimeout, 10*time.Second)
+	v.SetDefault(varEventsAMQPURL, "")
+	v.SetDefault(varEventsAMQPExchange, "fabric8-build")
+	v.SetDefault(varEventsRetention, defaultEventsRetention)
+	v.SetDefault(varRateLimitEnabled, false)
+	v.SetDefault(varRateLimitStore, RateLimitStoreMemory)
+	v.SetDefault(varRateLimitIdentity, map[string]string{"*": "20/1s:40", "create": "1/1s:10"})
+	v.SetDefault(varRateLimitSpace, map[string]string{"*": "50/1s:100", "create": "2/1s:20"})
+	v.SetDefault(varTracingExporter, TracingExporterNone)
+	v.SetDefault(varTracingFile, "")
+	v.SetDefault(varTracingSampleRate, 1.0)
+	v.SetDefault(varHTTPAddress, "0.0.0.0:8080")
+	v.SetDefault(varMetricsHTTPAddress, "0.0.0.0:8080")
+	v.SetDefault(varHTTPShutdownDelay, time.Duration(0))
+	v.SetDefault(varHTTPShutdownTimeout, 25*time.Second)
+	v.SetDefault(varDeveloperModeEnabled, false)
+	v.SetDefault(varLocalServicesEnabled, false)
+	v.SetDefault(varDBLogsEnabled, false)
+	v.SetDefault(varCleanTestDataEnabled, true)
 	//---------
 	// Postgres
 	//---------
-	c.v.SetDefault(varPostgresHost, "localhost")
-	c.v.SetDefault(varPostgresPort, 5432)
-	c.v.SetDefault(varPostgresUser, "postgres")
-	c.v.SetDefault(varPostgresDatabase, "postgres")
-	c.v.SetDefault(varPostgresPassword, "mysecretpassword")
-	c.v.SetDefault(varPostgresSSLMode, "disable")
-	c.v.SetDefault(varPostgresConnectionTimeout, 5)
-	c.v.SetDefault(varPostgresConnectionMaxIdle, -1)
-	c.v.SetDefault(varPostgresConnectionMaxOpen, -1)
+	v.SetDefault(varPostgresHost, "localhost")
+	v.SetDefault(varPostgresPort, 5432)
+	v.SetDefault(varPostgresUser, "postgres")
+	v.SetDefault(varPostgresDatabase, "postgres")
+	v.SetDefault(varPostgresPassword, "mysecretpassword")
+	v.SetDefault(varPostgresSSLMode, "disable")
+	v.SetDefault(varPostgresConnectionTimeout, 5)
+	v.SetDefault(varPostgresConnectionMaxIdle, -1)
+	v.SetDefault(varPostgresConnectionMaxOpen, -1)
 	// Number of seconds to wait before trying to connect again
-	c.v.SetDefault(varPostgresConnectionRetrySleep, time.Second)
+	v.SetDefault(varPostgresConnectionRetrySleep, time.Second)
 
 	// Timeout of a transaction in minutes
-	c.v.SetDefault(varPostgresTransactionTimeout, 5*time.Minute)
+	v.SetDefault(varPostgresTransactionTimeout, 5*time.Minute)
 }
 
 // DeveloperModeEnabled returns `true` if development related features (as set via default, config file, or environment variable),
 // e.g. token generation endpoint are enabled
 func (c *Config) DeveloperModeEnabled() bool {
-	return c.v.GetBool(varDeveloperModeEnabled)
+	return c.v().GetBool(varDeveloperModeEnabled)
+}
+
+// IsLocalServicesEnabled returns `true` if the service should run against
+// local stand-ins of the WIT and ENV services instead of the configured
+// ones. This is only honored in developer mode.
+func (c *Config) IsLocalServicesEnabled() bool {
+	return c.DeveloperModeEnabled() && c.v().GetBool(varLocalServicesEnabled)
+}
+
+// UseLocalServices overrides the WIT and ENV service URLs, e.g. to point them
+// to local stand-ins.
+func (c *Config) UseLocalServices(witURL, envURL string) {
+	c.override(map[string]interface{}{
+		varWITURL: witURL,
+		varEnvURL: envURL,
+	})
 }
 
 func (c *Config) GetDevModePrivateKey() []byte {
@@ -127,8 +274,8 @@ func (c *Config) GetDevModePrivateKey() []byte {
 
 // GetAuthServiceUrl returns Auth Service URL
 func (c *Config) GetAuthServiceURL() string {
-	if c.v.IsSet(varAuthURL) {
-		return c.v.GetString(varAuthURL)
+	if c.v().IsSet(varAuthURL) {
+		return c.v().GetString(varAuthURL)
 	}
 	if c.DeveloperModeEnabled() {
 		return "https://auth.prod-preview.openshift.io"
@@ -138,23 +285,23 @@ func (c *Config) GetAuthServiceURL() string {
 
 // GetEnvServiceUrl returns Env Service URL
 func (c *Config) GetEnvServiceURL() (string, error) {
-	return c.v.GetString(varEnvURL), nil
+	return c.v().GetString(varEnvURL), nil
 }
 
 // GetEnvironment returns the current environment application is deployed in
 // like 'production', 'prod-preview', 'local', etc as the value of environment variable
 // `F8_ENVIRONMENT` is set.
 func (c *Config) GetEnvironment() string {
-	if c.v.IsSet(varEnvironment) {
-		return c.v.GetString(varEnvironment)
+	if c.v().IsSet(varEnvironment) {
+		return c.v().GetString(varEnvironment)
 	}
 	return "local"
 }
 
 // IsLogJSON returns if we should log json format (as set via config file or environment variable)
 func (c *Config) IsLogJSON() bool {
-	if c.v.IsSet(varLogJSON) {
-		return c.v.GetBool(varLogJSON)
+	if c.v().IsSet(varLogJSON) {
+		return c.v().GetBool(varLogJSON)
 	}
 	if c.DeveloperModeEnabled() {
 		return false
@@ -165,21 +312,71 @@ func (c *Config) IsLogJSON() bool {
 // GetHTTPAddress returns the HTTP address (as set via default, config file, or environment variable)
 // that the wit server binds to (e.g. "0.0.0.0:8080")
 func (c *Config) GetHTTPAddress() string {
-	return c.v.GetString(varHTTPAddress)
+	return c.v().GetString(varHTTPAddress)
+}
+
+// GetHTTPShutdownDelay returns how long to wait between flipping the
+// readiness to failing and closing the listeners on shutdown, to let the
+// load balancer stop routing traffic to the instance.
+func (c *Config) GetHTTPShutdownDelay() time.Duration {
+	return c.v().GetDuration(varHTTPShutdownDelay)
+}
+
+// GetHTTPShutdownTimeout returns the maximum duration to wait on shutdown for
+// the in-flight requests and the background workers to complete
+func (c *Config) GetHTTPShutdownTimeout() time.Duration {
+	return c.v().GetDuration(varHTTPShutdownTimeout)
 }
 
 // GetMetricsHTTPAddress returns the address the /metrics endpoing will be mounted.
 // By default GetMetricsHTTPAddress is the same as GetHTTPAddress
 func (c *Config) GetMetricsHTTPAddress() string {
-	return c.v.GetString(varMetricsHTTPAddress)
+	return c.v().GetString(varMetricsHTTPAddress)
+}
+
+// TLSSettings holds the TLS configuration of a listener
+type TLSSettings struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// ClientAuth is the client certificate authentication mode: none,
+	// request, require, verify-if-given or require-and-verify
+	ClientAuth string
+}
+
+// Enabled returns true if the listener should serve TLS
+func (s TLSSettings) Enabled() bool {
+	return s.CertFile != "" || s.KeyFile != ""
+}
+
+// GetHTTPTLSSettings returns the TLS configuration of the HTTP listener. TLS
+// is disabled unless a certificate and key are configured.
+func (c *Config) GetHTTPTLSSettings() TLSSettings {
+	return TLSSettings{
+		CertFile:     c.v().GetString(varHTTPTLSCertFile),
+		KeyFile:      c.v().GetString(varHTTPTLSKeyFile),
+		ClientCAFile: c.v().GetString(varHTTPTLSClientCAFile),
+		ClientAuth:   c.v().GetString(varHTTPTLSClientAuth),
+	}
+}
+
+// GetMetricsTLSSettings returns the TLS configuration of the metrics listener
+// when it runs on a separate address.
+func (c *Config) GetMetricsTLSSettings() TLSSettings {
+	return TLSSettings{
+		CertFile:     c.v().GetString(varMetricsTLSCertFile),
+		KeyFile:      c.v().GetString(varMetricsTLSKeyFile),
+		ClientCAFile: c.v().GetString(varMetricsTLSClientCAFile),
+		ClientAuth:   c.v().GetString(varMetricsTLSClientAuth),
+	}
 }
 
 // GetDiagnoseHTTPAddress returns the address of where to start the gops handler.
 // By default GetDiagnoseHTTPAddress is 127.0.0.1:0 in devMode, but turned off in prod mode
 // unless explicitly configured
 func (c *Config) GetDiagnoseHTTPAddress() string {
-	if c.v.IsSet(varDiagnoseHTTPAddress) {
-		return c.v.GetString(varDiagnoseHTTPAddress)
+	if c.v().IsSet(varDiagnoseHTTPAddress) {
+		return c.v().GetString(varDiagnoseHTTPAddress)
 	} else if c.DeveloperModeEnabled() {
 		return "127.0.0.1:0"
 	}
@@ -188,65 +385,310 @@ func (c *Config) GetDiagnoseHTTPAddress() string {
 
 // GetLogLevel returns the loggging level (as set via config file or environment variable)
 func (c *Config) GetLogLevel() string {
-	return c.v.GetString(varLogLevel)
+	return c.v().GetString(varLogLevel)
+}
+
+// IsAccessLogEnabled returns true if an entry is logged for each request
+func (c *Config) IsAccessLogEnabled() bool {
+	return c.v().GetBool(varAccessLogEnabled)
+}
+
+// GetAccessLogSampleRate returns the fraction (0 to 1) of the successful
+// requests written to the access log
+func (c *Config) GetAccessLogSampleRate() float64 {
+	return c.v().GetFloat64(varAccessLogSampleRate)
+}
+
+// GetAccessLogExclude returns the path prefixes of the requests never written
+// to the access log
+func (c *Config) GetAccessLogExclude() []string {
+	return c.v().GetStringSlice(varAccessLogExclude)
+}
+
+// GetIdempotencyKeyTTL returns how long the responses of the requests sent
+// with an Idempotency-Key header are kept
+func (c *Config) GetIdempotencyKeyTTL() time.Duration {
+	return c.v().GetDuration(varIdempotencyKeyTTL)
+}
+
+// GetServiceAccountNames returns the names of the service accounts allowed
+// to call the internal endpoints
+func (c *Config) GetServiceAccountNames() []string {
+	return c.v().GetStringSlice(varServiceAccountNames)
+}
+
+// GetServiceAccountIDs returns the IDs of the service accounts allowed to
+// call the internal endpoints
+func (c *Config) GetServiceAccountIDs() []string {
+	return c.v().GetStringSlice(varServiceAccountIDs)
+}
+
+// IsSpaceSweeperEnabled returns true if the pipeline environment maps of the
+// spaces deleted in WIT are periodically deleted
+func (c *Config) IsSpaceSweeperEnabled() bool {
+	return c.v().GetBool(varSpaceSweeperEnabled)
+}
+
+// GetSpaceSweeperInterval returns the interval between two checks of the
+// spaces
+func (c *Config) GetSpaceSweeperInterval() time.Duration {
+	return c.v().GetDuration(varSpaceSweeperInterval)
+}
+
+// GetSpaceSweeperGracePeriod returns how long a space must be missing from
+// WIT before its pipeline environment maps are deleted
+func (c *Config) GetSpaceSweeperGracePeriod() time.Duration {
+	return c.v().GetDuration(varSpaceSweeperGrace)
+}
+
+// GetSpaceSweeperToken returns the service account token used to read the
+// spaces from WIT
+func (c *Config) GetSpaceSweeperToken() string {
+	return c.v().GetString(varSpaceSweeperToken)
+}
+
+// GetTrashRetention returns how long the deleted pipeline environment maps
+// are kept in the trash before being purged
+func (c *Config) GetTrashRetention() time.Duration {
+	return c.v().GetDuration(varTrashRetention)
+}
+
+// GetTrashPurgeInterval returns the interval between two purges of the trash
+func (c *Config) GetTrashPurgeInterval() time.Duration {
+	return c.v().GetDuration(varTrashPurgeInterval)
+}
+
+// GetQuotaMaxMaps returns the default maximum number of pipeline environment
+// maps of a space, 0 meaning unlimited
+func (c *Config) GetQuotaMaxMaps() int {
+	return c.v().GetInt(varQuotaMaxMaps)
+}
+
+// GetQuotaMaxEnvironmentsPerMap returns the default maximum number of
+// environments of a pipeline environment map, 0 meaning unlimited
+func (c *Config) GetQuotaMaxEnvironmentsPerMap() int {
+	return c.v().GetInt(varQuotaMaxEnvironments)
+}
+
+// GetEventsHeartbeatInterval returns how often a comment is sent on the idle
+// event streams
+func (c *Config) GetEventsHeartbeatInterval() time.Duration {
+	return c.v().GetDuration(varEventsHeartbeat)
+}
+
+// GetEventsMaxStreamsPerIdentity returns the maximum number of concurrent
+// event streams of an identity, 0 meaning unlimited
+func (c *Config) GetEventsMaxStreamsPerIdentity() int {
+	return c.v().GetInt(varEventsMaxStreams)
+}
+
+// Publishers of the events
+const (
+	EventsPublisherNone = "none"
+	EventsPublisherHTTP = "http"
+	EventsPublisherAMQP = "amqp"
+)
+
+// GetEventsPublisher returns where the events are published: none, http or
+// amqp
+func (c *Config) GetEventsPublisher() string {
+	return c.v().GetString(varEventsPublisher)
+}
+
+// GetEventsPublisherInterval returns the interval between two publications
+// of the pending events
+func (c *Config) GetEventsPublisherInterval() time.Duration {
+	return c.v().GetDuration(varEventsInterval)
+}
+
+// GetEventsPublisherHTTPURL returns the URL the events are posted to with
+// the http publisher
+func (c *Config) GetEventsPublisherHTTPURL() string {
+	return c.v().GetString(varEventsHTTPURL)
+}
+
+// GetEventsPublisherHTTPTimeout returns how long the http publisher waits
+// for the response of the sink
+func (c *Config) GetEventsPublisherHTTPTimeout() time.Duration {
+	return c.v().GetDuration(varEventsHTTPTimeout)
+}
+
+// GetEventsPublisherAMQPURL returns the URL of the broker of the amqp
+// publisher
+func (c *Config) GetEventsPublisherAMQPURL() string {
+	return c.v().GetString(varEventsAMQPURL)
+}
+
+// GetEventsPublisherAMQPExchange returns the topic exchange the events are
+// published to with the amqp publisher
+func (c *Config) GetEventsPublisherAMQPExchange() string {
+	return c.v().GetString(varEventsAMQPExchange)
+}
+
+// GetEventsRetention returns how long the published changes of the pipeline
+// environment maps are kept before being purged
+func (c *Config) GetEventsRetention() time.Duration {
+	return c.v().GetDuration(varEventsRetention)
+}
+
+// Stores of the rate limiter buckets
+const (
+	RateLimitStoreMemory   = "memory"
+	RateLimitStorePostgres = "postgres"
+)
+
+// IsRateLimitEnabled returns true if the requests on the pipeline environment
+// maps are rate limited
+func (c *Config) IsRateLimitEnabled() bool {
+	return c.v().GetBool(varRateLimitEnabled)
+}
+
+// GetRateLimitStore returns where the rate limiter buckets are kept: memory
+// (per replica) or postgres (shared by the replicas)
+func (c *Config) GetRateLimitStore() string {
+	return c.v().GetString(varRateLimitStore)
+}
+
+// GetRateLimitIdentityLimits returns the limits of the requests of each
+// identity by action name, "*" being the limit of the other actions. The
+// limits are written `<requests>/<period>:<burst>`, see ParseRateLimit.
+func (c *Config) GetRateLimitIdentityLimits() map[string]RateLimit {
+	return c.rateLimits(varRateLimitIdentity)
+}
+
+// GetRateLimitSpaceLimits returns the limits of the requests on each space
+// by action name, see GetRateLimitIdentityLimits
+func (c *Config) GetRateLimitSpaceLimits() map[string]RateLimit {
+	return c.rateLimits(varRateLimitSpace)
+}
+
+// rateLimits returns the limits of the given setting, the invalid ones being
+// reported by Validate
+func (c *Config) rateLimits(key string) map[string]RateLimit {
+	limits := map[string]RateLimit{}
+	for action, spec := range c.v().GetStringMapString(key) {
+		if l, err := ParseRateLimit(spec); err == nil {
+			limits[action] = l
+		}
+	}
+	return limits
+}
+
+// RateLimit is the refill rate and the capacity of a token bucket
+type RateLimit struct {
+	// Rate is the number of requests allowed per second
+	Rate float64
+	// Burst is the number of requests allowed at once
+	Burst int
+}
+
+// ParseRateLimit parses a limit written as `<requests>/<period>:<burst>`,
+// for instance `60/1m:20` for 60 requests per minute with bursts of 20.
+func ParseRateLimit(s string) (RateLimit, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("invalid limit %q, expected <requests>/<period>:<burst>", s)
+	}
+	rate := strings.Split(parts[0], "/")
+	if len(rate) != 2 {
+		return RateLimit{}, fmt.Errorf("invalid limit %q, expected <requests>/<period>:<burst>", s)
+	}
+	requests, err := strconv.ParseFloat(rate[0], 64)
+	if err != nil || requests <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid limit %q: the number of requests must be positive", s)
+	}
+	period, err := time.ParseDuration(rate[1])
+	if err != nil || period <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid limit %q: the period must be a positive duration", s)
+	}
+	burst, err := strconv.Atoi(parts[1])
+	if err != nil || burst <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid limit %q: the burst must be a positive integer", s)
+	}
+	return RateLimit{Rate: requests / period.Seconds(), Burst: burst}, nil
+}
+
+// Exporters of the traces
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterFile   = "file"
+)
+
+// GetTracingExporter returns where the traces are exported: none, stdout or
+// file
+func (c *Config) GetTracingExporter() string {
+	return c.v().GetString(varTracingExporter)
+}
+
+// GetTracingFile returns the file the traces are appended to with the file
+// exporter
+func (c *Config) GetTracingFile() string {
+	return c.v().GetString(varTracingFile)
+}
+
+// GetTracingSampleRate returns the fraction (0 to 1) of the traces started
+// by this service which are exported
+func (c *Config) GetTracingSampleRate() float64 {
+	return c.v().GetFloat64(varTracingSampleRate)
 }
 
 // GetPostgresHost returns the postgres host as set via default, config file, or environment variable
 func (c *Config) GetPostgresHost() string {
-	return c.v.GetString(varPostgresHost)
+	return c.v().GetString(varPostgresHost)
 }
 
 // GetPostgresPort returns the postgres port as set via default, config file, or environment variable
 func (c *Config) GetPostgresPort() int64 {
-	return c.v.GetInt64(varPostgresPort)
+	return c.v().GetInt64(varPostgresPort)
 }
 
 // GetPostgresUser returns the postgres user as set via default, config file, or environment variable
 func (c *Config) GetPostgresUser() string {
-	return c.v.GetString(varPostgresUser)
+	return c.v().GetString(varPostgresUser)
 }
 
 // GetPostgresDatabase returns the postgres database as set via default, config file, or environment variable
 func (c *Config) GetPostgresDatabase() string {
-	return c.v.GetString(varPostgresDatabase)
+	return c.v().GetString(varPostgresDatabase)
 }
 
 // GetPostgresPassword returns the postgres password as set via default, config file, or environment variable
 func (c *Config) GetPostgresPassword() string {
-	return c.v.GetString(varPostgresPassword)
+	return c.v().GetString(varPostgresPassword)
 }
 
 // GetPostgresSSLMode returns the postgres sslmode as set via default, config file, or environment variable
 func (c *Config) GetPostgresSSLMode() string {
-	return c.v.GetString(varPostgresSSLMode)
+	return c.v().GetString(varPostgresSSLMode)
 }
 
 // GetPostgresConnectionTimeout returns the postgres connection timeout as set via default, config file, or environment variable
 func (c *Config) GetPostgresConnectionTimeout() int64 {
-	return c.v.GetInt64(varPostgresConnectionTimeout)
+	return c.v().GetInt64(varPostgresConnectionTimeout)
 }
 
 // GetPostgresConnectionRetrySleep returns the number of seconds (as set via default, config file, or environment variable)
 // to wait before trying to connect again
 func (c *Config) GetPostgresConnectionRetrySleep() time.Duration {
-	return c.v.GetDuration(varPostgresConnectionRetrySleep)
+	return c.v().GetDuration(varPostgresConnectionRetrySleep)
 }
 
 // GetPostgresTransactionTimeout returns the number of minutes to timeout a transaction
 func (c *Config) GetPostgresTransactionTimeout() time.Duration {
-	return c.v.GetDuration(varPostgresTransactionTimeout)
+	return c.v().GetDuration(varPostgresTransactionTimeout)
 }
 
 // GetPostgresConnectionMaxIdle returns the number of connections that should be keept alive in the database connection pool at
 // any given time. -1 represents no restrictions/default behavior
 func (c *Config) GetPostgresConnectionMaxIdle() int {
-	return c.v.GetInt(varPostgresConnectionMaxIdle)
+	return c.v().GetInt(varPostgresConnectionMaxIdle)
 }
 
 // GetPostgresConnectionMaxOpen returns the max number of open connections that should be open in the database connection pool.
 // -1 represents no restrictions/default behavior
 func (c *Config) GetPostgresConnectionMaxOpen() int {
-	return c.v.GetInt(varPostgresConnectionMaxOpen)
+	return c.v().GetInt(varPostgresConnectionMaxOpen)
 }
 
 // GetPostgresConfigString returns a ready to use string for usage in sql.Open()
@@ -264,25 +706,25 @@ func (c *Config) GetPostgresConfigString() string {
 
 // GetAuthURL returns Auth service URL
 func (c *Config) GetAuthURL() string {
-	return c.v.GetString(varAuthURL)
+	return c.v().GetString(varAuthURL)
 }
 
 func (c *Config) GetSentryDSN() string {
-	return c.v.GetString(varSentryDSN)
+	return c.v().GetString(varSentryDSN)
 }
 
 // Return True if we want to have DB Logs Enabled
 func (c *Config) IsDBLogsEnabled() bool {
-	return c.v.GetBool(varDBLogsEnabled)
+	return c.v().GetBool(varDBLogsEnabled)
 }
 
 // IsCleanTestDataEnabled return true if we want to have clean data enabled
 func (c *Config) IsCleanTestDataEnabled() bool {
-	return c.v.GetBool(varCleanTestDataEnabled)
+	return c.v().GetBool(varCleanTestDataEnabled)
 }
 
 // GetWITURL returns the WIT URL where WIT is running
 // If AUTH_WIT_URL is not set and Auth in not in Dev Mode then we calculate the URL from the Auth Service URL domain
 func (c *Config) GetWITURL() (string, error) {
-	return c.v.GetString(varWITURL), nil
+	return c.v().GetString(varWITURL), nil
 }
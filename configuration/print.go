@@ -0,0 +1,120 @@
+package configuration
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Sources of a configuration value
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceUnset   = "unset"
+)
+
+const redacted = "********"
+
+// optionalKeys are the settings without default value, they wouldn't be
+// listed otherwise when not set
+var optionalKeys = []string{
+	varAuthURL,
+	varWITURL,
+	varEnvURL,
+	varEnvironment,
+	varSentryDSN,
+	varLogJSON,
+	varDiagnoseHTTPAddress,
+	varHTTPTLSCertFile,
+	varHTTPTLSKeyFile,
+	varHTTPTLSClientCAFile,
+	varHTTPTLSClientAuth,
+	varMetricsTLSCertFile,
+	varMetricsTLSKeyFile,
+	varMetricsTLSClientCAFile,
+	varMetricsTLSClientAuth,
+}
+
+// sensitiveKeyParts are the parts of a key denoting a secret value, the URL
+// of the broker holding its credentials
+var sensitiveKeyParts = []string{"password", "secret", "dsn", "token", "private", "amqp.url"}
+
+// Setting is a configuration value along with where it comes from
+type Setting struct {
+	Key    string
+	Value  interface{}
+	Source string
+}
+
+// Settings returns all the settings of the merged configuration (defaults,
+// config file and `F8_*` environment variables) sorted by key, with the
+// sensitive values redacted.
+func (c *Config) Settings() []Setting {
+	keys := map[string]struct{}{}
+	for _, k := range c.v().AllKeys() {
+		keys[k] = struct{}{}
+	}
+	for _, k := range optionalKeys {
+		keys[k] = struct{}{}
+	}
+
+	settings := make([]Setting, 0, len(keys))
+	for k := range keys {
+		s := Setting{Key: k, Value: c.v().Get(k), Source: c.source(k)}
+		if isSensitive(k) && s.Value != nil && fmt.Sprint(s.Value) != "" {
+			s.Value = redacted
+		}
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Key < settings[j].Key
+	})
+	return settings
+}
+
+// Print writes the merged configuration to w, with the sensitive values
+// redacted and the source of each value.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, s := range c.Settings() {
+		value := s.Value
+		if value == nil {
+			value = ""
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%s\n", s.Key, value, s.Source)
+	}
+	return tw.Flush()
+}
+
+// source returns where the value of the given key comes from
+func (c *Config) source(key string) string {
+	if _, ok := os.LookupEnv(envVarName(key)); ok {
+		return SourceEnv
+	}
+	if c.v().InConfig(key) {
+		return SourceFile
+	}
+	if c.v().IsSet(key) {
+		return SourceDefault
+	}
+	return SourceUnset
+}
+
+// envVarName returns the environment variable overriding the given key
+func envVarName(key string) string {
+	return "F8_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+func isSensitive(key string) bool {
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/fsnotify/fsnotify"
+	errs "github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+)
+
+// reloadableKeys are the settings which can change while the service is
+// running, a reload changing any other setting is rejected
+var reloadableKeys = map[string]struct{}{
+	varLogLevel:          {},
+	varWITURL:            {},
+	varEnvURL:            {},
+	varIdempotencyKeyTTL: {},
+	varRateLimitIdentity: {},
+	varRateLimitSpace:    {},
+}
+
+// reloadDebounce is how long to wait after a change of the config file
+// before reloading it, so that editors writing in several steps are handled
+const reloadDebounce = 500 * time.Millisecond
+
+// Results of a reload
+const (
+	reloadSucceeded = "success"
+	reloadRejected  = "rejected"
+	reloadFailed    = "failed"
+)
+
+var reloadCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "fabric8_build_service",
+	Name:      "config_reloads_total",
+	Help:      "Number of configuration reloads by result.",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(reloadCounter)
+}
+
+// OnReload registers a function called after each successful reload
+func (c *Config) OnReload(fn func(*Config)) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.listeners = append(c.state.listeners, fn)
+}
+
+// Reload reads the configuration file and environment variables again and
+// atomically swaps in the new values. The reload is rejected if the new
+// configuration is invalid or changes a setting which isn't reloadable.
+func (c *Config) Reload() error {
+	c.state.mu.Lock()
+	changed, err := c.reload()
+	listeners := append([]func(*Config){}, c.state.listeners...)
+	c.state.mu.Unlock()
+
+	if err != nil {
+		result := reloadFailed
+		if _, ok := errs.Cause(err).(*ImmutableSettingsError); ok {
+			result = reloadRejected
+		}
+		reloadCounter.WithLabelValues(result).Inc()
+		log.Error(context.TODO(), map[string]interface{}{
+			"config_file_path": c.state.configFilePath,
+			"err":              err,
+		}, "configuration reload %s", result)
+		return err
+	}
+
+	reloadCounter.WithLabelValues(reloadSucceeded).Inc()
+	if len(changed) == 0 {
+		log.Debug(context.TODO(), map[string]interface{}{
+			"config_file_path": c.state.configFilePath,
+		}, "configuration reloaded, nothing changed")
+		return nil
+	}
+	log.Info(context.TODO(), map[string]interface{}{
+		"config_file_path": c.state.configFilePath,
+		"changed":          strings.Join(changed, ","),
+	}, "configuration reloaded")
+	for _, fn := range listeners {
+		fn(c)
+	}
+	return nil
+}
+
+// ImmutableSettingsError is returned when a reload changes settings which
+// require a restart
+type ImmutableSettingsError struct {
+	Keys []string
+}
+
+func (e *ImmutableSettingsError) Error() string {
+	return fmt.Sprintf("settings can't be changed without a restart: %s", strings.Join(e.Keys, ", "))
+}
+
+// reload swaps in the new configuration and returns the changed settings.
+// Caller must hold the state lock.
+func (c *Config) reload() ([]string, error) {
+	v, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	candidate := &Config{state: &state{}}
+	candidate.state.v.Store(v)
+	if err := candidate.Validate(); err != nil {
+		return nil, err
+	}
+
+	changed := changedKeys(c.v(), v)
+	var immutable []string
+	for _, k := range changed {
+		if !isReloadable(k) {
+			immutable = append(immutable, k)
+		}
+	}
+	if len(immutable) > 0 {
+		return nil, &ImmutableSettingsError{Keys: immutable}
+	}
+	c.state.v.Store(v)
+	return changed, nil
+}
+
+// isReloadable returns true if the given setting, or the map holding it, is
+// reloadable
+func isReloadable(key string) bool {
+	for {
+		if _, ok := reloadableKeys[key]; ok {
+			return true
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return false
+		}
+		key = key[:i]
+	}
+}
+
+// override sets the given values, which are kept across reloads
+func (c *Config) override(values map[string]interface{}) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	for k, value := range values {
+		c.state.overrides[k] = value
+	}
+	v, err := c.load()
+	if err != nil {
+		// the config file was already read successfully, so fall back to a
+		// copy of the current registry, which is never modified as it is
+		// read concurrently
+		v = copyViper(c.v())
+		for k, value := range c.state.overrides {
+			v.Set(k, value)
+		}
+	}
+	c.state.v.Store(v)
+}
+
+// copyViper returns a new registry holding the values of the given one
+func copyViper(v *viper.Viper) *viper.Viper {
+	cp := viper.New()
+	for _, k := range v.AllKeys() {
+		cp.Set(k, v.Get(k))
+	}
+	return cp
+}
+
+// load reads a new registry with the overrides applied. Caller must hold the
+// state lock.
+func (c *Config) load() (*viper.Viper, error) {
+	v, err := newViper(c.state.configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	for k, value := range c.state.overrides {
+		v.Set(k, value)
+	}
+	return v, nil
+}
+
+// changedKeys returns the sorted keys whose value differs between the two
+// registries
+func changedKeys(old, new *viper.Viper) []string {
+	keys := map[string]struct{}{}
+	for _, v := range []*viper.Viper{old, new} {
+		for _, k := range v.AllKeys() {
+			keys[k] = struct{}{}
+		}
+	}
+	for _, k := range optionalKeys {
+		keys[k] = struct{}{}
+	}
+	var changed []string
+	for k := range keys {
+		if !reflect.DeepEqual(old.Get(k), new.Get(k)) {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Watch reloads the configuration when the config file changes, until the
+// context is done. The parent directory is watched so that atomic
+// replacements (e.g. of a mounted ConfigMap) are detected.
+func (c *Config) Watch(ctx context.Context) {
+	if c.state.configFilePath == "" {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err}, "unable to watch the configuration file")
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(c.state.configFilePath)); err != nil {
+		log.Error(ctx, map[string]interface{}{
+			"err":              err,
+			"config_file_path": c.state.configFilePath,
+		}, "unable to watch the configuration file")
+		return
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-watcher.Events:
+			reload = time.After(reloadDebounce)
+		case err := <-watcher.Errors:
+			log.Error(ctx, map[string]interface{}{"err": err}, "error while watching the configuration file")
+		case <-reload:
+			reload = nil
+			// errors are logged and counted by Reload
+			_ = c.Reload()
+		}
+	}
+}
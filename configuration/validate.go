@@ -0,0 +1,226 @@
+package configuration
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-build/application/tlsconfig"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ValidationError aggregates all the problems found in the configuration
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration:\n - %s", strings.Join(e.Errors, "\n - "))
+}
+
+func (e *ValidationError) add(format string, args ...interface{}) {
+	e.Errors = append(e.Errors, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the configuration and returns a ValidationError listing
+// all the problems found, if any.
+func (c *Config) Validate() error {
+	verr := &ValidationError{}
+
+	c.validateURL(verr, varAuthURL)
+	c.validateURL(verr, varWITURL)
+	c.validateURL(verr, varEnvURL)
+
+	c.validateAddress(verr, varHTTPAddress, true)
+	c.validateAddress(verr, varMetricsHTTPAddress, false)
+	c.validateAddress(verr, varDiagnoseHTTPAddress, false)
+
+	if port := c.GetPostgresPort(); port <= 0 || port > 65535 {
+		verr.add("%s: invalid port %d", varPostgresPort, port)
+	}
+	if _, err := logrus.ParseLevel(c.GetLogLevel()); err != nil {
+		verr.add("%s: %s", varLogLevel, err)
+	}
+	if rate := c.GetAccessLogSampleRate(); rate < 0 || rate > 1 {
+		verr.add("%s: %v is not between 0 and 1", varAccessLogSampleRate, rate)
+	}
+	if c.GetIdempotencyKeyTTL() <= 0 {
+		verr.add("%s: must be positive", varIdempotencyKeyTTL)
+	}
+	for _, id := range c.GetServiceAccountIDs() {
+		if _, err := uuid.FromString(id); err != nil {
+			verr.add("%s: %q is not an ID", varServiceAccountIDs, id)
+		}
+	}
+	if c.IsSpaceSweeperEnabled() {
+		if c.GetSpaceSweeperToken() == "" {
+			verr.add("%s: required by %s", varSpaceSweeperToken, varSpaceSweeperEnabled)
+		}
+		if c.GetSpaceSweeperInterval() <= 0 {
+			verr.add("%s: must be positive", varSpaceSweeperInterval)
+		}
+		if c.GetSpaceSweeperGracePeriod() < 0 {
+			verr.add("%s: must not be negative", varSpaceSweeperGrace)
+		}
+	}
+	if c.GetTrashRetention() <= 0 {
+		verr.add("%s: must be positive", varTrashRetention)
+	}
+	if c.GetTrashPurgeInterval() <= 0 {
+		verr.add("%s: must be positive", varTrashPurgeInterval)
+	}
+	if c.GetQuotaMaxMaps() < 0 {
+		verr.add("%s: must not be negative", varQuotaMaxMaps)
+	}
+	if c.GetQuotaMaxEnvironmentsPerMap() < 0 {
+		verr.add("%s: must not be negative", varQuotaMaxEnvironments)
+	}
+	if c.GetEventsHeartbeatInterval() <= 0 {
+		verr.add("%s: must be positive", varEventsHeartbeat)
+	}
+	if c.GetEventsMaxStreamsPerIdentity() < 0 {
+		verr.add("%s: must not be negative", varEventsMaxStreams)
+	}
+	switch c.GetEventsPublisher() {
+	case EventsPublisherNone:
+	case EventsPublisherHTTP:
+		if c.GetEventsPublisherHTTPURL() == "" {
+			verr.add("%s: required by the %s publisher", varEventsHTTPURL, EventsPublisherHTTP)
+		}
+		if c.GetEventsPublisherHTTPTimeout() <= 0 {
+			verr.add("%s: must be positive", varEventsHTTPTimeout)
+		}
+	case EventsPublisherAMQP:
+		if c.GetEventsPublisherAMQPURL() == "" {
+			verr.add("%s: required by the %s publisher", varEventsAMQPURL, EventsPublisherAMQP)
+		}
+		if c.GetEventsPublisherAMQPExchange() == "" {
+			verr.add("%s: required by the %s publisher", varEventsAMQPExchange, EventsPublisherAMQP)
+		}
+	default:
+		verr.add("%s: unknown publisher %q, expected one of %s, %s or %s", varEventsPublisher, c.GetEventsPublisher(),
+			EventsPublisherNone, EventsPublisherHTTP, EventsPublisherAMQP)
+	}
+	if c.GetEventsPublisherInterval() <= 0 {
+		verr.add("%s: must be positive", varEventsInterval)
+	}
+	if c.GetEventsRetention() <= 0 {
+		verr.add("%s: must be positive", varEventsRetention)
+	}
+	switch c.GetRateLimitStore() {
+	case RateLimitStoreMemory, RateLimitStorePostgres:
+	default:
+		verr.add("%s: unknown store %q, expected %s or %s", varRateLimitStore, c.GetRateLimitStore(),
+			RateLimitStoreMemory, RateLimitStorePostgres)
+	}
+	c.validateRateLimits(verr, varRateLimitIdentity)
+	c.validateRateLimits(verr, varRateLimitSpace)
+	switch c.GetTracingExporter() {
+	case TracingExporterNone, TracingExporterStdout:
+	case TracingExporterFile:
+		if c.GetTracingFile() == "" {
+			verr.add("%s: required by the %s exporter", varTracingFile, TracingExporterFile)
+		}
+	default:
+		verr.add("%s: unknown exporter %q, expected one of %s, %s or %s", varTracingExporter, c.GetTracingExporter(),
+			TracingExporterNone, TracingExporterStdout, TracingExporterFile)
+	}
+	if rate := c.GetTracingSampleRate(); rate < 0 || rate > 1 {
+		verr.add("%s: %v is not between 0 and 1", varTracingSampleRate, rate)
+	}
+	if c.GetHTTPShutdownTimeout() <= 0 {
+		verr.add("%s: must be positive", varHTTPShutdownTimeout)
+	}
+
+	c.validateTLS(verr, "http.tls", c.GetHTTPTLSSettings())
+	c.validateTLS(verr, "metrics.tls", c.GetMetricsTLSSettings())
+
+	// conflicting settings
+	if c.GetMetricsHTTPAddress() == c.GetHTTPAddress() && c.GetMetricsTLSSettings().Enabled() {
+		verr.add("metrics.tls: the metrics are served by the HTTP listener, use http.tls instead")
+	}
+	if c.v().GetBool(varLocalServicesEnabled) && !c.DeveloperModeEnabled() {
+		verr.add("%s: requires %s", varLocalServicesEnabled, varDeveloperModeEnabled)
+	}
+	if maxIdle, maxOpen := c.GetPostgresConnectionMaxIdle(), c.GetPostgresConnectionMaxOpen(); maxOpen > 0 && maxIdle > maxOpen {
+		verr.add("%s: %d is greater than %s %d", varPostgresConnectionMaxIdle, maxIdle, varPostgresConnectionMaxOpen, maxOpen)
+	}
+	if diag := c.GetDiagnoseHTTPAddress(); diag != "" && !strings.HasSuffix(diag, ":0") &&
+		(diag == c.GetHTTPAddress() || diag == c.GetMetricsHTTPAddress()) {
+		verr.add("%s: %s is already used by the HTTP or metrics server", varDiagnoseHTTPAddress, diag)
+	}
+
+	if len(verr.Errors) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func (c *Config) validateTLS(verr *ValidationError, prefix string, s TLSSettings) {
+	if s.Enabled() && (s.CertFile == "" || s.KeyFile == "") {
+		verr.add("%s: both cert.file and key.file are required", prefix)
+	}
+	if !s.Enabled() && (s.ClientCAFile != "" || s.ClientAuth != "") {
+		verr.add("%s: client authentication requires cert.file and key.file", prefix)
+	}
+	for _, f := range []string{s.CertFile, s.KeyFile, s.ClientCAFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			verr.add("%s: %s", prefix, err)
+		}
+	}
+	if s.ClientAuth != "" {
+		if _, err := tlsconfig.ParseClientAuth(s.ClientAuth); err != nil {
+			verr.add("%s: %s", prefix, err)
+		}
+	}
+}
+
+// validateRateLimits checks the limits by action of the given setting
+func (c *Config) validateRateLimits(verr *ValidationError, key string) {
+	for action, spec := range c.v().GetStringMapString(key) {
+		if _, err := ParseRateLimit(spec); err != nil {
+			verr.add("%s.%s: %s", key, action, err)
+		}
+	}
+}
+
+// validateURL checks the URL of the given setting, if set
+func (c *Config) validateURL(verr *ValidationError, key string) {
+	value := c.v().GetString(key)
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		verr.add("%s: %s", key, err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		verr.add("%s: %q is not an absolute http(s) URL", key, value)
+	}
+}
+
+func (c *Config) validateAddress(verr *ValidationError, key string, required bool) {
+	value := c.v().GetString(key)
+	if value == "" {
+		if required {
+			verr.add("%s: is required", key)
+		}
+		return
+	}
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		verr.add("%s: %s", key, err)
+		return
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		verr.add("%s: invalid port %q", key, port)
+	}
+}
@@ -0,0 +1,309 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/application/serviceaccount"
+	"github.com/fabric8-services/fabric8-build/application/spacesweeper"
+	"github.com/fabric8-services/fabric8-build/quota"
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/goadesign/goa"
+	guuid "github.com/goadesign/goa/uuid"
+	uuid "github.com/satori/go.uuid"
+)
+
+// AdminController implements the admin resource, restricted to the
+// configured service accounts.
+type AdminController struct {
+	*goa.Controller
+	db              application.DB
+	svcFactory      application.ServiceFactory
+	serviceAccounts *serviceaccount.Authorizer
+	// SpaceGracePeriod is how long a space must be missing from WIT before
+	// its maps are deleted by a cleanup
+	SpaceGracePeriod time.Duration
+	// Quotas are the limits of the spaces without override
+	Quotas quota.Limits
+}
+
+// NewAdminController creates an admin controller.
+func NewAdminController(service *goa.Service, db application.DB, svcFactory application.ServiceFactory, serviceAccounts *serviceaccount.Authorizer) *AdminController {
+	return &AdminController{
+		Controller:       service.NewController("AdminController"),
+		db:               db,
+		svcFactory:       svcFactory,
+		serviceAccounts:  serviceAccounts,
+		SpaceGracePeriod: spacesweeper.DefaultGracePeriod,
+		Quotas:           quota.DefaultLimits,
+	}
+}
+
+// Stats runs the stats action.
+func (c *AdminController) Stats(ctx *app.StatsAdminContext) error {
+	if _, err := c.serviceAccounts.Authorize(ctx); err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	stats, err := c.db.PipelineEnvMap().Stats(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	missing, err := c.db.MissingSpaces().Count(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	res := &app.AdminStatsSingle{
+		Data: &app.AdminStats{
+			PipelineEnvironmentMaps: int(stats.Maps),
+			Spaces:                  int(stats.Spaces),
+			Environments:            int(stats.Environments),
+			MissingSpaces:           int(missing),
+		},
+	}
+	return ctx.OK(res)
+}
+
+// Cleanup runs the cleanup action.
+func (c *AdminController) Cleanup(ctx *app.CleanupAdminContext) error {
+	account, err := c.serviceAccounts.Authorize(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	// the spaces are read from WIT with the token of the service account
+	now := time.Now()
+	sweeper := spacesweeper.New(c.db, c.svcFactory.WITService(), c.SpaceGracePeriod)
+	purged, err := sweeper.Sweep(ctx, now)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	keys, err := c.db.IdempotencyKeys().DeleteExpired(ctx, now)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	log.Info(ctx, map[string]interface{}{
+		"service_account": account.Name,
+		"purged_spaces":   len(purged),
+		"purged_keys":     keys,
+	}, "cleanup done")
+
+	res := &app.AdminCleanupSingle{
+		Data: &app.AdminCleanup{
+			PurgedSpaces:          []uuid.UUID{},
+			PurgedIdempotencyKeys: int(keys),
+		},
+	}
+	res.Data.PurgedSpaces = append(res.Data.PurgedSpaces, purged...)
+	return ctx.OK(res)
+}
+
+// Reconcile runs the reconcile action.
+func (c *AdminController) Reconcile(ctx *app.ReconcileAdminContext) error {
+	account, err := c.serviceAccounts.Authorize(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	var spaceIDs []uuid.UUID
+	if ctx.SpaceID != nil {
+		spaceIDs = []uuid.UUID{*ctx.SpaceID}
+	} else {
+		spaceIDs, err = c.db.PipelineEnvMap().ListSpaceIDs(ctx)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, err)
+		}
+	}
+
+	res := &app.AdminReconciliationSingle{
+		Data: &app.AdminReconciliation{
+			DryRun:               ctx.DryRun,
+			DanglingEnvironments: []*app.DanglingEnvironment{},
+			FailedSpaces:         []uuid.UUID{},
+		},
+	}
+	// the service account is the author of the revisions of the fixed maps
+	var authorID *uuid.UUID
+	if id, err := uuid.FromString(account.ID); err == nil {
+		authorID = &id
+	}
+	for _, spaceID := range spaceIDs {
+		dangling, err := c.reconcileSpace(ctx, spaceID, ctx.DryRun, authorID)
+		if derr, ok := invalidDefinition(err); ok && ctx.SpaceID != nil {
+			return ctx.UnprocessableEntity(definitionErrors(derr))
+		}
+		if err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"err":      err,
+				"space_id": spaceID.String(),
+			}, "unable to reconcile the space")
+			res.Data.FailedSpaces = append(res.Data.FailedSpaces, spaceID)
+			continue
+		}
+		res.Data.DanglingEnvironments = append(res.Data.DanglingEnvironments, dangling...)
+	}
+	log.Info(ctx, map[string]interface{}{
+		"service_account": account.Name,
+		"dry_run":         ctx.DryRun,
+		"dangling":        len(res.Data.DanglingEnvironments),
+		"failed":          len(res.Data.FailedSpaces),
+	}, "reconciliation done")
+	return ctx.OK(res)
+}
+
+// ShowQuota runs the showQuota action.
+func (c *AdminController) ShowQuota(ctx *app.ShowQuotaAdminContext) error {
+	if _, err := c.serviceAccounts.Authorize(ctx); err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	res, err := c.spaceQuota(ctx, c.db, ctx.SpaceID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	return ctx.OK(res)
+}
+
+// UpdateQuota runs the updateQuota action.
+func (c *AdminController) UpdateQuota(ctx *app.UpdateQuotaAdminContext) error {
+	account, err := c.serviceAccounts.Authorize(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	var res *app.SpaceQuotaSingle
+	err = application.Transactional(c.db, func(appl application.Application) error {
+		_, err := appl.SpaceQuotas().Save(ctx, &quota.Override{
+			SpaceID:               ctx.SpaceID,
+			MaxMaps:               ctx.Payload.Data.MaxMaps,
+			MaxEnvironmentsPerMap: ctx.Payload.Data.MaxEnvironmentsPerMap,
+		})
+		if err != nil {
+			return err
+		}
+		res, err = c.spaceQuota(ctx, appl, ctx.SpaceID)
+		return err
+	})
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	log.Info(ctx, map[string]interface{}{
+		"service_account":          account.Name,
+		"space_id":                 ctx.SpaceID.String(),
+		"max_maps":                 res.Data.MaxMaps,
+		"max_environments_per_map": res.Data.MaxEnvironmentsPerMap,
+	}, "space quotas overridden")
+	return ctx.OK(res)
+}
+
+// DeleteQuota runs the deleteQuota action.
+func (c *AdminController) DeleteQuota(ctx *app.DeleteQuotaAdminContext) error {
+	account, err := c.serviceAccounts.Authorize(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	err = application.Transactional(c.db, func(appl application.Application) error {
+		return appl.SpaceQuotas().Delete(ctx, ctx.SpaceID)
+	})
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	log.Info(ctx, map[string]interface{}{
+		"service_account": account.Name,
+		"space_id":        ctx.SpaceID.String(),
+	}, "space quotas reset")
+	return ctx.NoContent()
+}
+
+// spaceQuota returns the quotas of the given space and their usage
+func (c *AdminController) spaceQuota(ctx context.Context, appl application.Application, spaceID uuid.UUID) (*app.SpaceQuotaSingle, error) {
+	limits, o, err := spaceLimits(ctx, appl, c.Quotas, spaceID)
+	if err != nil {
+		return nil, err
+	}
+	maps, err := appl.PipelineEnvMap().CountBySpace(ctx, spaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &app.SpaceQuotaSingle{
+		Data: &app.SpaceQuota{
+			SpaceID:               spaceID,
+			MaxMaps:               limits.MaxMaps,
+			MaxEnvironmentsPerMap: limits.MaxEnvironmentsPerMap,
+			Maps:                  maps,
+		},
+	}
+	if o != nil {
+		res.Data.Override = &app.SpaceQuotaOverride{
+			MaxMaps:               o.MaxMaps,
+			MaxEnvironmentsPerMap: o.MaxEnvironmentsPerMap,
+		}
+	}
+	return res, nil
+}
+
+// reconcileSpace returns the environments referenced by the maps of the
+// given space which are unknown to the env service, removing them from the
+// maps unless dryRun
+func (c *AdminController) reconcileSpace(ctx context.Context, spaceID uuid.UUID, dryRun bool, authorID *uuid.UUID) ([]*app.DanglingEnvironment, error) {
+	envList, err := c.svcFactory.ENVService().GetEnvList(ctx, spaceID.String())
+	if err != nil {
+		return nil, err
+	}
+	envs := convertToEnvUIDList(envList)
+	ppls, err := c.db.PipelineEnvMap().List(ctx, spaceID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var dangling []*app.DanglingEnvironment
+	missing := map[uuid.UUID][]uuid.UUID{}
+	for _, ppl := range ppls {
+		for _, env := range ppl.Environments {
+			if env.EnvironmentID == nil {
+				continue
+			}
+			envID, _ := guuid.FromString(env.EnvironmentID.String())
+			if _, ok := envs[envID]; ok {
+				continue
+			}
+			dangling = append(dangling, &app.DanglingEnvironment{
+				PipelineEnvironmentMapID: ppl.ID,
+				SpaceID:                  spaceID,
+				EnvUUID:                  *env.EnvironmentID,
+			})
+			missing[ppl.ID] = append(missing[ppl.ID], *env.EnvironmentID)
+		}
+	}
+	if dryRun || len(missing) == 0 {
+		return dangling, nil
+	}
+
+	err = application.Transactional(c.db, func(appl application.Application) error {
+		for pplID, envIDs := range missing {
+			if _, err := appl.PipelineEnvMap().DeleteEnvironments(ctx, pplID, envIDs); err != nil {
+				return err
+			}
+			ppl, err := appl.PipelineEnvMap().Load(ctx, pplID)
+			if err != nil {
+				return err
+			}
+			if err := checkDefinition(ctx, appl, ppl); err != nil {
+				return err
+			}
+			if _, err := appl.PipelineEnvMapRevisions().Create(ctx, ppl, authorID, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dangling, nil
+}
@@ -0,0 +1,277 @@
+package controller_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-build/app/test"
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/application/env/envservice"
+	"github.com/fabric8-services/fabric8-build/application/serviceaccount"
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-build/controller"
+	"github.com/fabric8-services/fabric8-build/gormapp"
+	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	"github.com/goadesign/goa"
+	goajwt "github.com/goadesign/goa/middleware/security/jwt"
+	guuid "github.com/goadesign/goa/uuid"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/h2non/gock.v1"
+)
+
+type AdminControllerSuite struct {
+	testsuite.DBTestSuite
+	db     *gormapp.GormDB
+	config *configuration.Config
+
+	svc  *goa.Service
+	ctrl *controller.AdminController
+}
+
+func TestAdminController(t *testing.T) {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	suite.Run(t, &AdminControllerSuite{DBTestSuite: testsuite.NewDBTestSuite(config), config: config})
+}
+
+func (s *AdminControllerSuite) SetupSuite() {
+	s.DBTestSuite.SetupSuite()
+	s.db = gormapp.NewGormDB(s.DB)
+
+	os.Setenv("F8_WIT_URL", "http://witservice")
+	os.Setenv("F8_ENV_URL", "http://envservice")
+	config, err := configuration.New("")
+	require.NoError(s.T(), err)
+
+	s.svc = goa.New("admin-test")
+	s.ctrl = controller.NewAdminController(s.svc, s.db, application.NewServiceFactory(config),
+		serviceaccount.NewAuthorizer([]string{"fabric8-wit"}, nil))
+	s.ctrl.SpaceGracePeriod = 0
+}
+
+func (s *AdminControllerSuite) TearDownTest() {
+	gock.OffAll()
+}
+
+// contextWithToken returns a context holding a token with the given claims,
+// signed with the dev-mode private key
+func (s *AdminControllerSuite) contextWithToken(claims jwt.MapClaims) context.Context {
+	pem := s.config.GetDevModePrivateKey()
+	require.NotNil(s.T(), pem, "requires F8_DEVELOPER_MODE_ENABLED")
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+	require.NoError(s.T(), err)
+	raw, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	require.NoError(s.T(), err)
+	token, err := jwt.Parse(raw, func(*jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	require.NoError(s.T(), err)
+	return goajwt.WithJWT(context.Background(), token)
+}
+
+func (s *AdminControllerSuite) serviceAccountContext() context.Context {
+	return s.contextWithToken(jwt.MapClaims{
+		"sub":                    uuid.NewV4().String(),
+		serviceaccount.NameClaim: "fabric8-wit",
+	})
+}
+
+func (s *AdminControllerSuite) userContext() context.Context {
+	return s.contextWithToken(jwt.MapClaims{
+		"sub":                uuid.NewV4().String(),
+		"preferred_username": "fabric8-wit",
+	})
+}
+
+func (s *AdminControllerSuite) createMap(name string, spaceID uuid.UUID, envIDs ...uuid.UUID) *build.PipelineEnvMap {
+	var envs []build.PipelineEnvironment
+	for i := range envIDs {
+		envs = append(envs, build.PipelineEnvironment{EnvironmentID: &envIDs[i]})
+	}
+	ppl, err := s.db.PipelineEnvMap().Create(context.Background(), &build.PipelineEnvMap{
+		Name:         &name,
+		SpaceID:      &spaceID,
+		Environments: envs,
+	})
+	require.NoError(s.T(), err)
+	return ppl
+}
+
+func (s *AdminControllerSuite) TestStats() {
+	ctx := s.serviceAccountContext()
+	_, before := test.StatsAdminOK(s.T(), ctx, s.svc, s.ctrl)
+	s.createMap("admin-stats", uuid.NewV4(), uuid.NewV4(), uuid.NewV4())
+
+	s.T().Run("ok", func(t *testing.T) {
+		_, after := test.StatsAdminOK(t, ctx, s.svc, s.ctrl)
+		assert.Equal(t, before.Data.PipelineEnvironmentMaps+1, after.Data.PipelineEnvironmentMaps)
+		assert.Equal(t, before.Data.Spaces+1, after.Data.Spaces)
+		assert.Equal(t, before.Data.Environments+2, after.Data.Environments)
+	})
+
+	s.T().Run("unauthorized", func(t *testing.T) {
+		test.StatsAdminUnauthorized(t, context.Background(), s.svc, s.ctrl)
+	})
+
+	s.T().Run("forbidden to users", func(t *testing.T) {
+		test.StatsAdminForbidden(t, s.userContext(), s.svc, s.ctrl)
+	})
+
+	s.T().Run("forbidden to other service accounts", func(t *testing.T) {
+		ctx := s.contextWithToken(jwt.MapClaims{
+			"sub":                    uuid.NewV4().String(),
+			serviceaccount.NameClaim: "fabric8-tenant",
+		})
+		test.StatsAdminForbidden(t, ctx, s.svc, s.ctrl)
+	})
+}
+
+func (s *AdminControllerSuite) TestCleanup() {
+	spaceID := uuid.NewV4()
+	ppl := s.createMap("admin-cleanup", spaceID, uuid.NewV4())
+	// the checks of the other spaces fail, they are kept
+	gock.New("http://witservice").
+		Get("/api/spaces/" + spaceID.String()).
+		Reply(404).
+		JSON(`{"errors":[{"status":"404","code":"not_found","detail":"space not found"}]}`)
+
+	s.T().Run("forbidden to users", func(t *testing.T) {
+		test.CleanupAdminForbidden(t, s.userContext(), s.svc, s.ctrl)
+	})
+
+	s.T().Run("ok", func(t *testing.T) {
+		_, res := test.CleanupAdminOK(t, s.serviceAccountContext(), s.svc, s.ctrl)
+		assert.Contains(t, res.Data.PurgedSpaces, spaceID)
+		_, err := s.db.PipelineEnvMap().Load(context.Background(), ppl.ID)
+		require.Error(t, err)
+	})
+}
+
+func (s *AdminControllerSuite) TestReconcile() {
+	spaceID := uuid.NewV4()
+	env1ID, env2ID := uuid.NewV4(), uuid.NewV4()
+	ppl := s.createMap("admin-reconcile", spaceID, env1ID, env2ID)
+	// env2 was deleted
+	envList := func() {
+		_envID1, _ := guuid.FromString(env1ID.String())
+		name := "env1"
+		b, _ := json.Marshal(envservice.EnvironmentsList{
+			Data: []*envservice.Environment{{
+				ID:         &_envID1,
+				Attributes: &envservice.EnvironmentAttributes{Name: &name},
+				Links:      &envservice.GenericLinks{},
+				Type:       "environments",
+			}},
+			Links: &envservice.PagingLinks{},
+			Meta:  &envservice.EnvironmentListMeta{},
+		})
+		gock.New("http://envservice").
+			Get("/api/spaces/" + spaceID.String() + "/environments").
+			Reply(200).
+			JSON(string(b))
+	}
+
+	s.T().Run("forbidden to users", func(t *testing.T) {
+		test.ReconcileAdminForbidden(t, s.userContext(), s.svc, s.ctrl, &spaceID, true)
+	})
+
+	s.T().Run("dry run", func(t *testing.T) {
+		envList()
+		_, res := test.ReconcileAdminOK(t, s.serviceAccountContext(), s.svc, s.ctrl, &spaceID, true)
+		assert.True(t, res.Data.DryRun)
+		require.Len(t, res.Data.DanglingEnvironments, 1)
+		assert.Equal(t, ppl.ID, res.Data.DanglingEnvironments[0].PipelineEnvironmentMapID)
+		assert.Equal(t, env2ID, res.Data.DanglingEnvironments[0].EnvUUID)
+		loaded, err := s.db.PipelineEnvMap().Load(context.Background(), ppl.ID)
+		require.NoError(t, err)
+		assert.Len(t, loaded.Environments, 2)
+	})
+
+	s.T().Run("targeted by the definition", func(t *testing.T) {
+		_, err := s.db.PipelineDefinitions().Create(context.Background(), ppl.ID, deployDefinition(env2ID), nil)
+		require.NoError(t, err)
+		envList()
+		_, jerrs := test.ReconcileAdminUnprocessableEntity(t, s.serviceAccountContext(), s.svc, s.ctrl, &spaceID, false)
+		require.Len(t, jerrs.Errors, 1)
+		assert.Equal(t, "stages[0].environment", jerrs.Errors[0].Meta["path"])
+		loaded, err := s.db.PipelineEnvMap().Load(context.Background(), ppl.ID)
+		require.NoError(t, err)
+		assert.Len(t, loaded.Environments, 2)
+
+		// the definition stops targeting it
+		_, err = s.db.PipelineDefinitions().Create(context.Background(), ppl.ID, deployDefinition(env1ID), nil)
+		require.NoError(t, err)
+	})
+
+	s.T().Run("fixed", func(t *testing.T) {
+		envList()
+		_, res := test.ReconcileAdminOK(t, s.serviceAccountContext(), s.svc, s.ctrl, &spaceID, false)
+		require.Len(t, res.Data.DanglingEnvironments, 1)
+		loaded, err := s.db.PipelineEnvMap().Load(context.Background(), ppl.ID)
+		require.NoError(t, err)
+		require.Len(t, loaded.Environments, 1)
+		assert.Equal(t, env1ID, *loaded.Environments[0].EnvironmentID)
+	})
+
+	s.T().Run("env service failing", func(t *testing.T) {
+		gock.New("http://envservice").
+			Get("/api/spaces/" + spaceID.String() + "/environments").
+			Reply(500)
+		_, res := test.ReconcileAdminOK(t, s.serviceAccountContext(), s.svc, s.ctrl, &spaceID, false)
+		assert.Empty(t, res.Data.DanglingEnvironments)
+		assert.Equal(t, []uuid.UUID{spaceID}, res.Data.FailedSpaces)
+	})
+}
+
+func (s *AdminControllerSuite) TestQuotas() {
+	spaceID := uuid.NewV4()
+	s.createMap("admin-quotas", spaceID, uuid.NewV4())
+	ctx := s.serviceAccountContext()
+
+	s.T().Run("defaults", func(t *testing.T) {
+		_, res := test.ShowQuotaAdminOK(t, ctx, s.svc, s.ctrl, spaceID)
+		assert.Equal(t, s.ctrl.Quotas.MaxMaps, res.Data.MaxMaps)
+		assert.Equal(t, s.ctrl.Quotas.MaxEnvironmentsPerMap, res.Data.MaxEnvironmentsPerMap)
+		assert.Equal(t, 1, res.Data.Maps)
+		assert.Nil(t, res.Data.Override)
+	})
+
+	s.T().Run("overridden", func(t *testing.T) {
+		maxMaps := 3
+		_, res := test.UpdateQuotaAdminOK(t, ctx, s.svc, s.ctrl, spaceID, &app.UpdateQuotaAdminPayload{
+			Data: &app.SpaceQuotaOverride{MaxMaps: &maxMaps},
+		})
+		assert.Equal(t, 3, res.Data.MaxMaps)
+		assert.Equal(t, s.ctrl.Quotas.MaxEnvironmentsPerMap, res.Data.MaxEnvironmentsPerMap)
+		require.NotNil(t, res.Data.Override)
+		assert.Equal(t, 3, *res.Data.Override.MaxMaps)
+		assert.Nil(t, res.Data.Override.MaxEnvironmentsPerMap)
+
+		_, res = test.ShowQuotaAdminOK(t, ctx, s.svc, s.ctrl, spaceID)
+		assert.Equal(t, 3, res.Data.MaxMaps)
+	})
+
+	s.T().Run("reset", func(t *testing.T) {
+		test.DeleteQuotaAdminNoContent(t, ctx, s.svc, s.ctrl, spaceID)
+		_, res := test.ShowQuotaAdminOK(t, ctx, s.svc, s.ctrl, spaceID)
+		assert.Equal(t, s.ctrl.Quotas.MaxMaps, res.Data.MaxMaps)
+		assert.Nil(t, res.Data.Override)
+	})
+
+	s.T().Run("forbidden to users", func(t *testing.T) {
+		test.ShowQuotaAdminForbidden(t, s.userContext(), s.svc, s.ctrl, spaceID)
+		maxMaps := 1000
+		test.UpdateQuotaAdminForbidden(t, s.userContext(), s.svc, s.ctrl, spaceID, &app.UpdateQuotaAdminPayload{
+			Data: &app.SpaceQuotaOverride{MaxMaps: &maxMaps},
+		})
+		test.DeleteQuotaAdminForbidden(t, s.userContext(), s.svc, s.ctrl, spaceID)
+	})
+}
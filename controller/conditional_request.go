@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goadesign/goa"
+	errs "github.com/pkg/errors"
+)
+
+// pipelineEnvMapCacheControl lets the clients reuse the maps for a short
+// while, then revalidate them with a conditional request
+const pipelineEnvMapCacheControl = "private, max-age=2, must-revalidate"
+
+// validators identify the representation of a resource, so that the
+// conditional requests are answered with 304 Not Modified when it is
+// unchanged
+type validators struct {
+	etag         string
+	lastModified *time.Time
+}
+
+// newValidators computes the ETag from the given response data. The last
+// modification time is optional.
+func newValidators(data interface{}, lastModified *time.Time) (*validators, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, errs.Wrap(err, "unable to compute the ETag")
+	}
+	sum := sha1.Sum(b)
+	v := &validators{etag: `"` + hex.EncodeToString(sum[:]) + `"`}
+	if lastModified != nil && !lastModified.IsZero() {
+		t := lastModified.UTC().Truncate(time.Second)
+		v.lastModified = &t
+	}
+	return v, nil
+}
+
+// setHeaders sets the ETag, Last-Modified and Cache-Control headers of the
+// response, including the 304 ones
+func (v *validators) setHeaders(rd *goa.ResponseData) {
+	rd.Header().Set("ETag", v.etag)
+	if v.lastModified != nil {
+		rd.Header().Set("Last-Modified", v.lastModified.Format(http.TimeFormat))
+	}
+	rd.Header().Set("Cache-Control", pipelineEnvMapCacheControl)
+}
+
+// notModified returns true if the representation matches the conditions of
+// the request. As per RFC 7232, If-Modified-Since is ignored when
+// If-None-Match is given.
+func (v *validators) notModified(ifNoneMatch, ifModifiedSince *string) bool {
+	if ifNoneMatch != nil {
+		for _, tag := range strings.Split(*ifNoneMatch, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == v.etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince != nil && v.lastModified != nil {
+		since, err := http.ParseTime(*ifModifiedSince)
+		if err != nil {
+			// invalid dates are ignored
+			return false
+		}
+		return !v.lastModified.After(since)
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/events"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/fabric8-services/fabric8-common/token"
+	"github.com/goadesign/goa"
+	goajwt "github.com/goadesign/goa/middleware/security/jwt"
+	errs "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// changesBatchSize is the number of changes read at once by the streams
+const changesBatchSize = 100
+
+// EventsController implements the events resource.
+type EventsController struct {
+	*goa.Controller
+	db          application.DB
+	svcFactory  application.ServiceFactory
+	broadcaster *events.Broadcaster
+	// Streams limits the concurrent streams of each identity
+	Streams *events.Limiter
+	// HeartbeatInterval is how often a comment is sent on the idle streams,
+	// which also read the changes again in case a notification was missed
+	HeartbeatInterval time.Duration
+	closing           chan struct{}
+	closeOnce         sync.Once
+}
+
+// NewEventsController creates an events controller, the streams being woken
+// up by the given broadcaster.
+func NewEventsController(service *goa.Service, db application.DB, svcFactory application.ServiceFactory, broadcaster *events.Broadcaster) *EventsController {
+	return &EventsController{
+		Controller:        service.NewController("EventsController"),
+		db:                db,
+		svcFactory:        svcFactory,
+		broadcaster:       broadcaster,
+		Streams:           events.NewLimiter(events.DefaultMaxStreamsPerIdentity),
+		HeartbeatInterval: events.DefaultHeartbeatInterval,
+		closing:           make(chan struct{}),
+	}
+}
+
+// Close ends the streams, which would otherwise keep the server from
+// shutting down. The clients reconnect to another instance.
+func (c *EventsController) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+}
+
+// Stream runs the stream action.
+func (c *EventsController) Stream(ctx *app.StreamEventsContext) error {
+	tokenMgr, err := token.ReadManagerFromContext(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	identityID, err := tokenMgr.Locate(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, errors.NewUnauthorizedError(err.Error()))
+	}
+
+	var after int64
+	if ctx.LastEventID != nil {
+		after, err = build.DecodeCursor(*ctx.LastEventID)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, errors.NewBadParameterError("Last-Event-ID", *ctx.LastEventID).Expected("the ID of an event of the stream"))
+		}
+	}
+
+	// the access to the space is checked for each stream, with the token of
+	// the client
+	spaceID := ctx.SpaceID
+	err = checkSpaceExist(ctx, c.svcFactory, spaceID.String())
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	if ctx.LastEventID == nil {
+		after, err = c.db.PipelineEnvMapChanges().Latest(ctx, spaceID)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, err)
+		}
+	} else {
+		expired, err := cursorExpired(ctx, c.db, spaceID, after)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, err)
+		}
+		if expired {
+			return ctx.Gone(cursorExpiredErrors("the events following the Last-Event-ID were purged, list the maps again before streaming the events"))
+		}
+	}
+
+	flusher, ok := ctx.ResponseData.ResponseWriter.(http.Flusher)
+	if !ok {
+		return app.JSONErrorResponse(ctx, errors.NewInternalError(ctx, errs.New("streaming is not supported")))
+	}
+	if !c.Streams.Acquire(identityID.String()) {
+		return ctx.TooManyRequests(tooManyStreamsErrors())
+	}
+	defer c.Streams.Release(identityID.String())
+
+	// subscribed before reading the changes, so that none is missed
+	sub := c.broadcaster.Subscribe(spaceID)
+	defer sub.Close()
+	heartbeat := time.NewTicker(c.HeartbeatInterval)
+	defer heartbeat.Stop()
+	// the client reconnects with a fresh token
+	var expired <-chan time.Time
+	if exp, ok := tokenExpiry(ctx); ok {
+		timer := time.NewTimer(time.Until(exp))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	rw := ctx.ResponseData
+	rw.Header().Set("Content-Type", events.ContentType)
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	for {
+		after, err = c.sendChanges(ctx, rw, spaceID, after)
+		if err != nil {
+			// the response is started, the client resumes from the last event
+			log.Warn(ctx, map[string]interface{}{
+				"err":      err,
+				"space_id": spaceID.String(),
+			}, "event stream interrupted")
+			return nil
+		}
+		flusher.Flush()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ctx.Request.Context().Done():
+			return nil
+		case <-expired:
+			return nil
+		case <-c.closing:
+			return nil
+		case <-sub.C:
+		case <-heartbeat.C:
+			if err := events.WriteHeartbeat(rw); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// sendChanges writes the changes of the given space following the given
+// sequence number, returning the sequence number of the last one written
+func (c *EventsController) sendChanges(ctx context.Context, w io.Writer, spaceID uuid.UUID, after int64) (int64, error) {
+	for {
+		changes, err := c.db.PipelineEnvMapChanges().List(ctx, spaceID, after, changesBatchSize)
+		if err != nil {
+			return after, err
+		}
+		for _, change := range changes {
+			err := events.WriteEvent(w, change.Cursor(), events.MapChangeType(change.Operation), convertToPipelineEnvironmentMapChange(change))
+			if err != nil {
+				return after, err
+			}
+			after = change.Seq
+		}
+		if len(changes) < changesBatchSize {
+			return after, nil
+		}
+	}
+}
+
+// tokenExpiry returns the expiry of the token of the request, if any
+func tokenExpiry(ctx context.Context) (time.Time, bool) {
+	tk := goajwt.ContextJWT(ctx)
+	if tk == nil {
+		return time.Time{}, false
+	}
+	claims, ok := tk.Claims.(jwt.MapClaims)
+	if !ok {
+		return time.Time{}, false
+	}
+	switch exp := claims["exp"].(type) {
+	case float64:
+		return time.Unix(int64(exp), 0), true
+	case json.Number:
+		if v, err := exp.Int64(); err == nil {
+			return time.Unix(v, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func tooManyStreamsErrors() *app.JSONAPIErrors {
+	id := uuid.NewV4().String()
+	status := strconv.Itoa(http.StatusTooManyRequests)
+	code := "too_many_requests_error"
+	title := "Too Many Requests"
+	return &app.JSONAPIErrors{
+		Errors: []*app.JSONAPIError{{
+			ID:     &id,
+			Status: &status,
+			Code:   &code,
+			Title:  &title,
+			Detail: "too many concurrent event streams, close one before opening another",
+		}},
+	}
+}
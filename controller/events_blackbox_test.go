@@ -0,0 +1,195 @@
+package controller_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-build/app/test"
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/controller"
+	"github.com/fabric8-services/fabric8-build/events"
+	testauth "github.com/fabric8-services/fabric8-common/test/auth"
+	"github.com/goadesign/goa"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestStreamEvents() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	identity := testauth.NewIdentity()
+	svc, err := testauth.ServiceAsUser("events-test", identity)
+	require.NoError(s.T(), err)
+	broadcaster := events.NewBroadcaster()
+	ctrl := controller.NewEventsController(svc, s.db, s.svcFactory, broadcaster)
+	ctrl.HeartbeatInterval = time.Hour
+
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-events", spaceID, env1ID)
+	_, created := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), created)
+	changes, err := s.db.PipelineEnvMapChanges().List(context.Background(), spaceID, 0, 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), changes, 1)
+
+	// stream runs the stream until the given delay is elapsed
+	stream := func(t *testing.T, delay time.Duration, lastEventID *string) string {
+		ctx, cancel := context.WithTimeout(svc.Context, delay)
+		defer cancel()
+		s.createGockONSpace(spaceID, "space1")
+		rw := test.StreamEventsOK(t, ctx, svc, ctrl, spaceID, lastEventID)
+		assert.Equal(t, events.ContentType, rw.Header().Get("Content-Type"))
+		return rw.(*httptest.ResponseRecorder).Body.String()
+	}
+
+	s.T().Run("resumed", func(t *testing.T) {
+		since := build.EncodeCursor(0)
+		body := stream(t, 200*time.Millisecond, &since)
+		assert.Contains(t, body, "id: "+changes[0].Cursor()+"\nevent: pipeline-environment-map.create\ndata: ")
+		assert.Contains(t, body, created.Data.ID.String())
+	})
+
+	s.T().Run("live", func(t *testing.T) {
+		// the deadline only fails the test if the update is never streamed
+		ctx, cancel := context.WithTimeout(svc.Context, 10*time.Second)
+		defer cancel()
+		rw := newEventsRecorder("event: pipeline-environment-map.update")
+		s.createGockONSpace(spaceID, "space1")
+		done := make(chan error, 1)
+		go func() {
+			done <- serveEvents(ctx, svc, ctrl, rw, spaceID)
+		}()
+
+		// the stream is subscribed once the response is started
+		select {
+		case <-rw.started:
+		case <-ctx.Done():
+			t.Fatal("the stream didn't start")
+		}
+		err := application.Transactional(s.db, func(appl application.Application) error {
+			ppl, err := appl.PipelineEnvMap().Load(context.Background(), *created.Data.ID)
+			if err != nil {
+				return err
+			}
+			ppl.Environments = []build.PipelineEnvironment{{EnvironmentID: &env2ID}}
+			_, err = appl.PipelineEnvMap().Save(context.Background(), ppl)
+			return err
+		})
+		require.NoError(t, err)
+		broadcaster.Notify(spaceID)
+		select {
+		case <-rw.found:
+		case <-ctx.Done():
+			t.Fatal("the update wasn't streamed")
+		}
+		cancel()
+		require.NoError(t, <-done)
+
+		body := rw.Body.String()
+		assert.NotContains(t, body, "event: pipeline-environment-map.create")
+		assert.Contains(t, body, "event: pipeline-environment-map.update")
+		assert.Contains(t, body, env2ID.String())
+	})
+
+	s.T().Run("heartbeat", func(t *testing.T) {
+		ctrl.HeartbeatInterval = 20 * time.Millisecond
+		defer func() { ctrl.HeartbeatInterval = time.Hour }()
+		body := stream(t, 100*time.Millisecond, nil)
+		assert.Contains(t, body, ": heartbeat\n\n")
+	})
+
+	s.T().Run("too many streams", func(t *testing.T) {
+		ctrl.Streams = events.NewLimiter(1)
+		defer func() { ctrl.Streams = events.NewLimiter(events.DefaultMaxStreamsPerIdentity) }()
+		require.True(t, ctrl.Streams.Acquire(identity.ID.String()))
+		s.createGockONSpace(spaceID, "space1")
+		_, jerrs := test.StreamEventsTooManyRequests(t, svc.Context, svc, ctrl, spaceID, nil)
+		require.Len(t, jerrs.Errors, 1)
+	})
+
+	s.T().Run("invalid last event id", func(t *testing.T) {
+		lastEventID := "not-an-event"
+		test.StreamEventsBadRequest(t, svc.Context, svc, ctrl, spaceID, &lastEventID)
+	})
+
+	s.T().Run("purged last event id", func(t *testing.T) {
+		// the create is purged, long before the other tests
+		err := s.db.PipelineEnvMapChanges().MarkPublished(context.Background(), []int64{changes[0].Seq}, time.Now().Add(-1000*time.Hour))
+		require.NoError(t, err)
+		_, err = s.db.PipelineEnvMapChanges().Purge(context.Background(), time.Now().Add(-999*time.Hour))
+		require.NoError(t, err)
+
+		lastEventID := build.EncodeCursor(0)
+		s.createGockONSpace(spaceID, "space1")
+		_, jerrs := test.StreamEventsGone(t, svc.Context, svc, ctrl, spaceID, &lastEventID)
+		require.Len(t, jerrs.Errors, 1)
+		assert.Equal(t, "cursor_expired", *jerrs.Errors[0].Code)
+
+		// nothing is missed after the purged create
+		lastEventID = changes[0].Cursor()
+		body := stream(t, 200*time.Millisecond, &lastEventID)
+		assert.Contains(t, body, "event: pipeline-environment-map.update")
+	})
+
+	s.T().Run("unauthorized", func(t *testing.T) {
+		ctrl := controller.NewEventsController(s.svc, s.db, s.svcFactory, broadcaster)
+		test.StreamEventsUnauthorized(t, s.ctx, s.svc, ctrl, spaceID, nil)
+	})
+}
+
+// eventsRecorder records an event stream, signaling when the response is
+// started and when the body contains the awaited text
+type eventsRecorder struct {
+	*httptest.ResponseRecorder
+	awaited     string
+	started     chan struct{}
+	found       chan struct{}
+	startedOnce sync.Once
+	foundOnce   sync.Once
+}
+
+func newEventsRecorder(awaited string) *eventsRecorder {
+	return &eventsRecorder{
+		ResponseRecorder: httptest.NewRecorder(),
+		awaited:          awaited,
+		started:          make(chan struct{}),
+		found:            make(chan struct{}),
+	}
+}
+
+func (r *eventsRecorder) WriteHeader(code int) {
+	r.ResponseRecorder.WriteHeader(code)
+	r.startedOnce.Do(func() { close(r.started) })
+}
+
+func (r *eventsRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseRecorder.Write(b)
+	if strings.Contains(r.Body.String(), r.awaited) {
+		r.foundOnce.Do(func() { close(r.found) })
+	}
+	return n, err
+}
+
+// serveEvents runs the stream action of the given space with the given
+// response writer, until the context is done
+func serveEvents(ctx context.Context, svc *goa.Service, ctrl *controller.EventsController, rw http.ResponseWriter, spaceID uuid.UUID) error {
+	req := httptest.NewRequest("GET", "/api/spaces/"+spaceID.String()+"/events", nil)
+	params := url.Values{"spaceID": []string{spaceID.String()}}
+	goaCtx := goa.NewContext(goa.WithAction(ctx, "EventsTest"), rw, req, params)
+	streamCtx, err := app.NewStreamEventsContext(goaCtx, req, svc)
+	if err != nil {
+		return err
+	}
+	return ctrl.Stream(streamCtx)
+}
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/fabric8-services/fabric8-common/token"
+	errs "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ShowDefinition runs the showDefinition action.
+func (c *PipelineEnvironmentMapsController) ShowDefinition(ctx *app.ShowDefinitionPipelineEnvironmentMapsContext) error {
+	// the definitions of the deleted maps are not shown
+	_, err := c.db.PipelineEnvMap().Load(ctx, ctx.ID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	var def *build.Definition
+	if ctx.Version != nil {
+		def, err = c.db.PipelineDefinitions().Load(ctx, ctx.ID, *ctx.Version)
+	} else {
+		def, err = c.db.PipelineDefinitions().LoadLatest(ctx, ctx.ID)
+	}
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	return ctx.OK(&app.PipelineDefinitionSingle{
+		Data: convertToPipelineDefinition(def),
+	})
+}
+
+// UpdateDefinition runs the updateDefinition action.
+func (c *PipelineEnvironmentMapsController) UpdateDefinition(ctx *app.UpdateDefinitionPipelineEnvironmentMapsContext) error {
+	tokenMgr, err := token.ReadManagerFromContext(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	identityID, err := tokenMgr.Locate(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, errors.NewUnauthorizedError(err.Error()))
+	}
+
+	ppl, err := c.db.PipelineEnvMap().Load(ctx, ctx.ID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	err = checkSpaceExist(ctx, c.svcFactory, ppl.SpaceID.String())
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	var def *build.Definition
+	err = application.Transactional(c.db, func(appl application.Application) error {
+		// the stages are checked against the environments the map has when
+		// the definition is saved
+		ppl, err := appl.PipelineEnvMap().Load(ctx, ctx.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := build.ParseDefinition(ctx.Payload.Data.Document, environmentIDs(ppl)); err != nil {
+			return err
+		}
+		def, err = appl.PipelineDefinitions().Create(ctx, ctx.ID, ctx.Payload.Data.Document, &identityID)
+		return err
+	})
+	if err != nil {
+		if derr, ok := invalidDefinition(err); ok {
+			return ctx.UnprocessableEntity(definitionErrors(derr))
+		}
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	return ctx.OK(&app.PipelineDefinitionSingle{
+		Data: convertToPipelineDefinition(def),
+	})
+}
+
+// checkDefinition checks the latest pipeline definition of the given map, if
+// any, against its environments. It is run in the transactions changing the
+// environments of the maps, so that no stage is left targeting an environment
+// the map no longer has.
+func checkDefinition(ctx context.Context, appl application.Application, ppl *build.PipelineEnvMap) error {
+	def, err := appl.PipelineDefinitions().LoadLatest(ctx, ppl.ID)
+	if err != nil {
+		if ok, _ := errors.IsNotFoundError(err); ok {
+			return nil
+		}
+		return err
+	}
+	_, err = build.ParseDefinition(def.Document, environmentIDs(ppl))
+	return err
+}
+
+// environmentIDs returns the IDs of the environments of the given map, in
+// their order
+func environmentIDs(ppl *build.PipelineEnvMap) []uuid.UUID {
+	envIDs := []uuid.UUID{}
+	for _, env := range ppl.Environments {
+		if env.EnvironmentID != nil {
+			envIDs = append(envIDs, *env.EnvironmentID)
+		}
+	}
+	return envIDs
+}
+
+// invalidDefinition returns the pipeline definition error causing the given
+// error, if any
+func invalidDefinition(err error) (*build.InvalidDefinitionError, bool) {
+	e, ok := errs.Cause(err).(*build.InvalidDefinitionError)
+	return e, ok
+}
+
+// definitionErrors converts the errors of an invalid pipeline definition to
+// JSONAPI errors, with the line, column and path of each one in meta
+func definitionErrors(e *build.InvalidDefinitionError) *app.JSONAPIErrors {
+	status := strconv.Itoa(http.StatusUnprocessableEntity)
+	code := "invalid_pipeline_definition_error"
+	title := "Invalid pipeline definition"
+	res := &app.JSONAPIErrors{}
+	for _, derr := range e.Errors {
+		id := uuid.NewV4().String()
+		res.Errors = append(res.Errors, &app.JSONAPIError{
+			ID:     &id,
+			Status: &status,
+			Code:   &code,
+			Title:  &title,
+			Detail: derr.Error(),
+			Meta: map[string]interface{}{
+				"line":   derr.Line,
+				"column": derr.Column,
+				"path":   derr.Path,
+			},
+		})
+	}
+	return res
+}
+
+// convertToPipelineDefinition converts the version of the pipeline definition
+// from the database to its representation
+func convertToPipelineDefinition(def *build.Definition) *app.PipelineDefinition {
+	return &app.PipelineDefinition{
+		Version:   &def.Version,
+		Document:  def.Document,
+		AuthorID:  def.AuthorID,
+		CreatedAt: &def.CreatedAt,
+	}
+}
@@ -0,0 +1,181 @@
+package controller_test
+
+import (
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-build/app/test"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPipelineDefinitionPayload(document string) *app.PipelineDefinitionSingle {
+	return &app.PipelineDefinitionSingle{
+		Data: &app.PipelineDefinition{Document: document},
+	}
+}
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestDefinition() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-stage-definition", spaceID, env1ID)
+	_, newEnv := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), newEnv)
+	pplID := *newEnv.Data.ID
+
+	document := `version: 1
+builder:
+  image: fabric8/maven-builder
+stages:
+- name: build
+  steps:
+  - name: package
+    run: mvn -B package
+- name: stage
+  environment: ` + env1ID.String() + `
+  steps:
+  - name: deploy
+    run: oc apply -f target/openshift
+`
+
+	s.T().Run("no definition", func(t *testing.T) {
+		test.ShowDefinitionPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, pplID, nil)
+	})
+
+	s.T().Run("update", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		_, def := test.UpdateDefinitionPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID, newPipelineDefinitionPayload(document))
+		require.NotNil(t, def.Data.Version)
+		assert.Equal(t, 1, *def.Data.Version)
+		assert.Equal(t, document, def.Data.Document)
+		assert.NotNil(t, def.Data.AuthorID)
+
+		s.createGockONSpace(spaceID, "space1")
+		_, def = test.UpdateDefinitionPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID, newPipelineDefinitionPayload(document+"- name: test\n  steps:\n  - name: it\n    run: mvn verify\n"))
+		assert.Equal(t, 2, *def.Data.Version)
+	})
+
+	s.T().Run("show", func(t *testing.T) {
+		_, def := test.ShowDefinitionPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID, nil)
+		assert.Equal(t, 2, *def.Data.Version)
+		assert.Contains(t, def.Data.Document, "name: test")
+
+		version := 1
+		_, def = test.ShowDefinitionPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID, &version)
+		assert.Equal(t, 1, *def.Data.Version)
+		assert.Equal(t, document, def.Data.Document)
+
+		version = 5
+		test.ShowDefinitionPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, pplID, &version)
+	})
+
+	s.T().Run("invalid document", func(t *testing.T) {
+		// env2 is an environment of the space but not of the map
+		invalid := `version: 1
+builder:
+  image: fabric8/maven-builder
+stages:
+- name: stage
+  environment: ` + env2ID.String() + `
+  steps:
+  - name: deploy
+`
+		s.createGockONSpace(spaceID, "space1")
+		_, jerrs := test.UpdateDefinitionPipelineEnvironmentMapsUnprocessableEntity(t, s.ctx2, s.svc2, s.ctrl2, pplID, newPipelineDefinitionPayload(invalid))
+		require.Len(t, jerrs.Errors, 2)
+		assert.Equal(t, 6, jerrs.Errors[0].Meta["line"])
+		assert.Equal(t, "stages[0].environment", jerrs.Errors[0].Meta["path"])
+		assert.Contains(t, jerrs.Errors[0].Detail, "line 6: ")
+		assert.Equal(t, 8, jerrs.Errors[1].Meta["line"])
+		assert.Equal(t, "stages[0].steps[0].run", jerrs.Errors[1].Meta["path"])
+
+		// nothing is saved
+		_, def := test.ShowDefinitionPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID, nil)
+		assert.Equal(t, 2, *def.Data.Version)
+	})
+
+	s.T().Run("invalid yaml", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		_, jerrs := test.UpdateDefinitionPipelineEnvironmentMapsUnprocessableEntity(t, s.ctx2, s.svc2, s.ctrl2, pplID, newPipelineDefinitionPayload("version: 1\nbuilder:\n  image: fabric8: maven\n"))
+		require.Len(t, jerrs.Errors, 1)
+		assert.Equal(t, 3, jerrs.Errors[0].Meta["line"])
+	})
+
+	s.T().Run("unknown map", func(t *testing.T) {
+		test.UpdateDefinitionPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, uuid.NewV4(), newPipelineDefinitionPayload(document))
+		test.ShowDefinitionPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, uuid.NewV4(), nil)
+	})
+
+	s.T().Run("unauthorized", func(t *testing.T) {
+		test.UpdateDefinitionPipelineEnvironmentMapsUnauthorized(t, s.ctx, s.svc, s.ctrl, pplID, newPipelineDefinitionPayload(document))
+	})
+}
+
+// deployDefinition returns a pipeline definition document deploying to the
+// given environment
+func deployDefinition(envID uuid.UUID) string {
+	return `version: 1
+builder:
+  image: fabric8/maven-builder
+stages:
+- name: stage
+  environment: ` + envID.String() + `
+  steps:
+  - name: deploy
+    run: oc apply -f target/openshift
+`
+}
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestDefinitionEnvironments() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-stage-definition-envs", spaceID, env1ID)
+	_, newEnv := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), newEnv)
+	pplID := *newEnv.Data.ID
+	// the second revision has only env2, the third one env1 again
+	for _, envID := range []uuid.UUID{env2ID, env1ID} {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		test.UpdatePipelineEnvironmentMapsOK(s.T(), s.ctx2, s.svc2, s.ctrl2, pplID, updatePipelineEnvironmentMapPayload(payload, envID))
+	}
+	s.createGockONSpace(spaceID, "space1")
+	test.UpdateDefinitionPipelineEnvironmentMapsOK(s.T(), s.ctx2, s.svc2, s.ctrl2, pplID, newPipelineDefinitionPayload(deployDefinition(env1ID)))
+
+	s.T().Run("update removing the targeted environment", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		_, jerrs := test.UpdatePipelineEnvironmentMapsUnprocessableEntity(t, s.ctx2, s.svc2, s.ctrl2, pplID, updatePipelineEnvironmentMapPayload(payload, env2ID))
+		require.Len(t, jerrs.Errors, 1)
+		assert.Equal(t, "invalid_pipeline_definition_error", *jerrs.Errors[0].Code)
+		assert.Equal(t, "stages[0].environment", jerrs.Errors[0].Meta["path"])
+
+		// nothing is saved
+		_, ppl := test.ShowPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID, nil, nil)
+		require.Len(t, ppl.Data.Environments, 1)
+		assert.Equal(t, env1ID, *ppl.Data.Environments[0].EnvUUID)
+	})
+
+	s.T().Run("restore removing the targeted environment", func(t *testing.T) {
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		_, jerrs := test.RestoreRevisionPipelineEnvironmentMapsUnprocessableEntity(t, s.ctx2, s.svc2, s.ctrl2, pplID, 2)
+		require.Len(t, jerrs.Errors, 1)
+		assert.Equal(t, "invalid_pipeline_definition_error", *jerrs.Errors[0].Code)
+
+		_, revs := test.ListRevisionsPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID)
+		assert.Len(t, revs.Data, 3)
+	})
+
+	s.T().Run("update keeping the targeted environment", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		test.UpdatePipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID, updatePipelineEnvironmentMapPayload(payload, env1ID))
+	})
+}
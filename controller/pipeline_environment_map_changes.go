@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/build"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ListChanges runs the listChanges action.
+func (c *PipelineEnvironmentMapsController) ListChanges(ctx *app.ListChangesPipelineEnvironmentMapsContext) error {
+	var after int64
+	if ctx.Since != nil {
+		var err error
+		after, err = build.DecodeCursor(*ctx.Since)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, err)
+		}
+	}
+
+	spaceID := ctx.SpaceID
+	err := checkSpaceExist(ctx, c.svcFactory, spaceID.String())
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	if ctx.Since != nil {
+		expired, err := cursorExpired(ctx, c.db, spaceID, after)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, err)
+		}
+		if expired {
+			return ctx.Gone(cursorExpiredErrors("the changes following the cursor were purged, list the maps again before reading the changes"))
+		}
+	}
+
+	// one more change tells if there are more
+	changes, err := c.db.PipelineEnvMapChanges().List(ctx, spaceID, after, ctx.Limit+1)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	res := &app.PipelineEnvironmentMapChangesList{
+		Data: []*app.PipelineEnvironmentMapChange{},
+		Meta: &app.PipelineEnvironmentMapChangesMeta{
+			Cursor:  build.EncodeCursor(after),
+			HasMore: len(changes) > ctx.Limit,
+		},
+	}
+	if res.Meta.HasMore {
+		changes = changes[:ctx.Limit]
+	}
+	for _, change := range changes {
+		res.Data = append(res.Data, convertToPipelineEnvironmentMapChange(change))
+		res.Meta.Cursor = change.Cursor()
+	}
+	return ctx.OK(res)
+}
+
+// convertToPipelineEnvironmentMapChange converts the change from the
+// database to its representation, without the state of the map for the
+// tombstones
+func convertToPipelineEnvironmentMapChange(change *build.Change) *app.PipelineEnvironmentMapChange {
+	res := &app.PipelineEnvironmentMapChange{
+		Cursor:    change.Cursor(),
+		Operation: change.Operation,
+		ID:        change.PipelineEnvMapID,
+		ChangedAt: change.ChangedAt,
+	}
+	if change.Operation == build.ChangeDelete {
+		return res
+	}
+	res.Name = change.Name
+	res.Environments = []*app.EnvironmentAttributes{}
+	for _, envID := range change.Environments() {
+		envID := envID
+		res.Environments = append(res.Environments, &app.EnvironmentAttributes{EnvUUID: &envID})
+	}
+	res.Labels = change.Labels
+	if res.Labels == nil {
+		res.Labels = map[string]string{}
+	}
+	return res
+}
+
+// cursorExpired tells if some of the changes of the given space following the
+// given sequence number were purged, the changes read from it would then miss
+// them
+func cursorExpired(ctx context.Context, db application.DB, spaceID uuid.UUID, after int64) (bool, error) {
+	purged, err := db.PipelineEnvMapChanges().Purged(ctx, spaceID)
+	if err != nil {
+		return false, err
+	}
+	return after < purged, nil
+}
+
+func cursorExpiredErrors(detail string) *app.JSONAPIErrors {
+	id := uuid.NewV4().String()
+	status := strconv.Itoa(http.StatusGone)
+	code := "cursor_expired"
+	title := "Gone"
+	return &app.JSONAPIErrors{
+		Errors: []*app.JSONAPIError{{
+			ID:     &id,
+			Status: &status,
+			Code:   &code,
+			Title:  &title,
+			Detail: detail,
+		}},
+	}
+}
@@ -0,0 +1,106 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/app/test"
+	"github.com/fabric8-services/fabric8-build/build"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestListChanges() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-changes", spaceID, env1ID)
+	_, created := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), created)
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	_, updated := test.UpdatePipelineEnvironmentMapsOK(s.T(), s.ctx2, s.svc2, s.ctrl2, *created.Data.ID, updatePipelineEnvironmentMapPayload(payload, env2ID))
+	require.NotNil(s.T(), updated)
+
+	s.T().Run("from the start", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		_, list := test.ListChangesPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, 100, nil)
+		require.Len(t, list.Data, 2)
+		assert.Equal(t, build.ChangeCreate, list.Data[0].Operation)
+		assert.Equal(t, *created.Data.ID, list.Data[0].ID)
+		assert.Equal(t, "osio-changes", *list.Data[0].Name)
+		assert.Equal(t, env1ID, *list.Data[0].Environments[0].EnvUUID)
+		assert.Equal(t, build.ChangeUpdate, list.Data[1].Operation)
+		assert.Equal(t, env2ID, *list.Data[1].Environments[0].EnvUUID)
+		assert.Equal(t, list.Data[1].Cursor, list.Meta.Cursor)
+		assert.False(t, list.Meta.HasMore)
+	})
+
+	s.T().Run("resumed", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		_, first := test.ListChangesPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, 1, nil)
+		require.Len(t, first.Data, 1)
+		assert.Equal(t, build.ChangeCreate, first.Data[0].Operation)
+		assert.True(t, first.Meta.HasMore)
+
+		s.createGockONSpace(spaceID, "space1")
+		_, next := test.ListChangesPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, 1, &first.Meta.Cursor)
+		require.Len(t, next.Data, 1)
+		assert.Equal(t, build.ChangeUpdate, next.Data[0].Operation)
+		assert.False(t, next.Meta.HasMore)
+
+		// nothing new, the cursor stays
+		s.createGockONSpace(spaceID, "space1")
+		_, last := test.ListChangesPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, 1, &next.Meta.Cursor)
+		assert.Empty(t, last.Data)
+		assert.Equal(t, next.Meta.Cursor, last.Meta.Cursor)
+	})
+
+	s.T().Run("deleted", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		test.DeletePipelineEnvironmentMapsNoContent(t, s.ctx2, s.svc2, s.ctrl2, *created.Data.ID)
+		s.createGockONSpace(spaceID, "space1")
+		_, list := test.ListChangesPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, 100, nil)
+		require.Len(t, list.Data, 3)
+		assert.Equal(t, build.ChangeDelete, list.Data[2].Operation)
+		assert.Equal(t, *created.Data.ID, list.Data[2].ID)
+		assert.Nil(t, list.Data[2].Name)
+		assert.Nil(t, list.Data[2].Environments)
+	})
+
+	s.T().Run("invalid cursor", func(t *testing.T) {
+		since := "not-a-cursor"
+		test.ListChangesPipelineEnvironmentMapsBadRequest(t, s.ctx2, s.svc2, s.ctrl2, spaceID, 100, &since)
+	})
+
+	s.T().Run("purged", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		_, first := test.ListChangesPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, 1, nil)
+		require.Len(t, first.Data, 1)
+		// the create and the update are purged, long before the other tests
+		changes, err := s.db.PipelineEnvMapChanges().List(context.Background(), spaceID, 0, 100)
+		require.NoError(t, err)
+		require.Len(t, changes, 3)
+		err = s.db.PipelineEnvMapChanges().MarkPublished(context.Background(), []int64{changes[0].Seq, changes[1].Seq}, time.Now().Add(-1000*time.Hour))
+		require.NoError(t, err)
+		_, err = s.db.PipelineEnvMapChanges().Purge(context.Background(), time.Now().Add(-999*time.Hour))
+		require.NoError(t, err)
+
+		// the update would be missed
+		s.createGockONSpace(spaceID, "space1")
+		_, jerrs := test.ListChangesPipelineEnvironmentMapsGone(t, s.ctx2, s.svc2, s.ctrl2, spaceID, 100, &first.Meta.Cursor)
+		require.Len(t, jerrs.Errors, 1)
+		assert.Equal(t, "cursor_expired", *jerrs.Errors[0].Code)
+
+		// nothing is missed after the last purged change
+		since := changes[1].Cursor()
+		s.createGockONSpace(spaceID, "space1")
+		_, list := test.ListChangesPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, 100, &since)
+		require.Len(t, list.Data, 1)
+		assert.Equal(t, build.ChangeDelete, list.Data[0].Operation)
+	})
+}
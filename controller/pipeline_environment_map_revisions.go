@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/fabric8-services/fabric8-common/token"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ListRevisions runs the listRevisions action.
+func (c *PipelineEnvironmentMapsController) ListRevisions(ctx *app.ListRevisionsPipelineEnvironmentMapsContext) error {
+	// the revisions of the deleted maps are not shown
+	_, err := c.db.PipelineEnvMap().Load(ctx, ctx.ID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	revs, err := c.db.PipelineEnvMapRevisions().List(ctx, ctx.ID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	res := &app.PipelineEnvironmentMapRevisionsList{
+		Data: []*app.PipelineEnvironmentMapRevision{},
+		Meta: &app.PipelineEnvironmentListMeta{TotalCount: len(revs)},
+	}
+	for _, rev := range revs {
+		res.Data = append(res.Data, convertToPipelineEnvironmentMapRevision(rev))
+	}
+	return ctx.OK(res)
+}
+
+// DiffRevisions runs the diffRevisions action.
+func (c *PipelineEnvironmentMapsController) DiffRevisions(ctx *app.DiffRevisionsPipelineEnvironmentMapsContext) error {
+	_, err := c.db.PipelineEnvMap().Load(ctx, ctx.ID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	from, err := c.db.PipelineEnvMapRevisions().Load(ctx, ctx.ID, ctx.From)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	to, err := c.db.PipelineEnvMapRevisions().Load(ctx, ctx.ID, ctx.To)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	return ctx.OK(&app.PipelineEnvironmentMapRevisionDiffSingle{
+		Data: diffRevisions(from, to),
+	})
+}
+
+// RestoreRevision runs the restoreRevision action.
+func (c *PipelineEnvironmentMapsController) RestoreRevision(ctx *app.RestoreRevisionPipelineEnvironmentMapsContext) error {
+	tokenMgr, err := token.ReadManagerFromContext(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	identityID, err := tokenMgr.Locate(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, errors.NewUnauthorizedError(err.Error()))
+	}
+
+	ppl, err := c.db.PipelineEnvMap().Load(ctx, ctx.ID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	rev, err := c.db.PipelineEnvMapRevisions().Load(ctx, ctx.ID, ctx.Rev)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	// the environments of the revision may have been deleted since
+	newEnvs, err := c.checkEnvironmentExistAndConvert(ctx, ppl.SpaceID.String(), revisionEnvironments(rev))
+	if err != nil {
+		return app.JSONErrorResponse(ctx, errors.NewNotFoundError("environment", err.Error()))
+	}
+	if newEnvs == nil {
+		newEnvs = []build.PipelineEnvironment{}
+	}
+
+	err = application.Transactional(c.db, func(appl application.Application) error {
+		ppl, err = appl.PipelineEnvMap().Load(ctx, ctx.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkQuotas(ctx, appl, c.Quotas, *ppl.SpaceID, false, len(newEnvs)); err != nil {
+			return err
+		}
+
+		ppl.Name = &rev.Name
+		ppl.Environments = newEnvs
+		ppl, err = appl.PipelineEnvMap().Save(ctx, ppl)
+		if err != nil {
+			return err
+		}
+		if err := checkDefinition(ctx, appl, ppl); err != nil {
+			return err
+		}
+		_, err = appl.PipelineEnvMapRevisions().Create(ctx, ppl, &identityID, &rev.Revision)
+		return err
+	})
+	if err != nil {
+		if qerr, ok := quotaExceeded(err); ok {
+			return ctx.UnprocessableEntity(quotaErrors(qerr, http.StatusUnprocessableEntity))
+		}
+		if derr, ok := invalidDefinition(err); ok {
+			return ctx.UnprocessableEntity(definitionErrors(derr))
+		}
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	res := &app.PipelineEnvironmentMapSingle{
+		Data: convertToPipelineEnvironmentMapStruct(ppl),
+	}
+	return ctx.OK(res)
+}
+
+// revisionEnvironments returns the environments of the given revision, in
+// their order
+func revisionEnvironments(rev *build.Revision) []*app.EnvironmentAttributes {
+	envs := []*app.EnvironmentAttributes{}
+	for _, envID := range rev.Environments() {
+		envID := envID
+		envs = append(envs, &app.EnvironmentAttributes{EnvUUID: &envID})
+	}
+	return envs
+}
+
+// diffRevisions returns the environments added and removed between the given
+// revisions, and whether the environments kept are in another order
+func diffRevisions(from, to *build.Revision) *app.PipelineEnvironmentMapRevisionDiff {
+	diff := &app.PipelineEnvironmentMapRevisionDiff{
+		From:                from.Revision,
+		To:                  to.Revision,
+		NameFrom:            from.Name,
+		NameTo:              to.Name,
+		AddedEnvironments:   []*app.EnvironmentAttributes{},
+		RemovedEnvironments: []*app.EnvironmentAttributes{},
+	}
+	fromIDs, toIDs := from.Environments(), to.Environments()
+	inFrom, inTo := map[uuid.UUID]bool{}, map[uuid.UUID]bool{}
+	for _, id := range fromIDs {
+		inFrom[id] = true
+	}
+	for _, id := range toIDs {
+		inTo[id] = true
+	}
+
+	var keptFrom, keptTo []uuid.UUID
+	for _, id := range fromIDs {
+		if inTo[id] {
+			keptFrom = append(keptFrom, id)
+			continue
+		}
+		id := id
+		diff.RemovedEnvironments = append(diff.RemovedEnvironments, &app.EnvironmentAttributes{EnvUUID: &id})
+	}
+	for _, id := range toIDs {
+		if inFrom[id] {
+			keptTo = append(keptTo, id)
+			continue
+		}
+		id := id
+		diff.AddedEnvironments = append(diff.AddedEnvironments, &app.EnvironmentAttributes{EnvUUID: &id})
+	}
+	for i := range keptFrom {
+		if i >= len(keptTo) || keptFrom[i] != keptTo[i] {
+			diff.OrderChanged = true
+			break
+		}
+	}
+	return diff
+}
+
+// convertToPipelineEnvironmentMapRevision converts the revision from the
+// database to its representation
+func convertToPipelineEnvironmentMapRevision(rev *build.Revision) *app.PipelineEnvironmentMapRevision {
+	return &app.PipelineEnvironmentMapRevision{
+		Revision:     rev.Revision,
+		Name:         rev.Name,
+		Environments: revisionEnvironments(rev),
+		AuthorID:     rev.AuthorID,
+		RestoredFrom: rev.RestoredFrom,
+		CreatedAt:    rev.CreatedAt,
+	}
+}
@@ -0,0 +1,83 @@
+package controller_test
+
+import (
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/app/test"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestRevisions() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-stage-revisions", spaceID, env1ID)
+	_, newEnv := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), newEnv)
+	pplID := *newEnv.Data.ID
+
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	test.UpdatePipelineEnvironmentMapsOK(s.T(), s.ctx2, s.svc2, s.ctrl2, pplID, updatePipelineEnvironmentMapPayload(payload, env2ID))
+
+	s.T().Run("list", func(t *testing.T) {
+		_, revs := test.ListRevisionsPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID)
+		require.Len(t, revs.Data, 2)
+		assert.Equal(t, 2, revs.Meta.TotalCount)
+		assert.Equal(t, 1, revs.Data[0].Revision)
+		assert.Equal(t, env1ID, *revs.Data[0].Environments[0].EnvUUID)
+		assert.NotNil(t, revs.Data[0].AuthorID)
+		assert.Equal(t, 2, revs.Data[1].Revision)
+		assert.Equal(t, env2ID, *revs.Data[1].Environments[0].EnvUUID)
+	})
+
+	s.T().Run("diff", func(t *testing.T) {
+		_, diff := test.DiffRevisionsPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID, 1, 2)
+		assert.Equal(t, "osio-stage-revisions", diff.Data.NameFrom)
+		assert.Equal(t, "osio-stage-revisions", diff.Data.NameTo)
+		require.Len(t, diff.Data.AddedEnvironments, 1)
+		assert.Equal(t, env2ID, *diff.Data.AddedEnvironments[0].EnvUUID)
+		require.Len(t, diff.Data.RemovedEnvironments, 1)
+		assert.Equal(t, env1ID, *diff.Data.RemovedEnvironments[0].EnvUUID)
+		assert.False(t, diff.Data.OrderChanged)
+	})
+
+	s.T().Run("diff unknown revision", func(t *testing.T) {
+		test.DiffRevisionsPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, pplID, 1, 5)
+	})
+
+	s.T().Run("restore", func(t *testing.T) {
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		_, restored := test.RestoreRevisionPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID, 1)
+		require.Len(t, restored.Data.Environments, 1)
+		assert.Equal(t, env1ID, *restored.Data.Environments[0].EnvUUID)
+
+		_, revs := test.ListRevisionsPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, pplID)
+		require.Len(t, revs.Data, 3)
+		require.NotNil(t, revs.Data[2].RestoredFrom)
+		assert.Equal(t, 1, *revs.Data[2].RestoredFrom)
+		assert.Equal(t, env1ID, *revs.Data[2].Environments[0].EnvUUID)
+	})
+
+	s.T().Run("restore deleted environment", func(t *testing.T) {
+		// env2 is not known anymore
+		s.createGockONEnvList(spaceID, env1ID, uuid.NewV4())
+		test.RestoreRevisionPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, pplID, 2)
+	})
+
+	s.T().Run("restore unknown revision", func(t *testing.T) {
+		test.RestoreRevisionPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, pplID, 9)
+	})
+
+	s.T().Run("unknown map", func(t *testing.T) {
+		test.ListRevisionsPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, uuid.NewV4())
+	})
+
+	s.T().Run("restore unauthorized", func(t *testing.T) {
+		test.RestoreRevisionPipelineEnvironmentMapsUnauthorized(t, s.ctx, s.svc, s.ctrl, pplID, 1)
+	})
+}
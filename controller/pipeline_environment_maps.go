@@ -2,10 +2,17 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
 	"github.com/fabric8-services/fabric8-build/app"
 	"github.com/fabric8-services/fabric8-build/application"
 	"github.com/fabric8-services/fabric8-build/application/env"
+	"github.com/fabric8-services/fabric8-build/application/serviceaccount"
 	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/idempotency"
+	"github.com/fabric8-services/fabric8-build/quota"
 	"github.com/fabric8-services/fabric8-common/errors"
 	"github.com/fabric8-services/fabric8-common/httpsupport"
 	"github.com/fabric8-services/fabric8-common/token"
@@ -13,6 +20,7 @@ import (
 	guuid "github.com/goadesign/goa/uuid"
 	errs "github.com/pkg/errors"
 	"github.com/prometheus/common/log"
+	uuid "github.com/satori/go.uuid"
 )
 
 // PipelineEnvironmentMapsController implements the PipelineEnvironmentMaps resource.
@@ -20,14 +28,29 @@ type PipelineEnvironmentMapsController struct {
 	*goa.Controller
 	db         application.DB
 	svcFactory application.ServiceFactory
+	// IdempotencyTTL is how long the responses of the create requests sent
+	// with an Idempotency-Key header are kept, read on each request so that
+	// it can be reloaded
+	IdempotencyTTL func() time.Duration
+	// ServiceAccounts are the service accounts allowed to delete the maps
+	// of a space
+	ServiceAccounts *serviceaccount.Authorizer
+	// TrashRetention is how long the deleted maps are kept in the trash
+	TrashRetention time.Duration
+	// Quotas are the limits of the spaces without override
+	Quotas quota.Limits
 }
 
 // NewPipelineEnvironmentMapsController creates a PipelineEnvironmentMaps controller.
 func NewPipelineEnvironmentMapsController(service *goa.Service, db application.DB, svcFactory application.ServiceFactory) *PipelineEnvironmentMapsController {
 	return &PipelineEnvironmentMapsController{
-		Controller: service.NewController("PipelineEnvironmentControllerMap"),
-		db:         db,
-		svcFactory: svcFactory,
+		Controller:      service.NewController("PipelineEnvironmentControllerMap"),
+		db:              db,
+		svcFactory:      svcFactory,
+		IdempotencyTTL:  func() time.Duration { return idempotency.DefaultTTL },
+		ServiceAccounts: serviceaccount.NewAuthorizer(serviceaccount.DefaultNames, nil),
+		TrashRetention:  build.DefaultTrashRetention,
+		Quotas:          quota.DefaultLimits,
 	}
 }
 
@@ -37,7 +60,7 @@ func (c *PipelineEnvironmentMapsController) Create(ctx *app.CreatePipelineEnviro
 	if err != nil {
 		return app.JSONErrorResponse(ctx, err)
 	}
-	_, err = tokenMgr.Locate(ctx)
+	identityID, err := tokenMgr.Locate(ctx)
 	if err != nil {
 		return app.JSONErrorResponse(ctx, errors.NewUnauthorizedError(err.Error()))
 	}
@@ -47,9 +70,25 @@ func (c *PipelineEnvironmentMapsController) Create(ctx *app.CreatePipelineEnviro
 		return app.JSONErrorResponse(ctx, err)
 	}
 
+	// replay the response of the original request sent with the same key
+	var fingerprint string
+	if ctx.IdempotencyKey != nil {
+		fingerprint, err = idempotency.Fingerprint("create", ctx.SpaceID, ctx.Payload)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, err)
+		}
+		res, err := c.loadIdempotentResponse(ctx, identityID, *ctx.IdempotencyKey, fingerprint)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, err)
+		}
+		if res != nil {
+			return replayCreated(ctx, res)
+		}
+	}
+
 	reqPpl := ctx.Payload.Data
 	spaceID := ctx.SpaceID
-	err = c.checkSpaceExist(ctx, spaceID.String())
+	err = checkSpaceExist(ctx, c.svcFactory, spaceID.String())
 	if err != nil {
 		return app.JSONErrorResponse(ctx, err)
 	}
@@ -61,9 +100,20 @@ func (c *PipelineEnvironmentMapsController) Create(ctx *app.CreatePipelineEnviro
 
 	var ppl *build.PipelineEnvMap
 	err = application.Transactional(c.db, func(appl application.Application) error {
+		// the key is reserved first, so that a concurrent retry waits for
+		// this request and then replays its response
+		if ctx.IdempotencyKey != nil {
+			if err := c.reserveIdempotencyKey(ctx, appl, identityID, *ctx.IdempotencyKey, fingerprint); err != nil {
+				return err
+			}
+		}
+		if err := checkQuotas(ctx, appl, c.Quotas, spaceID, true, len(newEnvs)); err != nil {
+			return err
+		}
 		newPipeline := build.PipelineEnvMap{
 			Name:         &reqPpl.Name,
 			SpaceID:      &spaceID,
+			Labels:       build.Labels(reqPpl.Labels),
 			Environments: newEnvs,
 		}
 
@@ -73,30 +123,35 @@ func (c *PipelineEnvironmentMapsController) Create(ctx *app.CreatePipelineEnviro
 				"failed to create pipelineenvmap: %s", newPipeline.Name)
 			return errs.Wrapf(err, "failed to create pipelineenvmap: %s", *newPipeline.Name)
 		}
+		if _, err = appl.PipelineEnvMapRevisions().Create(ctx, ppl, &identityID, nil); err != nil {
+			return err
+		}
 
+		if ctx.IdempotencyKey != nil {
+			return saveIdempotentResponse(ctx, appl, identityID, *ctx.IdempotencyKey, convertToCreatedPipelineEnvironmentMap(ppl))
+		}
 		return nil
 	})
 
 	if err != nil {
+		// the original request holding the key was committed meanwhile
+		if ctx.IdempotencyKey != nil {
+			if res, lerr := c.loadIdempotentResponse(ctx, identityID, *ctx.IdempotencyKey, fingerprint); lerr == nil && res != nil {
+				return replayCreated(ctx, res)
+			}
+		}
+		if qerr, ok := quotaExceeded(err); ok {
+			// adding a map to a full space is forbidden, while a map with
+			// too many environments is unprocessable
+			if qerr.Quota == quota.Maps {
+				return ctx.Forbidden(quotaErrors(qerr, http.StatusForbidden))
+			}
+			return ctx.UnprocessableEntity(quotaErrors(qerr, http.StatusUnprocessableEntity))
+		}
 		return app.JSONErrorResponse(ctx, err)
 	}
 
-	newEnvAttributes := []*app.EnvironmentAttributes{}
-	for _, pipeline := range ppl.Environments {
-		newEnvAttributes = append(newEnvAttributes, &app.EnvironmentAttributes{
-			EnvUUID: pipeline.EnvironmentID,
-		})
-	}
-
-	res := &app.PipelineEnvironmentMapSingle{
-		Data: &app.PipelineEnvironmentMaps{
-			ID:           &ppl.ID,
-			Name:         *ppl.Name,
-			Environments: newEnvAttributes,
-			SpaceID:      ppl.SpaceID,
-		},
-	}
-
+	res := convertToCreatedPipelineEnvironmentMap(ppl)
 	ctx.ResponseData.Header().Set(
 		"Location",
 		httpsupport.AbsoluteURL(&goa.RequestData{Request: ctx.Request}, app.PipelineEnvironmentMapsHref(res.Data.ID), nil),
@@ -106,13 +161,37 @@ func (c *PipelineEnvironmentMapsController) Create(ctx *app.CreatePipelineEnviro
 
 // List runs the list action.
 func (c *PipelineEnvironmentMapsController) List(ctx *app.ListPipelineEnvironmentMapsContext) error {
+	var sort []build.SortKey
+	if ctx.Sort != nil {
+		var err error
+		sort, err = build.ParseSort(*ctx.Sort)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, err)
+		}
+	}
+	fields, err := parseFieldset("fields[pipelineenvironmentmaps]", ctx.FieldsPipelineenvironmentmaps, pipelineEnvMapFields)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	var selector build.Selector
+	if ctx.LabelSelector != nil {
+		selector, err = build.ParseSelector(*ctx.LabelSelector)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, err)
+		}
+	}
+
 	spaceID := ctx.SpaceID
-	err := c.checkSpaceExist(ctx, spaceID.String())
+	err = checkSpaceExist(ctx, c.svcFactory, spaceID.String())
 	if err != nil {
 		return app.JSONErrorResponse(ctx, err)
 	}
 
-	pplenvmaps, err := c.db.PipelineEnvMap().List(ctx, spaceID)
+	pplenvmaps, err := c.db.PipelineEnvMap().List(ctx, spaceID, selector, sort...)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	lastModified, err := c.db.PipelineEnvMap().LastModified(ctx, spaceID)
 	if err != nil {
 		return app.JSONErrorResponse(ctx, err)
 	}
@@ -122,6 +201,55 @@ func (c *PipelineEnvironmentMapsController) List(ctx *app.ListPipelineEnvironmen
 		newPipelineEnvMapList = append(newPipelineEnvMapList, convertToPipelineEnvironmentMapStruct(pipEnvMap))
 	}
 
+	res := &app.PipelineEnvironmentMapsList{
+		Data: newPipelineEnvMapList,
+	}
+	var body interface{} = res
+	var sparse *app.SparsePipelineEnvironmentMapsList
+	if fields != nil {
+		sparse = &app.SparsePipelineEnvironmentMapsList{
+			Data: []*app.SparsePipelineEnvironmentMaps{},
+		}
+		for _, pipEnvMap := range newPipelineEnvMapList {
+			sparse.Data = append(sparse.Data, sparsePipelineEnvMap(pipEnvMap, fields))
+		}
+		body = sparse
+	}
+
+	v, err := newValidators(body, lastModified)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	v.setHeaders(ctx.ResponseData)
+	if v.notModified(ctx.IfNoneMatch, ctx.IfModifiedSince) {
+		return ctx.NotModified()
+	}
+	if sparse != nil {
+		return ctx.OKSparse(sparse)
+	}
+	return ctx.OK(res)
+}
+
+// ListByEnvironment runs the listByEnvironment action.
+func (c *PipelineEnvironmentMapsController) ListByEnvironment(ctx *app.ListByEnvironmentPipelineEnvironmentMapsContext) error {
+	pplenvmaps, err := c.db.PipelineEnvMap().ListByEnvironment(ctx, ctx.EnvID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	// the service accounts get the maps of all the spaces, the users only
+	// the ones of the spaces they can read
+	if _, err := c.ServiceAccounts.Authorize(ctx); err != nil {
+		pplenvmaps, err = readableMaps(ctx, c.svcFactory, pplenvmaps)
+		if err != nil {
+			return app.JSONErrorResponse(ctx, err)
+		}
+	}
+
+	newPipelineEnvMapList := []*app.PipelineEnvironmentMaps{}
+	for _, pipEnvMap := range pplenvmaps {
+		newPipelineEnvMapList = append(newPipelineEnvMapList, convertToPipelineEnvironmentMapStruct(pipEnvMap))
+	}
+
 	res := &app.PipelineEnvironmentMapsList{
 		Data: newPipelineEnvMapList,
 	}
@@ -140,6 +268,14 @@ func (c *PipelineEnvironmentMapsController) Show(ctx *app.ShowPipelineEnvironmen
 	res := &app.PipelineEnvironmentMapSingle{
 		Data: data,
 	}
+	v, err := newValidators(res, &pipenvmap.UpdatedAt)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	v.setHeaders(ctx.ResponseData)
+	if v.notModified(ctx.IfNoneMatch, ctx.IfModifiedSince) {
+		return ctx.NotModified()
+	}
 	return ctx.OK(res)
 }
 
@@ -149,7 +285,7 @@ func (c *PipelineEnvironmentMapsController) Update(ctx *app.UpdatePipelineEnviro
 	if err != nil {
 		return app.JSONErrorResponse(ctx, err)
 	}
-	_, err = tokenMgr.Locate(ctx)
+	identityID, err := tokenMgr.Locate(ctx)
 	if err != nil {
 		return app.JSONErrorResponse(ctx, errors.NewUnauthorizedError(err.Error()))
 	}
@@ -161,7 +297,7 @@ func (c *PipelineEnvironmentMapsController) Update(ctx *app.UpdatePipelineEnviro
 
 	reqPpl := ctx.Payload.Data
 	spaceID := reqPpl.SpaceID
-	err = c.checkSpaceExist(ctx, spaceID.String())
+	err = checkSpaceExist(ctx, c.svcFactory, spaceID.String())
 	if err != nil {
 		return app.JSONErrorResponse(ctx, err)
 	}
@@ -173,17 +309,36 @@ func (c *PipelineEnvironmentMapsController) Update(ctx *app.UpdatePipelineEnviro
 
 	var ppl *build.PipelineEnvMap
 	err = application.Transactional(c.db, func(appl application.Application) error {
-		ppl, err = c.db.PipelineEnvMap().Load(ctx, ctx.ID)
+		ppl, err = appl.PipelineEnvMap().Load(ctx, ctx.ID)
 		if err != nil {
-			return app.JSONErrorResponse(ctx, err)
+			return err
+		}
+		if err := checkQuotas(ctx, appl, c.Quotas, *ppl.SpaceID, false, len(newEnvs)); err != nil {
+			return err
 		}
 
 		ppl.Name = &ctx.Payload.Data.Name
 		ppl.Environments = newEnvs
+		if ctx.Payload.Data.Labels != nil {
+			ppl.Labels = build.Labels(ctx.Payload.Data.Labels)
+		}
 		ppl, err = appl.PipelineEnvMap().Save(ctx, ppl)
+		if err != nil {
+			return err
+		}
+		if err := checkDefinition(ctx, appl, ppl); err != nil {
+			return err
+		}
+		_, err = appl.PipelineEnvMapRevisions().Create(ctx, ppl, &identityID, nil)
 		return err
 	})
 	if err != nil {
+		if qerr, ok := quotaExceeded(err); ok {
+			return ctx.UnprocessableEntity(quotaErrors(qerr, http.StatusUnprocessableEntity))
+		}
+		if derr, ok := invalidDefinition(err); ok {
+			return ctx.UnprocessableEntity(definitionErrors(derr))
+		}
 		return app.JSONErrorResponse(ctx, err)
 	}
 
@@ -194,17 +349,223 @@ func (c *PipelineEnvironmentMapsController) Update(ctx *app.UpdatePipelineEnviro
 	return ctx.OK(res)
 }
 
+// Delete runs the delete action.
+func (c *PipelineEnvironmentMapsController) Delete(ctx *app.DeletePipelineEnvironmentMapsContext) error {
+	tokenMgr, err := token.ReadManagerFromContext(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	_, err = tokenMgr.Locate(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, errors.NewUnauthorizedError(err.Error()))
+	}
+
+	// the access to the space of the map is checked with the token of the
+	// client
+	ppl, err := c.db.PipelineEnvMap().Load(ctx, ctx.ID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	err = checkSpaceExist(ctx, c.svcFactory, ppl.SpaceID.String())
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	err = application.Transactional(c.db, func(appl application.Application) error {
+		return appl.PipelineEnvMap().Delete(ctx, ctx.ID)
+	})
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	return ctx.NoContent()
+}
+
+// ListTrash runs the listTrash action.
+func (c *PipelineEnvironmentMapsController) ListTrash(ctx *app.ListTrashPipelineEnvironmentMapsContext) error {
+	spaceID := ctx.SpaceID
+	err := checkSpaceExist(ctx, c.svcFactory, spaceID.String())
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	ppls, err := c.db.PipelineEnvMap().ListDeleted(ctx, spaceID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	res := &app.DeletedPipelineEnvironmentMapsList{
+		Data: []*app.DeletedPipelineEnvironmentMap{},
+		Meta: &app.PipelineEnvironmentListMeta{TotalCount: len(ppls)},
+	}
+	for _, ppl := range ppls {
+		res.Data = append(res.Data, convertToDeletedPipelineEnvironmentMap(ppl, c.TrashRetention))
+	}
+	return ctx.OK(res)
+}
+
+// Restore runs the restore action.
+func (c *PipelineEnvironmentMapsController) Restore(ctx *app.RestorePipelineEnvironmentMapsContext) error {
+	tokenMgr, err := token.ReadManagerFromContext(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	identityID, err := tokenMgr.Locate(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, errors.NewUnauthorizedError(err.Error()))
+	}
+
+	// the access to the space of the map is checked with the token of the
+	// client
+	deleted, err := c.db.PipelineEnvMap().LoadDeleted(ctx, ctx.ID)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	err = checkSpaceExist(ctx, c.svcFactory, deleted.SpaceID.String())
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	var ppl *build.PipelineEnvMap
+	err = application.Transactional(c.db, func(appl application.Application) error {
+		// the restored map counts again in the quotas of the space
+		if err := checkQuotas(ctx, appl, c.Quotas, *deleted.SpaceID, true, len(deleted.Environments)); err != nil {
+			return err
+		}
+		ppl, err = appl.PipelineEnvMap().Restore(ctx, ctx.ID)
+		if err != nil {
+			return err
+		}
+		_, err = appl.PipelineEnvMapRevisions().Create(ctx, ppl, &identityID, nil)
+		return err
+	})
+	if err != nil {
+		if qerr, ok := quotaExceeded(err); ok {
+			// the environments of a map in the trash can't be changed, so
+			// exceeding any quota forbids the restore
+			return ctx.Forbidden(quotaErrors(qerr, http.StatusForbidden))
+		}
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	res := &app.PipelineEnvironmentMapSingle{
+		Data: convertToPipelineEnvironmentMapStruct(ppl),
+	}
+	return ctx.OK(res)
+}
+
+// DeleteBySpace runs the deleteBySpace action.
+func (c *PipelineEnvironmentMapsController) DeleteBySpace(ctx *app.DeleteBySpacePipelineEnvironmentMapsContext) error {
+	_, err := c.ServiceAccounts.Authorize(ctx)
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+
+	err = application.Transactional(c.db, func(appl application.Application) error {
+		if _, err := appl.PipelineEnvMap().DeleteBySpace(ctx, ctx.SpaceID); err != nil {
+			return err
+		}
+		return appl.MissingSpaces().Delete(ctx, ctx.SpaceID)
+	})
+	if err != nil {
+		return app.JSONErrorResponse(ctx, err)
+	}
+	return ctx.NoContent()
+}
+
+// loadIdempotentResponse returns the response of the create request sent
+// with the given key if any. Reusing a key with another payload is an error.
+func (c *PipelineEnvironmentMapsController) loadIdempotentResponse(ctx context.Context, identityID uuid.UUID, key, fingerprint string) (*app.PipelineEnvironmentMapSingle, error) {
+	k, err := c.db.IdempotencyKeys().Load(ctx, identityID, key, time.Now())
+	if err != nil {
+		if ok, _ := errors.IsNotFoundError(err); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if k.Fingerprint != fingerprint {
+		return nil, errors.NewBadParameterError(idempotency.HeaderName, key).Expected("the payload of the original request")
+	}
+	var res app.PipelineEnvironmentMapSingle
+	if err := json.Unmarshal([]byte(k.Response), &res); err != nil {
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	return &res, nil
+}
+
+// reserveIdempotencyKey creates the key of the create request until the end
+// of its transaction, a concurrent request with the same key waiting for its
+// commit and then failing with a data conflict error
+func (c *PipelineEnvironmentMapsController) reserveIdempotencyKey(ctx context.Context, appl application.Application, identityID uuid.UUID, key, fingerprint string) error {
+	now := time.Now()
+	return appl.IdempotencyKeys().Create(ctx, &idempotency.Key{
+		Key:         key,
+		IdentityID:  identityID,
+		Operation:   "create",
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(c.IdempotencyTTL()),
+	})
+}
+
+// saveIdempotentResponse saves the response of the create request sent with
+// the given key, which was reserved in the same transaction
+func saveIdempotentResponse(ctx context.Context, appl application.Application, identityID uuid.UUID, key string, res *app.PipelineEnvironmentMapSingle) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return errors.NewInternalError(ctx, err)
+	}
+	return appl.IdempotencyKeys().Complete(ctx, identityID, key, http.StatusCreated, string(b))
+}
+
+// replayCreated sends the response of the original create request
+func replayCreated(ctx *app.CreatePipelineEnvironmentMapsContext, res *app.PipelineEnvironmentMapSingle) error {
+	ctx.ResponseData.Header().Set("Idempotent-Replayed", "true")
+	ctx.ResponseData.Header().Set(
+		"Location",
+		httpsupport.AbsoluteURL(&goa.RequestData{Request: ctx.Request}, app.PipelineEnvironmentMapsHref(res.Data.ID), nil),
+	)
+	return ctx.Created(res)
+}
+
 // This will check whether the given space exist or not
-func (c *PipelineEnvironmentMapsController) checkSpaceExist(ctx context.Context, spaceID string) error {
+func checkSpaceExist(ctx context.Context, svcFactory application.ServiceFactory, spaceID string) error {
 	// TODO(chmouel): Make sure we have the rights for that space
 	// TODO(chmouel): Better error reporting when NOTFound
-	_, err := c.svcFactory.WITService().GetSpace(ctx, spaceID)
+	_, err := svcFactory.WITService().GetSpace(ctx, spaceID)
 	if err != nil {
 		return errs.Wrapf(err, "failed to get space id: %s from wit", spaceID)
 	}
 	return nil
 }
 
+// readableMaps returns the maps of the spaces which the caller can read,
+// each space being checked once
+func readableMaps(ctx context.Context, svcFactory application.ServiceFactory, ppls []*build.PipelineEnvMap) ([]*build.PipelineEnvMap, error) {
+	readable := map[uuid.UUID]bool{}
+	res := make([]*build.PipelineEnvMap, 0, len(ppls))
+	for _, ppl := range ppls {
+		if ppl.SpaceID == nil {
+			continue
+		}
+		ok, checked := readable[*ppl.SpaceID]
+		if !checked {
+			err := checkSpaceExist(ctx, svcFactory, ppl.SpaceID.String())
+			switch errs.Cause(err).(type) {
+			case nil:
+				ok = true
+			case errors.NotFoundError, errors.ForbiddenError:
+				ok = false
+			default:
+				return nil, err
+			}
+			readable[*ppl.SpaceID] = ok
+		}
+		if ok {
+			res = append(res, ppl)
+		}
+	}
+	return res, nil
+}
+
 // This will check whether the env's exit and then convert to build.Environment List
 func (c *PipelineEnvironmentMapsController) checkEnvironmentExistAndConvert(ctx context.Context, spaceID string, envs []*app.EnvironmentAttributes) ([]build.PipelineEnvironment, error) {
 	envList, err := c.svcFactory.ENVService().GetEnvList(ctx, spaceID)
@@ -236,6 +597,26 @@ func convertToEnvUIDList(envList []env.Environment) map[guuid.UUID]string {
 	return envMap
 }
 
+// this will convert the created pipeline to the create response
+func convertToCreatedPipelineEnvironmentMap(ppl *build.PipelineEnvMap) *app.PipelineEnvironmentMapSingle {
+	newEnvAttributes := []*app.EnvironmentAttributes{}
+	for _, pipeline := range ppl.Environments {
+		newEnvAttributes = append(newEnvAttributes, &app.EnvironmentAttributes{
+			EnvUUID: pipeline.EnvironmentID,
+		})
+	}
+
+	return &app.PipelineEnvironmentMapSingle{
+		Data: &app.PipelineEnvironmentMaps{
+			ID:           &ppl.ID,
+			Name:         *ppl.Name,
+			Environments: newEnvAttributes,
+			SpaceID:      ppl.SpaceID,
+			Labels:       labelsOf(ppl),
+		},
+	}
+}
+
 // this will convert the pipeline struct from database to pipeline-environment struct
 func convertToPipelineEnvironmentMapStruct(ppl *build.PipelineEnvMap) *app.PipelineEnvironmentMaps {
 	newEnvAttributes := []*app.EnvironmentAttributes{}
@@ -250,10 +631,39 @@ func convertToPipelineEnvironmentMapStruct(ppl *build.PipelineEnvMap) *app.Pipel
 		Name:         *ppl.Name,
 		Environments: newEnvAttributes,
 		SpaceID:      ppl.SpaceID,
+		Labels:       labelsOf(ppl),
 	}
 	return pe
 }
 
+// labelsOf returns the labels of the given map, always as an object
+func labelsOf(ppl *build.PipelineEnvMap) map[string]string {
+	if ppl.Labels == nil {
+		return map[string]string{}
+	}
+	return ppl.Labels
+}
+
+// this will convert the deleted pipeline struct from database to its trash
+// representation
+func convertToDeletedPipelineEnvironmentMap(ppl *build.PipelineEnvMap, retention time.Duration) *app.DeletedPipelineEnvironmentMap {
+	newEnvAttributes := []*app.EnvironmentAttributes{}
+	for _, pipelineEnv := range ppl.Environments {
+		newEnvAttributes = append(newEnvAttributes, &app.EnvironmentAttributes{
+			EnvUUID: pipelineEnv.EnvironmentID,
+		})
+	}
+
+	return &app.DeletedPipelineEnvironmentMap{
+		ID:           ppl.ID,
+		Name:         *ppl.Name,
+		Environments: newEnvAttributes,
+		SpaceID:      ppl.SpaceID,
+		DeletedAt:    *ppl.DeletedAt,
+		PurgeAt:      ppl.DeletedAt.Add(retention),
+	}
+}
+
 func validateCreatePipelineEnvironmentMap(ctx *app.CreatePipelineEnvironmentMapsContext) error {
 	if ctx.Payload.Data == nil {
 		return errors.NewBadParameterError("data", nil).Expected("not nil")
@@ -267,7 +677,7 @@ func validateCreatePipelineEnvironmentMap(ctx *app.CreatePipelineEnvironmentMaps
 	if ctx.Payload.Data.Environments == nil || len(ctx.Payload.Data.Environments) == 0 {
 		return errors.NewBadParameterError("data.environments", nil).Expected("not nil")
 	}
-	return nil
+	return build.ValidateLabels("data.labels", ctx.Payload.Data.Labels)
 }
 
 func validateUpdatePipelineEnvironmentMap(ctx *app.UpdatePipelineEnvironmentMapsContext) error {
@@ -283,5 +693,5 @@ func validateUpdatePipelineEnvironmentMap(ctx *app.UpdatePipelineEnvironmentMaps
 	if ctx.Payload.Data.Environments == nil || len(ctx.Payload.Data.Environments) == 0 {
 		return errors.NewBadParameterError("data.environments", nil).Expected("not nil")
 	}
-	return nil
+	return build.ValidateLabels("data.labels", ctx.Payload.Data.Labels)
 }
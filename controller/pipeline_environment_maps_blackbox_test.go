@@ -15,14 +15,19 @@ import (
 	"github.com/fabric8-services/fabric8-build/app/test"
 	"github.com/fabric8-services/fabric8-build/application"
 	"github.com/fabric8-services/fabric8-build/application/env/envservice"
+	"github.com/fabric8-services/fabric8-build/application/serviceaccount"
 	"github.com/fabric8-services/fabric8-build/application/wit/witservice"
+	"github.com/fabric8-services/fabric8-build/build"
 	"github.com/fabric8-services/fabric8-build/configuration"
 	"github.com/fabric8-services/fabric8-build/controller"
 	"github.com/fabric8-services/fabric8-build/gormapp"
+	"github.com/fabric8-services/fabric8-build/idempotency"
 	testauth "github.com/fabric8-services/fabric8-common/test/auth"
 	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	"github.com/fabric8-services/fabric8-common/token"
 	"github.com/goadesign/goa"
 	guuid "github.com/goadesign/goa/uuid"
+	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -189,7 +194,7 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestCreate() {
 		s.createGockONSpace(space1ID, "space1")
 		s.createGockONEnvList(space1ID, env1ID, env2ID)
 		payload := newPipelineEnvironmentMapPayload("osio-stage-create", space1ID, env1ID)
-		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, space1ID, payload)
+		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, space1ID, nil, payload)
 		assert.NotNil(t, newEnv)
 		assert.NotNil(t, newEnv.Data.ID)
 		assert.NotNil(t, newEnv.Data.Environments[0].EnvUUID)
@@ -199,7 +204,7 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestCreate() {
 		s.createGockONSpace(space2ID, "space2")
 		s.createGockONEnvList(space2ID, env1ID, env2ID)
 		payload = newPipelineEnvironmentMapPayload("osio-stage-create", space2ID, env1ID)
-		_, newEnv = test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, space2ID, payload)
+		_, newEnv = test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, space2ID, nil, payload)
 		assert.NotNil(t, newEnv)
 		assert.NotNil(t, newEnv.Data.ID)
 		assert.NotNil(t, newEnv.Data.Environments[0].EnvUUID)
@@ -213,12 +218,12 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestCreate() {
 		s.createGockONSpace(space1ID, "space1")
 		s.createGockONEnvList(space1ID, env1ID, env2ID)
 		payload := newPipelineEnvironmentMapPayload("osio-stage-create-conflict", space1ID, env1ID)
-		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, space1ID, payload)
+		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, space1ID, nil, payload)
 		assert.NotNil(t, newEnv)
 
 		s.createGockONSpace(space1ID, "space1")
 		s.createGockONEnvList(space1ID, env1ID, env2ID)
-		response, err := test.CreatePipelineEnvironmentMapsConflict(t, s.ctx2, s.svc2, s.ctrl2, space1ID, payload)
+		response, err := test.CreatePipelineEnvironmentMapsConflict(t, s.ctx2, s.svc2, s.ctrl2, space1ID, nil, payload)
 		require.NotNil(t, response.Header().Get("Location"))
 		assert.Regexp(s.T(), ".*data_conflict_error.*", err.Errors)
 
@@ -234,7 +239,7 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestCreate() {
 			Get("/api/spaces/" + failSpaceID.String()).
 			Reply(404)
 		payload = newPipelineEnvironmentMapPayload("space-not-found", failSpaceID, env1ID)
-		response, err = test.CreatePipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, failSpaceID, payload)
+		response, err = test.CreatePipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, failSpaceID, nil, payload)
 		require.NotNil(t, response.Header().Get("Location"))
 		assert.Regexp(s.T(), ".*not_found.*", err.Errors)
 
@@ -243,7 +248,7 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestCreate() {
 			Get("/api/spaces/" + failSpaceID.String()).
 			Reply(422)
 		payload = newPipelineEnvironmentMapPayload("space-unkown-error", failSpaceID, env1ID)
-		response, err = test.CreatePipelineEnvironmentMapsInternalServerError(t, s.ctx2, s.svc2, s.ctrl2, failSpaceID, payload)
+		response, err = test.CreatePipelineEnvironmentMapsInternalServerError(t, s.ctx2, s.svc2, s.ctrl2, failSpaceID, nil, payload)
 		require.NotNil(t, response.Header().Get("Location"))
 		assert.Regexp(s.T(), ".*unknown_error.*", err.Errors)
 
@@ -251,7 +256,7 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestCreate() {
 		s.createGockONSpace(space1ID, "space1")
 		s.createGockONEnvList(space1ID, env1ID, env2ID)
 		payload = newPipelineEnvironmentMapPayload("env-not-found", space1ID, failEnvID)
-		response, err = test.CreatePipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, space1ID, payload)
+		response, err = test.CreatePipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, space1ID, nil, payload)
 		require.NotNil(t, response.Header().Get("Location"))
 		assert.Regexp(s.T(), ".*not_found.*", err.Errors)
 	})
@@ -263,11 +268,114 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestCreate() {
 		s.createGockONSpace(space1ID, "space1")
 		s.createGockONEnvList(space1ID, env1ID, env2ID)
 		payload := newPipelineEnvironmentMapPayload("osio-stage", space1ID, env2ID)
-		_, err := test.CreatePipelineEnvironmentMapsUnauthorized(t, s.ctx, s.svc, s.ctrl, space1ID, payload)
+		_, err := test.CreatePipelineEnvironmentMapsUnauthorized(t, s.ctx, s.svc, s.ctrl, space1ID, nil, payload)
 		assert.NotNil(t, err)
 	})
 }
 
+func (s *PipelineEnvironmentMapsControllerSuite) TestCreateIdempotent() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	key := "key-" + uuid.NewV4().String()
+	payload := newPipelineEnvironmentMapPayload("osio-stage-idempotent", spaceID, env1ID)
+	rw, newEnv := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, &key, payload)
+	require.NotNil(s.T(), newEnv.Data.ID)
+	assert.Empty(s.T(), rw.Header().Get("Idempotent-Replayed"))
+
+	s.T().Run("replayed", func(t *testing.T) {
+		rw, replayed := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, &key, payload)
+		assert.Equal(t, "true", rw.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, *newEnv.Data.ID, *replayed.Data.ID)
+		assert.Equal(t, newEnv.Data.Name, replayed.Data.Name)
+		assert.NotEmpty(t, rw.Header().Get("Location"))
+	})
+
+	s.T().Run("key reused with another payload", func(t *testing.T) {
+		other := newPipelineEnvironmentMapPayload("osio-stage-idempotent-other", spaceID, env1ID)
+		_, err := test.CreatePipelineEnvironmentMapsBadRequest(t, s.ctx2, s.svc2, s.ctrl2, spaceID, &key, other)
+		require.NotNil(t, err)
+		assert.Contains(t, err.Errors[0].Detail, "Idempotency-Key")
+	})
+
+	s.T().Run("concurrent retry", func(t *testing.T) {
+		ctx := context.Background()
+		key := "key-" + uuid.NewV4().String()
+		payload := newPipelineEnvironmentMapPayload("osio-stage-idempotent-concurrent", spaceID, env1ID)
+		fingerprint, err := idempotency.Fingerprint("create", spaceID, payload)
+		require.NoError(t, err)
+		tokenMgr, err := token.ReadManagerFromContext(s.ctx2)
+		require.NoError(t, err)
+		identityID, err := tokenMgr.Locate(s.ctx2)
+		require.NoError(t, err)
+
+		// the original request is still in flight, holding the key and the
+		// name of the map until its commit
+		tx := s.DB.Begin()
+		defer tx.Rollback()
+		now := time.Now()
+		err = idempotency.NewRepository(tx).Create(ctx, &idempotency.Key{
+			Key:         key,
+			IdentityID:  identityID,
+			Operation:   "create",
+			Fingerprint: fingerprint,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(time.Hour),
+		})
+		require.NoError(t, err)
+		ppl, err := build.NewRepository(tx).Create(ctx, &build.PipelineEnvMap{
+			Name:         &payload.Data.Name,
+			SpaceID:      &spaceID,
+			Environments: []build.PipelineEnvironment{{EnvironmentID: &env1ID}},
+		})
+		require.NoError(t, err)
+
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		retried := make(chan *app.PipelineEnvironmentMapSingle, 1)
+		replayed := make(chan string, 1)
+		go func() {
+			rw, res := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, &key, payload)
+			replayed <- rw.Header().Get("Idempotent-Replayed")
+			retried <- res
+		}()
+		waitForLock(t, s.DB)
+
+		b, err := json.Marshal(app.PipelineEnvironmentMapSingle{Data: &app.PipelineEnvironmentMaps{
+			ID:           &ppl.ID,
+			Name:         payload.Data.Name,
+			SpaceID:      &spaceID,
+			Environments: payload.Data.Environments,
+		}})
+		require.NoError(t, err)
+		require.NoError(t, idempotency.NewRepository(tx).Complete(ctx, identityID, key, http.StatusCreated, string(b)))
+		require.NoError(t, tx.Commit().Error)
+
+		// the retry waited for the original request and replayed its response
+		select {
+		case header := <-replayed:
+			assert.Equal(t, "true", header)
+			res := <-retried
+			require.NotNil(t, res)
+			assert.Equal(t, ppl.ID, *res.Data.ID)
+		case <-time.After(10 * time.Second):
+			t.Fatal("the retry was not answered")
+		}
+	})
+
+	s.T().Run("expired", func(t *testing.T) {
+		_, err := s.db.IdempotencyKeys().DeleteExpired(context.Background(), time.Now().Add(48*time.Hour))
+		require.NoError(t, err)
+		// the map was created by the original request
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		_, err2 := test.CreatePipelineEnvironmentMapsConflict(t, s.ctx2, s.svc2, s.ctrl2, spaceID, &key, payload)
+		require.NotNil(t, err2)
+	})
+}
+
 func (s *PipelineEnvironmentMapsControllerSuite) TestShow() {
 	s.T().Run("ok", func(t *testing.T) {
 		spaceID := uuid.NewV4()
@@ -276,10 +384,10 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestShow() {
 		s.createGockONSpace(spaceID, "space1")
 		s.createGockONEnvList(spaceID, env1ID, env2ID)
 		payload := newPipelineEnvironmentMapPayload("osio-stage-show", spaceID, env1ID)
-		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, payload)
+		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
 		require.NotNil(t, newEnv)
 
-		_, env := test.ShowPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID)
+		_, env := test.ShowPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, nil)
 		assert.NotNil(t, env)
 		assert.Equal(t, newEnv.Data.ID, env.Data.ID)
 	})
@@ -291,14 +399,98 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestShow() {
 		s.createGockONSpace(spaceID, "space1")
 		s.createGockONEnvList(spaceID, env1ID, env2ID)
 		payload := newPipelineEnvironmentMapPayload("osio-stage-show", spaceID, env1ID)
-		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, payload)
+		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
 		require.NotNil(t, newEnv)
 
-		_, err := test.ShowPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, uuid.NewV4())
+		_, err := test.ShowPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, uuid.NewV4(), nil, nil)
 		assert.NotNil(t, err)
 	})
 }
 
+func (s *PipelineEnvironmentMapsControllerSuite) TestShowConditional() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-stage-show-conditional", spaceID, env1ID)
+	_, newEnv := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), newEnv)
+
+	rw, _ := test.ShowPipelineEnvironmentMapsOK(s.T(), s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, nil)
+	etag := rw.Header().Get("ETag")
+	lastModified := rw.Header().Get("Last-Modified")
+	require.NotEmpty(s.T(), etag)
+	require.NotEmpty(s.T(), lastModified)
+	assert.NotEmpty(s.T(), rw.Header().Get("Cache-Control"))
+
+	s.T().Run("if-none-match", func(t *testing.T) {
+		rw := test.ShowPipelineEnvironmentMapsNotModified(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, &etag)
+		assert.Equal(t, etag, rw.Header().Get("ETag"))
+		other := `"other", W/` + etag
+		test.ShowPipelineEnvironmentMapsNotModified(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, &other)
+	})
+
+	s.T().Run("if-modified-since", func(t *testing.T) {
+		test.ShowPipelineEnvironmentMapsNotModified(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, &lastModified, nil)
+		before := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+		test.ShowPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, &before, nil)
+	})
+
+	s.T().Run("if-none-match takes precedence", func(t *testing.T) {
+		other := `"other"`
+		test.ShowPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, &lastModified, &other)
+	})
+
+	s.T().Run("modified", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		test.UpdatePipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, updatePipelineEnvironmentMapPayload(payload, env2ID))
+		rw, _ := test.ShowPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, &etag)
+		assert.NotEqual(t, etag, rw.Header().Get("ETag"))
+	})
+}
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestListConditional() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-stage-list-conditional", spaceID, env1ID)
+	_, newEnv := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), newEnv)
+	// the map was modified long ago, so that its deletion changes the
+	// Last-Modified header, which has a precision of a second
+	require.NoError(s.T(), s.DB.Model(&build.PipelineEnvMap{}).Where("id = ?", newEnv.Data.ID.String()).
+		UpdateColumn("updated_at", time.Now().Add(-time.Hour)).Error)
+
+	s.createGockONSpace(spaceID, "space1")
+	rw, _ := test.ListPipelineEnvironmentMapsOK(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, nil, nil, nil, nil)
+	etag := rw.Header().Get("ETag")
+	lastModified := rw.Header().Get("Last-Modified")
+	require.NotEmpty(s.T(), etag)
+	require.NotEmpty(s.T(), lastModified)
+
+	s.T().Run("not modified", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		test.ListPipelineEnvironmentMapsNotModified(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, nil, nil, nil, &etag)
+		s.createGockONSpace(spaceID, "space1")
+		test.ListPipelineEnvironmentMapsNotModified(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, nil, nil, &lastModified, nil)
+	})
+
+	s.T().Run("deleted", func(t *testing.T) {
+		// the deletion is seen by both validators
+		s.createGockONSpace(spaceID, "space1")
+		test.DeletePipelineEnvironmentMapsNoContent(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID)
+		s.createGockONSpace(spaceID, "space1")
+		_, list := test.ListPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, nil, nil, nil, &etag)
+		assert.Empty(t, list.Data)
+		s.createGockONSpace(spaceID, "space1")
+		test.ListPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, nil, nil, &lastModified, nil)
+	})
+}
+
 func (s *PipelineEnvironmentMapsControllerSuite) TestList() {
 	s.T().Run("ok", func(t *testing.T) {
 		spaceID := uuid.NewV4()
@@ -307,27 +499,211 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestList() {
 		s.createGockONSpace(spaceID, "space1")
 		s.createGockONEnvList(spaceID, env1ID, env2ID)
 		payload := newPipelineEnvironmentMapPayload("osio-stage-show", spaceID, env1ID)
-		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, payload)
+		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
 		require.NotNil(t, newEnv)
 		s.createGockONSpace(spaceID, "space1")
 		s.createGockONEnvList(spaceID, env1ID, env2ID)
 		payload2 := newPipelineEnvironmentMapPayload("osio-stage-show2", spaceID, env2ID)
-		_, newEnv2 := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, payload2)
+		_, newEnv2 := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload2)
 		require.NotNil(t, newEnv2)
 
 		s.createGockONSpace(spaceID, "space1")
-		_, env := test.ListPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID)
+		_, env := test.ListPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, nil, nil, nil, nil)
 		assert.NotNil(t, env)
 		assert.Equal(t, 2, len(env.Data))
 	})
 
 	s.T().Run("space_not_found", func(t *testing.T) {
 		spaceID := uuid.NewV4()
-		_, err := test.ListPipelineEnvironmentMapsInternalServerError(t, s.ctx2, s.svc2, s.ctrl2, spaceID)
+		_, err := test.ListPipelineEnvironmentMapsInternalServerError(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, nil, nil, nil, nil)
 		assert.NotNil(t, err)
 	})
 }
 
+func (s *PipelineEnvironmentMapsControllerSuite) TestListSortedSparse() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	for _, name := range []string{"osio-sort-b", "osio-sort-a"} {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		payload := newPipelineEnvironmentMapPayload(name, spaceID, env1ID)
+		test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	}
+
+	s.T().Run("sorted", func(t *testing.T) {
+		sort := "-name"
+		s.createGockONSpace(spaceID, "space1")
+		_, list := test.ListPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, nil, &sort, nil, nil)
+		require.Len(t, list.Data, 2)
+		assert.Equal(t, "osio-sort-b", list.Data[0].Name)
+		assert.Equal(t, "osio-sort-a", list.Data[1].Name)
+	})
+
+	s.T().Run("sparse", func(t *testing.T) {
+		sort := "name"
+		fields := "name"
+		s.createGockONSpace(spaceID, "space1")
+		rw, list := test.ListPipelineEnvironmentMapsOKSparse(t, s.ctx2, s.svc2, s.ctrl2, spaceID, &fields, nil, &sort, nil, nil)
+		require.Len(t, list.Data, 2)
+		require.NotNil(t, list.Data[0].Name)
+		assert.Equal(t, "osio-sort-a", *list.Data[0].Name)
+		assert.NotEqual(t, uuid.Nil, list.Data[0].ID)
+		assert.Nil(t, list.Data[0].SpaceID)
+		assert.Empty(t, list.Data[0].Environments)
+		assert.Nil(t, list.Data[0].Labels)
+		assert.NotEmpty(t, rw.Header().Get("ETag"))
+	})
+
+	s.T().Run("not sortable", func(t *testing.T) {
+		sort := "space"
+		test.ListPipelineEnvironmentMapsBadRequest(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, nil, &sort, nil, nil)
+	})
+
+	s.T().Run("unknown field", func(t *testing.T) {
+		fields := "name,owner"
+		test.ListPipelineEnvironmentMapsBadRequest(t, s.ctx2, s.svc2, s.ctrl2, spaceID, &fields, nil, nil, nil, nil)
+	})
+
+	s.T().Run("links not selectable", func(t *testing.T) {
+		fields := "name,links"
+		test.ListPipelineEnvironmentMapsBadRequest(t, s.ctx2, s.svc2, s.ctrl2, spaceID, &fields, nil, nil, nil, nil)
+	})
+}
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestListLabels() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	ids := map[string]uuid.UUID{}
+	for name, labels := range map[string]map[string]string{
+		"osio-labels-web":   {"team": "payments", "tier": "web"},
+		"osio-labels-batch": {"team": "payments", "tier": "batch"},
+	} {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		payload := newPipelineEnvironmentMapPayload(name, spaceID, env1ID)
+		payload.Data.Labels = labels
+		_, created := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+		require.NotNil(s.T(), created)
+		assert.Equal(s.T(), labels, created.Data.Labels)
+		ids[name] = *created.Data.ID
+	}
+
+	s.T().Run("selected", func(t *testing.T) {
+		selector := "team=payments,tier!=batch"
+		s.createGockONSpace(spaceID, "space1")
+		_, list := test.ListPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, &selector, nil, nil, nil)
+		require.Len(t, list.Data, 1)
+		assert.Equal(t, "osio-labels-web", list.Data[0].Name)
+		assert.Equal(t, map[string]string{"team": "payments", "tier": "web"}, list.Data[0].Labels)
+	})
+
+	s.T().Run("updated", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		payload := updatePipelineEnvironmentMapPayload(newPipelineEnvironmentMapPayload("osio-labels-batch", spaceID, env1ID), env2ID)
+		id := ids["osio-labels-batch"]
+		payload.Data.ID = &id
+		payload.Data.Labels = map[string]string{"team": "billing"}
+		_, updated := test.UpdatePipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, id, payload)
+		assert.Equal(t, map[string]string{"team": "billing"}, updated.Data.Labels)
+
+		selector := "team in (billing)"
+		s.createGockONSpace(spaceID, "space1")
+		_, list := test.ListPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, &selector, nil, nil, nil)
+		require.Len(t, list.Data, 1)
+		assert.Equal(t, "osio-labels-batch", list.Data[0].Name)
+	})
+
+	s.T().Run("invalid selector", func(t *testing.T) {
+		selector := "tier in (web"
+		test.ListPipelineEnvironmentMapsBadRequest(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, &selector, nil, nil, nil)
+	})
+
+	s.T().Run("invalid labels", func(t *testing.T) {
+		payload := newPipelineEnvironmentMapPayload("osio-labels-invalid", spaceID, env1ID)
+		payload.Data.Labels = map[string]string{"team": "pay ments"}
+		test.CreatePipelineEnvironmentMapsBadRequest(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	})
+}
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestDeleteBySpace() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-stage-by-space", spaceID, env1ID)
+	_, newEnv := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), newEnv)
+
+	s.T().Run("unauthorized", func(t *testing.T) {
+		test.DeleteBySpacePipelineEnvironmentMapsUnauthorized(t, s.ctx, s.svc, s.ctrl, spaceID)
+	})
+
+	s.T().Run("forbidden to users", func(t *testing.T) {
+		test.DeleteBySpacePipelineEnvironmentMapsForbidden(t, s.ctx2, s.svc2, s.ctrl2, spaceID)
+		test.ShowPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, nil)
+	})
+
+	s.T().Run("ok", func(t *testing.T) {
+		sa := testauth.NewIdentity()
+		sa.Username = "fabric8-wit"
+		svc, err := testauth.ServiceAsServiceAccountUser("ppl-test-sa", sa)
+		require.NoError(t, err)
+		ctrl := controller.NewPipelineEnvironmentMapsController(svc, s.db, s.svcFactory)
+		test.DeleteBySpacePipelineEnvironmentMapsNoContent(t, svc.Context, svc, ctrl, spaceID)
+		test.ShowPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, nil)
+	})
+}
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestListByEnvironment() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-stage-by-env", spaceID, env1ID)
+	_, newEnv := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), newEnv)
+	// the environment is also referenced in another space
+	otherSpaceID := uuid.NewV4()
+	s.createGockONSpace(otherSpaceID, "space2")
+	s.createGockONEnvList(otherSpaceID, env1ID, uuid.NewV4())
+	payload = newPipelineEnvironmentMapPayload("osio-stage-by-env-other", otherSpaceID, env1ID)
+	_, otherEnv := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, otherSpaceID, nil, payload)
+	require.NotNil(s.T(), otherEnv)
+
+	s.T().Run("ok", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		gock.New("http://witservice").
+			Get("/api/spaces/" + otherSpaceID.String()).
+			Reply(403)
+		_, list := test.ListByEnvironmentPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, env1ID)
+		// the map of the space the caller can't read is not returned
+		require.Len(t, list.Data, 1)
+		assert.Equal(t, *newEnv.Data.ID, *list.Data[0].ID)
+		assert.Equal(t, "osio-stage-by-env", list.Data[0].Name)
+	})
+
+	s.T().Run("service account", func(t *testing.T) {
+		sa := testauth.NewIdentity()
+		sa.Username = "fabric8-env"
+		svc, err := testauth.ServiceAsServiceAccountUser("ppl-test-sa", sa)
+		require.NoError(t, err)
+		ctrl := controller.NewPipelineEnvironmentMapsController(svc, s.db, s.svcFactory)
+		ctrl.ServiceAccounts = serviceaccount.NewAuthorizer([]string{"fabric8-env"}, nil)
+		_, list := test.ListByEnvironmentPipelineEnvironmentMapsOK(t, svc.Context, svc, ctrl, env1ID)
+		assert.Len(t, list.Data, 2)
+	})
+
+	s.T().Run("not referenced", func(t *testing.T) {
+		_, list := test.ListByEnvironmentPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, env2ID)
+		assert.Empty(t, list.Data)
+	})
+}
+
 func (s *PipelineEnvironmentMapsControllerSuite) TestUpdate() {
 	s.T().Run("ok", func(t *testing.T) {
 		spaceID := uuid.NewV4()
@@ -336,7 +712,7 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestUpdate() {
 		s.createGockONSpace(spaceID, "space1")
 		s.createGockONEnvList(spaceID, env1ID, env2ID)
 		payload := newPipelineEnvironmentMapPayload("osio-stage-update", spaceID, env1ID)
-		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, payload)
+		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
 		require.NotNil(t, newEnv)
 		s.createGockONSpace(spaceID, "space1")
 		s.createGockONEnvList(spaceID, env1ID, env2ID)
@@ -353,7 +729,7 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestUpdate() {
 		s.createGockONSpace(space1ID, "space1")
 		s.createGockONEnvList(space1ID, env1ID, env2ID)
 		payload := newPipelineEnvironmentMapPayload("osio-stage", space1ID, env2ID)
-		_, env := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, space1ID, payload)
+		_, env := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, space1ID, nil, payload)
 		assert.NotNil(t, env)
 		s.createGockONSpace(space1ID, "space1")
 		s.createGockONEnvList(space1ID, env1ID, env2ID)
@@ -363,6 +739,136 @@ func (s *PipelineEnvironmentMapsControllerSuite) TestUpdate() {
 	})
 }
 
+func (s *PipelineEnvironmentMapsControllerSuite) TestDelete() {
+	s.T().Run("ok", func(t *testing.T) {
+		spaceID := uuid.NewV4()
+		env1ID := uuid.NewV4()
+		env2ID := uuid.NewV4()
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		payload := newPipelineEnvironmentMapPayload("osio-stage-delete", spaceID, env1ID)
+		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+		require.NotNil(t, newEnv)
+
+		s.createGockONSpace(spaceID, "space1")
+		test.DeletePipelineEnvironmentMapsNoContent(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID)
+		test.ShowPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, nil)
+	})
+
+	s.T().Run("not_found", func(t *testing.T) {
+		_, err := test.DeletePipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, uuid.NewV4())
+		assert.NotNil(t, err)
+	})
+
+	s.T().Run("space forbidden", func(t *testing.T) {
+		spaceID := uuid.NewV4()
+		env1ID := uuid.NewV4()
+		env2ID := uuid.NewV4()
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		payload := newPipelineEnvironmentMapPayload("osio-stage-delete-forbidden", spaceID, env1ID)
+		_, newEnv := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+		require.NotNil(t, newEnv)
+
+		// the caller has no access to the space in WIT
+		gock.New("http://witservice").
+			Get("/api/spaces/" + spaceID.String()).
+			Reply(403).
+			JSON(`{"errors":[{"status":"403","code":"forbidden_error","detail":"access denied"}]}`)
+		test.DeletePipelineEnvironmentMapsForbidden(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID)
+		test.ShowPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, nil)
+	})
+
+	s.T().Run("unauthorized", func(t *testing.T) {
+		_, err := test.DeletePipelineEnvironmentMapsUnauthorized(t, s.ctx, s.svc, s.ctrl, uuid.NewV4())
+		assert.NotNil(t, err)
+	})
+}
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestTrash() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-stage-trash", spaceID, env1ID)
+	_, newEnv := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), newEnv)
+	s.createGockONSpace(spaceID, "space1")
+	test.DeletePipelineEnvironmentMapsNoContent(s.T(), s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID)
+
+	s.T().Run("list", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		_, list := test.ListTrashPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID)
+		require.Len(t, list.Data, 1)
+		assert.Equal(t, 1, list.Meta.TotalCount)
+		assert.Equal(t, *newEnv.Data.ID, list.Data[0].ID)
+		assert.Equal(t, env1ID, *list.Data[0].Environments[0].EnvUUID)
+		assert.Equal(t, s.ctrl2.TrashRetention, list.Data[0].PurgeAt.Sub(list.Data[0].DeletedAt))
+	})
+
+	s.T().Run("restore name taken", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		_, other := test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+		s.createGockONSpace(spaceID, "space1")
+		test.RestorePipelineEnvironmentMapsConflict(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID)
+		s.createGockONSpace(spaceID, "space1")
+		test.DeletePipelineEnvironmentMapsNoContent(t, s.ctx2, s.svc2, s.ctrl2, *other.Data.ID)
+	})
+
+	s.T().Run("restore space forbidden", func(t *testing.T) {
+		// the caller has no access to the space in WIT
+		gock.New("http://witservice").
+			Get("/api/spaces/" + spaceID.String()).
+			Reply(403).
+			JSON(`{"errors":[{"status":"403","code":"forbidden_error","detail":"access denied"}]}`)
+		test.RestorePipelineEnvironmentMapsForbidden(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID)
+		test.ShowPipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, nil)
+	})
+
+	s.T().Run("restore", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		_, restored := test.RestorePipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID)
+		assert.Equal(t, "osio-stage-trash", restored.Data.Name)
+		require.Len(t, restored.Data.Environments, 1)
+		test.ShowPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID, nil, nil)
+
+		// the restore is recorded as a revision
+		_, revs := test.ListRevisionsPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID)
+		require.Len(t, revs.Data, 2)
+		assert.Equal(t, 2, revs.Data[1].Revision)
+		assert.Equal(t, env1ID, *revs.Data[1].Environments[0].EnvUUID)
+		assert.NotNil(t, revs.Data[1].AuthorID)
+	})
+
+	s.T().Run("restore not deleted", func(t *testing.T) {
+		test.RestorePipelineEnvironmentMapsNotFound(t, s.ctx2, s.svc2, s.ctrl2, *newEnv.Data.ID)
+	})
+
+	s.T().Run("restore unauthorized", func(t *testing.T) {
+		test.RestorePipelineEnvironmentMapsUnauthorized(t, s.ctx, s.svc, s.ctrl, *newEnv.Data.ID)
+	})
+}
+
+// waitForLock waits until a query on the idempotency keys waits for a lock
+func waitForLock(t *testing.T, db *gorm.DB) {
+	deadline := time.After(10 * time.Second)
+	for {
+		var waiting int
+		err := db.Raw("SELECT count(*) FROM pg_stat_activity WHERE wait_event_type = 'Lock' AND datname = current_database() AND query LIKE '%idempotency_keys%'").Row().Scan(&waiting)
+		require.NoError(t, err)
+		if waiting > 0 {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatal("no query on the idempotency keys is waiting for a lock")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
 func newPipelineEnvironmentMapPayload(name string, spaceID uuid.UUID, envUUID uuid.UUID) *app.CreatePipelineEnvironmentMapsPayload {
 	payload := &app.CreatePipelineEnvironmentMapsPayload{
 		Data: &app.PipelineEnvironmentMaps{
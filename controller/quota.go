@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/quota"
+	"github.com/fabric8-services/fabric8-common/errors"
+	errs "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// spaceLimits returns the limits of the given space, along with its
+// override if any
+func spaceLimits(ctx context.Context, appl application.Application, defaults quota.Limits, spaceID uuid.UUID) (quota.Limits, *quota.Override, error) {
+	o, err := appl.SpaceQuotas().Load(ctx, spaceID)
+	if err != nil {
+		if ok, _ := errors.IsNotFoundError(err); ok {
+			return defaults, nil, nil
+		}
+		return defaults, nil, err
+	}
+	return defaults.Apply(o), o, nil
+}
+
+// checkQuotas returns a quota.ExceededError if a map with the given number
+// of environments exceeds the limits of the space, adding a map to the space
+// if newMap. The checks of the maps of a space are serialized until the end
+// of the transaction.
+func checkQuotas(ctx context.Context, appl application.Application, defaults quota.Limits, spaceID uuid.UUID, newMap bool, envs int) error {
+	if newMap {
+		if err := appl.SpaceQuotas().Lock(ctx, spaceID); err != nil {
+			return err
+		}
+	}
+	limits, _, err := spaceLimits(ctx, appl, defaults, spaceID)
+	if err != nil {
+		return err
+	}
+	if newMap {
+		maps, err := appl.PipelineEnvMap().CountBySpace(ctx, spaceID)
+		if err != nil {
+			return err
+		}
+		if err := limits.CheckMaps(maps); err != nil {
+			return err
+		}
+	}
+	return limits.CheckEnvironments(envs)
+}
+
+// quotaExceeded returns the quota error causing the given error, if any
+func quotaExceeded(err error) (*quota.ExceededError, bool) {
+	e, ok := errs.Cause(err).(*quota.ExceededError)
+	return e, ok
+}
+
+// quotaErrors converts the given quota error to its JSONAPI error, with the
+// limit and the current usage in meta
+func quotaErrors(e *quota.ExceededError, status int) *app.JSONAPIErrors {
+	id := uuid.NewV4().String()
+	statusText := strconv.Itoa(status)
+	code := "quota_exceeded_error"
+	title := "Quota exceeded"
+	return &app.JSONAPIErrors{
+		Errors: []*app.JSONAPIError{{
+			ID:     &id,
+			Status: &statusText,
+			Code:   &code,
+			Title:  &title,
+			Detail: e.Error(),
+			Meta: map[string]interface{}{
+				"quota": e.Quota,
+				"limit": e.Limit,
+				"usage": e.Usage,
+			},
+		}},
+	}
+}
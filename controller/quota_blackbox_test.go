@@ -0,0 +1,89 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-build/app/test"
+	"github.com/fabric8-services/fabric8-build/quota"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *PipelineEnvironmentMapsControllerSuite) TestQuotas() {
+	spaceID := uuid.NewV4()
+	env1ID := uuid.NewV4()
+	env2ID := uuid.NewV4()
+	maxMaps, maxEnvs := 1, 1
+	_, err := s.db.SpaceQuotas().Save(context.Background(), &quota.Override{
+		SpaceID:               spaceID,
+		MaxMaps:               &maxMaps,
+		MaxEnvironmentsPerMap: &maxEnvs,
+	})
+	require.NoError(s.T(), err)
+	twoEnvs := func(payload *app.PipelineEnvironmentMaps) {
+		payload.Environments = append(payload.Environments, &app.EnvironmentAttributes{EnvUUID: &env2ID})
+	}
+
+	s.T().Run("too many environments", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		payload := newPipelineEnvironmentMapPayload("osio-quota-envs", spaceID, env1ID)
+		twoEnvs(payload.Data)
+		_, jerrs := test.CreatePipelineEnvironmentMapsUnprocessableEntity(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+		require.Len(t, jerrs.Errors, 1)
+		assert.Equal(t, quota.EnvironmentsPerMap, jerrs.Errors[0].Meta["quota"])
+		assert.EqualValues(t, 1, jerrs.Errors[0].Meta["limit"])
+		assert.EqualValues(t, 2, jerrs.Errors[0].Meta["usage"])
+	})
+
+	s.createGockONSpace(spaceID, "space1")
+	s.createGockONEnvList(spaceID, env1ID, env2ID)
+	payload := newPipelineEnvironmentMapPayload("osio-quota-1", spaceID, env1ID)
+	_, created := test.CreatePipelineEnvironmentMapsCreated(s.T(), s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	require.NotNil(s.T(), created)
+
+	s.T().Run("too many maps", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		payload := newPipelineEnvironmentMapPayload("osio-quota-2", spaceID, env1ID)
+		_, jerrs := test.CreatePipelineEnvironmentMapsForbidden(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+		require.Len(t, jerrs.Errors, 1)
+		assert.Equal(t, quota.Maps, jerrs.Errors[0].Meta["quota"])
+		assert.EqualValues(t, 1, jerrs.Errors[0].Meta["limit"])
+		assert.EqualValues(t, 1, jerrs.Errors[0].Meta["usage"])
+	})
+
+	s.T().Run("update with too many environments", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		update := updatePipelineEnvironmentMapPayload(payload, env1ID)
+		update.Data.ID = created.Data.ID
+		twoEnvs(update.Data)
+		test.UpdatePipelineEnvironmentMapsUnprocessableEntity(t, s.ctx2, s.svc2, s.ctrl2, *created.Data.ID, update)
+	})
+
+	s.T().Run("deleted maps not counted", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		test.DeletePipelineEnvironmentMapsNoContent(t, s.ctx2, s.svc2, s.ctrl2, *created.Data.ID)
+		s.createGockONSpace(spaceID, "space1")
+		s.createGockONEnvList(spaceID, env1ID, env2ID)
+		payload := newPipelineEnvironmentMapPayload("osio-quota-2", spaceID, env1ID)
+		test.CreatePipelineEnvironmentMapsCreated(t, s.ctx2, s.svc2, s.ctrl2, spaceID, nil, payload)
+	})
+
+	s.T().Run("restore with too many maps", func(t *testing.T) {
+		s.createGockONSpace(spaceID, "space1")
+		_, jerrs := test.RestorePipelineEnvironmentMapsForbidden(t, s.ctx2, s.svc2, s.ctrl2, *created.Data.ID)
+		require.Len(t, jerrs.Errors, 1)
+		assert.Equal(t, quota.Maps, jerrs.Errors[0].Meta["quota"])
+		assert.EqualValues(t, 1, jerrs.Errors[0].Meta["limit"])
+		assert.EqualValues(t, 1, jerrs.Errors[0].Meta["usage"])
+		// the map stays in the trash
+		_, list := test.ListTrashPipelineEnvironmentMapsOK(t, s.ctx2, s.svc2, s.ctrl2, spaceID)
+		require.Len(t, list.Data, 1)
+		assert.Equal(t, *created.Data.ID, list.Data[0].ID)
+	})
+}
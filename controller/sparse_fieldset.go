@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"strings"
+
+	"github.com/fabric8-services/fabric8-build/app"
+	"github.com/fabric8-services/fabric8-common/errors"
+)
+
+// pipelineEnvMapFields are the attributes of the pipeline environment maps
+// which can be selected with a sparse fieldset, the id is always returned
+var pipelineEnvMapFields = map[string]struct{}{
+	"name":         {},
+	"spaceID":      {},
+	"environments": {},
+	"labels":       {},
+}
+
+// parseFieldset returns the attributes selected by the given sparse fieldset
+// parameter, nil if none was given
+func parseFieldset(param string, value *string, allowed map[string]struct{}) (map[string]struct{}, error) {
+	if value == nil || *value == "" {
+		return nil, nil
+	}
+	fields := map[string]struct{}{}
+	for _, field := range strings.Split(*value, ",") {
+		if _, ok := allowed[field]; !ok {
+			return nil, errors.NewBadParameterError(param, field).Expected("one of name, spaceID, environments or labels")
+		}
+		fields[field] = struct{}{}
+	}
+	return fields, nil
+}
+
+// sparsePipelineEnvMap returns the given map with only the selected
+// attributes
+func sparsePipelineEnvMap(m *app.PipelineEnvironmentMaps, fields map[string]struct{}) *app.SparsePipelineEnvironmentMaps {
+	res := &app.SparsePipelineEnvironmentMaps{ID: *m.ID}
+	if _, ok := fields["name"]; ok {
+		res.Name = &m.Name
+	}
+	if _, ok := fields["spaceID"]; ok {
+		res.SpaceID = m.SpaceID
+	}
+	if _, ok := fields["environments"]; ok {
+		res.Environments = m.Environments
+	}
+	if _, ok := fields["labels"]; ok {
+		res.Labels = m.Labels
+	}
+	return res
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/fabric8-services/fabric8-build/app"
@@ -19,6 +20,7 @@ var (
 // StatusController implements the status resource.
 type StatusController struct {
 	*goa.Controller
+	shuttingDown int32
 }
 
 // NewStatusController creates a status controller.
@@ -33,5 +35,16 @@ func (c *StatusController) Show(ctx *app.ShowStatusContext) error {
 	res.BuildTime = BuildTime
 	res.StartTime = StartTime
 
+	if atomic.LoadInt32(&c.shuttingDown) == 1 {
+		msg := "service is shutting down"
+		res.Error = &msg
+		return ctx.ServiceUnavailable(res)
+	}
 	return ctx.OK(res)
 }
+
+// ShuttingDown makes the status report the service as unavailable so that
+// no new traffic is routed to it.
+func (c *StatusController) ShuttingDown() {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+}
@@ -22,3 +22,15 @@ func TestShowStatus(t *testing.T) {
 	_, err := time.Parse("2006-01-02T15:04:05Z", res.StartTime)
 	assert.Nil(t, err, "Incorrect layout of StartTime")
 }
+
+func TestShowStatusShuttingDown(t *testing.T) {
+	var (
+		service = goa.New("status-test")
+		ctrl    = NewStatusController(service)
+	)
+	ctrl.ShuttingDown()
+	_, res := test.ShowStatusServiceUnavailable(t, context.Background(), service, ctrl)
+
+	assert.Equal(t, "0", res.Commit, "Commit not found")
+	assert.NotNil(t, res.Error)
+}
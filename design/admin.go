@@ -0,0 +1,183 @@
+package design
+
+import (
+	d "github.com/goadesign/goa/design"
+	a "github.com/goadesign/goa/design/apidsl"
+)
+
+var adminStats = a.Type("AdminStats", func() {
+	a.Description(`Counts of the stored data.`)
+	a.Attribute("pipelineEnvironmentMaps", d.Integer, "Number of pipeline environment maps")
+	a.Attribute("spaces", d.Integer, "Number of spaces having pipeline environment maps")
+	a.Attribute("environments", d.Integer, "Number of environments referenced by the maps")
+	a.Attribute("missingSpaces", d.Integer, "Number of spaces not found in WIT, waiting for the grace period")
+	a.Required("pipelineEnvironmentMaps", "spaces", "environments", "missingSpaces")
+})
+
+var adminCleanup = a.Type("AdminCleanup", func() {
+	a.Description(`Result of a cleanup.`)
+	a.Attribute("purgedSpaces", a.ArrayOf(d.UUID), "IDs of the deleted spaces whose maps were deleted")
+	a.Attribute("purgedIdempotencyKeys", d.Integer, "Number of expired idempotency keys deleted")
+	a.Required("purgedSpaces", "purgedIdempotencyKeys")
+})
+
+var danglingEnvironment = a.Type("DanglingEnvironment", func() {
+	a.Description(`Environment referenced by a pipeline environment map but unknown to the env service.`)
+	a.Attribute("pipelineEnvironmentMapID", d.UUID, "ID of the pipeline environment map")
+	a.Attribute("spaceID", d.UUID, "ID of the space of the map")
+	a.Attribute("envUUID", d.UUID, "ID of the missing environment")
+	a.Required("pipelineEnvironmentMapID", "spaceID", "envUUID")
+})
+
+var adminReconciliation = a.Type("AdminReconciliation", func() {
+	a.Description(`Result of a reconciliation with the env service.`)
+	a.Attribute("dryRun", d.Boolean, "True if the dangling environments were only reported")
+	a.Attribute("danglingEnvironments", a.ArrayOf(danglingEnvironment), "The dangling environments found")
+	a.Attribute("failedSpaces", a.ArrayOf(d.UUID), "IDs of the spaces whose environments could not be listed")
+	a.Required("dryRun", "danglingEnvironments", "failedSpaces")
+})
+
+var spaceQuotaOverride = a.Type("SpaceQuotaOverride", func() {
+	a.Description(`Limits of a space replacing the configured defaults, the omitted ones keeping the default.`)
+	a.Attribute("maxMaps", d.Integer, "Maximum number of pipeline environment maps of the space, 0 meaning unlimited", func() {
+		a.Minimum(0)
+		a.Example(200)
+	})
+	a.Attribute("maxEnvironmentsPerMap", d.Integer, "Maximum number of environments of a map, 0 meaning unlimited", func() {
+		a.Minimum(0)
+		a.Example(30)
+	})
+})
+
+var spaceQuota = a.Type("SpaceQuota", func() {
+	a.Description(`Quotas of a space and their usage.`)
+	a.Attribute("spaceID", d.UUID, "ID of the space")
+	a.Attribute("maxMaps", d.Integer, "Maximum number of pipeline environment maps of the space, 0 meaning unlimited")
+	a.Attribute("maxEnvironmentsPerMap", d.Integer, "Maximum number of environments of a map, 0 meaning unlimited")
+	a.Attribute("maps", d.Integer, "Number of pipeline environment maps of the space")
+	a.Attribute("override", spaceQuotaOverride, "The limits overridden for the space, if any")
+	a.Required("spaceID", "maxMaps", "maxEnvironmentsPerMap", "maps")
+})
+
+var adminStatsSingle = JSONSingle(
+	"AdminStats", "Holds the counts of the stored data",
+	adminStats,
+	nil)
+
+var adminCleanupSingle = JSONSingle(
+	"AdminCleanup", "Holds the result of a cleanup",
+	adminCleanup,
+	nil)
+
+var adminReconciliationSingle = JSONSingle(
+	"AdminReconciliation", "Holds the result of a reconciliation",
+	adminReconciliation,
+	nil)
+
+var spaceQuotaOverrideSingle = JSONSingle(
+	"SpaceQuotaOverride", "Holds the limits overridden for a space",
+	spaceQuotaOverride,
+	nil)
+
+var spaceQuotaSingle = JSONSingle(
+	"SpaceQuota", "Holds the quotas of a space",
+	spaceQuota,
+	nil)
+
+// The admin endpoints are internal, restricted to the configured service
+// accounts
+var _ = a.Resource("admin", func() {
+	a.BasePath("/admin")
+
+	a.Action("stats", func() {
+		a.Description("Count the stored pipeline environment maps, spaces and environments.")
+		a.Routing(
+			a.GET("/stats"),
+		)
+		a.Response(d.OK, adminStatsSingle)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+	})
+
+	a.Action("cleanup", func() {
+		a.Description(`Delete now the pipeline environment maps of the spaces deleted in WIT for the whole
+grace period, and the expired idempotency keys.`)
+		a.Routing(
+			a.POST("/cleanup"),
+		)
+		a.Response(d.OK, adminCleanupSingle)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+	})
+
+	a.Action("reconcile", func() {
+		a.Description(`Check the environments referenced by the pipeline environment maps against the env service,
+and remove the dangling ones unless dryRun. The environments of a space are not removed if the pipeline
+definition of one of its maps has a stage targeting one of them: the space is reported as failed, or rejected
+(422) when it is the only one reconciled.`)
+		a.Params(func() {
+			a.Param("spaceID", d.UUID, "Only reconcile the maps of the given space")
+			a.Param("dryRun", d.Boolean, "Only report the dangling environments", func() {
+				a.Default(true)
+			})
+		})
+		a.Routing(
+			a.POST("/reconciliation"),
+		)
+		a.Response(d.OK, adminReconciliationSingle)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.UnprocessableEntity, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+	})
+
+	a.Action("showQuota", func() {
+		a.Description("Show the quotas of the given space and their usage.")
+		a.Params(func() {
+			a.Param("spaceID", d.UUID, "ID of the space")
+		})
+		a.Routing(
+			a.GET("/quotas/:spaceID"),
+		)
+		a.Response(d.OK, spaceQuotaSingle)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+	})
+
+	a.Action("updateQuota", func() {
+		a.Description(`Override the quotas of the given space, the existing maps exceeding the new limits being
+kept.`)
+		a.Params(func() {
+			a.Param("spaceID", d.UUID, "ID of the space")
+		})
+		a.Routing(
+			a.PUT("/quotas/:spaceID"),
+		)
+		a.Payload(spaceQuotaOverrideSingle)
+		a.Response(d.OK, spaceQuotaSingle)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+	})
+
+	a.Action("deleteQuota", func() {
+		a.Description("Reset the quotas of the given space to the configured defaults.")
+		a.Params(func() {
+			a.Param("spaceID", d.UUID, "ID of the space")
+		})
+		a.Routing(
+			a.DELETE("/quotas/:spaceID"),
+		)
+		a.Response(d.NoContent)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+	})
+})
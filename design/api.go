@@ -19,7 +19,8 @@ var _ = a.API("fabric8-build", func() {
 	})
 	a.Origin("/[.*openshift.io|localhost]/", func() {
 		a.Methods("GET", "POST", "PUT", "PATCH", "DELETE")
-		a.Headers("X-Request-Id", "Content-Type", "Authorization", "If-None-Match", "If-Modified-Since")
+		a.Headers("X-Request-Id", "Content-Type", "Authorization", "If-None-Match", "If-Modified-Since", "Last-Event-ID", "Idempotency-Key")
+		a.Expose("ETag", "Last-Modified", "Cache-Control", "Idempotent-Replayed")
 		a.MaxAge(600)
 		a.Credentials()
 	})
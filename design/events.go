@@ -0,0 +1,35 @@
+package design
+
+import (
+	d "github.com/goadesign/goa/design"
+	a "github.com/goadesign/goa/design/apidsl"
+)
+
+var _ = a.Resource("events", func() {
+	a.Action("stream", func() {
+		a.Description(`Stream the changes of the pipeline environment maps of the given space as server-sent events,
+the data of each event being a PipelineEnvironmentMapChange and its ID the cursor of the change. The stream
+starts after the change of the Last-Event-ID header, or with the next change without it. A Last-Event-ID
+before the purged changes is rejected (410), the client must then list the maps again. A comment is sent
+on idle streams, and the stream is closed when the token expires. The number of concurrent streams of each
+identity is limited (429). The clients must accept text/event-stream.`)
+		a.Params(func() {
+			a.Param("spaceID", d.UUID, "Space ID of the pipeline environment maps")
+		})
+		a.Headers(func() {
+			a.Header("Last-Event-ID", d.String, "ID of the last event received, to resume the stream after it")
+		})
+		a.Routing(
+			a.GET("/spaces/:spaceID/events"),
+		)
+		a.Response(d.OK, func() {
+			a.Media("text/event-stream")
+		})
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Gone, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+	})
+})
@@ -24,6 +24,10 @@ var pipelineEnvMap = a.Type("PipelineEnvironmentMaps", func() {
 		a.Example("myapp-stage")
 	})
 	a.Attribute("environments", a.ArrayOf(envAttrs), "An array of environments")
+	a.Attribute("labels", a.HashOf(d.String, d.String), `Key/value labels organizing the maps, validated like the
+Kubernetes labels. Omitted on update to keep the current labels.`, func() {
+		a.Example(map[string]string{"team": "payments", "tier": "web"})
+	})
 	a.Attribute("links", genericLinks)
 	a.Required("name", "environments")
 })
@@ -44,12 +48,153 @@ var pipelineEnvMapList = JSONList(
 	pagingLinks,
 	pipelineEnvMapListMeta)
 
+var pipelineEnvMapSparse = a.Type("SparsePipelineEnvironmentMaps", func() {
+	a.Reference(pipelineEnvMap)
+	a.Description(`Pipeline environment map with only the attributes selected by a sparse fieldset, the id
+being always returned.`)
+	a.Attribute("id")
+	a.Attribute("spaceID")
+	a.Attribute("name")
+	a.Attribute("environments")
+	a.Attribute("labels")
+	a.Required("id")
+})
+
+var pipelineEnvMapSparseList = JSONList(
+	"SparsePipelineEnvironmentMaps", "Holds the list of pipeline environment maps restricted to a sparse fieldset",
+	pipelineEnvMapSparse,
+	pagingLinks,
+	pipelineEnvMapListMeta)
+
+var pipelineEnvMapRevision = a.Type("PipelineEnvironmentMapRevision", func() {
+	a.Description(`Immutable state of a pipeline environment map, recorded on each change.`)
+	a.Attribute("revision", d.Integer, "Number of the revision, starting at 1", func() {
+		a.Example(3)
+	})
+	a.Attribute("name", d.String, "The pipeline environment map name", func() {
+		a.Example("myapp-stage")
+	})
+	a.Attribute("environments", a.ArrayOf(envAttrs), "The environments, in their order")
+	a.Attribute("authorID", d.UUID, "ID of the identity who made the change", func() {
+		a.Example("40bbdd3d-8b5d-4fd6-ac90-7236b669af04")
+	})
+	a.Attribute("restoredFrom", d.Integer, "Number of the revision restored by this one, if any", func() {
+		a.Example(1)
+	})
+	a.Attribute("createdAt", d.DateTime, "When the change was made")
+	a.Required("revision", "name", "environments", "createdAt")
+})
+
+var pipelineEnvMapRevisionDiff = a.Type("PipelineEnvironmentMapRevisionDiff", func() {
+	a.Description(`Differences between two revisions of a pipeline environment map.`)
+	a.Attribute("from", d.Integer, "Number of the older revision")
+	a.Attribute("to", d.Integer, "Number of the newer revision")
+	a.Attribute("nameFrom", d.String, "Name of the map in the older revision")
+	a.Attribute("nameTo", d.String, "Name of the map in the newer revision")
+	a.Attribute("addedEnvironments", a.ArrayOf(envAttrs), "The environments only in the newer revision")
+	a.Attribute("removedEnvironments", a.ArrayOf(envAttrs), "The environments only in the older revision")
+	a.Attribute("orderChanged", d.Boolean, "True if the environments of both revisions are in another order")
+	a.Required("from", "to", "nameFrom", "nameTo", "addedEnvironments", "removedEnvironments", "orderChanged")
+})
+
+var pipelineEnvMapRevisionList = JSONList(
+	"PipelineEnvironmentMapRevisions", "Holds the revisions of a pipeline environment map",
+	pipelineEnvMapRevision,
+	nil,
+	pipelineEnvMapListMeta)
+
+var pipelineEnvMapRevisionDiffSingle = JSONSingle(
+	"PipelineEnvironmentMapRevisionDiff", "Holds the differences between two revisions",
+	pipelineEnvMapRevisionDiff,
+	nil)
+
+var deletedPipelineEnvMap = a.Type("DeletedPipelineEnvironmentMap", func() {
+	a.Description(`Pipeline environment map in the trash, which can be restored until purged.`)
+	a.Attribute("id", d.UUID, "ID of the pipeline environment map", func() {
+		a.Example("40bbdd3d-8b5d-4fd6-ac90-7236b669af04")
+	})
+	a.Attribute("spaceID", d.UUID, "ID of the space of the pipeline environment map", func() {
+		a.Example("40bbdd3d-8b5d-4fd6-ac90-7236b669af04")
+	})
+	a.Attribute("name", d.String, "The pipeline environment map name", func() {
+		a.Example("myapp-stage")
+	})
+	a.Attribute("environments", a.ArrayOf(envAttrs), "The environments the map had when deleted")
+	a.Attribute("deletedAt", d.DateTime, "When the map was deleted")
+	a.Attribute("purgeAt", d.DateTime, "When the map will be permanently deleted")
+	a.Required("id", "name", "environments", "deletedAt", "purgeAt")
+})
+
+var deletedPipelineEnvMapList = JSONList(
+	"DeletedPipelineEnvironmentMaps", "Holds the list of the pipeline environment maps in the trash",
+	deletedPipelineEnvMap,
+	nil,
+	pipelineEnvMapListMeta)
+
+var pipelineEnvMapChange = a.Type("PipelineEnvironmentMapChange", func() {
+	a.Description(`Create, update or delete of a pipeline environment map. The deletes are tombstones, without
+the state of the map.`)
+	a.Attribute("cursor", d.String, "Opaque cursor to read the changes following this one")
+	a.Attribute("operation", d.String, "The change", func() {
+		a.Enum("create", "update", "delete")
+	})
+	a.Attribute("id", d.UUID, "ID of the pipeline environment map", func() {
+		a.Example("40bbdd3d-8b5d-4fd6-ac90-7236b669af04")
+	})
+	a.Attribute("name", d.String, "The name of the map after the change", func() {
+		a.Example("myapp-stage")
+	})
+	a.Attribute("environments", a.ArrayOf(envAttrs), "The environments of the map after the change, in their order")
+	a.Attribute("labels", a.HashOf(d.String, d.String), "The labels of the map after the change")
+	a.Attribute("changedAt", d.DateTime, "When the change was made")
+	a.Required("cursor", "operation", "id", "changedAt")
+})
+
+var pipelineEnvMapChangesMeta = a.Type("PipelineEnvironmentMapChangesMeta", func() {
+	a.Attribute("cursor", d.String, "Opaque cursor to read the next changes, the given one if there is none")
+	a.Attribute("hasMore", d.Boolean, "True if more changes follow the returned ones")
+	a.Required("cursor", "hasMore")
+})
+
+var pipelineEnvMapChangeList = JSONList(
+	"PipelineEnvironmentMapChanges", "Holds the changes of the pipeline environment maps of a space",
+	pipelineEnvMapChange,
+	nil,
+	pipelineEnvMapChangesMeta)
+
+var pipelineDefinition = a.Type("PipelineDefinition", func() {
+	a.Description(`Version of the pipeline definition of a pipeline environment map: a YAML document with the
+version of its schema, the builder image and the stages, each one running its steps and possibly targeting
+one of the environments of the map.`)
+	a.Attribute("version", d.Integer, "Number of the version of the definition, starting at 1")
+	a.Attribute("document", d.String, "The YAML document", func() {
+		a.MaxLength(65536)
+		a.Example("version: 1\nbuilder:\n  image: fabric8/maven-builder\nstages:\n- name: build\n  steps:\n  - name: package\n    run: mvn -B package\n")
+	})
+	a.Attribute("authorID", d.UUID, "ID of the identity which saved the version")
+	a.Attribute("createdAt", d.DateTime, "When the version was saved")
+	a.Required("document")
+})
+
+var pipelineDefinitionSingle = JSONSingle(
+	"PipelineDefinition", "Holds a version of the pipeline definition of a pipeline environment map",
+	pipelineDefinition,
+	nil)
+
 var _ = a.Resource("PipelineEnvironmentMaps", func() {
 	a.Action("create", func() {
-		a.Description("Create pipeline environment map")
+		a.Description(`Create pipeline environment map. Exceeding the quota of maps of the space is forbidden (403),
+exceeding the quota of environments per map is unprocessable (422).`)
 		a.Params(func() {
 			a.Param("spaceID", d.UUID, "Space ID for the pipeline environment map")
 		})
+		a.Headers(func() {
+			a.Header("Idempotency-Key", d.String, `Key identifying the request, the retries sent with
+the same key and payload get the response of the original request instead of creating another map.`, func() {
+				a.MinLength(1)
+				a.MaxLength(255)
+			})
+		})
 		a.Routing(
 			a.POST("/spaces/:spaceID/pipeline-environment-maps"),
 		)
@@ -60,23 +205,125 @@ var _ = a.Resource("PipelineEnvironmentMaps", func() {
 		a.Response(d.Unauthorized, JSONAPIErrors)
 		a.Response(d.MethodNotAllowed, JSONAPIErrors)
 		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
 		a.Response(d.Conflict, JSONAPIErrors)
 		a.Response(d.NotFound, JSONAPIErrors)
+		a.Response(d.UnprocessableEntity, JSONAPIErrors)
 	})
 
 	a.Action("list", func() {
 		a.Description("Retrieve list of pipeline environment maps (as JSONAPI) for the given space ID.")
 		a.Params(func() {
 			a.Param("spaceID", d.UUID, "Space ID for the pipeline environment map")
+			a.Param("fields[pipelineenvironmentmaps]", d.String, `Comma separated attributes to return (sparse fieldset),
+among name, spaceID, environments and labels. The id is always returned.`, func() {
+				a.Pattern(`^[a-zA-Z]+(,[a-zA-Z]+)*$`)
+				a.Example("name,spaceID")
+			})
+			a.Param("labelSelector", d.String, `Kubernetes label selector the maps must match: comma separated
+requirements among key=value, key!=value, key in (v1,v2), key notin (v1,v2), key and !key.`, func() {
+				a.Example("team=payments,tier!=batch")
+			})
+			a.Param("sort", d.String, `Comma separated sort keys among name, created-at and updated-at,
+prefixed with "-" for a descending order.`, func() {
+				a.Pattern(`^-?[a-z-]+(,-?[a-z-]+)*$`)
+				a.Example("name,-updated-at")
+			})
 		})
+		a.UseTrait("conditional")
 		a.Routing(
 			a.GET("/spaces/:spaceID/pipeline-environment-maps"),
 		)
 		a.Response(d.OK, pipelineEnvMapList)
+		a.Response("OKSparse", func() {
+			a.Description("The maps restricted to the sparse fieldset")
+			a.Status(200)
+			a.Media(pipelineEnvMapSparseList)
+			a.Headers(func() {
+				a.Header("Last-Modified", d.DateTime)
+				a.Header("ETag")
+				a.Header("Cache-Control")
+			})
+		})
+		a.Response(d.NotModified)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+	})
+
+	a.Action("listTrash", func() {
+		a.Description("Retrieve the deleted pipeline environment maps of the given space ID which can still be restored.")
+		a.Params(func() {
+			a.Param("spaceID", d.UUID, "Space ID for the pipeline environment map")
+		})
+		a.Routing(
+			a.GET("/spaces/:spaceID/pipeline-environment-maps/trash"),
+		)
+		a.Response(d.OK, deletedPipelineEnvMapList)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+	})
+
+	a.Action("listChanges", func() {
+		a.Description(`Retrieve the changes of the pipeline environment maps of the given space ID in their order,
+following the given cursor or from the oldest change kept. The cursor in meta resumes the reading. The changes
+are purged after their retention, a cursor before the purged changes is rejected (410) and the client must
+list the maps again.`)
+		a.Params(func() {
+			a.Param("spaceID", d.UUID, "Space ID for the pipeline environment map")
+			a.Param("since", d.String, "Cursor returned by a previous request, the changes following it are returned")
+			a.Param("limit", d.Integer, "Maximum number of changes to return", func() {
+				a.Minimum(1)
+				a.Maximum(1000)
+				a.Default(100)
+			})
+		})
+		a.Routing(
+			a.GET("/spaces/:spaceID/pipeline-environment-maps/changes"),
+		)
+		a.Response(d.OK, pipelineEnvMapChangeList)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Gone, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+	})
+
+	a.Action("deleteBySpace", func() {
+		a.Description("Delete all the pipeline environment maps of the given space, restricted to the service accounts.")
+		a.Params(func() {
+			a.Param("spaceID", d.UUID, "ID of the space")
+		})
+		a.Routing(
+			a.DELETE("/spaces/:spaceID/pipeline-environment-maps"),
+		)
+		a.Response(d.NoContent)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+	})
+
+	a.Action("listByEnvironment", func() {
+		a.Description("Retrieve list of pipeline environment maps (as JSONAPI) referencing the given environment ID, in the spaces the caller can read or in all the spaces for the service accounts.")
+		a.Params(func() {
+			a.Param("envID", d.UUID, "ID of the environment")
+		})
+		a.Routing(
+			a.GET("/environments/:envID/pipeline-environment-maps"),
+		)
+		a.Response(d.OK, pipelineEnvMapList)
 		a.Response(d.InternalServerError, JSONAPIErrors)
 		a.Response(d.BadRequest, JSONAPIErrors)
 		a.Response(d.Unauthorized, JSONAPIErrors)
 		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
 	})
 
 	a.Action("show", func() {
@@ -84,18 +331,22 @@ var _ = a.Resource("PipelineEnvironmentMaps", func() {
 		a.Params(func() {
 			a.Param("ID", d.UUID, "ID of the pipeline environment map")
 		})
+		a.UseTrait("conditional")
 		a.Routing(
 			a.GET("/pipeline-environment-maps/:ID"),
 		)
 		a.Response(d.OK, pipelineEnvMapSingle)
+		a.Response(d.NotModified)
 		a.Response(d.InternalServerError, JSONAPIErrors)
 		a.Response(d.NotFound, JSONAPIErrors)
 		a.Response(d.Unauthorized, JSONAPIErrors)
 		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
 	})
 
 	a.Action("update", func() {
-		a.Description("Update the pipeline environment map for the given ID.")
+		a.Description(`Update the pipeline environment map for the given ID. Exceeding the quota of environments per
+map, or removing an environment targeted by a stage of the pipeline definition, is unprocessable (422).`)
 		a.Params(func() {
 			a.Param("ID", d.UUID, "ID of the pipeline environment map to update")
 		})
@@ -111,6 +362,151 @@ var _ = a.Resource("PipelineEnvironmentMaps", func() {
 		a.Response(d.MethodNotAllowed, JSONAPIErrors)
 		a.Response(d.Conflict, JSONAPIErrors)
 		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+		a.Response(d.UnprocessableEntity, JSONAPIErrors)
+	})
+
+	a.Action("delete", func() {
+		a.Description("Delete the pipeline environment map for the given ID.")
+		a.Params(func() {
+			a.Param("ID", d.UUID, "ID of the pipeline environment map to delete")
+		})
+		a.Routing(
+			a.DELETE("/pipeline-environment-maps/:ID"),
+		)
+		a.Response(d.NoContent)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.NotFound, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+	})
+
+	a.Action("restore", func() {
+		a.Description(`Restore the deleted pipeline environment map for the given ID from the trash, unless another
+map of the space took its name.`)
+		a.Params(func() {
+			a.Param("ID", d.UUID, "ID of the deleted pipeline environment map")
+		})
+		a.Routing(
+			a.POST("/pipeline-environment-maps/:ID/restore"),
+		)
+		a.Response(d.OK, pipelineEnvMapSingle)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.NotFound, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Conflict, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+	})
+
+	a.Action("listRevisions", func() {
+		a.Description("Retrieve the revisions of the pipeline environment map for the given ID, oldest first.")
+		a.Params(func() {
+			a.Param("ID", d.UUID, "ID of the pipeline environment map")
+		})
+		a.Routing(
+			a.GET("/pipeline-environment-maps/:ID/revisions"),
+		)
+		a.Response(d.OK, pipelineEnvMapRevisionList)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.NotFound, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+	})
+
+	a.Action("diffRevisions", func() {
+		a.Description("Compare two revisions of the pipeline environment map for the given ID.")
+		a.Params(func() {
+			a.Param("ID", d.UUID, "ID of the pipeline environment map")
+			a.Param("from", d.Integer, "Number of the older revision", func() {
+				a.Minimum(1)
+			})
+			a.Param("to", d.Integer, "Number of the newer revision", func() {
+				a.Minimum(1)
+			})
+			a.Required("from", "to")
+		})
+		a.Routing(
+			a.GET("/pipeline-environment-maps/:ID/revisions/diff"),
+		)
+		a.Response(d.OK, pipelineEnvMapRevisionDiffSingle)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.NotFound, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+	})
+
+	a.Action("restoreRevision", func() {
+		a.Description(`Roll the pipeline environment map for the given ID back to the given revision,
+recording a new revision. Removing an environment targeted by a stage of the pipeline definition is
+unprocessable (422).`)
+		a.Params(func() {
+			a.Param("ID", d.UUID, "ID of the pipeline environment map")
+			a.Param("rev", d.Integer, "Number of the revision to restore", func() {
+				a.Minimum(1)
+			})
+		})
+		a.Routing(
+			a.POST("/pipeline-environment-maps/:ID/revisions/:rev/restore"),
+		)
+		a.Response(d.OK, pipelineEnvMapSingle)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.NotFound, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Conflict, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+		a.Response(d.UnprocessableEntity, JSONAPIErrors)
+	})
+
+	a.Action("showDefinition", func() {
+		a.Description(`Retrieve the pipeline definition of the pipeline environment map for the given ID, in its
+last version unless another one is given.`)
+		a.Params(func() {
+			a.Param("ID", d.UUID, "ID of the pipeline environment map")
+			a.Param("version", d.Integer, "Number of the version of the definition", func() {
+				a.Minimum(1)
+			})
+		})
+		a.Routing(
+			a.GET("/pipeline-environment-maps/:ID/definition"),
+		)
+		a.Response(d.OK, pipelineDefinitionSingle)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.NotFound, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+	})
+
+	a.Action("updateDefinition", func() {
+		a.Description(`Save the given document as the next version of the pipeline definition of the pipeline
+environment map for the given ID. An invalid document is unprocessable (422), with an error by problem giving
+its line, column and path in meta.`)
+		a.Params(func() {
+			a.Param("ID", d.UUID, "ID of the pipeline environment map")
+		})
+		a.Routing(
+			a.PUT("/pipeline-environment-maps/:ID/definition"),
+		)
+		a.Payload(pipelineDefinitionSingle)
+		a.Response(d.OK, pipelineDefinitionSingle)
+		a.Response(d.InternalServerError, JSONAPIErrors)
+		a.Response(d.NotFound, JSONAPIErrors)
+		a.Response(d.BadRequest, JSONAPIErrors)
+		a.Response(d.Unauthorized, JSONAPIErrors)
+		a.Response(d.Conflict, JSONAPIErrors)
+		a.Response(d.Forbidden, JSONAPIErrors)
+		a.Response(d.TooManyRequests, JSONAPIErrors)
+		a.Response(d.UnprocessableEntity, JSONAPIErrors)
 	})
 
 })
@@ -0,0 +1,122 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// AMQPPublisher publishes the events to a topic exchange of an AMQP 0-9-1
+// broker, such as RabbitMQ, the routing key being the type of the event. The
+// messages are persistent and confirmed by the broker. The connection is
+// opened on the first event and again after a failure.
+type AMQPPublisher struct {
+	url      string
+	exchange string
+
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+// NewAMQPPublisher creates a publisher to the given exchange of the broker
+// at the given URL
+func NewAMQPPublisher(url, exchange string) *AMQPPublisher {
+	return &AMQPPublisher{
+		url:      url,
+		exchange: exchange,
+	}
+}
+
+// Publish sends the event to the exchange and waits for its confirmation
+func (p *AMQPPublisher) Publish(ctx context.Context, e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ch == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	err = p.ch.Publish(p.exchange, e.Type, false, false, amqp.Publishing{
+		ContentType:  CloudEventsContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    e.ID,
+		Timestamp:    e.Time,
+		Type:         e.Type,
+		Body:         b,
+	})
+	if err != nil {
+		p.disconnect()
+		return err
+	}
+	select {
+	case confirm, ok := <-p.confirms:
+		if !ok {
+			p.disconnect()
+			return fmt.Errorf("the connection to the broker was closed before the confirmation of the event %s", e.ID)
+		}
+		if !confirm.Ack {
+			return fmt.Errorf("the broker rejected the event %s", e.ID)
+		}
+		return nil
+	case <-ctx.Done():
+		// the confirmation would be read by the next event
+		p.disconnect()
+		return ctx.Err()
+	}
+}
+
+// Close closes the connection to the broker
+func (p *AMQPPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.ch, p.confirms = nil, nil, nil
+	return err
+}
+
+// connect opens the connection and the channel in confirm mode, declaring
+// the exchange
+func (p *AMQPPublisher) connect() error {
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if err := ch.ExchangeDeclare(p.exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		conn.Close()
+		return err
+	}
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn, p.ch = conn, ch
+	p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	return nil
+}
+
+// disconnect closes the connection after a failure, the next event opening
+// another one
+func (p *AMQPPublisher) disconnect() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn, p.ch, p.confirms = nil, nil, nil
+}
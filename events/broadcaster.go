@@ -0,0 +1,87 @@
+// Package events streams the changes of the pipeline environment maps to the
+// clients. The writers notify the changes of a space through Postgres, so
+// that the streams of all the instances of the service wake up and read the
+// new changes from the changes feed.
+package events
+
+import (
+	"sync"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Broadcaster signals the subscribers of a space that its changes feed may
+// have new changes. The signals are coalesced: a subscriber is only told to
+// read the feed again, not what changed.
+type Broadcaster struct {
+	mu   sync.Mutex
+	subs map[uuid.UUID]map[*Subscription]struct{}
+}
+
+// Subscription receives the signals of a space until closed
+type Subscription struct {
+	// C receives a value when the space has new changes
+	C       <-chan struct{}
+	c       chan struct{}
+	spaceID uuid.UUID
+	b       *Broadcaster
+}
+
+// NewBroadcaster creates a broadcaster without subscribers
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		subs: map[uuid.UUID]map[*Subscription]struct{}{},
+	}
+}
+
+// Subscribe returns a subscription to the signals of the given space
+func (b *Broadcaster) Subscribe(spaceID uuid.UUID) *Subscription {
+	c := make(chan struct{}, 1)
+	s := &Subscription{C: c, c: c, spaceID: spaceID, b: b}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[spaceID] == nil {
+		b.subs[spaceID] = map[*Subscription]struct{}{}
+	}
+	b.subs[spaceID][s] = struct{}{}
+	return s
+}
+
+// Close stops the signals of the subscription
+func (s *Subscription) Close() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	delete(s.b.subs[s.spaceID], s)
+	if len(s.b.subs[s.spaceID]) == 0 {
+		delete(s.b.subs, s.spaceID)
+	}
+}
+
+// Notify signals the subscribers of the given space, without waiting for the
+// ones already signaled
+func (b *Broadcaster) Notify(spaceID uuid.UUID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs[spaceID] {
+		s.signal()
+	}
+}
+
+// NotifyAll signals the subscribers of all the spaces, when notifications
+// may have been missed
+func (b *Broadcaster) NotifyAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, subs := range b.subs {
+		for s := range subs {
+			s.signal()
+		}
+	}
+}
+
+func (s *Subscription) signal() {
+	select {
+	case s.c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/build"
+	uuid "github.com/satori/go.uuid"
+)
+
+// CloudEventsContentType is the media type of the events encoded in the
+// structured mode of CloudEvents
+const CloudEventsContentType = "application/cloudevents+json"
+
+// SpecVersion is the version of the CloudEvents specification of the events
+const SpecVersion = "1.0"
+
+// Types of the events published
+const (
+	TypeMapCreated = "io.fabric8.build.pipelineenvironmentmap.created"
+	TypeMapUpdated = "io.fabric8.build.pipelineenvironmentmap.updated"
+	TypeMapDeleted = "io.fabric8.build.pipelineenvironmentmap.deleted"
+)
+
+// Event is the JSON envelope of an event, as defined by CloudEvents 1.0
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// MapChange is the data of the events of the pipeline environment maps. The
+// state of the map is omitted for the deletes.
+type MapChange struct {
+	ID           uuid.UUID         `json:"id"`
+	SpaceID      uuid.UUID         `json:"spaceID"`
+	Name         *string           `json:"name,omitempty"`
+	Environments []uuid.UUID       `json:"environments,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	ChangedAt    time.Time         `json:"changedAt"`
+}
+
+// mapChangeTypes are the types of the events of the operations on the maps
+var mapChangeTypes = map[string]string{
+	build.ChangeCreate: TypeMapCreated,
+	build.ChangeUpdate: TypeMapUpdated,
+	build.ChangeDelete: TypeMapDeleted,
+}
+
+// NewMapChangeEvent returns the event of the given change of a pipeline
+// environment map. Its ID is the cursor of the change, which allows the
+// consumers to discard the events delivered twice.
+func NewMapChangeEvent(change *build.Change) (*Event, error) {
+	data := MapChange{
+		ID:        change.PipelineEnvMapID,
+		SpaceID:   change.SpaceID,
+		ChangedAt: change.ChangedAt,
+	}
+	if change.Operation != build.ChangeDelete {
+		data.Name = change.Name
+		data.Environments = change.Environments()
+		data.Labels = change.Labels
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		SpecVersion:     SpecVersion,
+		ID:              change.Cursor(),
+		Source:          "/api/spaces/" + change.SpaceID.String() + "/pipeline-environment-maps",
+		Type:            mapChangeTypes[change.Operation],
+		Subject:         change.PipelineEnvMapID.String(),
+		Time:            change.ChangedAt,
+		DataContentType: "application/json",
+		Data:            b,
+	}, nil
+}
@@ -0,0 +1,194 @@
+package events_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/events"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signaled(s *events.Subscription) bool {
+	select {
+	case <-s.C:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	b := events.NewBroadcaster()
+	space1, space2 := uuid.NewV4(), uuid.NewV4()
+	sub1 := b.Subscribe(space1)
+	sub2 := b.Subscribe(space2)
+	defer sub2.Close()
+
+	t.Run("notify", func(t *testing.T) {
+		b.Notify(space1)
+		assert.True(t, signaled(sub1))
+		assert.False(t, signaled(sub2))
+	})
+
+	t.Run("coalesced", func(t *testing.T) {
+		b.Notify(space1)
+		b.Notify(space1)
+		assert.True(t, signaled(sub1))
+		assert.False(t, signaled(sub1))
+	})
+
+	t.Run("notify all", func(t *testing.T) {
+		b.NotifyAll()
+		assert.True(t, signaled(sub1))
+		assert.True(t, signaled(sub2))
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		sub1.Close()
+		b.Notify(space1)
+		assert.False(t, signaled(sub1))
+	})
+}
+
+func TestLimiter(t *testing.T) {
+	t.Run("limited", func(t *testing.T) {
+		l := events.NewLimiter(2)
+		require.True(t, l.Acquire("alice"))
+		require.True(t, l.Acquire("alice"))
+		assert.False(t, l.Acquire("alice"))
+		assert.True(t, l.Acquire("bob"))
+		l.Release("alice")
+		assert.True(t, l.Acquire("alice"))
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		l := events.NewLimiter(0)
+		for i := 0; i < 100; i++ {
+			require.True(t, l.Acquire("alice"))
+		}
+	})
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, events.WriteEvent(&buf, "abc", events.MapChangeType("create"), map[string]string{"name": "a\nb"}))
+	require.NoError(t, events.WriteHeartbeat(&buf))
+	assert.Equal(t, "id: abc\nevent: pipeline-environment-map.create\ndata: {\"name\":\"a\\nb\"}\n\n: heartbeat\n\n", buf.String())
+}
+
+func TestIsStreamRequest(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/spaces/foo/events", nil)
+	require.NoError(t, err)
+	assert.False(t, events.IsStreamRequest(req))
+	req.Header.Set("Accept", "text/event-stream")
+	assert.True(t, events.IsStreamRequest(req))
+}
+
+func TestNewMapChangeEvent(t *testing.T) {
+	name := "myapp"
+	envID := uuid.NewV4()
+	change := &build.Change{
+		Seq:              42,
+		SpaceID:          uuid.NewV4(),
+		PipelineEnvMapID: uuid.NewV4(),
+		Operation:        build.ChangeUpdate,
+		Name:             &name,
+		EnvironmentIDs:   []string{envID.String()},
+		Labels:           build.Labels{"team": "payments"},
+		ChangedAt:        time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+
+	t.Run("update", func(t *testing.T) {
+		e, err := events.NewMapChangeEvent(change)
+		require.NoError(t, err)
+		b, err := json.Marshal(e)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"specversion": "1.0",
+			"id": "`+change.Cursor()+`",
+			"source": "/api/spaces/`+change.SpaceID.String()+`/pipeline-environment-maps",
+			"type": "io.fabric8.build.pipelineenvironmentmap.updated",
+			"subject": "`+change.PipelineEnvMapID.String()+`",
+			"time": "2019-03-04T05:06:07Z",
+			"datacontenttype": "application/json",
+			"data": {
+				"id": "`+change.PipelineEnvMapID.String()+`",
+				"spaceID": "`+change.SpaceID.String()+`",
+				"name": "myapp",
+				"environments": ["`+envID.String()+`"],
+				"labels": {"team": "payments"},
+				"changedAt": "2019-03-04T05:06:07Z"
+			}
+		}`, string(b))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		deleted := *change
+		deleted.Operation = build.ChangeDelete
+		deleted.Name = nil
+		deleted.EnvironmentIDs = nil
+		deleted.Labels = nil
+		e, err := events.NewMapChangeEvent(&deleted)
+		require.NoError(t, err)
+		assert.Equal(t, events.TypeMapDeleted, e.Type)
+		assert.JSONEq(t, `{
+			"id": "`+change.PipelineEnvMapID.String()+`",
+			"spaceID": "`+change.SpaceID.String()+`",
+			"changedAt": "2019-03-04T05:06:07Z"
+		}`, string(e.Data))
+	})
+}
+
+func TestChannelPublisher(t *testing.T) {
+	p := events.NewChannelPublisher(1)
+	e := &events.Event{ID: "1"}
+	require.NoError(t, p.Publish(context.Background(), e))
+	assert.Equal(t, e, <-p.C)
+
+	// full
+	require.NoError(t, p.Publish(context.Background(), e))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, p.Publish(ctx, e))
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	var received []map[string]interface{}
+	status := http.StatusAccepted
+	sink := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "application/cloudevents+json; charset=utf-8", req.Header.Get("Content-Type"))
+		b, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		var e map[string]interface{}
+		require.NoError(t, json.Unmarshal(b, &e))
+		received = append(received, e)
+		rw.WriteHeader(status)
+	}))
+	defer sink.Close()
+	p := events.NewHTTPPublisher(sink.URL, time.Second)
+	defer p.Close()
+
+	t.Run("accepted", func(t *testing.T) {
+		err := p.Publish(context.Background(), &events.Event{SpecVersion: events.SpecVersion, ID: "1", Type: events.TypeMapCreated})
+		require.NoError(t, err)
+		require.Len(t, received, 1)
+		assert.Equal(t, "1", received[0]["id"])
+		assert.Equal(t, events.TypeMapCreated, received[0]["type"])
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		err := p.Publish(context.Background(), &events.Event{SpecVersion: events.SpecVersion, ID: "2", Type: events.TypeMapCreated})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "503")
+	})
+}
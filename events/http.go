@@ -0,0 +1,60 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/rest"
+)
+
+// HTTPPublisher posts the events to an HTTP sink in the structured mode of
+// CloudEvents, a 2xx response acknowledging the event
+type HTTPPublisher struct {
+	url  string
+	doer rest.HttpDoer
+}
+
+// NewHTTPPublisher creates a publisher posting the events to the given URL,
+// waiting for the response at most for the given timeout
+func NewHTTPPublisher(url string, timeout time.Duration) *HTTPPublisher {
+	return &HTTPPublisher{
+		url:  url,
+		doer: &rest.HttpClientDoer{HttpClient: &http.Client{Timeout: timeout}},
+	}
+}
+
+// Publish posts the event to the sink
+func (p *HTTPPublisher) Publish(ctx context.Context, e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", CloudEventsContentType+"; charset=utf-8")
+	resp, err := p.doer.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drained so that the connection is reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the sink rejected the event %s with the status %d", e.ID, resp.StatusCode)
+	}
+	return nil
+}
+
+// Close does nothing
+func (p *HTTPPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,46 @@
+package events
+
+import "sync"
+
+// Default settings of the streams
+const (
+	DefaultMaxStreamsPerIdentity = 5
+)
+
+// Limiter caps the number of concurrent streams of each identity, 0 meaning
+// unlimited
+type Limiter struct {
+	max     int
+	mu      sync.Mutex
+	streams map[string]int
+}
+
+// NewLimiter creates a limiter allowing max concurrent streams per identity
+func NewLimiter(max int) *Limiter {
+	return &Limiter{
+		max:     max,
+		streams: map[string]int{},
+	}
+}
+
+// Acquire takes a stream of the given identity, returning false if it has
+// reached the limit
+func (l *Limiter) Acquire(identity string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.streams[identity] >= l.max {
+		return false
+	}
+	l.streams[identity]++
+	return true
+}
+
+// Release gives back a stream taken by Acquire
+func (l *Limiter) Release(identity string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.streams[identity]--
+	if l.streams[identity] <= 0 {
+		delete(l.streams, identity)
+	}
+}
@@ -0,0 +1,58 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/fabric8-services/fabric8-common/log"
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Channel is the Postgres notification channel of the changes, the payload
+// being the ID of the space. The notifications are sent by a trigger of the
+// changes table when the transaction commits.
+const Channel = "pipeline_env_map_changes"
+
+// Listen relays the notifications of the changes to the broadcaster until the
+// context is done. After a reconnection all the subscribers are signaled, as
+// notifications may have been missed.
+func Listen(ctx context.Context, connStr string, b *Broadcaster) error {
+	listener := pq.NewListener(connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+			log.Warn(ctx, map[string]interface{}{
+				"err": err,
+			}, "lost the notifications of the changes")
+		case pq.ListenerEventReconnected:
+			b.NotifyAll()
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(Channel); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			// nil after a reconnection
+			if n == nil {
+				b.NotifyAll()
+				continue
+			}
+			spaceID, err := uuid.FromString(n.Extra)
+			if err != nil {
+				log.Warn(ctx, map[string]interface{}{
+					"payload": n.Extra,
+				}, "invalid notification of the changes")
+				continue
+			}
+			b.Notify(spaceID)
+		case <-time.After(time.Minute):
+			// checks the connection which may be silently broken
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"context"
+)
+
+// Publisher sends the events to their consumers. The events are delivered
+// at least once, in their order.
+type Publisher interface {
+	// Publish returns once the event is accepted by the broker or sink
+	Publish(ctx context.Context, e *Event) error
+	// Close releases the resources of the publisher
+	Close() error
+}
+
+// ChannelPublisher sends the events on a channel, for the tests and the
+// consumers within the service
+type ChannelPublisher struct {
+	C chan *Event
+}
+
+// NewChannelPublisher creates a publisher on a channel of the given size
+func NewChannelPublisher(size int) *ChannelPublisher {
+	return &ChannelPublisher{
+		C: make(chan *Event, size),
+	}
+}
+
+// Publish sends the event on the channel, waiting for room in the channel
+// until the context is done
+func (p *ChannelPublisher) Publish(ctx context.Context, e *Event) error {
+	select {
+	case p.C <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close does nothing, the channel being left open
+func (p *ChannelPublisher) Close() error {
+	return nil
+}
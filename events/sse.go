@@ -0,0 +1,45 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of the event streams
+const ContentType = "text/event-stream"
+
+// DefaultHeartbeatInterval is how often a comment is sent on an idle stream,
+// so that the proxies do not close it
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// MapChangeType returns the type of the events of the given operation on a
+// pipeline environment map
+func MapChangeType(operation string) string {
+	return "pipeline-environment-map." + operation
+}
+
+// IsStreamRequest returns true if the client asks for an event stream
+func IsStreamRequest(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), ContentType)
+}
+
+// WriteEvent writes an event of the given ID and type, the data being
+// encoded in JSON on a single line
+func WriteEvent(w io.Writer, id, eventType string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, eventType, b)
+	return err
+}
+
+// WriteHeartbeat writes a comment, ignored by the clients
+func WriteHeartbeat(w io.Writer) error {
+	_, err := io.WriteString(w, ": heartbeat\n\n")
+	return err
+}
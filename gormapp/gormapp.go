@@ -6,6 +6,9 @@ import (
 
 	"github.com/fabric8-services/fabric8-build/application"
 	"github.com/fabric8-services/fabric8-build/build"
+	"github.com/fabric8-services/fabric8-build/idempotency"
+	"github.com/fabric8-services/fabric8-build/quota"
+	"github.com/fabric8-services/fabric8-build/space"
 	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 )
@@ -96,3 +99,27 @@ func (g *GormTransaction) Rollback() error {
 func (g *GormBase) PipelineEnvMap() build.Repository {
 	return build.NewRepository(g.db)
 }
+
+func (g *GormBase) PipelineEnvMapRevisions() build.RevisionRepository {
+	return build.NewRevisionRepository(g.db)
+}
+
+func (g *GormBase) PipelineEnvMapChanges() build.ChangeRepository {
+	return build.NewChangeRepository(g.db)
+}
+
+func (g *GormBase) PipelineDefinitions() build.DefinitionRepository {
+	return build.NewDefinitionRepository(g.db)
+}
+
+func (g *GormBase) IdempotencyKeys() idempotency.Repository {
+	return idempotency.NewRepository(g.db)
+}
+
+func (g *GormBase) MissingSpaces() space.MissingRepository {
+	return space.NewMissingRepository(g.db)
+}
+
+func (g *GormBase) SpaceQuotas() quota.Repository {
+	return quota.NewRepository(g.db)
+}
@@ -0,0 +1,146 @@
+// Package idempotency keeps the responses of the requests sent with an
+// Idempotency-Key header, so that the retries of a request get the original
+// response instead of running it again.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/tracing"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/fabric8-services/fabric8-common/gormsupport"
+	"github.com/goadesign/goa"
+	"github.com/jinzhu/gorm"
+	errs "github.com/pkg/errors"
+	"github.com/prometheus/common/log"
+	uuid "github.com/satori/go.uuid"
+)
+
+// HeaderName is the header holding the idempotency key of a request
+const HeaderName = "Idempotency-Key"
+
+// DefaultTTL is how long the responses are kept by default
+const DefaultTTL = 24 * time.Hour
+
+// Key is the response of a request sent with an idempotency key, keys being
+// scoped to the identity which sent the request
+type Key struct {
+	Key         string    `gorm:"primary_key"`
+	IdentityID  uuid.UUID `sql:"type:uuid" gorm:"primary_key"`
+	Operation   string
+	Fingerprint string
+	StatusCode  int
+	Response    string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// TableName implements gorm.tabler
+func (Key) TableName() string {
+	return "idempotency_keys"
+}
+
+// Fingerprint returns a digest of the operation and of its parameters, as
+// marshalled in JSON
+func Fingerprint(operation string, params ...interface{}) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(operation))
+	for _, p := range params {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return "", errs.Wrap(err, "unable to compute the request fingerprint")
+		}
+		h.Write([]byte{0})
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type Repository interface {
+	Load(ctx context.Context, identityID uuid.UUID, key string, now time.Time) (*Key, error)
+	Create(ctx context.Context, k *Key) error
+	Complete(ctx context.Context, identityID uuid.UUID, key string, statusCode int, response string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type GormRepository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *GormRepository {
+	return &GormRepository{
+		db: db,
+	}
+}
+
+// Load the unexpired key of the given identity
+func (r *GormRepository) Load(ctx context.Context, identityID uuid.UUID, key string, now time.Time) (*Key, error) {
+	defer goa.MeasureSince([]string{"goa", "db", "idempotency_keys", "load"}, time.Now())
+	k := Key{}
+	tx := tracing.WithGormContext(ctx, r.db).Where("identity_id = ? AND key = ? AND expires_at > ?", identityID, key, now).First(&k)
+	if tx.RecordNotFound() {
+		return nil, errors.NewNotFoundError("idempotency-key", key)
+	}
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error, "key": key},
+			"unable to load the idempotency key")
+		return nil, errors.NewInternalError(ctx, tx.Error)
+	}
+	return &k, nil
+}
+
+// Create the given key, replacing the expired one if any. It returns a data
+// conflict error if the key is already in use.
+func (r *GormRepository) Create(ctx context.Context, k *Key) error {
+	defer goa.MeasureSince([]string{"goa", "db", "idempotency_keys", "create"}, time.Now())
+	db := tracing.WithGormContext(ctx, r.db)
+	err := db.Where("identity_id = ? AND key = ? AND expires_at <= ?", k.IdentityID, k.Key, k.CreatedAt).Delete(&Key{}).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "key": k.Key},
+			"unable to delete the expired idempotency key")
+		return errors.NewInternalError(ctx, err)
+	}
+	err = db.Create(k).Error
+	if err != nil {
+		if gormsupport.IsUniqueViolation(err, "idempotency_keys_pkey") {
+			return errors.NewDataConflictError("a request with the idempotency key " + k.Key + " is already being processed")
+		}
+		log.Error(ctx, map[string]interface{}{"err": err, "key": k.Key},
+			"unable to create the idempotency key")
+		return errs.WithStack(err)
+	}
+	return nil
+}
+
+// Complete records the response of the request of the given key, which is
+// created without response at the start of the request
+func (r *GormRepository) Complete(ctx context.Context, identityID uuid.UUID, key string, statusCode int, response string) error {
+	defer goa.MeasureSince([]string{"goa", "db", "idempotency_keys", "complete"}, time.Now())
+	tx := tracing.WithGormContext(ctx, r.db).Model(&Key{}).Where("identity_id = ? AND key = ?", identityID, key).
+		Updates(map[string]interface{}{"status_code": statusCode, "response": response})
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error, "key": key},
+			"unable to save the response of the idempotency key")
+		return errors.NewInternalError(ctx, tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return errors.NewNotFoundError("idempotency-key", key)
+	}
+	return nil
+}
+
+// DeleteExpired deletes the keys expired at the given time
+func (r *GormRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	defer goa.MeasureSince([]string{"goa", "db", "idempotency_keys", "delete_expired"}, time.Now())
+	tx := tracing.WithGormContext(ctx, r.db).Where("expires_at <= ?", now).Delete(&Key{})
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error},
+			"unable to delete the expired idempotency keys")
+		return 0, errors.NewInternalError(ctx, tx.Error)
+	}
+	return tx.RowsAffected, nil
+}
@@ -0,0 +1,138 @@
+package idempotency_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-build/idempotency"
+	"github.com/fabric8-services/fabric8-common/errors"
+	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type IdempotencyRepositorySuite struct {
+	testsuite.DBTestSuite
+	repo *idempotency.GormRepository
+}
+
+func TestIdempotencyRepository(t *testing.T) {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	suite.Run(t, &IdempotencyRepositorySuite{DBTestSuite: testsuite.NewDBTestSuite(config)})
+}
+
+func (s *IdempotencyRepositorySuite) SetupSuite() {
+	s.DBTestSuite.SetupSuite()
+	s.repo = idempotency.NewRepository(s.DB)
+}
+
+func newKey(identityID uuid.UUID, now time.Time) *idempotency.Key {
+	return &idempotency.Key{
+		Key:         "key-" + uuid.NewV4().String(),
+		IdentityID:  identityID,
+		Operation:   "create",
+		Fingerprint: "abc",
+		StatusCode:  201,
+		Response:    `{"data":{}}`,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+}
+
+func (s *IdempotencyRepositorySuite) TestCreateAndLoad() {
+	ctx := context.Background()
+	now := time.Now()
+	identityID := uuid.NewV4()
+	k := newKey(identityID, now)
+	require.NoError(s.T(), s.repo.Create(ctx, k))
+
+	loaded, err := s.repo.Load(ctx, identityID, k.Key, now)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), k.Fingerprint, loaded.Fingerprint)
+	assert.Equal(s.T(), k.Response, loaded.Response)
+
+	s.T().Run("scoped to the identity", func(t *testing.T) {
+		_, err := s.repo.Load(ctx, uuid.NewV4(), k.Key, now)
+		ok, _ := errors.IsNotFoundError(err)
+		assert.True(t, ok)
+	})
+
+	s.T().Run("key in use", func(t *testing.T) {
+		err := s.repo.Create(ctx, newKeyWithName(identityID, k.Key, now))
+		require.Error(t, err)
+		assert.IsType(t, errors.DataConflictError{}, err)
+	})
+
+	s.T().Run("expired", func(t *testing.T) {
+		later := now.Add(2 * time.Hour)
+		_, err := s.repo.Load(ctx, identityID, k.Key, later)
+		ok, _ := errors.IsNotFoundError(err)
+		assert.True(t, ok)
+		// the expired key can be reused
+		require.NoError(t, s.repo.Create(ctx, newKeyWithName(identityID, k.Key, later)))
+		_, err = s.repo.Load(ctx, identityID, k.Key, later)
+		require.NoError(t, err)
+	})
+}
+
+func newKeyWithName(identityID uuid.UUID, name string, now time.Time) *idempotency.Key {
+	k := newKey(identityID, now)
+	k.Key = name
+	return k
+}
+
+func (s *IdempotencyRepositorySuite) TestComplete() {
+	ctx := context.Background()
+	now := time.Now()
+	identityID := uuid.NewV4()
+	// reserved without response
+	k := newKey(identityID, now)
+	k.StatusCode, k.Response = 0, ""
+	require.NoError(s.T(), s.repo.Create(ctx, k))
+
+	require.NoError(s.T(), s.repo.Complete(ctx, identityID, k.Key, 201, `{"data":{}}`))
+	loaded, err := s.repo.Load(ctx, identityID, k.Key, now)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 201, loaded.StatusCode)
+	assert.Equal(s.T(), `{"data":{}}`, loaded.Response)
+
+	s.T().Run("unknown key", func(t *testing.T) {
+		err := s.repo.Complete(ctx, uuid.NewV4(), k.Key, 201, `{"data":{}}`)
+		ok, _ := errors.IsNotFoundError(err)
+		assert.True(t, ok)
+	})
+}
+
+func (s *IdempotencyRepositorySuite) TestDeleteExpired() {
+	ctx := context.Background()
+	now := time.Now()
+	k := newKey(uuid.NewV4(), now)
+	require.NoError(s.T(), s.repo.Create(ctx, k))
+
+	deleted, err := s.repo.DeleteExpired(ctx, now.Add(2*time.Hour))
+	require.NoError(s.T(), err)
+	assert.True(s.T(), deleted >= 1)
+	_, err = s.repo.Load(ctx, k.IdentityID, k.Key, now)
+	ok, _ := errors.IsNotFoundError(err)
+	assert.True(s.T(), ok)
+}
+
+func TestFingerprint(t *testing.T) {
+	spaceID := uuid.NewV4()
+	f1, err := idempotency.Fingerprint("create", spaceID, map[string]string{"name": "a"})
+	require.NoError(t, err)
+	f2, err := idempotency.Fingerprint("create", spaceID, map[string]string{"name": "a"})
+	require.NoError(t, err)
+	assert.Equal(t, f1, f2)
+	f3, err := idempotency.Fingerprint("create", spaceID, map[string]string{"name": "b"})
+	require.NoError(t, err)
+	assert.NotEqual(t, f1, f3)
+	f4, err := idempotency.Fingerprint("update", spaceID, map[string]string{"name": "a"})
+	require.NoError(t, err)
+	assert.NotEqual(t, f1, f4)
+}
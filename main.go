@@ -3,18 +3,36 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"os/user"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/fabric8-services/fabric8-build/app"
 	"github.com/fabric8-services/fabric8-build/application"
+	"github.com/fabric8-services/fabric8-build/application/accesslog"
+	"github.com/fabric8-services/fabric8-build/application/eventrelay"
+	"github.com/fabric8-services/fabric8-build/application/metrics"
+	"github.com/fabric8-services/fabric8-build/application/ratelimit"
+	"github.com/fabric8-services/fabric8-build/application/serviceaccount"
+	"github.com/fabric8-services/fabric8-build/application/spacesweeper"
+	"github.com/fabric8-services/fabric8-build/application/tlsconfig"
+	"github.com/fabric8-services/fabric8-build/application/tracing"
+	"github.com/fabric8-services/fabric8-build/application/worker"
 	"github.com/fabric8-services/fabric8-build/configuration"
 	"github.com/fabric8-services/fabric8-build/controller"
+	"github.com/fabric8-services/fabric8-build/events"
 	"github.com/fabric8-services/fabric8-build/gormapp"
 	"github.com/fabric8-services/fabric8-build/migration"
+	"github.com/fabric8-services/fabric8-build/quota"
+	"github.com/fabric8-services/fabric8-build/test/fake"
 	"github.com/fabric8-services/fabric8-common/goamiddleware"
 	"github.com/fabric8-services/fabric8-common/log"
 	"github.com/fabric8-services/fabric8-common/metric"
@@ -24,11 +42,13 @@ import (
 	goalogrus "github.com/goadesign/goa/logging/logrus"
 	"github.com/goadesign/goa/middleware"
 	"github.com/goadesign/goa/middleware/gzip"
+	goajwt "github.com/goadesign/goa/middleware/security/jwt"
 	"github.com/google/gops/agent"
 	"github.com/jinzhu/gorm"
 	_ "github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
@@ -38,11 +58,20 @@ func main() {
 	// --------------------------------------------------------------------
 	var configFilePath string
 	var printConfig bool
-	var migrateDB bool
+	var migrateDB migrateFlag
+	var dryRun bool
 	flag.StringVar(&configFilePath, "config", "", "Path to the config file to read")
 	flag.BoolVar(&printConfig, "printConfig", false, "Prints the config (including merged environment variables) and exits")
-	flag.BoolVar(&migrateDB, "migrateDatabase", false, "Migrates the database to the newest version and exits.")
+	flag.Var(&migrateDB, "migrateDatabase", "Migrates the database to the newest version and exits. "+
+		"Use -migrateDatabase=status to print the applied and pending versions, "+
+		"or -migrateDatabase=to:N to migrate up or down to version N.")
+	flag.BoolVar(&dryRun, "dryRun", false, "With -migrateDatabase, prints the SQL which would run instead of running it")
 	flag.Parse()
+	if dryRun && !migrateDB.IsSet() {
+		fmt.Fprintln(os.Stderr, "-dryRun requires -migrateDatabase")
+		flag.Usage()
+		os.Exit(2)
+	}
 
 	// Override default -config switch with environment variable only if -config switch was
 	// not explicitly given via the command line.
@@ -67,21 +96,41 @@ func main() {
 	}
 
 	if printConfig {
+		if err := config.Print(os.Stdout); err != nil {
+			log.Panic(context.TODO(), map[string]interface{}{
+				"err": err,
+			}, "failed to print the configuration")
+		}
+		if err := config.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		os.Exit(0)
 	}
 
+	if err := config.Validate(); err != nil {
+		log.Panic(context.TODO(), map[string]interface{}{
+			"config_file_path": configFilePath,
+			"err":              err,
+		}, "invalid configuration")
+	}
+
 	// Initialized developer mode flag and log level for the logger
 	log.InitializeLogger(config.IsLogJSON(), config.GetLogLevel())
 
 	db := connect(config)
-	defer func() {
-		err = db.Close()
+	tracing.RegisterGormCallbacks(db)
+
+	if migrateDB.IsSet() {
+		err = runMigration(db, config, migrateDB, dryRun)
 		if err != nil {
 			log.Panic(context.TODO(), map[string]interface{}{
-				"err": err,
-			}, "failure to close db connexion")
+				"err":     err,
+				"command": migrateDB.String(),
+			}, "failed migration")
 		}
-	}()
+		os.Exit(0)
+	}
 
 	err = migration.Migrate(db.DB(), config.GetPostgresDatabase())
 	if err != nil {
@@ -89,9 +138,6 @@ func main() {
 			"err": err,
 		}, "failed migration")
 	}
-	if migrateDB {
-		os.Exit(0)
-	}
 
 	// Initialize sentry client
 	haltSentry, err := sentry.InitializeSentryClient(
@@ -104,17 +150,30 @@ func main() {
 			"err": err,
 		}, "failed to setup the sentry client")
 	}
-	defer haltSentry()
+
+	configureTracing(config)
 
 	printUserInfo()
 
+	if config.IsLocalServicesEnabled() {
+		stopLocalServices := startLocalServices(config)
+		defer stopLocalServices()
+	}
+
 	// Create service
 	service := goa.New("fabric8-build")
 
 	// Mount middleware
 	service.Use(middleware.RequestID())
 	// Use our own log request to inject identity id and modify other properties
-	service.Use(gzip.Middleware(9))
+	if config.IsAccessLogEnabled() {
+		service.Use(accesslog.Middleware(accesslog.Options{
+			SampleRate: config.GetAccessLogSampleRate(),
+			Exclude:    config.GetAccessLogExclude(),
+		}))
+	}
+	service.Use(tracing.Middleware())
+	service.Use(skipEventStreams(gzip.Middleware(9)))
 	service.Use(app.ErrorHandler(service, true))
 	service.Use(middleware.Recover())
 
@@ -123,6 +182,7 @@ func main() {
 	tokenCtxMW := goamiddleware.TokenContext(tokenMgr, app.NewJWTSecurity())
 	service.Use(tokenCtxMW)
 	service.Use(token.InjectTokenManager(tokenMgr))
+	service.Use(accesslog.Identity(tokenMgr))
 
 	// Create the service factory
 	svcFactory := application.NewServiceFactory(config)
@@ -135,16 +195,153 @@ func main() {
 	service.WithLogger(goalogrus.New(log.Logger()))
 	// service.Use(metric.Recorder())
 
+	// record the goa metrics (repository timings) and the db pool statistics
+	if _, err := metrics.Setup(prometheus.DefaultRegisterer); err != nil {
+		log.Panic(context.TODO(), map[string]interface{}{
+			"err": err,
+		}, "failed to setup the goa metrics")
+	}
+	prometheus.MustRegister(metrics.NewDBStatsCollector(db.DB()))
+
 	// Mount the 'status' controller
 	statusCtrl := controller.NewStatusController(service)
 	app.MountStatusController(service, statusCtrl)
 
 	appDB := gormapp.NewGormDB(db)
 
+	// Background workers, stopped on shutdown
+	workers := worker.NewGroup()
+
+	// Reload the configuration when the file changes or on SIGHUP
+	config.OnReload(applyLogLevel)
+	workers.Go("config-watch", config.Watch)
+	workers.Go("config-sighup", func(ctx context.Context) {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				// errors are logged by Reload
+				_ = config.Reload()
+			}
+		}
+	})
+
+	// The service accounts allowed to call the internal endpoints
+	serviceAccounts := serviceaccount.NewAuthorizer(config.GetServiceAccountNames(), config.GetServiceAccountIDs())
+
 	// Mount the 'pipeline environment map' controller
 	pipelineEnvCtrl := controller.NewPipelineEnvironmentMapsController(service, appDB, svcFactory)
+	pipelineEnvCtrl.ServiceAccounts = serviceAccounts
+	if config.IsRateLimitEnabled() {
+		pipelineEnvCtrl.Use(rateLimiter(config, db, tokenMgr, workers))
+	}
+	pipelineEnvCtrl.IdempotencyTTL = config.GetIdempotencyKeyTTL
+	if config.IsSpaceSweeperEnabled() {
+		startSpaceSweeper(config, appDB, svcFactory, workers)
+	}
+	workers.Every("idempotency-purge", time.Hour, func(ctx context.Context) {
+		purged, err := appDB.IdempotencyKeys().DeleteExpired(ctx, time.Now())
+		if err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"err": err,
+			}, "unable to purge the expired idempotency keys")
+			return
+		}
+		log.Debug(ctx, map[string]interface{}{
+			"purged": purged,
+		}, "expired idempotency keys purged")
+	})
+	pipelineEnvCtrl.TrashRetention = config.GetTrashRetention()
+	pipelineEnvCtrl.Quotas = quotaLimits(config)
+	workers.Every("trash-purge", config.GetTrashPurgeInterval(), func(ctx context.Context) {
+		var purged int64
+		err := application.Transactional(appDB, func(appl application.Application) error {
+			var err error
+			purged, err = appl.PipelineEnvMap().Purge(ctx, time.Now().Add(-config.GetTrashRetention()))
+			return err
+		})
+		if err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"err": err,
+			}, "unable to purge the deleted pipeline environment maps")
+			return
+		}
+		log.Debug(ctx, map[string]interface{}{
+			"purged": purged,
+		}, "deleted pipeline environment maps purged")
+	})
 	app.MountPipelineEnvironmentMapsController(service, pipelineEnvCtrl)
 
+	// Mount the internal 'admin' controller
+	adminCtrl := controller.NewAdminController(service, appDB, svcFactory, serviceAccounts)
+	adminCtrl.SpaceGracePeriod = config.GetSpaceSweeperGracePeriod()
+	adminCtrl.Quotas = quotaLimits(config)
+	app.MountAdminController(service, adminCtrl)
+
+	// Mount the 'events' controller, the streams being woken up by the
+	// notifications of the changes sent through postgres
+	broadcaster := events.NewBroadcaster()
+	workers.Go("events-listener", func(ctx context.Context) {
+		if err := events.Listen(ctx, config.GetPostgresConfigString(), broadcaster); err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"err": err,
+			}, "unable to listen to the changes, the event streams only read them on heartbeats")
+		}
+	})
+	eventsCtrl := controller.NewEventsController(service, appDB, svcFactory, broadcaster)
+	eventsCtrl.Streams = events.NewLimiter(config.GetEventsMaxStreamsPerIdentity())
+	eventsCtrl.HeartbeatInterval = config.GetEventsHeartbeatInterval()
+	app.MountEventsController(service, eventsCtrl)
+
+	// Publish the changes to the configured broker or sink
+	if publisher := newEventsPublisher(config); publisher != nil {
+		defer publisher.Close()
+		relay := eventrelay.New(appDB, publisher)
+		workers.Every("events-relay", config.GetEventsPublisherInterval(), func(ctx context.Context) {
+			published, err := relay.Relay(ctx)
+			if err != nil {
+				log.Error(ctx, map[string]interface{}{
+					"err":       err,
+					"published": published,
+				}, "unable to publish the changes")
+				return
+			}
+			log.Debug(ctx, map[string]interface{}{
+				"published": published,
+			}, "changes published")
+		})
+	} else {
+		// the changes are not kept pending until a publisher is configured
+		workers.Every("events-discard", config.GetEventsPublisherInterval(), func(ctx context.Context) {
+			discarded, err := eventrelay.Discard(ctx, appDB)
+			if err != nil {
+				log.Error(ctx, map[string]interface{}{
+					"err": err,
+				}, "unable to discard the unpublished changes")
+				return
+			}
+			log.Debug(ctx, map[string]interface{}{
+				"discarded": discarded,
+			}, "unpublished changes discarded")
+		})
+	}
+	workers.Every("events-purge", time.Hour, func(ctx context.Context) {
+		purged, err := appDB.PipelineEnvMapChanges().Purge(ctx, time.Now().Add(-config.GetEventsRetention()))
+		if err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"err": err,
+			}, "unable to purge the published changes")
+			return
+		}
+		log.Debug(ctx, map[string]interface{}{
+			"purged": purged,
+		}, "published changes purged")
+	})
+
 	log.Logger().Infoln("Git Commit SHA: ", app.Commit)
 	log.Logger().Infoln("UTC Build Time: ", app.BuildTime)
 	log.Logger().Infoln("UTC Start Time: ", app.StartTime)
@@ -166,31 +363,341 @@ func main() {
 	}
 
 	// // Start/mount metrics http
+	var metricsSrv *http.Server
 	if config.GetHTTPAddress() == config.GetMetricsHTTPAddress() {
 		http.Handle("/metrics", promhttp.Handler())
 	} else {
-		go func(metricAddress string) {
-			mx := http.NewServeMux()
-			mx.Handle("/metrics", promhttp.Handler())
-			if err := http.ListenAndServe(metricAddress, mx); err != nil {
+		mx := http.NewServeMux()
+		mx.Handle("/metrics", promhttp.Handler())
+		metricsSrv = &http.Server{Addr: config.GetMetricsHTTPAddress(), Handler: mx}
+		configureTLS(metricsSrv, config.GetMetricsTLSSettings(), workers)
+		go func() {
+			if err := listenAndServe(metricsSrv); err != nil && err != http.ErrServerClosed {
 				log.Error(context.TODO(), map[string]interface{}{
-					"addr": metricAddress,
+					"addr": metricsSrv.Addr,
 					"err":  err,
 				}, "unable to connect to metrics server")
 				service.LogError("startup", "err", err)
 			}
-		}(config.GetMetricsHTTPAddress())
+		}()
 	}
 
 	// Start http
-	if err := http.ListenAndServe(config.GetHTTPAddress(), nil); err != nil {
+	srv := &http.Server{Addr: config.GetHTTPAddress()}
+	srv.RegisterOnShutdown(eventsCtrl.Close)
+	configureTLS(srv, config.GetHTTPTLSSettings(), workers)
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- listenAndServe(srv)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-srvErr:
 		log.Error(context.TODO(), map[string]interface{}{
 			"addr": config.GetHTTPAddress(),
 			"err":  err,
 		}, "unable to connect to server")
 		service.LogError("startup", "err", err)
+	case sig := <-signals:
+		log.Info(context.TODO(), map[string]interface{}{
+			"signal": sig.String(),
+		}, "received signal, shutting down")
 	}
 
+	shutdown(config, statusCtrl, srv, metricsSrv, workers)
+
+	err = db.Close()
+	if err != nil {
+		log.Error(context.TODO(), map[string]interface{}{
+			"err": err,
+		}, "failure to close db connexion")
+	}
+	if err := tracing.Close(); err != nil {
+		log.Error(context.TODO(), map[string]interface{}{
+			"err": err,
+		}, "failure to close the trace exporter")
+	}
+	haltSentry()
+	log.Info(context.TODO(), nil, "shutdown complete")
+}
+
+// applyLogLevel sets the level of the logger from the (reloaded) configuration
+func applyLogLevel(config *configuration.Config) {
+	level, err := logrus.ParseLevel(config.GetLogLevel())
+	if err != nil {
+		log.Error(context.TODO(), map[string]interface{}{
+			"err": err,
+		}, "invalid log level")
+		return
+	}
+	log.Logger().SetLevel(level)
+}
+
+// skipEventStreams bypasses the given middleware for the event streams,
+// which must not be buffered
+func skipEventStreams(m goa.Middleware) goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		wrapped := m(h)
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			if events.IsStreamRequest(req) {
+				return h(ctx, rw, req)
+			}
+			return wrapped(ctx, rw, req)
+		}
+	}
+}
+
+// configureTLS sets up the TLS configuration of the given server if enabled
+// in the settings, the certificates are reloaded by a background worker when
+// their files change.
+func configureTLS(srv *http.Server, settings configuration.TLSSettings, workers *worker.Group) {
+	if !settings.Enabled() {
+		return
+	}
+	tlsCfg, reloader, err := tlsconfig.New(tlsconfig.Options{
+		CertFile:     settings.CertFile,
+		KeyFile:      settings.KeyFile,
+		ClientCAFile: settings.ClientCAFile,
+		ClientAuth:   settings.ClientAuth,
+	})
+	if err != nil {
+		log.Panic(context.TODO(), map[string]interface{}{
+			"addr": srv.Addr,
+			"err":  err,
+		}, "failed to setup TLS")
+	}
+	srv.TLSConfig = tlsCfg
+	workers.Go("tls-reload "+srv.Addr, reloader.Watch)
+	log.Info(context.TODO(), map[string]interface{}{
+		"addr":      srv.Addr,
+		"client_ca": settings.ClientCAFile,
+	}, "serving TLS")
+}
+
+// listenAndServe serves TLS if configured, plain HTTP otherwise
+func listenAndServe(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
+	return srv.ListenAndServe()
+}
+
+// shutdown stops the service gracefully: the readiness is flipped to failing
+// so that no new traffic is routed to this instance, then the server stops
+// accepting connections and drains the in-flight requests up to the
+// configured timeout, and finally the background workers and the metrics
+// server are stopped.
+func shutdown(config *configuration.Config, statusCtrl *controller.StatusController, srv, metricsSrv *http.Server, workers *worker.Group) {
+	statusCtrl.ShuttingDown()
+	if delay := config.GetHTTPShutdownDelay(); delay > 0 {
+		log.Info(context.TODO(), map[string]interface{}{
+			"delay": delay.String(),
+		}, "waiting for the readiness change to propagate")
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetHTTPShutdownTimeout())
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error(ctx, map[string]interface{}{
+			"err": err,
+		}, "failed to drain the in-flight requests")
+	}
+	if err := workers.Stop(ctx); err != nil {
+		log.Error(ctx, map[string]interface{}{
+			"err": err,
+		}, "failed to stop the background workers")
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"err": err,
+			}, "failed to stop the metrics server")
+		}
+	}
+}
+
+// migrateFlag holds the value of the -migrateDatabase switch which can be
+// used as a boolean or take `status` or `to:N` as value.
+type migrateFlag struct {
+	value string
+}
+
+func (f *migrateFlag) String() string {
+	return f.value
+}
+
+func (f *migrateFlag) Set(value string) error {
+	switch {
+	case value == "true", value == "false", value == "status":
+	case strings.HasPrefix(value, "to:"):
+		if _, err := strconv.ParseInt(strings.TrimPrefix(value, "to:"), 10, 64); err != nil {
+			return fmt.Errorf("invalid target version in %q", value)
+		}
+	default:
+		return fmt.Errorf("unknown value %q, expected status or to:N", value)
+	}
+	f.value = value
+	return nil
+}
+
+// IsBoolFlag allows to use -migrateDatabase without any value
+func (f *migrateFlag) IsBoolFlag() bool {
+	return true
+}
+
+// IsSet returns true if a migration command was requested
+func (f *migrateFlag) IsSet() bool {
+	return f.value != "" && f.value != "false"
+}
+
+// runMigration runs the migration command given to -migrateDatabase
+func runMigration(db *gorm.DB, config *configuration.Config, cmd migrateFlag, dryRun bool) error {
+	if cmd.value == "status" {
+		return migration.PrintStatus(db.DB(), os.Stdout)
+	}
+	target := migration.LatestVersion()
+	if strings.HasPrefix(cmd.value, "to:") {
+		target, _ = strconv.ParseInt(strings.TrimPrefix(cmd.value, "to:"), 10, 64)
+	}
+	err := migration.MigrateTo(db.DB(), config.GetPostgresDatabase(), target, dryRun, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		return migration.PrintStatus(db.DB(), os.Stdout)
+	}
+	return nil
+}
+
+// startSpaceSweeper periodically deletes the pipeline environment maps of the
+// spaces deleted in WIT
+func startSpaceSweeper(config *configuration.Config, db application.DB, svcFactory application.ServiceFactory, workers *worker.Group) {
+	sweeper := spacesweeper.New(db, svcFactory.WITService(), config.GetSpaceSweeperGracePeriod())
+	workers.Every("space-sweeper", config.GetSpaceSweeperInterval(), func(ctx context.Context) {
+		// the calls to WIT forward the service account token
+		ctx = goajwt.WithJWT(ctx, &jwt.Token{Raw: config.GetSpaceSweeperToken()})
+		purged, err := sweeper.Sweep(ctx, time.Now())
+		if err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"err": err,
+			}, "unable to sweep the deleted spaces")
+			return
+		}
+		log.Info(ctx, map[string]interface{}{
+			"purged": len(purged),
+		}, "deleted spaces swept")
+	})
+}
+
+// rateLimiter returns the rate limiting middleware configured from the
+// configuration, the idle buckets being pruned in the background
+func rateLimiter(config *configuration.Config, db *gorm.DB, tokenMgr token.Manager, workers *worker.Group) goa.Middleware {
+	// the limits are read on each request so that they can be reloaded
+	identityLimits := func() ratelimit.Limits {
+		return rateLimits(config.GetRateLimitIdentityLimits())
+	}
+	spaceLimits := func() ratelimit.Limits {
+		return rateLimits(config.GetRateLimitSpaceLimits())
+	}
+	var store ratelimit.Store
+	if config.GetRateLimitStore() == configuration.RateLimitStorePostgres {
+		store = ratelimit.NewPostgresStore(db.DB())
+	} else {
+		store = ratelimit.NewMemoryStore()
+	}
+
+	workers.Every("ratelimit-prune", time.Minute, func(ctx context.Context) {
+		// buckets idle for longer than the longest refill are full again
+		idle := identityLimits().MaxRefill()
+		if d := spaceLimits().MaxRefill(); d > idle {
+			idle = d
+		}
+		pruned, err := store.Prune(time.Now().Add(-idle))
+		if err != nil {
+			log.Error(ctx, map[string]interface{}{
+				"err": err,
+			}, "unable to prune the rate limit buckets")
+			return
+		}
+		log.Debug(ctx, map[string]interface{}{
+			"pruned": pruned,
+		}, "rate limit buckets pruned")
+	})
+
+	log.Info(context.TODO(), map[string]interface{}{
+		"store":    config.GetRateLimitStore(),
+		"identity": config.GetRateLimitIdentityLimits(),
+		"space":    config.GetRateLimitSpaceLimits(),
+	}, "rate limiting enabled")
+	return ratelimit.Middleware(ratelimit.Options{
+		Store:          store,
+		IdentityLimits: identityLimits,
+		SpaceLimits:    spaceLimits,
+		TokenManager:   tokenMgr,
+	})
+}
+
+// quotaLimits returns the default quotas of the spaces from the configuration
+func quotaLimits(config *configuration.Config) quota.Limits {
+	return quota.Limits{
+		MaxMaps:               config.GetQuotaMaxMaps(),
+		MaxEnvironmentsPerMap: config.GetQuotaMaxEnvironmentsPerMap(),
+	}
+}
+
+// rateLimits converts the rate limits of the configuration
+func rateLimits(limits map[string]configuration.RateLimit) ratelimit.Limits {
+	res := ratelimit.Limits{}
+	for action, l := range limits {
+		res[action] = ratelimit.Limit{Rate: l.Rate, Burst: l.Burst}
+	}
+	return res
+}
+
+// newEventsPublisher returns the publisher of the events selected in the
+// configuration, nil if none
+func newEventsPublisher(config *configuration.Config) events.Publisher {
+	var publisher events.Publisher
+	switch config.GetEventsPublisher() {
+	case configuration.EventsPublisherHTTP:
+		publisher = events.NewHTTPPublisher(config.GetEventsPublisherHTTPURL(), config.GetEventsPublisherHTTPTimeout())
+	case configuration.EventsPublisherAMQP:
+		publisher = events.NewAMQPPublisher(config.GetEventsPublisherAMQPURL(), config.GetEventsPublisherAMQPExchange())
+	default:
+		return nil
+	}
+	log.Info(context.TODO(), map[string]interface{}{
+		"publisher": config.GetEventsPublisher(),
+		"interval":  config.GetEventsPublisherInterval().String(),
+	}, "events publication enabled")
+	return publisher
+}
+
+// configureTracing sets the exporter of the traces from the configuration
+func configureTracing(config *configuration.Config) {
+	var exporter tracing.Exporter
+	switch config.GetTracingExporter() {
+	case configuration.TracingExporterStdout:
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case configuration.TracingExporterFile:
+		fileExporter, err := tracing.NewFileExporter(config.GetTracingFile())
+		if err != nil {
+			log.Panic(context.TODO(), map[string]interface{}{
+				"err": err,
+			}, "failed to setup the trace exporter")
+		}
+		exporter = fileExporter
+	default:
+		exporter = tracing.NoopExporter{}
+	}
+	tracing.Configure(exporter, config.GetTracingSampleRate())
+	log.Info(context.TODO(), map[string]interface{}{
+		"exporter":    config.GetTracingExporter(),
+		"sample_rate": config.GetTracingSampleRate(),
+	}, "tracing configured")
 }
 
 func connect(config *configuration.Config) *gorm.DB {
@@ -222,6 +729,24 @@ func connect(config *configuration.Config) *gorm.DB {
 	return db
 }
 
+// startLocalServices starts local stand-ins of the WIT and ENV services and
+// points the configuration to them. It returns a function stopping them.
+func startLocalServices(config *configuration.Config) func() {
+	witSvc := fake.NewWITServer()
+	witSvc.SetAutoProvision(true)
+	envSvc := fake.NewENVServer()
+	envSvc.SetAutoProvision(true)
+	config.UseLocalServices(witSvc.URL, envSvc.URL)
+	log.Warn(context.TODO(), map[string]interface{}{
+		"wit_url": witSvc.URL,
+		"env_url": envSvc.URL,
+	}, "running against local WIT and ENV services")
+	return func() {
+		witSvc.Close()
+		envSvc.Close()
+	}
+}
+
 func getTokenManager(config *configuration.Config) token.Manager {
 	tokenMgr, err := token.DefaultManager(config)
 	if err != nil {
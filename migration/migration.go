@@ -2,20 +2,65 @@ package migration
 
 import (
 	"database/sql"
+	"fmt"
+	"io"
+	"time"
 
 	"github.com/fabric8-services/fabric8-common/migration"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
 )
 
+// advisoryLockID is used to serialize concurrent down migrations
+const advisoryLockID = 8154617
+
 func Migrate(db *sql.DB, catalog string) error {
 	return migration.Migrate(db, catalog, Steps())
 }
 
 type Scripts [][]string
 
+// Steps returns the scripts migrating the database up, the version of each
+// step is its index.
 func Steps() Scripts {
 	return [][]string{
 		{"000-bootstrap.sql"},
 		{"001-pipelineenv.sql"},
+		{"002-rate-limit-buckets.sql"},
+		{"003-idempotency-keys.sql"},
+		{"004-missing-spaces.sql"},
+		{"005-pipeline-env-map-revisions.sql"},
+		{"006-pipeline-env-maps-trash.sql"},
+		{"007-pipeline-env-map-labels.sql"},
+		{"008-space-quotas.sql"},
+		{"009-pipeline-env-map-changes.sql"},
+		{"010-pipeline-env-map-changes-notify.sql"},
+		{"011-pipeline-env-map-changes-published.sql"},
+		{"012-pipeline-definitions.sql"},
+		{"013-pipeline-env-map-changes-retention.sql"},
+		{"014-pipeline-env-map-changes-purged.sql"},
+	}
+}
+
+// DownSteps returns the scripts rolling back each of the Steps, indexed by
+// version. The bootstrap step can't be rolled back.
+func DownSteps() Scripts {
+	return [][]string{
+		{},
+		{"down/001-pipelineenv.sql"},
+		{"down/002-rate-limit-buckets.sql"},
+		{"down/003-idempotency-keys.sql"},
+		{"down/004-missing-spaces.sql"},
+		{"down/005-pipeline-env-map-revisions.sql"},
+		{"down/006-pipeline-env-maps-trash.sql"},
+		{"down/007-pipeline-env-map-labels.sql"},
+		{"down/008-space-quotas.sql"},
+		{"down/009-pipeline-env-map-changes.sql"},
+		{"down/010-pipeline-env-map-changes-notify.sql"},
+		{"down/011-pipeline-env-map-changes-published.sql"},
+		{"down/012-pipeline-definitions.sql"},
+		{"down/013-pipeline-env-map-changes-retention.sql"},
+		{"down/014-pipeline-env-map-changes-purged.sql"},
 	}
 }
 
@@ -26,3 +71,170 @@ func (s Scripts) Asset(name string) ([]byte, error) {
 func (s Scripts) AssetNameWithArgs() [][]string {
 	return s
 }
+
+// VersionStatus describes whether a migration step has been applied
+type VersionStatus struct {
+	Version   int64
+	Script    string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// CurrentVersion returns the latest version applied to the database, or -1
+// if the database was never migrated.
+func CurrentVersion(db *sql.DB) (int64, error) {
+	var current sql.NullInt64
+	err := db.QueryRow("SELECT max(version) FROM version").Scan(&current)
+	if err != nil {
+		if isUndefinedTable(err) {
+			return -1, nil
+		}
+		return -1, errors.Wrap(err, "unable to read the current database version")
+	}
+	if !current.Valid {
+		return -1, nil
+	}
+	return current.Int64, nil
+}
+
+// Status returns the applied and pending versions of the database
+func Status(db *sql.DB) ([]VersionStatus, error) {
+	applied := map[int64]time.Time{}
+	rows, err := db.Query("SELECT version, updated_at FROM version")
+	if err != nil && !isUndefinedTable(err) {
+		return nil, errors.Wrap(err, "unable to read the database versions")
+	}
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var v int64
+			var at time.Time
+			if err := rows.Scan(&v, &at); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			applied[v] = at
+		}
+		if err := rows.Err(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	var res []VersionStatus
+	for v, step := range Steps() {
+		st := VersionStatus{Version: int64(v), Script: step[0]}
+		if at, ok := applied[int64(v)]; ok {
+			st.Applied = true
+			st.AppliedAt = &at
+		}
+		res = append(res, st)
+	}
+	return res, nil
+}
+
+// PrintStatus writes the applied and pending versions of the database to w
+func PrintStatus(db *sql.DB, w io.Writer) error {
+	status, err := Status(db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%-8s %-8s %-30s %s\n", "VERSION", "STATUS", "SCRIPT", "APPLIED AT")
+	for _, st := range status {
+		state, at := "pending", ""
+		if st.Applied {
+			state, at = "applied", st.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%-8d %-8s %-30s %s\n", st.Version, state, st.Script, at)
+	}
+	return nil
+}
+
+// LatestVersion returns the version the database is at once fully migrated
+func LatestVersion() int64 {
+	return int64(len(Steps()) - 1)
+}
+
+// MigrateTo migrates the database up or down to the given version. When
+// dryRun is set, the SQL which would run is written to w instead.
+func MigrateTo(db *sql.DB, catalog string, target int64, dryRun bool, w io.Writer) error {
+	if target < 0 || target > LatestVersion() {
+		return errors.Errorf("invalid target version %d, expected a version between 0 and %d", target, LatestVersion())
+	}
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case target > current:
+		if dryRun {
+			return printScripts(w, Steps(), current+1, target)
+		}
+		return migration.Migrate(db, catalog, Steps()[:target+1])
+	case target < current:
+		if dryRun {
+			return printScripts(w, DownSteps(), current, target+1)
+		}
+		return migrateDown(db, current, target)
+	}
+	fmt.Fprintf(w, "-- database is already at version %d\n", current)
+	return nil
+}
+
+// printScripts writes the scripts of versions from to to (both inclusive,
+// in that order) to w.
+func printScripts(w io.Writer, scripts Scripts, from, to int64) error {
+	step := int64(1)
+	if from > to {
+		step = -1
+	}
+	for v := from; v != to+step; v += step {
+		for _, name := range scripts[v] {
+			b, err := Asset(name)
+			if err != nil {
+				return errors.Wrapf(err, "unable to load script %s", name)
+			}
+			fmt.Fprintf(w, "-- version %d: %s\n%s\n", v, name, b)
+		}
+	}
+	return nil
+}
+
+// migrateDown runs the down scripts from the current version to the target
+// one in a single transaction.
+func migrateDown(db *sql.DB, current, target int64) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "unable to begin the down migration transaction")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = errors.WithStack(tx.Commit())
+	}()
+
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", advisoryLockID); err != nil {
+		return errors.Wrap(err, "unable to lock the database for the down migration")
+	}
+	down := DownSteps()
+	for v := current; v > target; v-- {
+		for _, name := range down[v] {
+			b, err := Asset(name)
+			if err != nil {
+				return errors.Wrapf(err, "unable to load script %s", name)
+			}
+			if _, err := tx.Exec(string(b)); err != nil {
+				return errors.Wrapf(err, "failed to roll back version %d with %s", v, name)
+			}
+		}
+		if _, err = tx.Exec("DELETE FROM version WHERE version = $1", v); err != nil {
+			return errors.Wrapf(err, "unable to remove version %d", v)
+		}
+	}
+	return nil
+}
+
+func isUndefinedTable(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	return ok && pqErr.Code == "42P01"
+}
@@ -1,10 +1,13 @@
 package migration_test
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/fabric8-services/fabric8-build/configuration"
 	"github.com/fabric8-services/fabric8-build/migration"
@@ -12,6 +15,7 @@ import (
 	migrationsupport "github.com/fabric8-services/fabric8-common/migration"
 	"github.com/fabric8-services/fabric8-common/resource"
 	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
@@ -96,6 +100,20 @@ func (s *MigrationTestSuite) TestMigrate() {
 	require.NoError(s.T(), err, "cannot connect to DB '%s'", dbName)
 	defer gormDB.Close()
 	s.T().Run("checkMigration001", checkMigration001)
+	s.T().Run("checkMigration002", checkMigration002)
+	s.T().Run("checkMigration003", checkMigration003)
+	s.T().Run("checkMigration004", checkMigration004)
+	s.T().Run("checkMigration005", checkMigration005)
+	s.T().Run("checkMigration006", checkMigration006)
+	s.T().Run("checkMigration007", checkMigration007)
+	s.T().Run("checkMigration008", checkMigration008)
+	s.T().Run("checkMigration009", checkMigration009)
+	s.T().Run("checkMigration010", checkMigration010)
+	s.T().Run("checkMigration011", checkMigration011)
+	s.T().Run("checkMigration012", checkMigration012)
+	s.T().Run("checkMigration013", checkMigration013)
+	s.T().Run("checkMigration014", checkMigration014)
+	s.T().Run("checkMigrateDown", checkMigrateDown)
 }
 
 func checkMigration001(t *testing.T) {
@@ -113,3 +131,296 @@ func checkMigration001(t *testing.T) {
 
 	})
 }
+
+func checkMigration002(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:3])
+	require.NoError(t, err)
+
+	t.Run("insert ok", func(t *testing.T) {
+		_, err := sqlDB.Exec("INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ('identity:create:foo', 4.5, now())")
+		require.NoError(t, err)
+	})
+
+	t.Run("duplicate key", func(t *testing.T) {
+		_, err := sqlDB.Exec("INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ('identity:create:foo', 1, now())")
+		require.Error(t, err)
+	})
+}
+
+func checkMigration003(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:4])
+	require.NoError(t, err)
+
+	insert := "INSERT INTO idempotency_keys (key, identity_id, operation, fingerprint, status_code, response, created_at, expires_at) " +
+		"VALUES ('key1', '80654c22-c378-40bc-a76e-33a4bcc45f79', 'create', 'abc', 201, '{}', now(), now() + interval '1 day')"
+	t.Run("insert ok", func(t *testing.T) {
+		_, err := sqlDB.Exec(insert)
+		require.NoError(t, err)
+	})
+
+	t.Run("duplicate key", func(t *testing.T) {
+		_, err := sqlDB.Exec(insert)
+		require.Error(t, err)
+	})
+}
+
+func checkMigration004(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:5])
+	require.NoError(t, err)
+
+	t.Run("insert ok", func(t *testing.T) {
+		_, err := sqlDB.Exec("INSERT INTO missing_spaces (space_id, first_seen_at, last_seen_at) VALUES (uuid_generate_v4(), now(), now())")
+		require.NoError(t, err)
+	})
+}
+
+func checkMigration005(t *testing.T) {
+	// the existing maps get their first revision
+	pipelineID := "2bb3d7f4-3b0b-4a37-9d4e-b4c4f7e3cf01"
+	envID := "d1c5b2a4-5d34-4a1c-9b47-7c1f1e0c2a11"
+	_, err := sqlDB.Exec(`INSERT INTO pipeline_env_maps (id, name, space_id, created_at, updated_at) VALUES ('` +
+		pipelineID + `', 'pipeline-revisions', uuid_generate_v4(), now(), now())`)
+	require.NoError(t, err)
+	_, err = sqlDB.Exec(`INSERT INTO pipeline_environments (environment_id, pipelineenvmap_id, created_at) VALUES ('` +
+		envID + `', '` + pipelineID + `', now())`)
+	require.NoError(t, err)
+
+	err = migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:6])
+	require.NoError(t, err)
+
+	t.Run("backfilled", func(t *testing.T) {
+		var name, envIDs string
+		err := sqlDB.QueryRow(`SELECT name, environment_ids::text FROM pipeline_env_map_revisions
+			WHERE pipelineenvmap_id = $1 AND revision = 1`, pipelineID).Scan(&name, &envIDs)
+		require.NoError(t, err)
+		assert.Equal(t, "pipeline-revisions", name)
+		assert.Equal(t, "{"+envID+"}", envIDs)
+	})
+
+	t.Run("duplicate revision", func(t *testing.T) {
+		_, err := sqlDB.Exec(`INSERT INTO pipeline_env_map_revisions (pipelineenvmap_id, revision, name, environment_ids, created_at)
+			VALUES ($1, 1, 'pipeline-revisions', '{}', now())`, pipelineID)
+		require.Error(t, err)
+	})
+}
+
+func checkMigration006(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:7])
+	require.NoError(t, err)
+
+	spaceID := "5d3c1b7e-9f0a-4c62-8d1e-2a4b6c8d0e13"
+	insert := "INSERT INTO pipeline_env_maps (name, space_id, created_at, deleted_at) VALUES ('pipeline-trash', '" + spaceID + "', now(), %s)"
+	t.Run("name of a deleted map reused", func(t *testing.T) {
+		_, err := sqlDB.Exec(fmt.Sprintf(insert, "now()"))
+		require.NoError(t, err)
+		_, err = sqlDB.Exec(fmt.Sprintf(insert, "NULL"))
+		require.NoError(t, err)
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		_, err := sqlDB.Exec(fmt.Sprintf(insert, "NULL"))
+		require.Error(t, err)
+	})
+}
+
+func checkMigration007(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:8])
+	require.NoError(t, err)
+
+	t.Run("existing maps without labels", func(t *testing.T) {
+		var count int
+		err := sqlDB.QueryRow("SELECT count(*) FROM pipeline_env_maps WHERE labels <> '{}'").Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("labels must be an object", func(t *testing.T) {
+		_, err := sqlDB.Exec("INSERT INTO pipeline_env_maps (name, space_id, created_at, labels) VALUES ('pipeline-labels', uuid_generate_v4(), now(), '[]')")
+		require.Error(t, err)
+		_, err = sqlDB.Exec(`INSERT INTO pipeline_env_maps (name, space_id, created_at, labels) VALUES ('pipeline-labels', uuid_generate_v4(), now(), '{"team":"payments"}')`)
+		require.NoError(t, err)
+	})
+}
+
+func checkMigration008(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:9])
+	require.NoError(t, err)
+
+	t.Run("insert ok", func(t *testing.T) {
+		_, err := sqlDB.Exec("INSERT INTO space_quotas (space_id, max_maps, updated_at) VALUES (uuid_generate_v4(), 10, now())")
+		require.NoError(t, err)
+	})
+
+	t.Run("negative limit", func(t *testing.T) {
+		_, err := sqlDB.Exec("INSERT INTO space_quotas (space_id, max_environments_per_map, updated_at) VALUES (uuid_generate_v4(), -1, now())")
+		require.Error(t, err)
+	})
+}
+
+func checkMigration009(t *testing.T) {
+	// the existing maps are created in the changes
+	pipelineID := "7e0c3d52-1f4b-4b8e-a0a6-3c2d5e9f1b21"
+	_, err := sqlDB.Exec(`INSERT INTO pipeline_env_maps (id, name, space_id, created_at, updated_at) VALUES ('` +
+		pipelineID + `', 'pipeline-changes', uuid_generate_v4(), now(), now())`)
+	require.NoError(t, err)
+
+	err = migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:10])
+	require.NoError(t, err)
+
+	t.Run("backfilled", func(t *testing.T) {
+		var operation, name string
+		err := sqlDB.QueryRow(`SELECT operation, name FROM pipeline_env_map_changes
+			WHERE pipelineenvmap_id = $1`, pipelineID).Scan(&operation, &name)
+		require.NoError(t, err)
+		assert.Equal(t, "create", operation)
+		assert.Equal(t, "pipeline-changes", name)
+	})
+
+	t.Run("unknown operation", func(t *testing.T) {
+		_, err := sqlDB.Exec(`INSERT INTO pipeline_env_map_changes (space_id, pipelineenvmap_id, operation, changed_at)
+			VALUES (uuid_generate_v4(), uuid_generate_v4(), 'rename', now())`)
+		require.Error(t, err)
+	})
+}
+
+func checkMigration010(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:11])
+	require.NoError(t, err)
+
+	t.Run("trigger", func(t *testing.T) {
+		var count int
+		err := sqlDB.QueryRow(`SELECT count(*) FROM pg_trigger
+			WHERE tgname = 'pipeline_env_map_changes_notify'`).Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("insert ok", func(t *testing.T) {
+		_, err := sqlDB.Exec(`INSERT INTO pipeline_env_map_changes (space_id, pipelineenvmap_id, operation, changed_at)
+			VALUES (uuid_generate_v4(), uuid_generate_v4(), 'delete', now())`)
+		require.NoError(t, err)
+	})
+}
+
+func checkMigration011(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:12])
+	require.NoError(t, err)
+
+	t.Run("existing changes published", func(t *testing.T) {
+		var unpublished int
+		err := sqlDB.QueryRow(`SELECT count(*) FROM pipeline_env_map_changes WHERE published_at IS NULL`).Scan(&unpublished)
+		require.NoError(t, err)
+		assert.Zero(t, unpublished)
+	})
+
+	t.Run("new changes unpublished", func(t *testing.T) {
+		var publishedAt *time.Time
+		err := sqlDB.QueryRow(`INSERT INTO pipeline_env_map_changes (space_id, pipelineenvmap_id, operation, changed_at)
+			VALUES (uuid_generate_v4(), uuid_generate_v4(), 'delete', now()) RETURNING published_at`).Scan(&publishedAt)
+		require.NoError(t, err)
+		assert.Nil(t, publishedAt)
+	})
+}
+
+func checkMigration012(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:13])
+	require.NoError(t, err)
+
+	pipelineID := "2b8f6a14-5c3e-4d7a-9e1b-6f0d2c4a8e35"
+	_, err = sqlDB.Exec(`INSERT INTO pipeline_env_maps (id, name, space_id, created_at) VALUES ('` +
+		pipelineID + `', 'pipeline-definitions', uuid_generate_v4(), now())`)
+	require.NoError(t, err)
+	insert := "INSERT INTO pipeline_definitions (pipelineenvmap_id, version, document, created_at) VALUES ($1, $2, 'version: 1', now())"
+
+	t.Run("insert ok", func(t *testing.T) {
+		_, err := sqlDB.Exec(insert, pipelineID, 1)
+		require.NoError(t, err)
+	})
+
+	t.Run("duplicate version", func(t *testing.T) {
+		_, err := sqlDB.Exec(insert, pipelineID, 1)
+		require.Error(t, err)
+	})
+
+	t.Run("invalid version", func(t *testing.T) {
+		_, err := sqlDB.Exec(insert, pipelineID, 0)
+		require.Error(t, err)
+	})
+
+	t.Run("deleted with the map", func(t *testing.T) {
+		_, err := sqlDB.Exec("DELETE FROM pipeline_env_maps WHERE id = $1", pipelineID)
+		require.NoError(t, err)
+		var count int
+		err = sqlDB.QueryRow("SELECT count(*) FROM pipeline_definitions WHERE pipelineenvmap_id = $1", pipelineID).Scan(&count)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+}
+
+func checkMigration013(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:14])
+	require.NoError(t, err)
+
+	t.Run("index on the publication", func(t *testing.T) {
+		var count int
+		err := sqlDB.QueryRow(`SELECT count(*) FROM pg_indexes WHERE tablename = 'pipeline_env_map_changes'
+			AND indexname = 'pipeline_env_map_changes_published_at_idx'`).Scan(&count)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+}
+
+func checkMigration014(t *testing.T) {
+	err := migrationsupport.Migrate(sqlDB, databaseName, migration.Steps()[:15])
+	require.NoError(t, err)
+	spaceID := "7c1e4b2a-9d3f-4a6e-8b5c-0f2d1e3a4b6c"
+
+	t.Run("insert ok", func(t *testing.T) {
+		_, err := sqlDB.Exec("INSERT INTO pipeline_env_map_changes_purged (space_id, seq) VALUES ($1, 42)", spaceID)
+		require.NoError(t, err)
+	})
+
+	t.Run("one per space", func(t *testing.T) {
+		_, err := sqlDB.Exec("INSERT INTO pipeline_env_map_changes_purged (space_id, seq) VALUES ($1, 43)", spaceID)
+		require.Error(t, err)
+	})
+}
+
+func checkMigrateDown(t *testing.T) {
+	current, err := migration.CurrentVersion(sqlDB)
+	require.NoError(t, err)
+	require.Equal(t, migration.LatestVersion(), current)
+	require.Len(t, migration.DownSteps(), len(migration.Steps()))
+
+	t.Run("dry run", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := migration.MigrateTo(sqlDB, databaseName, 0, true, buf)
+		require.NoError(t, err)
+		assert.Contains(t, buf.String(), "DROP TABLE IF EXISTS pipeline_env_maps")
+		current, err := migration.CurrentVersion(sqlDB)
+		require.NoError(t, err)
+		assert.Equal(t, migration.LatestVersion(), current)
+	})
+
+	t.Run("down and up", func(t *testing.T) {
+		err := migration.MigrateTo(sqlDB, databaseName, 0, false, ioutil.Discard)
+		require.NoError(t, err)
+		_, err = sqlDB.Exec("SELECT 1 FROM pipeline_env_maps")
+		require.Error(t, err)
+
+		status, err := migration.Status(sqlDB)
+		require.NoError(t, err)
+		assert.True(t, status[0].Applied)
+		assert.False(t, status[1].Applied)
+
+		err = migration.MigrateTo(sqlDB, databaseName, migration.LatestVersion(), false, ioutil.Discard)
+		require.NoError(t, err)
+		_, err = sqlDB.Exec("SELECT 1 FROM pipeline_env_maps")
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid target", func(t *testing.T) {
+		err := migration.MigrateTo(sqlDB, databaseName, migration.LatestVersion()+1, false, ioutil.Discard)
+		require.Error(t, err)
+	})
+}
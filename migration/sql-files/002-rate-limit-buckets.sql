@@ -0,0 +1,9 @@
+-- token buckets of the rate limiter, shared by the replicas
+CREATE TABLE rate_limit_buckets (
+    key text NOT NULL,
+    tokens double precision NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    PRIMARY KEY(key)
+);
+
+CREATE INDEX rate_limit_buckets_updated_at_idx ON rate_limit_buckets USING BTREE (updated_at);
@@ -0,0 +1,14 @@
+-- responses of the requests sent with an Idempotency-Key header
+CREATE TABLE idempotency_keys (
+    key text NOT NULL,
+    identity_id uuid NOT NULL,
+    operation text NOT NULL,
+    fingerprint text NOT NULL,
+    status_code integer NOT NULL,
+    response text NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    PRIMARY KEY(identity_id, key)
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys USING BTREE (expires_at);
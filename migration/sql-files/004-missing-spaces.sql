@@ -0,0 +1,8 @@
+-- spaces not found in WIT, their pipeline environment maps are deleted
+-- after a grace period
+CREATE TABLE missing_spaces (
+    space_id uuid NOT NULL,
+    first_seen_at timestamp with time zone NOT NULL,
+    last_seen_at timestamp with time zone NOT NULL,
+    PRIMARY KEY(space_id)
+);
@@ -0,0 +1,20 @@
+-- immutable revisions of the pipeline environment maps, the environment IDs
+-- being kept in their order
+CREATE TABLE pipeline_env_map_revisions (
+    pipelineenvmap_id uuid NOT NULL REFERENCES pipeline_env_maps(id) ON DELETE CASCADE,
+    revision integer NOT NULL,
+    name text NOT NULL,
+    environment_ids uuid[] NOT NULL,
+    author_id uuid,
+    restored_from integer,
+    created_at timestamp with time zone NOT NULL,
+    PRIMARY KEY(pipelineenvmap_id, revision)
+);
+
+-- the current state of the existing maps is their first revision
+INSERT INTO pipeline_env_map_revisions (pipelineenvmap_id, revision, name, environment_ids, created_at)
+SELECT m.id, 1, m.name,
+    COALESCE((SELECT array_agg(e.environment_id ORDER BY e.created_at) FROM pipeline_environments e
+        WHERE e.pipelineenvmap_id = m.id AND e.deleted_at IS NULL), '{}'),
+    COALESCE(m.updated_at, m.created_at, now())
+FROM pipeline_env_maps m;
@@ -0,0 +1,8 @@
+-- the names only have to be unique among the maps not deleted, so that a map
+-- in the trash does not prevent creating another one with its name
+ALTER TABLE pipeline_env_maps DROP CONSTRAINT pipeline_env_maps_name_space_id_key;
+CREATE UNIQUE INDEX pipeline_env_maps_name_space_id_key ON pipeline_env_maps (name, space_id) WHERE deleted_at IS NULL;
+
+-- used to purge the trash
+CREATE INDEX pipeline_env_maps_deleted_at_idx ON pipeline_env_maps USING BTREE (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX pipeline_environments_deleted_at_idx ON pipeline_environments USING BTREE (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,5 @@
+-- key/value labels organizing the pipeline environment maps, queried with
+-- label selectors
+ALTER TABLE pipeline_env_maps ADD COLUMN labels jsonb NOT NULL DEFAULT '{}'
+    CONSTRAINT pipeline_env_maps_labels_check CHECK (jsonb_typeof(labels) = 'object');
+CREATE INDEX pipeline_env_maps_labels_idx ON pipeline_env_maps USING GIN (labels);
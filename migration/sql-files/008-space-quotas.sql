@@ -0,0 +1,9 @@
+-- limits of the spaces replacing the configured defaults, NULL keeping the
+-- default and 0 meaning unlimited
+CREATE TABLE space_quotas (
+    space_id uuid NOT NULL,
+    max_maps integer CHECK (max_maps >= 0),
+    max_environments_per_map integer CHECK (max_environments_per_map >= 0),
+    updated_at timestamp with time zone NOT NULL,
+    PRIMARY KEY(space_id)
+);
@@ -0,0 +1,25 @@
+-- ordered creates, updates and deletes of the pipeline environment maps, read
+-- by the integrations to sync the maps of a space from a cursor. The deletes
+-- are tombstones without the state of the map.
+CREATE TABLE pipeline_env_map_changes (
+    seq bigserial NOT NULL,
+    space_id uuid NOT NULL,
+    pipelineenvmap_id uuid NOT NULL,
+    operation text NOT NULL CHECK (operation IN ('create', 'update', 'delete')),
+    name text,
+    environment_ids uuid[],
+    labels jsonb,
+    changed_at timestamp with time zone NOT NULL,
+    PRIMARY KEY(seq)
+);
+CREATE INDEX pipeline_env_map_changes_space_id_seq_idx ON pipeline_env_map_changes (space_id, seq);
+
+-- the existing maps are created from the start of the changes
+INSERT INTO pipeline_env_map_changes (space_id, pipelineenvmap_id, operation, name, environment_ids, labels, changed_at)
+SELECT m.space_id, m.id, 'create', m.name,
+    COALESCE((SELECT array_agg(e.environment_id ORDER BY e.created_at) FROM pipeline_environments e
+        WHERE e.pipelineenvmap_id = m.id AND e.deleted_at IS NULL), '{}'),
+    m.labels, COALESCE(m.updated_at, m.created_at, now())
+FROM pipeline_env_maps m
+WHERE m.space_id IS NOT NULL AND m.deleted_at IS NULL
+ORDER BY m.created_at, m.id;
@@ -0,0 +1,13 @@
+-- notifies the space of each change when the transaction commits, waking up
+-- the event streams of all the instances. The notifications of a space are
+-- deduplicated by Postgres within a transaction.
+CREATE FUNCTION notify_pipeline_env_map_change() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('pipeline_env_map_changes', NEW.space_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER pipeline_env_map_changes_notify
+    AFTER INSERT ON pipeline_env_map_changes
+    FOR EACH ROW EXECUTE PROCEDURE notify_pipeline_env_map_change();
@@ -0,0 +1,5 @@
+-- the changes are published to the configured message broker or sink after
+-- their commit, the changes recorded before are not published
+ALTER TABLE pipeline_env_map_changes ADD COLUMN published_at timestamp with time zone;
+UPDATE pipeline_env_map_changes SET published_at = changed_at;
+CREATE INDEX pipeline_env_map_changes_unpublished_idx ON pipeline_env_map_changes (seq) WHERE published_at IS NULL;
@@ -0,0 +1,10 @@
+-- immutable versions of the pipeline definition document of the pipeline
+-- environment maps, the YAML document being kept as sent
+CREATE TABLE pipeline_definitions (
+    pipelineenvmap_id uuid NOT NULL REFERENCES pipeline_env_maps(id) ON DELETE CASCADE,
+    version integer NOT NULL CHECK (version > 0),
+    document text NOT NULL,
+    author_id uuid,
+    created_at timestamp with time zone NOT NULL,
+    PRIMARY KEY(pipelineenvmap_id, version)
+);
@@ -0,0 +1,2 @@
+-- the published changes are purged after the retention of the changes
+CREATE INDEX pipeline_env_map_changes_published_at_idx ON pipeline_env_map_changes USING BTREE (published_at);
@@ -0,0 +1,7 @@
+-- sequence number of the last purged change of each space, the cursors
+-- before it can't be resumed without missing changes
+CREATE TABLE pipeline_env_map_changes_purged (
+    space_id uuid NOT NULL,
+    seq bigint NOT NULL,
+    PRIMARY KEY(space_id)
+);
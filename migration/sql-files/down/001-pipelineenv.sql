@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS pipeline_environments;
+DROP TABLE IF EXISTS pipeline_env_maps;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS missing_spaces;
@@ -0,0 +1,13 @@
+-- the deleted maps sharing their name with another map are purged first
+CREATE TEMPORARY TABLE conflicting_pipeline_env_maps ON COMMIT DROP AS
+SELECT d.id FROM pipeline_env_maps d
+WHERE d.deleted_at IS NOT NULL AND EXISTS (
+    SELECT 1 FROM pipeline_env_maps m
+    WHERE m.name = d.name AND m.space_id = d.space_id AND m.id <> d.id);
+DELETE FROM pipeline_environments WHERE pipelineenvmap_id IN (SELECT id FROM conflicting_pipeline_env_maps);
+DELETE FROM pipeline_env_maps WHERE id IN (SELECT id FROM conflicting_pipeline_env_maps);
+
+DROP INDEX IF EXISTS pipeline_environments_deleted_at_idx;
+DROP INDEX IF EXISTS pipeline_env_maps_deleted_at_idx;
+DROP INDEX IF EXISTS pipeline_env_maps_name_space_id_key;
+ALTER TABLE pipeline_env_maps ADD CONSTRAINT pipeline_env_maps_name_space_id_key UNIQUE (name, space_id);
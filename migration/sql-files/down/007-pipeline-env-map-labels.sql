@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS pipeline_env_maps_labels_idx;
+ALTER TABLE pipeline_env_maps DROP COLUMN IF EXISTS labels;
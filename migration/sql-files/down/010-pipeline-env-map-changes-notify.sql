@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS pipeline_env_map_changes_notify ON pipeline_env_map_changes;
+DROP FUNCTION IF EXISTS notify_pipeline_env_map_change();
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS pipeline_env_map_changes_unpublished_idx;
+ALTER TABLE pipeline_env_map_changes DROP COLUMN IF EXISTS published_at;
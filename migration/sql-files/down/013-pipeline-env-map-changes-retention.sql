@@ -0,0 +1 @@
+DROP INDEX IF EXISTS pipeline_env_map_changes_published_at_idx;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS pipeline_env_map_changes_purged;
@@ -0,0 +1,178 @@
+// Package quota bounds the pipeline environment maps of each space. The
+// limits are the configured defaults unless overridden for the space.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/tracing"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/goadesign/goa"
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/common/log"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Names of the quotas
+const (
+	// Maps is the number of pipeline environment maps of a space
+	Maps = "maps"
+	// EnvironmentsPerMap is the number of environments of a pipeline
+	// environment map
+	EnvironmentsPerMap = "environmentsPerMap"
+)
+
+// Default limits
+const (
+	DefaultMaxMaps               = 100
+	DefaultMaxEnvironmentsPerMap = 20
+)
+
+// Limits are the quotas of a space, 0 meaning unlimited
+type Limits struct {
+	MaxMaps               int
+	MaxEnvironmentsPerMap int
+}
+
+// DefaultLimits are the limits of the spaces without override, unless
+// configured otherwise
+var DefaultLimits = Limits{
+	MaxMaps:               DefaultMaxMaps,
+	MaxEnvironmentsPerMap: DefaultMaxEnvironmentsPerMap,
+}
+
+// Override holds the limits of a space replacing the defaults, the nil ones
+// keeping the default
+type Override struct {
+	SpaceID               uuid.UUID `sql:"type:uuid" gorm:"primary_key"`
+	MaxMaps               *int
+	MaxEnvironmentsPerMap *int
+	UpdatedAt             time.Time
+}
+
+// TableName implements gorm.tabler
+func (Override) TableName() string {
+	return "space_quotas"
+}
+
+// Apply returns the limits with the given override, if any
+func (l Limits) Apply(o *Override) Limits {
+	if o == nil {
+		return l
+	}
+	if o.MaxMaps != nil {
+		l.MaxMaps = *o.MaxMaps
+	}
+	if o.MaxEnvironmentsPerMap != nil {
+		l.MaxEnvironmentsPerMap = *o.MaxEnvironmentsPerMap
+	}
+	return l
+}
+
+// CheckMaps returns an ExceededError if a space having the given number of
+// maps cannot have another one
+func (l Limits) CheckMaps(maps int) error {
+	if l.MaxMaps > 0 && maps >= l.MaxMaps {
+		return &ExceededError{Quota: Maps, Limit: l.MaxMaps, Usage: maps}
+	}
+	return nil
+}
+
+// CheckEnvironments returns an ExceededError if a map cannot have the given
+// number of environments
+func (l Limits) CheckEnvironments(envs int) error {
+	if l.MaxEnvironmentsPerMap > 0 && envs > l.MaxEnvironmentsPerMap {
+		return &ExceededError{Quota: EnvironmentsPerMap, Limit: l.MaxEnvironmentsPerMap, Usage: envs}
+	}
+	return nil
+}
+
+// ExceededError is returned when a change would exceed a quota of the space
+type ExceededError struct {
+	Quota string
+	Limit int
+	Usage int
+}
+
+func (e *ExceededError) Error() string {
+	if e.Quota == Maps {
+		return fmt.Sprintf("the space already has %d pipeline environment maps, the limit being %d", e.Usage, e.Limit)
+	}
+	return fmt.Sprintf("the pipeline environment map has %d environments, the limit being %d", e.Usage, e.Limit)
+}
+
+type Repository interface {
+	Load(ctx context.Context, spaceID uuid.UUID) (*Override, error)
+	Save(ctx context.Context, o *Override) (*Override, error)
+	Delete(ctx context.Context, spaceID uuid.UUID) error
+	Lock(ctx context.Context, spaceID uuid.UUID) error
+}
+
+type GormRepository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *GormRepository {
+	return &GormRepository{
+		db: db,
+	}
+}
+
+// Load the override of the given space
+func (r *GormRepository) Load(ctx context.Context, spaceID uuid.UUID) (*Override, error) {
+	defer goa.MeasureSince([]string{"goa", "db", "space_quotas", "load"}, time.Now())
+	o := Override{}
+	tx := tracing.WithGormContext(ctx, r.db).Where("space_id = ?", spaceID).First(&o)
+	if tx.RecordNotFound() {
+		return nil, errors.NewNotFoundError("space-quota", spaceID.String())
+	}
+	if tx.Error != nil {
+		log.Error(ctx, map[string]interface{}{"err": tx.Error, "space_id": spaceID.String()},
+			"unable to load the quota override")
+		return nil, errors.NewInternalError(ctx, tx.Error)
+	}
+	return &o, nil
+}
+
+// Save creates or replaces the override of its space
+func (r *GormRepository) Save(ctx context.Context, o *Override) (*Override, error) {
+	defer goa.MeasureSince([]string{"goa", "db", "space_quotas", "save"}, time.Now())
+	saved := Override{}
+	err := tracing.WithGormContext(ctx, r.db).Raw(`INSERT INTO space_quotas (space_id, max_maps, max_environments_per_map, updated_at) VALUES (?, ?, ?, now())
+		ON CONFLICT (space_id) DO UPDATE SET max_maps = EXCLUDED.max_maps,
+			max_environments_per_map = EXCLUDED.max_environments_per_map, updated_at = EXCLUDED.updated_at
+		RETURNING space_id, max_maps, max_environments_per_map, updated_at`, o.SpaceID, o.MaxMaps, o.MaxEnvironmentsPerMap).Scan(&saved).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": o.SpaceID.String()},
+			"unable to save the quota override")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	return &saved, nil
+}
+
+// Delete the override of the given space, if any
+func (r *GormRepository) Delete(ctx context.Context, spaceID uuid.UUID) error {
+	defer goa.MeasureSince([]string{"goa", "db", "space_quotas", "delete"}, time.Now())
+	err := tracing.WithGormContext(ctx, r.db).Where("space_id = ?", spaceID).Delete(&Override{}).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to delete the quota override")
+		return errors.NewInternalError(ctx, err)
+	}
+	return nil
+}
+
+// Lock serializes the quota checks of the given space until the end of the
+// transaction, so that concurrent creations cannot both take the last map
+func (r *GormRepository) Lock(ctx context.Context, spaceID uuid.UUID) error {
+	defer goa.MeasureSince([]string{"goa", "db", "space_quotas", "lock"}, time.Now())
+	err := tracing.WithGormContext(ctx, r.db).Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "space_quotas:"+spaceID.String()).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to lock the quotas of the space")
+		return errors.NewInternalError(ctx, err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package quota_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-build/quota"
+	"github.com/fabric8-services/fabric8-common/errors"
+	testsuite "github.com/fabric8-services/fabric8-common/test/suite"
+	errs "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLimits(t *testing.T) {
+	maxMaps := 0
+	limits := quota.Limits{MaxMaps: 2, MaxEnvironmentsPerMap: 3}
+	assert.Equal(t, limits, limits.Apply(nil))
+	assert.Equal(t, quota.Limits{MaxMaps: 0, MaxEnvironmentsPerMap: 3}, limits.Apply(&quota.Override{MaxMaps: &maxMaps}))
+
+	assert.NoError(t, limits.CheckMaps(1))
+	err := limits.CheckMaps(2)
+	require.Error(t, err)
+	assert.Equal(t, &quota.ExceededError{Quota: quota.Maps, Limit: 2, Usage: 2}, err)
+
+	assert.NoError(t, limits.CheckEnvironments(3))
+	err = limits.CheckEnvironments(4)
+	require.Error(t, err)
+	assert.Equal(t, &quota.ExceededError{Quota: quota.EnvironmentsPerMap, Limit: 3, Usage: 4}, err)
+
+	// 0 is unlimited
+	unlimited := quota.Limits{}
+	assert.NoError(t, unlimited.CheckMaps(1000))
+	assert.NoError(t, unlimited.CheckEnvironments(1000))
+}
+
+type QuotaRepositorySuite struct {
+	testsuite.DBTestSuite
+	repo *quota.GormRepository
+}
+
+func TestQuotaRepository(t *testing.T) {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	suite.Run(t, &QuotaRepositorySuite{DBTestSuite: testsuite.NewDBTestSuite(config)})
+}
+
+func (s *QuotaRepositorySuite) SetupSuite() {
+	s.DBTestSuite.SetupSuite()
+	s.repo = quota.NewRepository(s.DB)
+}
+
+func (s *QuotaRepositorySuite) TestSaveAndLoad() {
+	ctx := context.Background()
+	spaceID := uuid.NewV4()
+	maxMaps, maxEnvs := 5, 2
+
+	saved, err := s.repo.Save(ctx, &quota.Override{SpaceID: spaceID, MaxMaps: &maxMaps})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), maxMaps, *saved.MaxMaps)
+	assert.Nil(s.T(), saved.MaxEnvironmentsPerMap)
+
+	s.T().Run("replaced", func(t *testing.T) {
+		_, err := s.repo.Save(ctx, &quota.Override{SpaceID: spaceID, MaxEnvironmentsPerMap: &maxEnvs})
+		require.NoError(t, err)
+		loaded, err := s.repo.Load(ctx, spaceID)
+		require.NoError(t, err)
+		assert.Nil(t, loaded.MaxMaps)
+		assert.Equal(t, maxEnvs, *loaded.MaxEnvironmentsPerMap)
+	})
+
+	s.T().Run("deleted", func(t *testing.T) {
+		require.NoError(t, s.repo.Delete(ctx, spaceID))
+		_, err := s.repo.Load(ctx, spaceID)
+		require.Error(t, err)
+		assert.IsType(t, errors.NotFoundError{}, errs.Cause(err))
+		// deleting again is a no-op
+		require.NoError(t, s.repo.Delete(ctx, spaceID))
+	})
+}
+
+func (s *QuotaRepositorySuite) TestLock() {
+	// the lock is held until the end of the transaction
+	tx := s.DB.Begin()
+	defer tx.Rollback()
+	require.NoError(s.T(), quota.NewRepository(tx).Lock(context.Background(), uuid.NewV4()))
+}
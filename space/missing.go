@@ -0,0 +1,84 @@
+// Package space keeps track of the spaces which no longer exist in WIT, so
+// that their pipeline environment maps are deleted after a grace period.
+package space
+
+import (
+	"context"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/tracing"
+	"github.com/fabric8-services/fabric8-common/errors"
+	"github.com/goadesign/goa"
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/common/log"
+	uuid "github.com/satori/go.uuid"
+)
+
+// MissingSpace is a space not found in WIT
+type MissingSpace struct {
+	SpaceID     uuid.UUID `sql:"type:uuid" gorm:"primary_key"`
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+}
+
+// TableName implements gorm.tabler
+func (MissingSpace) TableName() string {
+	return "missing_spaces"
+}
+
+type MissingRepository interface {
+	Mark(ctx context.Context, spaceID uuid.UUID, now time.Time) (*MissingSpace, error)
+	Delete(ctx context.Context, spaceID uuid.UUID) error
+	Count(ctx context.Context) (int64, error)
+}
+
+type GormMissingRepository struct {
+	db *gorm.DB
+}
+
+func NewMissingRepository(db *gorm.DB) *GormMissingRepository {
+	return &GormMissingRepository{
+		db: db,
+	}
+}
+
+// Mark records that the given space was not found at the given time and
+// returns when it was first not found
+func (r *GormMissingRepository) Mark(ctx context.Context, spaceID uuid.UUID, now time.Time) (*MissingSpace, error) {
+	defer goa.MeasureSince([]string{"goa", "db", "missing_spaces", "mark"}, time.Now())
+	m := MissingSpace{}
+	err := tracing.WithGormContext(ctx, r.db).Raw(`INSERT INTO missing_spaces (space_id, first_seen_at, last_seen_at) VALUES (?, ?, ?)
+		ON CONFLICT (space_id) DO UPDATE SET last_seen_at = EXCLUDED.last_seen_at
+		RETURNING space_id, first_seen_at, last_seen_at`, spaceID, now, now).Scan(&m).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to mark the space as missing")
+		return nil, errors.NewInternalError(ctx, err)
+	}
+	return &m, nil
+}
+
+// Delete forgets the given space, because it was found again or deleted
+func (r *GormMissingRepository) Delete(ctx context.Context, spaceID uuid.UUID) error {
+	defer goa.MeasureSince([]string{"goa", "db", "missing_spaces", "delete"}, time.Now())
+	err := tracing.WithGormContext(ctx, r.db).Where("space_id = ?", spaceID).Delete(&MissingSpace{}).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err, "space_id": spaceID.String()},
+			"unable to delete the missing space")
+		return errors.NewInternalError(ctx, err)
+	}
+	return nil
+}
+
+// Count returns the number of spaces currently missing
+func (r *GormMissingRepository) Count(ctx context.Context) (int64, error) {
+	defer goa.MeasureSince([]string{"goa", "db", "missing_spaces", "count"}, time.Now())
+	var count int64
+	err := tracing.WithGormContext(ctx, r.db).Model(&MissingSpace{}).Count(&count).Error
+	if err != nil {
+		log.Error(ctx, map[string]interface{}{"err": err},
+			"unable to count the missing spaces")
+		return 0, errors.NewInternalError(ctx, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,101 @@
+package fake
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-build/application/env/envservice"
+	guuid "github.com/goadesign/goa/uuid"
+)
+
+// DefaultEnvironments are the environment names created for a space when
+// ENVServer.SetAutoProvision is enabled.
+var DefaultEnvironments = []string{"stage", "run"}
+
+// ENVServer is a fake ENV service serving
+// `GET /api/spaces/:spaceID/environments`.
+type ENVServer struct {
+	*server
+	envs          map[guuid.UUID][]*envservice.Environment
+	autoProvision bool
+}
+
+// NewENVServer starts a fake ENV service. Callers must Close it when done.
+func NewENVServer() *ENVServer {
+	s := &ENVServer{
+		envs: map[guuid.UUID][]*envservice.Environment{},
+	}
+	s.server = newServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AddEnvironment registers an environment in the given space.
+func (s *ENVServer) AddEnvironment(spaceID, envID guuid.UUID, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.envs[spaceID] = append(s.envs[spaceID], newEnvironment(envID, name))
+}
+
+// RemoveEnvironment unregisters an environment from the given space.
+func (s *ENVServer) RemoveEnvironment(spaceID, envID guuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	envs := s.envs[spaceID][:0]
+	for _, e := range s.envs[spaceID] {
+		if *e.ID != envID {
+			envs = append(envs, e)
+		}
+	}
+	s.envs[spaceID] = envs
+}
+
+// SetAutoProvision makes the server answer with the DefaultEnvironments for
+// any space it doesn't know about instead of an empty list.
+func (s *ENVServer) SetAutoProvision(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.autoProvision = enabled
+}
+
+func (s *ENVServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/spaces/"), "/environments")
+	if r.Method != http.MethodGet || path == r.URL.Path || !strings.HasSuffix(r.URL.Path, "/environments") {
+		writeError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+		return
+	}
+	spaceID, err := guuid.FromString(path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	envs, ok := s.envs[spaceID]
+	if !ok && s.autoProvision {
+		for _, name := range DefaultEnvironments {
+			envs = append(envs, newEnvironment(guuid.NewV4(), name))
+		}
+		s.envs[spaceID] = envs
+	}
+	s.mu.Unlock()
+
+	if envs == nil {
+		envs = []*envservice.Environment{}
+	}
+	writeJSON(w, http.StatusOK, envservice.EnvironmentsList{
+		Data:  envs,
+		Links: &envservice.PagingLinks{},
+		Meta:  &envservice.EnvironmentListMeta{},
+	})
+}
+
+func newEnvironment(envID guuid.UUID, name string) *envservice.Environment {
+	return &envservice.Environment{
+		ID: &envID,
+		Attributes: &envservice.EnvironmentAttributes{
+			Name: &name,
+		},
+		Links: &envservice.GenericLinks{},
+		Type:  "environments",
+	}
+}
@@ -0,0 +1,116 @@
+package fake_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/env"
+	"github.com/fabric8-services/fabric8-build/application/wit"
+	"github.com/fabric8-services/fabric8-build/configuration"
+	"github.com/fabric8-services/fabric8-build/test/fake"
+	"github.com/fabric8-services/fabric8-common/errors"
+	guuid "github.com/goadesign/goa/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newServices(t *testing.T) (*fake.WITServer, *fake.ENVServer, *configuration.Config) {
+	config, err := configuration.New("")
+	require.NoError(t, err)
+	witSvc, envSvc := fake.NewWITServer(), fake.NewENVServer()
+	config.UseLocalServices(witSvc.URL, envSvc.URL)
+	return witSvc, envSvc, config
+}
+
+func TestWITServer(t *testing.T) {
+	witSvc, envSvc, config := newServices(t)
+	defer witSvc.Close()
+	defer envSvc.Close()
+	client := &wit.WITServiceImpl{Config: *config}
+
+	t.Run("ok", func(t *testing.T) {
+		spaceID, ownerID := guuid.NewV4(), guuid.NewV4()
+		witSvc.AddSpace(spaceID, "space1", ownerID)
+		space, err := client.GetSpace(context.Background(), spaceID.String())
+		require.NoError(t, err)
+		assert.Equal(t, spaceID, space.ID)
+		assert.Equal(t, ownerID, space.OwnerID)
+		assert.Equal(t, "space1", space.Name)
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		spaceID := guuid.NewV4()
+		witSvc.AddSpace(spaceID, "space2", guuid.NewV4())
+		witSvc.RemoveSpace(spaceID)
+		_, err := client.GetSpace(context.Background(), spaceID.String())
+		require.Error(t, err)
+		assert.IsType(t, errors.NotFoundError{}, err)
+	})
+
+	t.Run("auto_provision", func(t *testing.T) {
+		witSvc.SetAutoProvision(true)
+		defer witSvc.SetAutoProvision(false)
+		spaceID := guuid.NewV4()
+		space, err := client.GetSpace(context.Background(), spaceID.String())
+		require.NoError(t, err)
+		assert.Equal(t, spaceID, space.ID)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		spaceID := guuid.NewV4()
+		witSvc.AddSpace(spaceID, "space3", guuid.NewV4())
+		witSvc.FailWith("/api/spaces/", http.StatusServiceUnavailable)
+		defer witSvc.ClearFailures()
+		_, err := client.GetSpace(context.Background(), spaceID.String())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "503")
+	})
+}
+
+func TestENVServer(t *testing.T) {
+	witSvc, envSvc, config := newServices(t)
+	defer witSvc.Close()
+	defer envSvc.Close()
+	client := &env.ENVServiceImpl{Config: *config}
+
+	t.Run("ok", func(t *testing.T) {
+		spaceID, env1ID, env2ID := guuid.NewV4(), guuid.NewV4(), guuid.NewV4()
+		envSvc.AddEnvironment(spaceID, env1ID, "env1")
+		envSvc.AddEnvironment(spaceID, env2ID, "env2")
+		envSvc.RemoveEnvironment(spaceID, env1ID)
+		envs, err := client.GetEnvList(context.Background(), spaceID.String())
+		require.NoError(t, err)
+		require.Len(t, envs, 1)
+		assert.Equal(t, env2ID, envs[0].ID)
+		assert.Equal(t, "env2", envs[0].Name)
+	})
+
+	t.Run("auto_provision", func(t *testing.T) {
+		envSvc.SetAutoProvision(true)
+		defer envSvc.SetAutoProvision(false)
+		envs, err := client.GetEnvList(context.Background(), guuid.NewV4().String())
+		require.NoError(t, err)
+		assert.Len(t, envs, len(fake.DefaultEnvironments))
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		envSvc.FailWith("/", http.StatusUnauthorized)
+		defer envSvc.ClearFailures()
+		_, err := client.GetEnvList(context.Background(), guuid.NewV4().String())
+		require.Error(t, err)
+		assert.IsType(t, errors.UnauthorizedError{}, err)
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		envSvc.SetLatency(50 * time.Millisecond)
+		defer envSvc.SetLatency(0)
+		before := envSvc.Requests()
+		start := time.Now()
+		_, err := client.GetEnvList(context.Background(), guuid.NewV4().String())
+		require.NoError(t, err)
+		assert.True(t, time.Since(start) >= 50*time.Millisecond)
+		assert.Equal(t, before+1, envSvc.Requests())
+	})
+}
@@ -0,0 +1,112 @@
+// Package fake provides local stand-ins for the remote fabric8 services
+// (WIT and ENV) the build service talks to. The servers are based on
+// httptest and speak the same JSONAPI as the generated witservice and
+// envservice clients, so they can be used by tests and to run the service
+// offline during development.
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// server holds the behaviour shared by all the fake services: an artificial
+// latency, programmable failures and a counter of the requests received.
+type server struct {
+	*httptest.Server
+	mu       sync.RWMutex
+	latency  time.Duration
+	failures map[string]int
+	requests int
+}
+
+func newServer(h http.Handler) *server {
+	s := &server{
+		failures: map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		latency := s.latency
+		status := s.failureFor(r.URL.Path)
+		s.mu.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if status != 0 {
+			writeError(w, status, http.StatusText(status))
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// failureFor returns the status code registered for the longest prefix
+// matching the given path, or 0 if the request should be served normally.
+// Caller must hold the lock.
+func (s *server) failureFor(path string) int {
+	status, matched := 0, -1
+	for prefix, code := range s.failures {
+		if strings.HasPrefix(path, prefix) && len(prefix) > matched {
+			status, matched = code, len(prefix)
+		}
+	}
+	return status
+}
+
+// SetLatency makes the server wait for the given duration before answering
+// each request.
+func (s *server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// FailWith makes every request whose path starts with the given prefix fail
+// with the given HTTP status code. Use "/" to make all requests fail.
+func (s *server) FailWith(pathPrefix string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[pathPrefix] = status
+}
+
+// ClearFailures removes all failures registered with FailWith.
+func (s *server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = map[string]int{}
+}
+
+// Requests returns the number of requests received so far.
+func (s *server) Requests() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.requests
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": []map[string]interface{}{
+			{
+				"status": http.StatusText(status),
+				"detail": detail,
+			},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
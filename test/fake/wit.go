@@ -0,0 +1,101 @@
+package fake
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fabric8-services/fabric8-build/application/wit/witservice"
+	guuid "github.com/goadesign/goa/uuid"
+)
+
+// WITServer is a fake WIT service serving `GET /api/spaces/:spaceID`.
+type WITServer struct {
+	*server
+	spaces        map[guuid.UUID]*witservice.Space
+	autoProvision bool
+}
+
+// NewWITServer starts a fake WIT service. Callers must Close it when done.
+func NewWITServer() *WITServer {
+	s := &WITServer{
+		spaces: map[guuid.UUID]*witservice.Space{},
+	}
+	s.server = newServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AddSpace registers a space owned by the given identity.
+func (s *WITServer) AddSpace(spaceID guuid.UUID, name string, ownerID guuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spaces[spaceID] = newSpace(spaceID, name, ownerID)
+}
+
+// RemoveSpace unregisters a space, subsequent lookups answer with a 404.
+func (s *WITServer) RemoveSpace(spaceID guuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.spaces, spaceID)
+}
+
+// SetAutoProvision makes the server answer with a generated space for any
+// unknown space ID instead of a 404.
+func (s *WITServer) SetAutoProvision(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.autoProvision = enabled
+}
+
+func (s *WITServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, "/api/spaces/") {
+		writeError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+		return
+	}
+	spaceID, err := guuid.FromString(strings.TrimPrefix(r.URL.Path, "/api/spaces/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	space, ok := s.spaces[spaceID]
+	if !ok && s.autoProvision {
+		space = newSpace(spaceID, "space-"+spaceID.String()[:8], guuid.NewV4())
+		s.spaces[spaceID] = space
+		ok = true
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "space "+spaceID.String()+" not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, witservice.SpaceSingle{Data: space})
+}
+
+func newSpace(spaceID guuid.UUID, name string, ownerID guuid.UUID) *witservice.Space {
+	desc := "Description of " + name
+	version := 0
+	now := time.Now()
+	return &witservice.Space{
+		ID: &spaceID,
+		Attributes: &witservice.SpaceAttributes{
+			CreatedAt:   &now,
+			Description: &desc,
+			Name:        &name,
+			UpdatedAt:   &now,
+			Version:     &version,
+		},
+		Links: &witservice.GenericLinksForSpace{},
+		Type:  "spaces",
+		Relationships: &witservice.SpaceRelationships{
+			OwnedBy: &witservice.SpaceOwnedBy{
+				Data: &witservice.IdentityRelationData{
+					ID:   &ownerID,
+					Type: "identities",
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/fabric8-services/fabric8-build/client"
+	"github.com/goadesign/goa/uuid"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// run executes the command line and returns the process exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cmd := newRootCommand(stdin, stdout)
+	cmd.SetArgs(args)
+	cmd.SetOutput(stderr)
+	err := cmd.Execute()
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+	}
+	return exitCode(err)
+}
+
+func newRootCommand(stdin io.Reader, stdout io.Writer) *cobra.Command {
+	opts := &options{}
+	defaultURLValue := os.Getenv(envURL)
+	if defaultURLValue == "" {
+		defaultURLValue = defaultURL
+	}
+
+	root := &cobra.Command{
+		Use:           "f8build",
+		Short:         "Manage the pipeline environment maps of the fabric8 build service",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	root.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &usageError{err}
+	})
+	root.PersistentFlags().StringVar(&opts.url, "url", defaultURLValue, "URL of the build service (env: "+envURL+")")
+	root.PersistentFlags().StringVar(&opts.tokenFile, "token-file", "", "file containing the access token (env: "+envTokenFile+", or the token itself in "+envToken+")")
+	root.PersistentFlags().StringVarP(&opts.output, "output", "o", outputTable, "output format: table or json")
+
+	// withSession returns a cobra RunE func opening a session before running fn
+	withSession := func(fn func(s *session, args []string) error) func(*cobra.Command, []string) error {
+		return func(_ *cobra.Command, args []string) error {
+			s, err := newSession(opts)
+			if err != nil {
+				return err
+			}
+			return fn(s, args)
+		}
+	}
+
+	var spaceID, name, file, selector, sort string
+	var envIDs []string
+	var update bool
+
+	list := &cobra.Command{
+		Use:   "list --space SPACE_ID [--selector SELECTOR] [--sort KEYS]",
+		Short: "List the pipeline environment maps of a space",
+		Args:  exactArgs(0),
+		RunE: withSession(func(s *session, _ []string) error {
+			res, err := s.list(spaceID, selector, sort)
+			if err != nil {
+				return err
+			}
+			return printMaps(stdout, s.output, res, res.Data...)
+		}),
+	}
+	list.Flags().StringVar(&spaceID, "space", "", "ID of the space")
+	list.Flags().StringVarP(&selector, "selector", "l", "", "Label selector the maps must match, e.g. team=payments,tier!=batch")
+	list.Flags().StringVar(&sort, "sort", "", "Comma separated sort keys among name, created-at and updated-at, prefixed with - for a descending order")
+
+	show := &cobra.Command{
+		Use:   "show ID",
+		Short: "Show a pipeline environment map",
+		Args:  exactArgs(1),
+		RunE: withSession(func(s *session, args []string) error {
+			res, err := s.show(args[0])
+			if err != nil {
+				return err
+			}
+			return printMaps(stdout, s.output, res, res.Data)
+		}),
+	}
+
+	create := &cobra.Command{
+		Use:   "create --space SPACE_ID --name NAME --env ENV_ID...",
+		Short: "Create a pipeline environment map",
+		Args:  exactArgs(0),
+		RunE: withSession(func(s *session, _ []string) error {
+			space, err := parseUUID("space", spaceID)
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				return &usageError{errors.New("--name is required")}
+			}
+			envs, err := parseEnvironments(envIDs)
+			if err != nil {
+				return err
+			}
+			res, err := s.create(&client.PipelineEnvironmentMaps{
+				Name:         name,
+				SpaceID:      &space,
+				Environments: envs,
+			})
+			if err != nil {
+				return err
+			}
+			return printMaps(stdout, s.output, res, res.Data)
+		}),
+	}
+	create.Flags().StringVar(&spaceID, "space", "", "ID of the space")
+	create.Flags().StringVar(&name, "name", "", "name of the pipeline")
+	create.Flags().StringSliceVar(&envIDs, "env", nil, "ID of an environment, can be repeated")
+
+	upd := &cobra.Command{
+		Use:   "update ID [--name NAME] [--env ENV_ID...]",
+		Short: "Update the name or environments of a pipeline environment map",
+		Args:  exactArgs(1),
+		RunE: withSession(func(s *session, args []string) error {
+			current, err := s.show(args[0])
+			if err != nil {
+				return err
+			}
+			m := current.Data
+			if name != "" {
+				m.Name = name
+			}
+			if len(envIDs) > 0 {
+				if m.Environments, err = parseEnvironments(envIDs); err != nil {
+					return err
+				}
+			}
+			res, err := s.update(m)
+			if err != nil {
+				return err
+			}
+			return printMaps(stdout, s.output, res, res.Data)
+		}),
+	}
+	upd.Flags().StringVar(&name, "name", "", "new name of the pipeline")
+	upd.Flags().StringSliceVar(&envIDs, "env", nil, "ID of an environment, can be repeated; replaces all the environments")
+
+	del := &cobra.Command{
+		Use:   "delete ID",
+		Short: "Delete a pipeline environment map",
+		Args:  exactArgs(1),
+		RunE: withSession(func(s *session, args []string) error {
+			return s.delete(args[0])
+		}),
+	}
+
+	export := &cobra.Command{
+		Use:   "export --space SPACE_ID [--file FILE]",
+		Short: "Export the pipeline environment maps of a space as JSON",
+		Args:  exactArgs(0),
+		RunE: withSession(func(s *session, _ []string) error {
+			res, err := s.list(spaceID, "", "name")
+			if err != nil {
+				return err
+			}
+			w := stdout
+			if file != "" && file != "-" {
+				f, err := os.Create(file)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			return printMaps(w, outputJSON, res)
+		}),
+	}
+	export.Flags().StringVar(&spaceID, "space", "", "ID of the space")
+	export.Flags().StringVarP(&file, "file", "f", "-", "file to write to, - for stdout")
+
+	imp := &cobra.Command{
+		Use:   "import --space SPACE_ID [--file FILE] [--update]",
+		Short: "Import pipeline environment maps previously exported into a space",
+		Args:  exactArgs(0),
+		RunE: withSession(func(s *session, _ []string) error {
+			space, err := parseUUID("space", spaceID)
+			if err != nil {
+				return err
+			}
+			var b []byte
+			if file == "" || file == "-" {
+				b, err = ioutil.ReadAll(stdin)
+			} else {
+				b, err = ioutil.ReadFile(file)
+			}
+			if err != nil {
+				return err
+			}
+			var doc client.PipelineEnvironmentMapsList
+			if err := json.Unmarshal(b, &doc); err != nil {
+				return &usageError{errors.Wrap(err, "invalid import document")}
+			}
+			return importMaps(s, stdout, space, doc.Data, update)
+		}),
+	}
+	imp.Flags().StringVar(&spaceID, "space", "", "ID of the space to import into")
+	imp.Flags().StringVarP(&file, "file", "f", "-", "file to read from, - for stdin")
+	imp.Flags().BoolVar(&update, "update", false, "update the maps which already exist with the same name")
+
+	root.AddCommand(list, show, create, upd, del, export, imp)
+	return root
+}
+
+// importMaps creates the given maps in the space, and if update is set,
+// updates the ones already existing with the same name.
+func importMaps(s *session, w io.Writer, spaceID uuid.UUID, maps []*client.PipelineEnvironmentMaps, update bool) error {
+	var existing map[string]*client.PipelineEnvironmentMaps
+	var imported []*client.PipelineEnvironmentMaps
+	for _, m := range maps {
+		m.ID = nil
+		m.SpaceID = &spaceID
+		res, err := s.create(m)
+		if aerr, ok := err.(*apiError); ok && update && exitCode(aerr) == exitConflict {
+			if existing == nil {
+				current, err := s.list(spaceID.String(), "", "")
+				if err != nil {
+					return err
+				}
+				existing = map[string]*client.PipelineEnvironmentMaps{}
+				for _, c := range current.Data {
+					existing[c.Name] = c
+				}
+			}
+			if c, found := existing[m.Name]; found {
+				m.ID = c.ID
+				res, err = s.update(m)
+			}
+		}
+		if err != nil {
+			return errors.Wrapf(err, "unable to import %s", m.Name)
+		}
+		imported = append(imported, res.Data)
+	}
+	return printMaps(w, s.output, &client.PipelineEnvironmentMapsList{Data: imported}, imported...)
+}
+
+func exactArgs(n int) cobra.PositionalArgs {
+	return func(_ *cobra.Command, args []string) error {
+		if len(args) != n {
+			return &usageError{errors.Errorf("accepts %d argument(s), received %d", n, len(args))}
+		}
+		return nil
+	}
+}
+
+func parseUUID(what, s string) (uuid.UUID, error) {
+	if s == "" {
+		return uuid.UUID{}, &usageError{errors.Errorf("%s ID is required", what)}
+	}
+	id, err := uuid.FromString(s)
+	if err != nil {
+		return uuid.UUID{}, &usageError{errors.Errorf("invalid %s ID %q", what, s)}
+	}
+	return id, nil
+}
+
+func parseEnvironments(envIDs []string) ([]*client.EnvironmentAttributes, error) {
+	if len(envIDs) == 0 {
+		return nil, &usageError{errors.New("at least one --env is required")}
+	}
+	envs := make([]*client.EnvironmentAttributes, 0, len(envIDs))
+	for _, e := range envIDs {
+		id, err := parseUUID("environment", e)
+		if err != nil {
+			return nil, err
+		}
+		envs = append(envs, &client.EnvironmentAttributes{EnvUUID: &id})
+	}
+	return envs, nil
+}
+
+func uuidString(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
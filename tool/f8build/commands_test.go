@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fabric8-services/fabric8-build/client"
+	"github.com/goadesign/goa/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeService is an in-memory build service, rejecting the maps whose name
+// contains a space as invalid and the duplicated names of a space as
+// conflicting
+type fakeService struct {
+	t    *testing.T
+	mu   sync.Mutex
+	maps []*client.PipelineEnvironmentMaps
+}
+
+func newTestServer(t *testing.T, maps ...*client.PipelineEnvironmentMaps) (*httptest.Server, *fakeService) {
+	f := &fakeService{t: t, maps: maps}
+	return httptest.NewServer(http.HandlerFunc(f.serveHTTP)), f
+}
+
+// inSpace returns the maps of the given space
+func (f *fakeService) inSpace(spaceID uuid.UUID) []*client.PipelineEnvironmentMaps {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var res []*client.PipelineEnvironmentMaps
+	for _, m := range f.maps {
+		if *m.SpaceID == spaceID {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+func (f *fakeService) find(id string) *client.PipelineEnvironmentMaps {
+	for _, m := range f.maps {
+		if m.ID.String() == id {
+			return m
+		}
+	}
+	return nil
+}
+
+func (f *fakeService) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(f.t, "Bearer secret", r.Header.Get("Authorization"))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const spaces, maps = "/api/spaces/", "/api/pipeline-environment-maps/"
+	switch {
+	case strings.HasPrefix(r.URL.Path, spaces) && strings.HasSuffix(r.URL.Path, "/pipeline-environment-maps"):
+		spaceID, err := uuid.FromString(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, spaces), "/pipeline-environment-maps"))
+		if !assert.NoError(f.t, err) {
+			return
+		}
+		if r.Method == "POST" {
+			f.create(w, r, spaceID)
+			return
+		}
+		if sort := r.URL.Query().Get("sort"); sort != "" {
+			assert.Contains(f.t, []string{"name", "-name"}, sort)
+		}
+		if selector := r.URL.Query().Get("labelSelector"); selector != "" {
+			assert.Equal(f.t, "team=payments", selector)
+		}
+		list := client.PipelineEnvironmentMapsList{Data: []*client.PipelineEnvironmentMaps{}}
+		for _, m := range f.maps {
+			if *m.SpaceID == spaceID {
+				list.Data = append(list.Data, m)
+			}
+		}
+		writeTestJSON(w, http.StatusOK, "application/vnd.pipelineenvironmentmapslist+json", list)
+	case strings.HasPrefix(r.URL.Path, maps) && f.find(strings.TrimPrefix(r.URL.Path, maps)) != nil:
+		m := f.find(strings.TrimPrefix(r.URL.Path, maps))
+		switch r.Method {
+		case "GET":
+			writeTestJSON(w, http.StatusOK, "application/vnd.pipelineenvironmentmapsingle+json", client.PipelineEnvironmentMapSingle{Data: m})
+		case "PATCH":
+			var payload client.PipelineEnvironmentMapSingle
+			if !assert.NoError(f.t, json.NewDecoder(r.Body).Decode(&payload)) {
+				return
+			}
+			m.Name = payload.Data.Name
+			m.Environments = payload.Data.Environments
+			writeTestJSON(w, http.StatusOK, "application/vnd.pipelineenvironmentmapsingle+json", client.PipelineEnvironmentMapSingle{Data: m})
+		case "DELETE":
+			for i, other := range f.maps {
+				if other == m {
+					f.maps = append(f.maps[:i], f.maps[i+1:]...)
+					break
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		writeTestError(w, http.StatusNotFound, "not_found", "pipeline-environment not found")
+	}
+}
+
+func (f *fakeService) create(w http.ResponseWriter, r *http.Request, spaceID uuid.UUID) {
+	var payload client.PipelineEnvironmentMapSingle
+	if !assert.NoError(f.t, json.NewDecoder(r.Body).Decode(&payload)) {
+		return
+	}
+	m := payload.Data
+	if strings.Contains(m.Name, " ") {
+		writeTestError(w, http.StatusUnprocessableEntity, "invalid_request", "invalid name "+m.Name)
+		return
+	}
+	for _, other := range f.maps {
+		if *other.SpaceID == spaceID && other.Name == m.Name {
+			writeTestError(w, http.StatusConflict, "data_conflict_error", "name "+m.Name+" already exists")
+			return
+		}
+	}
+	id := uuid.NewV4()
+	m.ID = &id
+	m.SpaceID = &spaceID
+	f.maps = append(f.maps, m)
+	writeTestJSON(w, http.StatusCreated, "application/vnd.pipelineenvironmentmapsingle+json", client.PipelineEnvironmentMapSingle{Data: m})
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeTestError(w http.ResponseWriter, status int, code, detail string) {
+	writeTestJSON(w, status, "application/vnd.jsonapierrors+json", client.JSONAPIErrors{
+		Errors: []*client.JSONAPIError{{Code: &code, Detail: detail}},
+	})
+}
+
+func TestRun(t *testing.T) {
+	spaceID, mapID, envID := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	srv, svc := newTestServer(t, &client.PipelineEnvironmentMaps{
+		ID:           &mapID,
+		SpaceID:      &spaceID,
+		Name:         "osio-stage",
+		Environments: []*client.EnvironmentAttributes{{EnvUUID: &envID}},
+	})
+	defer srv.Close()
+
+	execIn := func(stdin io.Reader, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		args = append([]string{"--url", srv.URL}, args...)
+		code := run(args, stdin, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+	exec := func(args ...string) (int, string, string) {
+		return execIn(strings.NewReader(""), args...)
+	}
+	realToken := os.Getenv(envToken)
+	os.Setenv(envToken, "secret")
+	defer os.Setenv(envToken, realToken)
+	dir, err := ioutil.TempDir("", "f8build")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("list table", func(t *testing.T) {
+		code, out, _ := exec("list", "--space", spaceID.String())
+		require.Equal(t, exitOK, code)
+		assert.Contains(t, out, "osio-stage")
+		assert.Contains(t, out, envID.String())
+	})
+
+	t.Run("list sorted", func(t *testing.T) {
+		code, out, _ := exec("list", "--space", spaceID.String(), "--sort", "-name")
+		require.Equal(t, exitOK, code)
+		assert.Contains(t, out, "osio-stage")
+	})
+
+	t.Run("list selected", func(t *testing.T) {
+		code, out, _ := exec("list", "--space", spaceID.String(), "-l", "team=payments")
+		require.Equal(t, exitOK, code)
+		assert.Contains(t, out, "osio-stage")
+	})
+
+	t.Run("list json", func(t *testing.T) {
+		code, out, _ := exec("list", "--space", spaceID.String(), "-o", "json")
+		require.Equal(t, exitOK, code)
+		var doc client.PipelineEnvironmentMapsList
+		require.NoError(t, json.Unmarshal([]byte(out), &doc))
+		require.Len(t, doc.Data, 1)
+		assert.Equal(t, mapID, *doc.Data[0].ID)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		otherEnvID := uuid.NewV4()
+		code, out, _ := exec("create", "--space", spaceID.String(), "--name", "osio-run", "--env", otherEnvID.String(), "-o", "json")
+		require.Equal(t, exitOK, code)
+		var doc client.PipelineEnvironmentMapSingle
+		require.NoError(t, json.Unmarshal([]byte(out), &doc))
+		assert.Equal(t, "osio-run", doc.Data.Name)
+		require.Len(t, doc.Data.Environments, 1)
+		assert.Equal(t, otherEnvID, *doc.Data.Environments[0].EnvUUID)
+		assert.Len(t, svc.inSpace(spaceID), 2)
+	})
+
+	t.Run("create conflict", func(t *testing.T) {
+		code, _, errOut := exec("create", "--space", spaceID.String(), "--name", "osio-stage", "--env", envID.String())
+		assert.Equal(t, exitConflict, code)
+		assert.Contains(t, errOut, "data_conflict_error")
+	})
+
+	t.Run("create without environment", func(t *testing.T) {
+		code, _, _ := exec("create", "--space", spaceID.String(), "--name", "osio-none")
+		assert.Equal(t, exitUsage, code)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		newEnvID := uuid.NewV4()
+		code, out, _ := exec("update", mapID.String(), "--env", newEnvID.String())
+		require.Equal(t, exitOK, code)
+		assert.Contains(t, out, "osio-stage")
+		assert.Contains(t, out, newEnvID.String())
+		assert.NotContains(t, out, envID.String())
+	})
+
+	exported := filepath.Join(dir, "export.json")
+	t.Run("export", func(t *testing.T) {
+		code, out, _ := exec("export", "--space", spaceID.String(), "--file", exported)
+		require.Equal(t, exitOK, code)
+		assert.Empty(t, out)
+		b, err := ioutil.ReadFile(exported)
+		require.NoError(t, err)
+		var doc client.PipelineEnvironmentMapsList
+		require.NoError(t, json.Unmarshal(b, &doc))
+		assert.Len(t, doc.Data, 2)
+	})
+
+	t.Run("export to stdout", func(t *testing.T) {
+		code, out, _ := exec("export", "--space", spaceID.String())
+		require.Equal(t, exitOK, code)
+		assert.Contains(t, out, "osio-run")
+	})
+
+	t.Run("import", func(t *testing.T) {
+		otherSpaceID := uuid.NewV4()
+		code, out, _ := exec("import", "--space", otherSpaceID.String(), "--file", exported)
+		require.Equal(t, exitOK, code)
+		assert.Contains(t, out, otherSpaceID.String())
+		imported := svc.inSpace(otherSpaceID)
+		require.Len(t, imported, 2)
+		for _, m := range imported {
+			assert.NotEqual(t, mapID, *m.ID)
+		}
+	})
+
+	t.Run("import from stdin", func(t *testing.T) {
+		otherSpaceID := uuid.NewV4()
+		b, err := ioutil.ReadFile(exported)
+		require.NoError(t, err)
+		code, _, _ := execIn(bytes.NewReader(b), "import", "--space", otherSpaceID.String())
+		require.Equal(t, exitOK, code)
+		assert.Len(t, svc.inSpace(otherSpaceID), 2)
+	})
+
+	t.Run("import conflict", func(t *testing.T) {
+		code, _, errOut := exec("import", "--space", spaceID.String(), "--file", exported)
+		assert.Equal(t, exitConflict, code)
+		assert.Contains(t, errOut, "unable to import")
+		assert.Len(t, svc.inSpace(spaceID), 2)
+	})
+
+	t.Run("import update", func(t *testing.T) {
+		code, _, _ := exec("import", "--space", spaceID.String(), "--file", exported, "--update")
+		require.Equal(t, exitOK, code)
+		assert.Len(t, svc.inSpace(spaceID), 2)
+	})
+
+	t.Run("import invalid", func(t *testing.T) {
+		doc := `{"data": [{"name": "osio stage", "environments": [{"envUUID": "` + envID.String() + `"}]}]}`
+		code, _, errOut := execIn(strings.NewReader(doc), "import", "--space", uuid.NewV4().String())
+		assert.Equal(t, exitInvalid, code)
+		assert.Contains(t, errOut, "invalid_request")
+	})
+
+	t.Run("import malformed", func(t *testing.T) {
+		code, _, _ := execIn(strings.NewReader("not json"), "import", "--space", uuid.NewV4().String())
+		assert.Equal(t, exitUsage, code)
+	})
+
+	t.Run("token file", func(t *testing.T) {
+		os.Setenv(envToken, "wrong")
+		defer os.Setenv(envToken, "secret")
+		tokenFile := filepath.Join(dir, "token")
+		require.NoError(t, ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600))
+		code, out, _ := exec("--token-file", tokenFile, "show", mapID.String())
+		require.Equal(t, exitOK, code)
+		assert.Contains(t, out, "osio-stage")
+
+		os.Setenv(envTokenFile, tokenFile)
+		defer os.Unsetenv(envTokenFile)
+		code, _, _ = exec("show", mapID.String())
+		require.Equal(t, exitOK, code)
+	})
+
+	t.Run("missing token file", func(t *testing.T) {
+		code, _, errOut := exec("--token-file", filepath.Join(dir, "missing"), "show", mapID.String())
+		assert.Equal(t, exitError, code)
+		assert.Contains(t, errOut, "unable to read token file")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		code, _, _ := exec("delete", mapID.String())
+		assert.Equal(t, exitOK, code)
+		assert.Len(t, svc.inSpace(spaceID), 1)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		code, _, errOut := exec("show", uuid.NewV4().String())
+		assert.Equal(t, exitNotFound, code)
+		assert.Contains(t, errOut, "not_found: pipeline-environment not found")
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		code, _, _ := exec("show")
+		assert.Equal(t, exitUsage, code)
+		code, _, _ = exec("list", "--space", "not-a-uuid")
+		assert.Equal(t, exitUsage, code)
+		code, _, _ = exec("list", "--unknown")
+		assert.Equal(t, exitUsage, code)
+	})
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, exitOK, exitCode(nil))
+	assert.Equal(t, exitUnauthorized, exitCode(&apiError{status: http.StatusUnauthorized}))
+	assert.Equal(t, exitUnauthorized, exitCode(&apiError{status: http.StatusForbidden}))
+	assert.Equal(t, exitConflict, exitCode(&apiError{status: http.StatusConflict}))
+	assert.Equal(t, exitConflict, exitCode(errors.Wrap(&apiError{status: http.StatusConflict}, "unable to import")))
+	assert.Equal(t, exitInvalid, exitCode(&apiError{status: http.StatusBadRequest}))
+	assert.Equal(t, exitServerError, exitCode(&apiError{status: http.StatusBadGateway}))
+	assert.Equal(t, exitError, exitCode(assert.AnError))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-build/client"
+	"github.com/pkg/errors"
+)
+
+// Exit codes returned by the command
+const (
+	exitOK           = 0
+	exitError        = 1
+	exitUsage        = 2
+	exitUnauthorized = 3
+	exitNotFound     = 4
+	exitConflict     = 5
+	exitInvalid      = 6
+	exitServerError  = 7
+)
+
+// usageError is returned when the command line is invalid
+type usageError struct {
+	error
+}
+
+// apiError is returned when the service answers with a non successful status
+type apiError struct {
+	status int
+	errors []*client.JSONAPIError
+}
+
+func (e *apiError) Error() string {
+	if len(e.errors) == 0 {
+		return fmt.Sprintf("%d %s", e.status, http.StatusText(e.status))
+	}
+	details := make([]string, 0, len(e.errors))
+	for _, jerr := range e.errors {
+		if jerr.Code != nil {
+			details = append(details, fmt.Sprintf("%s: %s", *jerr.Code, jerr.Detail))
+		} else {
+			details = append(details, jerr.Detail)
+		}
+	}
+	return fmt.Sprintf("%d %s: %s", e.status, http.StatusText(e.status), strings.Join(details, "; "))
+}
+
+// exitCode returns the process exit code matching the given error, or the
+// error it wraps
+func exitCode(err error) int {
+	switch e := errors.Cause(err).(type) {
+	case nil:
+		return exitOK
+	case *usageError:
+		return exitUsage
+	case *apiError:
+		switch {
+		case e.status == http.StatusUnauthorized || e.status == http.StatusForbidden:
+			return exitUnauthorized
+		case e.status == http.StatusNotFound:
+			return exitNotFound
+		case e.status == http.StatusConflict:
+			return exitConflict
+		case e.status == http.StatusBadRequest || e.status == http.StatusUnprocessableEntity:
+			return exitInvalid
+		case e.status >= http.StatusInternalServerError:
+			return exitServerError
+		}
+	}
+	return exitError
+}
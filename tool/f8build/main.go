@@ -0,0 +1,12 @@
+// Command f8build manages the pipeline environment maps of the fabric8 build
+// service from the command line. It is built on top of the goa generated
+// client for the PipelineEnvironmentMaps resource.
+package main
+
+import (
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
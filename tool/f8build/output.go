@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fabric8-services/fabric8-build/client"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// printMaps prints the given maps either as a table or as a JSONAPI document
+func printMaps(w io.Writer, format string, doc interface{}, maps ...*client.PipelineEnvironmentMaps) error {
+	if format == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSPACE\tENVIRONMENTS")
+	for _, m := range maps {
+		envs := make([]string, 0, len(m.Environments))
+		for _, e := range m.Environments {
+			if e.EnvUUID != nil {
+				envs = append(envs, e.EnvUUID.String())
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", uuidString(m.ID), m.Name, uuidString(m.SpaceID), strings.Join(envs, ","))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/fabric8-services/fabric8-build/client"
+	goaclient "github.com/goadesign/goa/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	envURL       = "F8_BUILD_URL"
+	envToken     = "F8_TOKEN"
+	envTokenFile = "F8_TOKEN_FILE"
+	defaultURL   = "http://localhost:8080"
+)
+
+// options are the global flags shared by all sub-commands
+type options struct {
+	url       string
+	tokenFile string
+	output    string
+}
+
+// session wraps the generated client with the global options
+type session struct {
+	*client.Client
+	ctx    context.Context
+	output string
+}
+
+func newSession(opts *options) (*session, error) {
+	if opts.output != outputTable && opts.output != outputJSON {
+		return nil, &usageError{errors.Errorf("unknown output format %q, expected %q or %q", opts.output, outputTable, outputJSON)}
+	}
+	u, err := url.Parse(opts.url)
+	if err != nil || u.Host == "" {
+		return nil, &usageError{errors.Errorf("invalid service URL %q", opts.url)}
+	}
+
+	c := client.New(goaclient.HTTPClientDoer(http.DefaultClient))
+	c.Host = u.Host
+	c.Scheme = u.Scheme
+
+	tok, err := readToken(opts.tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	if tok != "" {
+		c.SetJWTSigner(&goaclient.JWTSigner{
+			TokenSource: &goaclient.StaticTokenSource{
+				StaticToken: &goaclient.StaticToken{Type: "Bearer", Value: tok},
+			},
+		})
+	}
+	return &session{Client: c, ctx: context.Background(), output: opts.output}, nil
+}
+
+// readToken returns the token from the given file if any, else from the
+// F8_TOKEN_FILE and F8_TOKEN environment variables.
+func readToken(tokenFile string) (string, error) {
+	if tokenFile == "" {
+		tokenFile = os.Getenv(envTokenFile)
+	}
+	if tokenFile != "" {
+		b, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return "", errors.Wrapf(err, "unable to read token file %s", tokenFile)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return strings.TrimSpace(os.Getenv(envToken)), nil
+}
+
+// check returns an apiError if the response is not the expected one
+func (s *session) check(resp *http.Response, expected int) error {
+	if resp.StatusCode == expected {
+		return nil
+	}
+	aerr := &apiError{status: resp.StatusCode}
+	if jerrs, err := s.DecodeJSONAPIErrors(resp); err == nil && jerrs != nil {
+		aerr.errors = jerrs.Errors
+	}
+	return aerr
+}
+
+func (s *session) list(spaceID, selector, sort string) (*client.PipelineEnvironmentMapsList, error) {
+	id, err := parseUUID("space", spaceID)
+	if err != nil {
+		return nil, err
+	}
+	var selectorParam, sortParam *string
+	if selector != "" {
+		selectorParam = &selector
+	}
+	if sort != "" {
+		sortParam = &sort
+	}
+	resp, err := s.ListPipelineEnvironmentMaps(s.ctx, client.ListPipelineEnvironmentMapsPath(id), nil, selectorParam, sortParam, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := s.check(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return s.DecodePipelineEnvironmentMapsList(resp)
+}
+
+func (s *session) show(mapID string) (*client.PipelineEnvironmentMapSingle, error) {
+	id, err := parseUUID("pipeline environment map", mapID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.ShowPipelineEnvironmentMaps(s.ctx, client.ShowPipelineEnvironmentMapsPath(id), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := s.check(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return s.DecodePipelineEnvironmentMapSingle(resp)
+}
+
+func (s *session) create(m *client.PipelineEnvironmentMaps) (*client.PipelineEnvironmentMapSingle, error) {
+	resp, err := s.CreatePipelineEnvironmentMaps(s.ctx,
+		client.CreatePipelineEnvironmentMapsPath(*m.SpaceID),
+		&client.CreatePipelineEnvironmentMapsPayload{Data: m}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := s.check(resp, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	return s.DecodePipelineEnvironmentMapSingle(resp)
+}
+
+func (s *session) update(m *client.PipelineEnvironmentMaps) (*client.PipelineEnvironmentMapSingle, error) {
+	resp, err := s.UpdatePipelineEnvironmentMaps(s.ctx,
+		client.UpdatePipelineEnvironmentMapsPath(*m.ID),
+		&client.UpdatePipelineEnvironmentMapsPayload{Data: m}, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := s.check(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return s.DecodePipelineEnvironmentMapSingle(resp)
+}
+
+func (s *session) delete(mapID string) error {
+	id, err := parseUUID("pipeline environment map", mapID)
+	if err != nil {
+		return err
+	}
+	resp, err := s.DeletePipelineEnvironmentMaps(s.ctx, client.DeletePipelineEnvironmentMapsPath(id))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s.check(resp, http.StatusNoContent)
+}